@@ -0,0 +1,18 @@
+-- Monthly message quota per tenant (commercial plans sell N messages/month).
+-- 0 means unlimited.
+ALTER TABLE tenants
+  ADD COLUMN IF NOT EXISTS quota_msgs_per_month BIGINT NOT NULL DEFAULT 0
+    CHECK (quota_msgs_per_month >= 0);
+
+-- Period counters (source of truth is Redis; persisted periodically by go-api).
+CREATE TABLE IF NOT EXISTS tenant_message_counters (
+  tenant_id UUID NOT NULL REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+  period_start TIMESTAMPTZ NOT NULL,
+  period_end TIMESTAMPTZ NOT NULL,
+  messages BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (tenant_id, period_start)
+);
+
+ALTER TABLE tenant_usage_snapshots
+  ADD COLUMN IF NOT EXISTS quota_msgs_per_month BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,83 @@
+-- Pricing catalog + monthly invoices generated from tenant_usage_snapshots.
+-- Amounts are stored in cents (integer) to avoid float rounding.
+
+CREATE TABLE IF NOT EXISTS pricing_catalog (
+  plan_type VARCHAR(20) PRIMARY KEY,
+  currency CHAR(3) NOT NULL DEFAULT 'BRL',
+  base_fee_cents BIGINT NOT NULL DEFAULT 0 CHECK (base_fee_cents >= 0),
+  included_messages BIGINT NOT NULL DEFAULT 0 CHECK (included_messages >= 0),
+  included_storage_mb INT NOT NULL DEFAULT 0 CHECK (included_storage_mb >= 0),
+  included_devices INT NOT NULL DEFAULT 0 CHECK (included_devices >= 0),
+  price_per_1k_messages_cents BIGINT NOT NULL DEFAULT 0 CHECK (price_per_1k_messages_cents >= 0),
+  price_per_gb_storage_cents BIGINT NOT NULL DEFAULT 0 CHECK (price_per_gb_storage_cents >= 0),
+  price_per_device_cents BIGINT NOT NULL DEFAULT 0 CHECK (price_per_device_cents >= 0),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO pricing_catalog (
+  plan_type, currency, base_fee_cents,
+  included_messages, included_storage_mb, included_devices,
+  price_per_1k_messages_cents, price_per_gb_storage_cents, price_per_device_cents
+)
+VALUES
+  ('starter',    'BRL',  9900,   1000000,  1000,   10, 50, 2000, 990),
+  ('pro',        'BRL', 49900,  10000000, 10000,  100, 30, 1500, 690),
+  ('enterprise', 'BRL', 199900, 100000000, 100000, 1000, 20, 1000, 490)
+ON CONFLICT (plan_type) DO NOTHING;
+
+-- Frozen snapshots are no longer refreshed by createUsageSnapshot.
+ALTER TABLE tenant_usage_snapshots
+  ADD COLUMN IF NOT EXISTS frozen_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS invoices (
+  invoice_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  tenant_id UUID NOT NULL REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+  snapshot_id BIGINT REFERENCES tenant_usage_snapshots(snapshot_id) ON DELETE SET NULL,
+  invoice_number VARCHAR(50) NOT NULL UNIQUE,
+  period_start TIMESTAMPTZ NOT NULL,
+  period_end TIMESTAMPTZ NOT NULL,
+  plan_type VARCHAR(20) NOT NULL,
+  currency CHAR(3) NOT NULL,
+  subtotal_cents BIGINT NOT NULL DEFAULT 0,
+  total_cents BIGINT NOT NULL DEFAULT 0,
+  status VARCHAR(20) NOT NULL DEFAULT 'draft'
+    CHECK (status IN ('draft', 'issued', 'paid', 'void')),
+  issued_at TIMESTAMPTZ,
+  paid_at TIMESTAMPTZ,
+  voided_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- One live (non-void) invoice per tenant period.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_tenant_period_live
+  ON invoices (tenant_id, period_start, period_end)
+  WHERE status <> 'void';
+
+CREATE INDEX IF NOT EXISTS idx_invoices_tenant_created
+  ON invoices (tenant_id, period_start DESC);
+
+CREATE TABLE IF NOT EXISTS invoice_line_items (
+  line_id BIGSERIAL PRIMARY KEY,
+  invoice_id UUID NOT NULL REFERENCES invoices(invoice_id) ON DELETE CASCADE,
+  kind VARCHAR(20) NOT NULL CHECK (kind IN ('base', 'messages', 'storage', 'devices')),
+  description TEXT NOT NULL,
+  quantity NUMERIC(18,2) NOT NULL DEFAULT 0,
+  unit_price_cents BIGINT NOT NULL DEFAULT 0,
+  amount_cents BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_line_items_invoice
+  ON invoice_line_items (invoice_id);
+
+ALTER TABLE invoices ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY tenant_isolation_invoices ON invoices
+    FOR SELECT
+    USING (
+        tenant_id = current_setting('app.current_tenant_id', true)::uuid
+        OR current_setting('app.current_user_role', true) = 'super_admin'
+    );
+
+CREATE TRIGGER trg_invoices_updated_at BEFORE UPDATE ON invoices
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_v2();
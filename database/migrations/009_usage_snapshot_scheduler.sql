@@ -0,0 +1,9 @@
+-- Scheduled usage snapshots (go-api background job).
+-- closed_at marks the final snapshot of a period, written once after the
+-- billing boundary.
+ALTER TABLE tenant_usage_snapshots
+  ADD COLUMN IF NOT EXISTS closed_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_tenant_usage_snapshots_open
+  ON tenant_usage_snapshots (period_start)
+  WHERE closed_at IS NULL;
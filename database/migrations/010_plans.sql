@@ -0,0 +1,106 @@
+-- Database-driven plan catalog. Tenants reference a plan by ID; tenant quota
+-- columns become optional overrides (NULL = use plan default).
+
+CREATE TABLE IF NOT EXISTS plans (
+  plan_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  code VARCHAR(40) NOT NULL UNIQUE CHECK (code ~ '^[a-z0-9_-]+$'),
+  name VARCHAR(100) NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  quota_devices INT NOT NULL DEFAULT 0 CHECK (quota_devices >= 0),
+  quota_msgs_per_min INT NOT NULL DEFAULT 360 CHECK (quota_msgs_per_min >= 0),
+  quota_storage_mb INT NOT NULL DEFAULT 1000 CHECK (quota_storage_mb >= 0),
+  quota_msgs_per_month BIGINT NOT NULL DEFAULT 0 CHECK (quota_msgs_per_month >= 0),
+  allow_overage BOOLEAN NOT NULL DEFAULT false,
+  retention_days INT NOT NULL DEFAULT 365 CHECK (retention_days > 0),
+  features JSONB NOT NULL DEFAULT '{}'::jsonb,
+  is_active BOOLEAN NOT NULL DEFAULT true,
+  is_default BOOLEAN NOT NULL DEFAULT false,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- At most one default plan (assigned to new tenants).
+CREATE UNIQUE INDEX IF NOT EXISTS idx_plans_single_default
+  ON plans (is_default) WHERE is_default;
+
+CREATE TRIGGER trg_plans_updated_at BEFORE UPDATE ON plans
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_v2();
+
+-- Seed with the defaults previously hardcoded on tenants (006 column defaults).
+INSERT INTO plans (code, name, quota_devices, quota_msgs_per_min, quota_storage_mb, quota_msgs_per_month, allow_overage, retention_days, is_default)
+VALUES
+  ('starter',    'Starter',    0,  360,  1000, 0, false, 365, true),
+  ('pro',        'Pro',        0,  360,  1000, 0, false, 365, false),
+  ('enterprise', 'Enterprise', 0,  360,  1000, 0, true,  365, false)
+ON CONFLICT (code) DO NOTHING;
+
+CREATE OR REPLACE FUNCTION default_plan_id() RETURNS UUID AS $$
+  SELECT plan_id FROM plans WHERE is_default LIMIT 1
+$$ LANGUAGE sql STABLE;
+
+ALTER TABLE tenants
+  ADD COLUMN IF NOT EXISTS plan_id UUID REFERENCES plans(plan_id);
+
+UPDATE tenants t
+SET plan_id = p.plan_id
+FROM plans p
+WHERE t.plan_id IS NULL AND p.code = t.plan_type;
+
+UPDATE tenants SET plan_id = default_plan_id() WHERE plan_id IS NULL;
+
+ALTER TABLE tenants
+  ALTER COLUMN plan_id SET DEFAULT default_plan_id(),
+  ALTER COLUMN plan_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_tenants_plan ON tenants(plan_id);
+
+-- plan_type is kept as a denormalized copy of plans.code (pricing_catalog and
+-- invoices are keyed by it); the CHECK on the three legacy values goes away.
+ALTER TABLE tenants DROP CONSTRAINT IF EXISTS tenants_plan_type_check;
+ALTER TABLE tenants ALTER COLUMN plan_type TYPE VARCHAR(40);
+
+CREATE OR REPLACE FUNCTION sync_tenant_plan_type() RETURNS TRIGGER AS $$
+BEGIN
+  SELECT code INTO NEW.plan_type FROM plans WHERE plan_id = NEW.plan_id;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_tenants_sync_plan_type BEFORE INSERT OR UPDATE OF plan_id ON tenants
+    FOR EACH ROW EXECUTE FUNCTION sync_tenant_plan_type();
+
+-- Quota columns become overrides.
+ALTER TABLE tenants
+  ALTER COLUMN quota_devices DROP NOT NULL,
+  ALTER COLUMN quota_devices DROP DEFAULT,
+  ALTER COLUMN quota_msgs_per_min DROP NOT NULL,
+  ALTER COLUMN quota_msgs_per_min DROP DEFAULT,
+  ALTER COLUMN quota_storage_mb DROP NOT NULL,
+  ALTER COLUMN quota_storage_mb DROP DEFAULT,
+  ALTER COLUMN quota_msgs_per_month DROP NOT NULL,
+  ALTER COLUMN quota_msgs_per_month DROP DEFAULT,
+  ALTER COLUMN allow_overage DROP NOT NULL,
+  ALTER COLUMN allow_overage DROP DEFAULT;
+
+-- Overage used to be honoured only on enterprise; keep non-enterprise tenants
+-- that had the flag set blocked, as before.
+UPDATE tenants SET allow_overage = false
+WHERE plan_type <> 'enterprise' AND allow_overage;
+
+-- Values equal to the plan default follow the plan from now on.
+UPDATE tenants t
+SET quota_devices        = NULLIF(t.quota_devices, p.quota_devices),
+    quota_msgs_per_min   = NULLIF(t.quota_msgs_per_min, p.quota_msgs_per_min),
+    quota_storage_mb     = NULLIF(t.quota_storage_mb, p.quota_storage_mb),
+    quota_msgs_per_month = NULLIF(t.quota_msgs_per_month, p.quota_msgs_per_month),
+    allow_overage        = NULLIF(t.allow_overage, p.allow_overage)
+FROM plans p
+WHERE p.plan_id = t.plan_id;
+
+-- Pricing is defined per plan code.
+ALTER TABLE pricing_catalog ALTER COLUMN plan_type TYPE VARCHAR(40);
+ALTER TABLE pricing_catalog
+  ADD CONSTRAINT fk_pricing_catalog_plan
+  FOREIGN KEY (plan_type) REFERENCES plans(code) ON DELETE CASCADE;
+
+ALTER TABLE invoices ALTER COLUMN plan_type TYPE VARCHAR(40);
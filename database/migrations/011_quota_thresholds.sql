@@ -0,0 +1,21 @@
+-- Quota early warnings (one notification per tenant/quota/threshold/period)
+-- and grace windows above the hard limit.
+
+CREATE TABLE IF NOT EXISTS quota_threshold_notifications (
+  tenant_id UUID NOT NULL REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+  quota VARCHAR(40) NOT NULL,
+  threshold_percent INT NOT NULL CHECK (threshold_percent > 0),
+  period_start TIMESTAMPTZ NOT NULL,
+  used NUMERIC(18,2) NOT NULL DEFAULT 0,
+  quota_limit BIGINT NOT NULL DEFAULT 0,
+  notified_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (tenant_id, quota, threshold_percent, period_start)
+);
+
+CREATE TABLE IF NOT EXISTS quota_grace_periods (
+  tenant_id UUID NOT NULL REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+  quota VARCHAR(40) NOT NULL,
+  period_start TIMESTAMPTZ NOT NULL,
+  started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (tenant_id, quota, period_start)
+);
@@ -0,0 +1,17 @@
+-- Overage metering for tenants with allow_overage (billable usage above quota).
+-- storage_over_byte_hours integrates bytes above quota_storage_mb over time;
+-- messages_over_quota is the accepted volume above quota_msgs_per_month.
+
+CREATE TABLE IF NOT EXISTS tenant_overage_usage (
+  tenant_id UUID NOT NULL REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+  period_start TIMESTAMPTZ NOT NULL,
+  period_end TIMESTAMPTZ NOT NULL,
+  storage_over_byte_hours NUMERIC(24,2) NOT NULL DEFAULT 0 CHECK (storage_over_byte_hours >= 0),
+  storage_over_bytes_peak BIGINT NOT NULL DEFAULT 0,
+  messages_over_quota BIGINT NOT NULL DEFAULT 0 CHECK (messages_over_quota >= 0),
+  last_sampled_at TIMESTAMPTZ,
+  storage_alerted_at TIMESTAMPTZ,
+  messages_alerted_at TIMESTAMPTZ,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (tenant_id, period_start)
+);
@@ -0,0 +1,28 @@
+-- Tenant invitations: a tenant admin invites an e-mail with a role; the
+-- single-use token (only its SHA-256 is stored) creates the user inside the
+-- inviting tenant on accept.
+
+CREATE TABLE IF NOT EXISTS user_invitations (
+  invite_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  tenant_id UUID NOT NULL REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+  email VARCHAR(255) NOT NULL,
+  role user_role NOT NULL CHECK (role IN ('tenant_admin', 'tenant_user')),
+  token_hash CHAR(64) NOT NULL UNIQUE,
+  invited_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  accepted_at TIMESTAMPTZ,
+  accepted_user_id UUID REFERENCES users(user_id) ON DELETE SET NULL,
+  revoked_at TIMESTAMPTZ,
+  revoked_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CONSTRAINT chk_invitation_single_outcome CHECK (accepted_at IS NULL OR revoked_at IS NULL)
+);
+
+-- At most one open invitation per tenant/e-mail (expired ones are revoked
+-- before a new invite is created).
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_invitations_open
+  ON user_invitations (tenant_id, email)
+  WHERE accepted_at IS NULL AND revoked_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_user_invitations_tenant
+  ON user_invitations (tenant_id, created_at DESC);
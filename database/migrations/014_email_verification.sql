@@ -0,0 +1,13 @@
+-- Real e-mail verification: new accounts start unverified and confirm via a
+-- signed link. Tenants may override the global policy for unverified logins
+-- (NULL = UNVERIFIED_LOGIN_POLICY).
+
+ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+UPDATE users SET email_verified_at = created_at WHERE email_verified AND email_verified_at IS NULL;
+
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS unverified_login_policy VARCHAR(20)
+  CHECK (unverified_login_policy IN ('allow', 'restricted', 'deny'));
+
+COMMENT ON COLUMN tenants.unverified_login_policy IS 'Override of UNVERIFIED_LOGIN_POLICY for this tenant (NULL = global)';
@@ -0,0 +1,24 @@
+-- Server-side sessions: every login opens a session that owns the refresh
+-- token chain (tokens carry it as the "sid" claim). Revoking the session
+-- ends refresh immediately and, through a Redis marker, the access tokens.
+
+CREATE TABLE IF NOT EXISTS user_sessions (
+  session_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+  tenant_id UUID REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+  user_agent TEXT,
+  ip_address VARCHAR(64),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  revoked_at TIMESTAMPTZ,
+  revoked_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+  revoked_reason VARCHAR(50)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user_active
+  ON user_sessions (user_id, last_used_at DESC)
+  WHERE revoked_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_expires
+  ON user_sessions (expires_at);
@@ -0,0 +1,29 @@
+-- TOTP multi-factor authentication. The secret is stored encrypted
+-- (AES-GCM, MFA_ENCRYPTION_KEY); enrolment is complete once confirmed_at is
+-- set. last_used_step rejects replays of an accepted code. Recovery codes are
+-- single-use and only their SHA-256 is kept.
+
+CREATE TABLE IF NOT EXISTS user_mfa (
+  user_id UUID PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+  secret_encrypted TEXT NOT NULL,
+  confirmed_at TIMESTAMPTZ,
+  last_used_step BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS user_mfa_recovery_codes (
+  code_id BIGSERIAL PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+  code_hash CHAR(64) NOT NULL,
+  used_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_mfa_recovery_codes_user
+  ON user_mfa_recovery_codes (user_id)
+  WHERE used_at IS NULL;
+
+-- Tenant admins may make MFA mandatory for their tenant (super admins always
+-- require it).
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS mfa_required BOOLEAN NOT NULL DEFAULT false;
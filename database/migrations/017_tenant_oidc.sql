@@ -0,0 +1,28 @@
+-- OpenID Connect single sign-on per tenant (authorization code + PKCE).
+-- The client secret is stored encrypted (AES-GCM, OIDC_ENCRYPTION_KEY); it
+-- may be empty for public clients. role_mapping maps values of role_claim
+-- (string or array claim, e.g. "groups") to a platform role.
+
+CREATE TABLE IF NOT EXISTS tenant_oidc_providers (
+  tenant_id UUID PRIMARY KEY REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+  issuer TEXT NOT NULL,
+  client_id TEXT NOT NULL,
+  client_secret_encrypted TEXT,
+  allowed_domains TEXT[] NOT NULL DEFAULT '{}',
+  role_claim VARCHAR(100),
+  role_mapping JSONB NOT NULL DEFAULT '{}'::jsonb,
+  default_role user_role NOT NULL DEFAULT 'tenant_user' CHECK (default_role <> 'super_admin'),
+  enabled BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Users provisioned just-in-time by SSO keep the IdP subject and cannot log
+-- in with a password.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_provider VARCHAR(20) NOT NULL DEFAULT 'password'
+  CHECK (auth_provider IN ('password', 'oidc'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_subject TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_oidc_subject
+  ON users (tenant_id, oidc_subject)
+  WHERE oidc_subject IS NOT NULL;
@@ -0,0 +1,40 @@
+-- Tenant-scoped custom roles built from the permissions catalog. A custom
+-- role refines a tenant_user: when assigned, its permission set replaces the
+-- built-in tenant_user permissions in the user's tokens. Permissions marked
+-- tenant_grantable = false (platform-only) can never be part of a custom role.
+
+ALTER TABLE permissions ADD COLUMN IF NOT EXISTS tenant_grantable BOOLEAN NOT NULL DEFAULT true;
+UPDATE permissions SET tenant_grantable = false WHERE name IN ('system:admin', 'tenants:write');
+
+CREATE TABLE IF NOT EXISTS tenant_roles (
+  role_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  tenant_id UUID NOT NULL REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+  name VARCHAR(50) NOT NULL,
+  description TEXT,
+  created_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CONSTRAINT uq_tenant_roles_name UNIQUE (tenant_id, name),
+  CONSTRAINT uq_tenant_roles_tenant_role UNIQUE (tenant_id, role_id)
+);
+
+CREATE TABLE IF NOT EXISTS tenant_role_permissions (
+  role_id UUID NOT NULL REFERENCES tenant_roles(role_id) ON DELETE CASCADE,
+  permission_id INT NOT NULL REFERENCES permissions(permission_id) ON DELETE CASCADE,
+  PRIMARY KEY (role_id, permission_id)
+);
+
+-- The composite FK keeps a user's custom role inside their own tenant; a
+-- role cannot be deleted while assigned.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS custom_role_id UUID;
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_users_custom_role') THEN
+    ALTER TABLE users ADD CONSTRAINT fk_users_custom_role
+      FOREIGN KEY (tenant_id, custom_role_id) REFERENCES tenant_roles (tenant_id, role_id) ON DELETE RESTRICT;
+  END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_users_custom_role
+  ON users (custom_role_id)
+  WHERE custom_role_id IS NOT NULL;
@@ -0,0 +1,18 @@
+-- Asymmetric JWT signing keys (RS256 / EdDSA), rotated by the API. A key
+-- signs from not_before until the next key takes over, and keeps verifying
+-- until expires_at (NULL while current), i.e. a refresh token lifetime
+-- after it stops signing. Keys are published at /.well-known/jwks.json
+-- before they sign. Private keys are PKCS#8 PEM sealed with AES-256-GCM
+-- (JWT_KEY_ENCRYPTION_KEY).
+
+CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+  kid VARCHAR(64) PRIMARY KEY,
+  algorithm VARCHAR(10) NOT NULL CHECK (algorithm IN ('RS256', 'EdDSA')),
+  private_key_encrypted TEXT NOT NULL,
+  not_before TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_jwt_signing_keys_algorithm
+  ON jwt_signing_keys (algorithm, not_before DESC);
@@ -0,0 +1,22 @@
+-- Tenant-managed API keys. Issued keys look like "iiot_<8 hex>_<secret>";
+-- key_prefix stores "iiot_<8 hex>" (legacy keys keep their first 8
+-- characters). Rotation issues a new key (rotated_from) and lets the old one
+-- live until its expires_at (grace period).
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rotated_from UUID REFERENCES api_keys(key_id) ON DELETE SET NULL;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS revoked_by UUID REFERENCES users(user_id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_tenant
+  ON api_keys (tenant_id, created_at DESC);
+
+INSERT INTO permissions (name, description) VALUES
+  ('api_keys:read', 'View tenant API keys'),
+  ('api_keys:write', 'Create, rotate and revoke tenant API keys')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission_id)
+SELECT r.role, p.permission_id
+FROM permissions p
+CROSS JOIN (VALUES ('super_admin'::user_role), ('tenant_admin'::user_role)) AS r(role)
+WHERE p.name IN ('api_keys:read', 'api_keys:write')
+ON CONFLICT DO NOTHING;
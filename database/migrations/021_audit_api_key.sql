@@ -0,0 +1,7 @@
+-- Audit attribution for machine access: records written while serving an
+-- API key request (actor_type 'api_key') carry the key in api_key_id.
+
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS api_key_id UUID REFERENCES api_keys(key_id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_audit_api_key
+  ON audit_log (api_key_id, timestamp DESC) WHERE api_key_id IS NOT NULL;
@@ -0,0 +1,76 @@
+-- Tamper-evident audit log: every new audit_log row is chained per tenant
+-- (rows without a tenant form the platform chain, keyed by the nil UUID).
+-- row_hash = sha256(prev_hash || '|' || canonical row content), so editing,
+-- deleting or re-ordering a row breaks every later link. The trigger keeps
+-- the chain whatever writes the row; audit_checkpoints holds the periodic
+-- HMAC-signed heads (written by the API) that catch a rewritten chain.
+-- Rows written before this migration stay unchained (chain_seq NULL).
+
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS chain_seq BIGINT;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS prev_hash TEXT;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS row_hash TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_chain
+  ON audit_log ((COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'::uuid)), chain_seq)
+  WHERE chain_seq IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS audit_chain_heads (
+  chain_key UUID PRIMARY KEY,
+  last_seq BIGINT NOT NULL DEFAULT 0,
+  last_hash TEXT NOT NULL DEFAULT '',
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+  checkpoint_id BIGSERIAL PRIMARY KEY,
+  chain_key UUID NOT NULL,
+  chain_seq BIGINT NOT NULL,
+  row_hash TEXT NOT NULL,
+  key_id VARCHAR(16) NOT NULL,
+  signature TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (chain_key, chain_seq)
+);
+
+COMMENT ON TABLE audit_checkpoints IS 'Signed audit chain heads (HMAC-SHA256 with AUDIT_CHECKPOINT_KEY)';
+
+-- Canonical content: a JSON array of the columns (jsonb text is normalized;
+-- the timestamp is rendered in UTC so the session TimeZone does not matter).
+CREATE OR REPLACE FUNCTION audit_row_hash(r audit_log) RETURNS TEXT AS $$
+  SELECT encode(sha256(convert_to(COALESCE(r.prev_hash, '') || '|' || jsonb_build_array(
+    r.chain_seq, r.audit_id, r.tenant_id, r.user_id, r.device_id, r.api_key_id,
+    r.event_type, r.event_category, r.severity, r.actor_type, r.actor_id,
+    r.resource_type, r.resource_id, r.action, r.result, r.ip_address, r.user_agent,
+    r.error_code, r.error_message, r.request_path, r.request_method, r.response_status,
+    r.duration_ms, r.metadata,
+    to_char(r.timestamp AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+  )::text, 'UTF8')), 'hex');
+$$ LANGUAGE sql STABLE;
+
+-- The head row lock serializes the writers of one tenant until commit.
+CREATE OR REPLACE FUNCTION audit_log_chain() RETURNS TRIGGER AS $$
+DECLARE
+  v_key UUID := COALESCE(NEW.tenant_id, '00000000-0000-0000-0000-000000000000'::uuid);
+  v_seq BIGINT;
+  v_hash TEXT;
+BEGIN
+  SELECT last_seq, last_hash INTO v_seq, v_hash FROM audit_chain_heads WHERE chain_key = v_key FOR UPDATE;
+  IF NOT FOUND THEN
+    INSERT INTO audit_chain_heads (chain_key) VALUES (v_key) ON CONFLICT (chain_key) DO NOTHING;
+    SELECT last_seq, last_hash INTO v_seq, v_hash FROM audit_chain_heads WHERE chain_key = v_key FOR UPDATE;
+  END IF;
+
+  NEW.chain_seq := v_seq + 1;
+  NEW.prev_hash := v_hash;
+  NEW.row_hash := audit_row_hash(NEW);
+
+  UPDATE audit_chain_heads
+  SET last_seq = NEW.chain_seq, last_hash = NEW.row_hash, updated_at = NOW()
+  WHERE chain_key = v_key;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_audit_log_chain ON audit_log;
+CREATE TRIGGER trg_audit_log_chain BEFORE INSERT ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_chain();
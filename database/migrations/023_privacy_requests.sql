@@ -0,0 +1,43 @@
+-- LGPD data subject erasure. A user (or an admin on their behalf) files an
+-- erasure request; an admin with privacy:manage approves or rejects it. On
+-- approval the users row is anonymized in place (the user_id stays as a
+-- pseudonym so invoices, devices and audit rows keep their references), and
+-- personal data in audit_log is redacted: redacted_at marks rows whose
+-- content no longer matches row_hash, so audit verification only checks
+-- their links. Billing audit rows keep their metadata as billing evidence.
+
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS redacted_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS privacy_erasure_requests (
+  request_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  tenant_id UUID REFERENCES tenants(tenant_id) ON DELETE CASCADE,
+  user_id UUID REFERENCES users(user_id) ON DELETE SET NULL,
+  status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'rejected', 'completed')),
+  reason TEXT,
+  requested_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+  requested_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  reviewed_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+  reviewed_at TIMESTAMPTZ,
+  review_note TEXT,
+  completed_at TIMESTAMPTZ,
+  result JSONB NOT NULL DEFAULT '{}'::jsonb
+);
+
+-- At most one open request per user.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_privacy_erasure_pending
+  ON privacy_erasure_requests (user_id)
+  WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS idx_privacy_erasure_tenant
+  ON privacy_erasure_requests (tenant_id, requested_at DESC);
+
+INSERT INTO permissions (name, description) VALUES
+  ('privacy:manage', 'Export personal data of and review erasure requests for tenant users')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission_id)
+SELECT r.role, p.permission_id
+FROM permissions p
+CROSS JOIN (VALUES ('super_admin'::user_role), ('tenant_admin'::user_role)) AS r(role)
+WHERE p.name = 'privacy:manage'
+ON CONFLICT DO NOTHING;
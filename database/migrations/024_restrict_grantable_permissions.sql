@@ -0,0 +1,14 @@
+-- Permissions that administer users can hand out tenant admin power: with
+-- users:write a custom role could promote users or invite new tenant admins,
+-- repoint the tenant SSO and turn off its MFA policy; users:delete and
+-- privacy:manage can remove or erase the admins themselves. They stay with
+-- the built-in tenant_admin and are no longer grantable to custom roles.
+
+UPDATE permissions SET tenant_grantable = false
+WHERE name IN ('users:write', 'users:delete', 'privacy:manage');
+
+-- Existing custom roles lose them (tokens already leave them out on refresh).
+DELETE FROM tenant_role_permissions trp
+USING permissions p
+WHERE p.permission_id = trp.permission_id
+  AND NOT p.tenant_grantable;
@@ -0,0 +1,20 @@
+-- Redacted audit rows stay verifiable. An erasure stores the hash of each
+-- row's redacted content (redacted_hash, same audit_row_hash as the chain)
+-- and appends a chained privacy.erasure_completed row per chain whose
+-- metadata.redacted maps audit_id -> redacted_hash. Verification checks a
+-- redacted row against the latest such record instead of skipping it, so
+-- editing it after the erasure is detected again.
+
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS redacted_hash TEXT;
+
+-- Rows redacted before this migration: record their current content once.
+UPDATE audit_log a
+SET redacted_hash = audit_row_hash(a)
+WHERE a.redacted_at IS NOT NULL AND a.chain_seq IS NOT NULL AND a.redacted_hash IS NULL;
+
+INSERT INTO audit_log (tenant_id, event_type, event_category, severity, actor_type, action, result, resource_type, metadata, timestamp)
+SELECT tenant_id, 'privacy.erasure_completed', 'privacy', 'info', 'system', 'record_redactions', 'success', 'audit_log',
+       jsonb_build_object('backfill', true, 'redacted', jsonb_object_agg(audit_id::text, redacted_hash)), NOW()
+FROM audit_log
+WHERE redacted_at IS NOT NULL AND chain_seq IS NOT NULL
+GROUP BY tenant_id;
@@ -53,7 +53,11 @@ upsert_api_key() {
     return 1
   fi
 
-  key_prefix="$(printf '%s' "$EMQX_WEBHOOK_API_KEY" | cut -c1-8)"
+  # Same lookup prefix as the API: "iiot_<8 hex>" for issued-format keys.
+  case "$EMQX_WEBHOOK_API_KEY" in
+    iiot_????????_*) key_prefix="$(printf '%s' "$EMQX_WEBHOOK_API_KEY" | cut -c1-13)" ;;
+    *) key_prefix="$(printf '%s' "$EMQX_WEBHOOK_API_KEY" | cut -c1-8)" ;;
+  esac
   escaped_key="$(printf "%s" "$EMQX_WEBHOOK_API_KEY" | sed "s/'/''/g")"
 
   psql "$PG_CONN" -v ON_ERROR_STOP=1 -Atqc "
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -33,6 +34,18 @@ type Config struct {
 	JWTAccessExpiration  time.Duration
 	JWTRefreshExpiration time.Duration
 
+	// JWT signing: HS256 (JWT_SECRET) or RS256/EdDSA with rotating keys
+	// published at /.well-known/jwks.json. JWTAcceptHS256 keeps HS256 tokens
+	// valid while migrating (off by default outside HS256, so JWT_SECRET
+	// stops minting tokens); keys rotate every JWTKeyRotationDays and are
+	// published JWTKeyPrepublishHours before they sign. The private keys are
+	// stored encrypted with JWTKeyEncryptionKey (required outside HS256).
+	JWTSigningAlg         string
+	JWTAcceptHS256        bool
+	JWTKeyRotationDays    int64
+	JWTKeyPrepublishHours int64
+	JWTKeyEncryptionKey   string
+
 	// Rate Limit
 	RateLimitDevicePerMin int64
 	RateLimitDevicePerSec int64
@@ -42,6 +55,31 @@ type Config struct {
 	// Cache
 	CacheTTLSeconds int64
 
+	// In-process ingest cache (device/tenant quota lookups)
+	IngestCacheMaxEntries    int64
+	IngestCacheDeviceTTLSecs int64
+	IngestCacheQuotaTTLSecs  int64
+
+	// Monthly message counters (Redis -> Postgres flush interval)
+	MessageCounterFlushSecs int64
+
+	// Scheduled usage snapshots (0 disables)
+	UsageSnapshotIntervalSecs int64
+
+	// Quota early warnings (percent of limit) and grace buffer above the hard limit
+	QuotaWarningThresholds []int
+	QuotaGracePercent      int64
+	QuotaGraceHours        int64
+
+	// Telemetry pruning by plan retention_days; deletes data, so opt-in
+	// (0, the default, disables; 86400 runs it daily)
+	TelemetryRetentionIntervalSecs int64
+
+	// Overage metering (allow_overage tenants); ceilings of 0 disable alerts
+	OverageMeterIntervalSecs    int64
+	OverageAlertCeilingGBHours  int64
+	OverageAlertCeilingMessages int64
+
 	// CORS
 	CORSAllowedOrigins string
 	CORSAllowedMethods string
@@ -61,6 +99,67 @@ type Config struct {
 	// Telegram notifications (quota events)
 	TelegramBotToken string
 	TelegramChatID   string
+
+	// SMTP (user-facing notifications; empty host logs instead of sending)
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// Public URL of the web app (links in invitation/reset e-mails)
+	AppBaseURL string
+
+	// Tenant invitations
+	InviteTTLHours int64
+
+	// Password reset tokens
+	PasswordResetTTLMins int64
+
+	// E-mail verification: link lifetime and what unverified users may do
+	// (allow | restricted | deny; tenants can override)
+	EmailVerificationTTLHours int64
+	UnverifiedLoginPolicy     string
+	UnverifiedPermissions     []string
+
+	// TOTP MFA: issuer shown in authenticator apps, key encrypting the stored
+	// secrets (falls back to JWT_SECRET) and login challenge lifetime
+	MFAIssuer           string
+	MFAEncryptionKey    string
+	MFAChallengeTTLMins int64
+
+	// OIDC single sign-on: callback registered at the IdPs, key encrypting the
+	// stored client secrets (falls back to JWT_SECRET) and login state lifetime.
+	// OIDCAllowInsecureIssuers (dev/test only) accepts http issuers on private
+	// or loopback hosts.
+	OIDCRedirectURL          string
+	OIDCEncryptionKey        string
+	OIDCStateTTLMins         int64
+	OIDCAllowInsecureIssuers bool
+
+	// Per-account login protection: failures before progressive delays,
+	// failures that lock the account, failure window and lockout duration
+	LoginDelayAfterFailures int64
+	LoginLockoutThreshold   int64
+	LoginFailureWindowMins  int64
+	LoginLockoutMins        int64
+
+	// Networks whose X-Forwarded-For / X-Real-IP headers are trusted
+	TrustedProxies []string
+
+	// Request audit: every POST/PUT/PATCH/DELETE (except the skipped paths,
+	// by default the telemetry webhook) is queued and written to audit_log
+	// in batches of RequestAuditBatchSize at least every RequestAuditFlushMs.
+	RequestAuditEnabled    bool
+	RequestAuditBufferSize int64
+	RequestAuditBatchSize  int64
+	RequestAuditFlushMs    int64
+	RequestAuditSkipPaths  []string
+
+	// Audit hash chain: every AuditCheckpointIntervalSecs (0 disables) the
+	// chain heads are signed with AuditCheckpointKey (falls back to JWT_SECRET)
+	AuditCheckpointKey          string
+	AuditCheckpointIntervalSecs int64
 }
 
 func Load() *Config {
@@ -87,6 +186,12 @@ func Load() *Config {
 		JWTAccessExpiration:  time.Hour,
 		JWTRefreshExpiration: 30 * 24 * time.Hour,
 
+		JWTSigningAlg:         getEnv("JWT_SIGNING_ALG", "HS256"),
+		JWTAcceptHS256:        getEnvBool("JWT_ACCEPT_HS256", getEnv("JWT_SIGNING_ALG", "HS256") == "HS256"),
+		JWTKeyRotationDays:    getEnvInt64("JWT_KEY_ROTATION_DAYS", 30),
+		JWTKeyPrepublishHours: getEnvInt64("JWT_KEY_PREPUBLISH_HOURS", 24),
+		JWTKeyEncryptionKey:   getEnv("JWT_KEY_ENCRYPTION_KEY", ""),
+
 		RateLimitDevicePerMin: getEnvInt64("RATE_LIMIT_DEVICE_PER_MIN", 12),
 		RateLimitDevicePerSec: getEnvInt64("RATE_LIMIT_DEVICE_PER_SEC", 5),
 		RateLimitSlotPerMin:   getEnvInt64("RATE_LIMIT_SLOT_PER_MIN", 12),
@@ -94,6 +199,24 @@ func Load() *Config {
 
 		CacheTTLSeconds: getEnvInt64("CACHE_TTL_SECONDS", 0),
 
+		IngestCacheMaxEntries:    getEnvInt64("INGEST_CACHE_MAX_ENTRIES", 10000),
+		IngestCacheDeviceTTLSecs: getEnvInt64("INGEST_CACHE_DEVICE_TTL_SECS", 30),
+		IngestCacheQuotaTTLSecs:  getEnvInt64("INGEST_CACHE_QUOTA_TTL_SECS", 60),
+
+		MessageCounterFlushSecs: getEnvInt64("MESSAGE_COUNTER_FLUSH_SECS", 60),
+
+		UsageSnapshotIntervalSecs: getEnvInt64("USAGE_SNAPSHOT_INTERVAL_SECS", 21600),
+
+		QuotaWarningThresholds: getEnvIntList("QUOTA_WARNING_THRESHOLDS", []int{80, 95}),
+		QuotaGracePercent:      getEnvInt64("QUOTA_GRACE_PERCENT", 0),
+		QuotaGraceHours:        getEnvInt64("QUOTA_GRACE_HOURS", 0),
+
+		TelemetryRetentionIntervalSecs: getEnvInt64("TELEMETRY_RETENTION_INTERVAL_SECS", 0),
+
+		OverageMeterIntervalSecs:    getEnvInt64("OVERAGE_METER_INTERVAL_SECS", 3600),
+		OverageAlertCeilingGBHours:  getEnvInt64("OVERAGE_ALERT_CEILING_GB_HOURS", 0),
+		OverageAlertCeilingMessages: getEnvInt64("OVERAGE_ALERT_CEILING_MESSAGES", 0),
+
 		CORSAllowedOrigins: getEnv("CORS_ALLOWED_ORIGINS", ""),
 		CORSAllowedMethods: getEnv("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE,OPTIONS"),
 		CORSAllowedHeaders: getEnv("CORS_ALLOWED_HEADERS", "Authorization,Content-Type"),
@@ -106,6 +229,47 @@ func Load() *Config {
 		MQTTBrokerPort:      getEnv("MQTT_BROKER_PORT", "1883"),
 		TelegramBotToken:    getEnv("TELEGRAM_BOT_TOKEN", ""),
 		TelegramChatID:      getEnv("TELEGRAM_CHAT_ID", ""),
+
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:     getEnv("SMTP_FROM", "no-reply@iiot.local"),
+
+		AppBaseURL: getEnv("APP_BASE_URL", "http://localhost:3000"),
+
+		InviteTTLHours: getEnvInt64("INVITE_TTL_HOURS", 72),
+
+		PasswordResetTTLMins: getEnvInt64("PASSWORD_RESET_TTL_MINS", 30),
+
+		EmailVerificationTTLHours: getEnvInt64("EMAIL_VERIFICATION_TTL_HOURS", 48),
+		UnverifiedLoginPolicy:     getEnv("UNVERIFIED_LOGIN_POLICY", "allow"),
+		UnverifiedPermissions:     getEnvStringList("UNVERIFIED_PERMISSIONS", []string{"devices:read", "telemetry:read"}),
+
+		MFAIssuer:           getEnv("MFA_ISSUER", "IIoT Platform"),
+		MFAEncryptionKey:    getEnv("MFA_ENCRYPTION_KEY", ""),
+		MFAChallengeTTLMins: getEnvInt64("MFA_CHALLENGE_TTL_MINS", 5),
+
+		OIDCRedirectURL:          getEnv("OIDC_REDIRECT_URL", "http://localhost:3001/api/v1/auth/oidc/callback"),
+		OIDCEncryptionKey:        getEnv("OIDC_ENCRYPTION_KEY", ""),
+		OIDCStateTTLMins:         getEnvInt64("OIDC_STATE_TTL_MINS", 10),
+		OIDCAllowInsecureIssuers: getEnvBool("OIDC_ALLOW_INSECURE_ISSUERS", false),
+
+		LoginDelayAfterFailures: getEnvInt64("LOGIN_DELAY_AFTER_FAILURES", 3),
+		LoginLockoutThreshold:   getEnvInt64("LOGIN_LOCKOUT_THRESHOLD", 10),
+		LoginFailureWindowMins:  getEnvInt64("LOGIN_FAILURE_WINDOW_MINS", 15),
+		LoginLockoutMins:        getEnvInt64("LOGIN_LOCKOUT_MINS", 15),
+
+		TrustedProxies: getEnvStringList("TRUSTED_PROXIES", []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"}),
+
+		RequestAuditEnabled:    getEnvBool("AUDIT_REQUESTS_ENABLED", true),
+		RequestAuditBufferSize: getEnvInt64("AUDIT_REQUESTS_BUFFER", 10000),
+		RequestAuditBatchSize:  getEnvInt64("AUDIT_REQUESTS_BATCH", 200),
+		RequestAuditFlushMs:    getEnvInt64("AUDIT_REQUESTS_FLUSH_MS", 1000),
+		RequestAuditSkipPaths:  getEnvStringList("AUDIT_REQUESTS_SKIP_PATHS", []string{"/api/telemetry", "/api/v1/telemetry"}),
+
+		AuditCheckpointKey:          getEnv("AUDIT_CHECKPOINT_KEY", ""),
+		AuditCheckpointIntervalSecs: getEnvInt64("AUDIT_CHECKPOINT_INTERVAL_SECS", 3600),
 	}
 }
 
@@ -137,6 +301,42 @@ func getEnvInt64(key string, def int64) int64 {
 	return def
 }
 
+// getEnvIntList parses a comma-separated list of integers ("80,95").
+func getEnvIntList(key string, def []int) []int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var out []int
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return def
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// getEnvStringList parses a comma-separated list ("devices:read,telemetry:read").
+func getEnvStringList(key string, def []string) []string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	out := []string{}
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
 func getEnvBool(key string, def bool) bool {
 	if v := os.Getenv(key); v != "" {
 		if b, err := strconv.ParseBool(v); err == nil {
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"iiot-go-api/config"
+	"iiot-go-api/utils"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxAPIKeyGraceHours bounds how long a rotated-out key keeps working.
+const maxAPIKeyGraceHours = 168
+
+// APIKeyHandler manages the API keys of the JWT tenant (machine access for
+// integrations such as the EMQX webhook).
+type APIKeyHandler struct {
+	DB     *pgxpool.Pool
+	Redis  *redis.Client
+	Config *config.Config
+}
+
+func NewAPIKeyHandler(db *pgxpool.Pool, redisClient *redis.Client, cfg *config.Config) *APIKeyHandler {
+	return &APIKeyHandler{
+		DB:     db,
+		Redis:  redisClient,
+		Config: cfg,
+	}
+}
+
+// APIKey is a tenant API key as shown to admins; the secret is only
+// returned by create and rotate.
+type APIKey struct {
+	KeyID       string     `json:"key_id"`
+	TenantID    string     `json:"tenant_id"`
+	Name        string     `json:"name"`
+	KeyPrefix   string     `json:"key_prefix"`
+	Scopes      []string   `json:"scopes"`
+	Status      string     `json:"status"`
+	CreatedBy   *string    `json:"created_by,omitempty"`
+	RotatedFrom *string    `json:"rotated_from,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+// APIKeySecretResponse carries a new key; Key is shown only this once.
+type APIKeySecretResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" validate:"required,min=2,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// RotateAPIKeyRequest: grace_period_hours (default 24, 0 revokes the old key
+// at once) is how long the old key keeps working.
+type RotateAPIKeyRequest struct {
+	GracePeriodHours *int `json:"grace_period_hours" validate:"omitempty,min=0,max=168"`
+}
+
+const apiKeyColumns = `key_id::text, tenant_id::text, name, key_prefix, scopes, status, user_id::text, rotated_from::text, last_used_at, expires_at, created_at, revoked_at`
+
+func scanAPIKey(row pgx.Row, k *APIKey) error {
+	return row.Scan(&k.KeyID, &k.TenantID, &k.Name, &k.KeyPrefix, &k.Scopes, &k.Status, &k.CreatedBy, &k.RotatedFrom, &k.LastUsedAt, &k.ExpiresAt, &k.CreatedAt, &k.RevokedAt)
+}
+
+// ListAPIKeys lists keys of the JWT tenant (active by default;
+// ?status=revoked|all widens the list). Keys past expires_at show as
+// "expired".
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	switch status {
+	case "":
+		status = "active"
+	case "active", "revoked", "all":
+	default:
+		utils.WriteError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(), `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE tenant_id = $1::uuid AND ($2 = 'all' OR status = $2)
+		ORDER BY created_at DESC
+	`, tenantID, status)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := scanAPIKey(rows, &k); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		k.Status = apiKeyStatus(k, now)
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"api_keys": keys})
+}
+
+// CreateAPIKey issues a key for the JWT tenant. Scopes are permission names
+// the caller holds (platform-only permissions are rejected).
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		utils.WriteError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+	req.Scopes = dedupeStrings(req.Scopes)
+	if missing := missingPermission(actorPermissions(r), req.Scopes); missing != "" {
+		utils.WriteError(w, http.StatusForbidden, "Cannot grant a permission you do not hold: "+missing)
+		return
+	}
+
+	ctx := r.Context()
+	if _, ok := grantablePermissionIDs(w, ctx, h.DB, req.Scopes); !ok {
+		return
+	}
+
+	created, secret, err := insertAPIKey(ctx, h.DB, tenantID, actorUserID, req.Name, req.Scopes, req.ExpiresAt, "")
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	recordAPIKeyEvent(h.DB, tenantID, actorUserID, created.KeyID, "api_key.created", "create_api_key", map[string]interface{}{
+		"name":       created.Name,
+		"key_prefix": created.KeyPrefix,
+		"scopes":     created.Scopes,
+		"expires_at": created.ExpiresAt,
+	})
+	utils.WriteJSON(w, http.StatusCreated, APIKeySecretResponse{APIKey: created, Key: secret})
+}
+
+// RevokeAPIKey revokes a key of the JWT tenant immediately, including its
+// cached validations.
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	keyID, ok := apiKeyIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	var k APIKey
+	err := scanAPIKey(h.DB.QueryRow(ctx, `
+		UPDATE api_keys SET status = 'revoked', revoked_at = NOW(), revoked_by = NULLIF($3,'')::uuid
+		WHERE key_id = $1::uuid AND tenant_id = $2::uuid AND status = 'active'
+		RETURNING `+apiKeyColumns,
+		keyID, tenantID, actorUserID), &k)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	h.purgeCache(ctx, keyID)
+
+	recordAPIKeyEvent(h.DB, tenantID, actorUserID, keyID, "api_key.revoked", "revoke_api_key", map[string]interface{}{
+		"name":       k.Name,
+		"key_prefix": k.KeyPrefix,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateAPIKey issues a replacement key with the same name, scopes and
+// expiry. The old key keeps working for the grace period, so integrations
+// can switch without downtime.
+func (h *APIKeyHandler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	keyID, ok := apiKeyIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req RotateAPIKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	grace := 24 * time.Hour
+	if req.GracePeriodHours != nil {
+		grace = time.Duration(*req.GracePeriodHours) * time.Hour
+	}
+
+	ctx := r.Context()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var old APIKey
+	err = scanAPIKey(tx.QueryRow(ctx, `
+		SELECT `+apiKeyColumns+` FROM api_keys
+		WHERE key_id = $1::uuid AND tenant_id = $2::uuid AND status = 'active'
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		FOR UPDATE
+	`, keyID, tenantID), &old)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if missing := missingPermission(actorPermissions(r), old.Scopes); missing != "" {
+		utils.WriteError(w, http.StatusForbidden, "Cannot grant a permission you do not hold: "+missing)
+		return
+	}
+
+	created, secret, err := insertAPIKey(ctx, tx, tenantID, actorUserID, old.Name, old.Scopes, old.ExpiresAt, old.KeyID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if grace == 0 {
+		_, err = tx.Exec(ctx, `
+			UPDATE api_keys SET status = 'revoked', revoked_at = NOW(), revoked_by = NULLIF($2,'')::uuid WHERE key_id = $1::uuid
+		`, keyID, actorUserID)
+	} else {
+		_, err = tx.Exec(ctx, `
+			UPDATE api_keys SET expires_at = LEAST(COALESCE(expires_at, $2), $2) WHERE key_id = $1::uuid
+		`, keyID, time.Now().Add(grace))
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	// Cached validations would outlive the shortened expiry.
+	h.purgeCache(ctx, keyID)
+
+	recordAPIKeyEvent(h.DB, tenantID, actorUserID, created.KeyID, "api_key.rotated", "rotate_api_key", map[string]interface{}{
+		"name":               created.Name,
+		"old_key_id":         old.KeyID,
+		"old_key_prefix":     old.KeyPrefix,
+		"new_key_prefix":     created.KeyPrefix,
+		"grace_period_hours": int64(grace.Hours()),
+	})
+	utils.WriteJSON(w, http.StatusCreated, APIKeySecretResponse{APIKey: created, Key: secret})
+}
+
+func (h *APIKeyHandler) purgeCache(ctx context.Context, keyID string) {
+	if h.Redis == nil {
+		return
+	}
+	if err := utils.PurgeAPIKeyCache(ctx, h.Redis, keyID); err != nil {
+		slog.Warn("api_key_cache_purge_failed", slog.String("key_id", keyID), slog.Any("error", err))
+	}
+}
+
+// insertAPIKey generates and stores a key (bcrypt hash only), returning the
+// row and the plaintext key.
+func insertAPIKey(ctx context.Context, q dbQueryRower, tenantID, userID, name string, scopes []string, expiresAt *time.Time, rotatedFrom string) (APIKey, string, error) {
+	var k APIKey
+	secret, prefix, err := utils.GenerateAPIKey()
+	if err != nil {
+		return k, "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcryptCost)
+	if err != nil {
+		return k, "", err
+	}
+	err = scanAPIKey(q.QueryRow(ctx, `
+		INSERT INTO api_keys (tenant_id, user_id, name, key_hash, key_prefix, scopes, expires_at, rotated_from)
+		VALUES ($1::uuid, NULLIF($2,'')::uuid, $3, $4, $5, $6, $7, NULLIF($8,'')::uuid)
+		RETURNING `+apiKeyColumns,
+		tenantID, userID, name, string(hash), prefix, scopes, expiresAt, rotatedFrom), &k)
+	return k, secret, err
+}
+
+// auditAPIKeyID is the API key behind the request, for audit attribution
+// ("" for JWT requests and background jobs).
+func auditAPIKeyID(ctx context.Context) string {
+	apiKeyID, _ := ctx.Value("api_key_id").(string)
+	return apiKeyID
+}
+
+func apiKeyStatus(k APIKey, now time.Time) string {
+	if k.Status == "active" && k.ExpiresAt != nil && !k.ExpiresAt.After(now) {
+		return "expired"
+	}
+	return k.Status
+}
+
+func apiKeyIDParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	keyID := r.PathValue("key_id")
+	if _, err := uuid.Parse(keyID); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid key_id")
+		return "", false
+	}
+	return keyID, true
+}
+
+func recordAPIKeyEvent(db *pgxpool.Pool, tenantID, userID, keyID, eventType, action string, metadata map[string]interface{}) {
+	if db == nil {
+		return
+	}
+	_, _ = db.Exec(context.Background(), `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, resource_type, resource_id, metadata, timestamp)
+		VALUES ($1::uuid, NULLIF($2,'')::uuid, $3, 'auth', 'info', 'user', NULLIF($2,'')::uuid, $4, 'success', 'api_key', $5::uuid, $6::jsonb, NOW())
+	`, tenantID, userID, eventType, action, keyID, toJSONB(metadata))
+}
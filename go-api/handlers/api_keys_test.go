@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateAPIKeyRejections(t *testing.T) {
+	t.Parallel()
+
+	h := &APIKeyHandler{}
+	cases := []struct {
+		name     string
+		tenantID string
+		body     string
+		status   int
+	}{
+		{"missing tenant", "", `{"name":"emqx","scopes":["telemetry:write"]}`, http.StatusUnauthorized},
+		{"no scopes", "tenant-1", `{"name":"emqx","scopes":[]}`, http.StatusBadRequest},
+		{"expired", "tenant-1", `{"name":"emqx","scopes":["telemetry:write"],"expires_at":"2000-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"scope not held", "tenant-1", `{"name":"emqx","scopes":["users:write"]}`, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/api-keys", strings.NewReader(c.body))
+		ctx := context.WithValue(req.Context(), "tenant_id", c.tenantID)
+		ctx = context.WithValue(ctx, "permissions", []string{"api_keys:write", "telemetry:write"})
+		w := httptest.NewRecorder()
+		h.CreateAPIKey(w, req.WithContext(ctx))
+		if w.Code != c.status {
+			t.Fatalf("%s: status = %d, want %d", c.name, w.Code, c.status)
+		}
+	}
+}
+
+func TestRotateAPIKeyValidation(t *testing.T) {
+	t.Parallel()
+
+	h := &APIKeyHandler{}
+	cases := []struct {
+		name  string
+		keyID string
+		body  string
+	}{
+		{"invalid key id", "not-a-uuid", ""},
+		{"grace too long", "7f1f8f3e-9d7c-4c1a-8a43-2a4d8c0c1e11", `{"grace_period_hours":1000}`},
+		{"negative grace", "7f1f8f3e-9d7c-4c1a-8a43-2a4d8c0c1e11", `{"grace_period_hours":-1}`},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/api-keys/"+c.keyID+"/rotate", strings.NewReader(c.body))
+		req.SetPathValue("key_id", c.keyID)
+		req = req.WithContext(context.WithValue(req.Context(), "tenant_id", "tenant-1"))
+		w := httptest.NewRecorder()
+		h.RotateAPIKey(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want %d", c.name, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAPIKeyStatus(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	if got := apiKeyStatus(APIKey{Status: "active", ExpiresAt: &past}, now); got != "expired" {
+		t.Fatalf("status = %q, want expired", got)
+	}
+	if got := apiKeyStatus(APIKey{Status: "active", ExpiresAt: &future}, now); got != "active" {
+		t.Fatalf("status = %q, want active", got)
+	}
+	if got := apiKeyStatus(APIKey{Status: "revoked", ExpiresAt: &past}, now); got != "revoked" {
+		t.Fatalf("status = %q, want revoked", got)
+	}
+}
@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iiot-go-api/config"
+	"iiot-go-api/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+	// maxAuditExportRows caps one export; narrow the time range for more.
+	maxAuditExportRows = 100000
+)
+
+// AuditHandler reads audit_log: tenant admins (and API keys with
+// audit:read) see their tenant, super admins every tenant.
+type AuditHandler struct {
+	DB     *pgxpool.Pool
+	Config *config.Config
+}
+
+func NewAuditHandler(db *pgxpool.Pool, cfg *config.Config) *AuditHandler {
+	return &AuditHandler{
+		DB:     db,
+		Config: cfg,
+	}
+}
+
+// AuditEntry is one audit_log row.
+type AuditEntry struct {
+	AuditID        int64           `json:"audit_id"`
+	TenantID       *string         `json:"tenant_id,omitempty"`
+	UserID         *string         `json:"user_id,omitempty"`
+	DeviceID       *string         `json:"device_id,omitempty"`
+	APIKeyID       *string         `json:"api_key_id,omitempty"`
+	EventType      string          `json:"event_type"`
+	EventCategory  string          `json:"event_category"`
+	Severity       string          `json:"severity"`
+	ActorType      string          `json:"actor_type"`
+	ActorID        *string         `json:"actor_id,omitempty"`
+	ResourceType   *string         `json:"resource_type,omitempty"`
+	ResourceID     *string         `json:"resource_id,omitempty"`
+	Action         string          `json:"action"`
+	Result         string          `json:"result"`
+	IPAddress      *string         `json:"ip_address,omitempty"`
+	UserAgent      *string         `json:"user_agent,omitempty"`
+	ErrorCode      *string         `json:"error_code,omitempty"`
+	ErrorMessage   *string         `json:"error_message,omitempty"`
+	RequestPath    *string         `json:"request_path,omitempty"`
+	RequestMethod  *string         `json:"request_method,omitempty"`
+	ResponseStatus *int32          `json:"response_status,omitempty"`
+	DurationMS     *int32          `json:"duration_ms,omitempty"`
+	Metadata       json.RawMessage `json:"metadata,omitempty"`
+	Timestamp      time.Time       `json:"timestamp"`
+	ChainSeq       *int64          `json:"chain_seq,omitempty"`
+	RowHash        *string         `json:"row_hash,omitempty"`
+	RedactedAt     *time.Time      `json:"redacted_at,omitempty"`
+}
+
+const auditColumns = `audit_id, tenant_id::text, user_id::text, device_id::text, api_key_id::text, event_type, event_category, severity,
+	actor_type, actor_id::text, resource_type, resource_id::text, action, result, host(ip_address), user_agent,
+	error_code, error_message, request_path, request_method, response_status, duration_ms, COALESCE(metadata, '{}'::jsonb), timestamp,
+	chain_seq, row_hash, redacted_at`
+
+func scanAuditEntry(row pgx.Row, e *AuditEntry) error {
+	var metadata []byte
+	err := row.Scan(&e.AuditID, &e.TenantID, &e.UserID, &e.DeviceID, &e.APIKeyID, &e.EventType, &e.EventCategory, &e.Severity,
+		&e.ActorType, &e.ActorID, &e.ResourceType, &e.ResourceID, &e.Action, &e.Result, &e.IPAddress, &e.UserAgent,
+		&e.ErrorCode, &e.ErrorMessage, &e.RequestPath, &e.RequestMethod, &e.ResponseStatus, &e.DurationMS, &metadata, &e.Timestamp,
+		&e.ChainSeq, &e.RowHash, &e.RedactedAt)
+	e.Metadata = metadata
+	return err
+}
+
+// auditFilter holds the query string filters; empty strings and nil times
+// mean "any".
+type auditFilter struct {
+	TenantID  string
+	EventType string
+	Category  string
+	Severity  string
+	ActorType string
+	ActorID   string
+	DeviceID  string
+	From      *time.Time
+	To        *time.Time
+	Cursor    *auditCursor
+	Limit     int
+}
+
+// auditCursor points at the last row of a page (newest first).
+type auditCursor struct {
+	Timestamp time.Time
+	AuditID   int64
+}
+
+func (c auditCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.Timestamp.UTC().Format(time.RFC3339Nano) + "|" + strconv.FormatInt(c.AuditID, 10)))
+}
+
+func decodeAuditCursor(s string) (*auditCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errors.New("malformed cursor")
+	}
+	c := &auditCursor{}
+	if c.Timestamp, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+		return nil, err
+	}
+	if c.AuditID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseAuditFilter reads the filters and scopes them to the caller: only a
+// super admin may omit the tenant or pick another one with ?tenant_id.
+func parseAuditFilter(r *http.Request, defaultLimit, maxLimit int) (auditFilter, int, string) {
+	q := r.URL.Query()
+	f := auditFilter{
+		EventType: strings.TrimSpace(q.Get("event_type")),
+		Category:  strings.TrimSpace(q.Get("category")),
+		Severity:  strings.TrimSpace(q.Get("severity")),
+		ActorType: strings.TrimSpace(q.Get("actor_type")),
+		ActorID:   strings.TrimSpace(q.Get("actor_id")),
+		DeviceID:  strings.TrimSpace(q.Get("device_id")),
+		Limit:     defaultLimit,
+	}
+
+	var status int
+	var msg string
+	if f.TenantID, status, msg = auditTenantScope(r); status != 0 {
+		return f, status, msg
+	}
+
+	for name, value := range map[string]string{"tenant_id": f.TenantID, "actor_id": f.ActorID, "device_id": f.DeviceID} {
+		if value == "" {
+			continue
+		}
+		if _, err := uuid.Parse(value); err != nil {
+			return f, http.StatusBadRequest, "Invalid " + name
+		}
+	}
+	for name, target := range map[string]**time.Time{"from": &f.From, "to": &f.To} {
+		value := strings.TrimSpace(q.Get(name))
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return f, http.StatusBadRequest, "Invalid " + name + " (RFC 3339 expected)"
+		}
+		*target = &t
+	}
+	if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+		return f, http.StatusBadRequest, "from must be before to"
+	}
+	if cursor := strings.TrimSpace(q.Get("cursor")); cursor != "" {
+		c, err := decodeAuditCursor(cursor)
+		if err != nil {
+			return f, http.StatusBadRequest, "Invalid cursor"
+		}
+		f.Cursor = c
+	}
+	if limit := strings.TrimSpace(q.Get("limit")); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxLimit {
+			return f, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxLimit)
+		}
+		f.Limit = n
+	}
+	return f, 0, ""
+}
+
+// auditTenantScope is the tenant the caller may read: their own, or for a
+// super admin the ?tenant_id given (empty meaning all tenants).
+func auditTenantScope(r *http.Request) (string, int, string) {
+	tenantID, _ := r.Context().Value("tenant_id").(string)
+	role, _ := r.Context().Value("role").(string)
+	if role == "super_admin" {
+		return strings.TrimSpace(r.URL.Query().Get("tenant_id")), 0, ""
+	}
+	if tenantID == "" {
+		return "", http.StatusUnauthorized, "Missing tenant context"
+	}
+	return tenantID, 0, ""
+}
+
+// queryAudit runs the filtered query newest first, keyset-paginated on
+// (timestamp, audit_id). Only the filters in use reach the SQL, so tenant
+// queries stay on idx_audit_tenant.
+func queryAudit(ctx context.Context, db *pgxpool.Pool, f auditFilter, limit int) (pgx.Rows, error) {
+	where, args := auditWhere(f)
+	args = append(args, limit)
+	return db.Query(ctx, `
+		SELECT `+auditColumns+`
+		FROM audit_log
+		WHERE `+where+`
+		ORDER BY timestamp DESC, audit_id DESC
+		LIMIT $`+strconv.Itoa(len(args)), args...)
+}
+
+func auditWhere(f auditFilter) (string, []interface{}) {
+	conds := []string{"TRUE"}
+	args := []interface{}{}
+	add := func(cond string, values ...interface{}) {
+		for _, v := range values {
+			args = append(args, v)
+			cond = strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1)
+		}
+		conds = append(conds, cond)
+	}
+	if f.TenantID != "" {
+		add("tenant_id = ?::uuid", f.TenantID)
+	}
+	if f.EventType != "" {
+		add("event_type = ?", f.EventType)
+	}
+	if f.Category != "" {
+		add("event_category = ?", f.Category)
+	}
+	if f.Severity != "" {
+		add("severity = ?", f.Severity)
+	}
+	if f.ActorType != "" {
+		add("actor_type = ?", f.ActorType)
+	}
+	if f.ActorID != "" {
+		add("?::uuid IN (actor_id, user_id, api_key_id)", f.ActorID)
+	}
+	if f.DeviceID != "" {
+		add("device_id = ?::uuid", f.DeviceID)
+	}
+	if f.From != nil {
+		add("timestamp >= ?", *f.From)
+	}
+	if f.To != nil {
+		add("timestamp < ?", *f.To)
+	}
+	if f.Cursor != nil {
+		add("(timestamp, audit_id) < (?, ?)", f.Cursor.Timestamp, f.Cursor.AuditID)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// ListAudit returns one page of audit entries; pass next_cursor back as
+// ?cursor= for the next (older) page.
+func (h *AuditHandler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	f, status, msg := parseAuditFilter(r, defaultAuditPageSize, maxAuditPageSize)
+	if status != 0 {
+		utils.WriteError(w, status, msg)
+		return
+	}
+
+	rows, err := queryAudit(r.Context(), h.DB, f, f.Limit+1)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := scanAuditEntry(rows, &e); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	var nextCursor *string
+	if len(entries) > f.Limit {
+		entries = entries[:f.Limit]
+		last := entries[len(entries)-1]
+		next := auditCursor{Timestamp: last.Timestamp, AuditID: last.AuditID}.encode()
+		nextCursor = &next
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"entries":     entries,
+		"next_cursor": nextCursor,
+	})
+}
+
+var auditCSVHeader = []string{
+	"audit_id", "timestamp", "tenant_id", "event_type", "event_category", "severity", "actor_type", "actor_id",
+	"user_id", "api_key_id", "device_id", "resource_type", "resource_id", "action", "result", "ip_address",
+	"user_agent", "request_method", "request_path", "response_status", "duration_ms", "error_code", "error_message", "metadata",
+	"chain_seq", "row_hash", "redacted_at",
+}
+
+func (e AuditEntry) csvRecord() []string {
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	num := func(n *int32) string {
+		if n == nil {
+			return ""
+		}
+		return strconv.Itoa(int(*n))
+	}
+	seq := ""
+	if e.ChainSeq != nil {
+		seq = strconv.FormatInt(*e.ChainSeq, 10)
+	}
+	redacted := ""
+	if e.RedactedAt != nil {
+		redacted = e.RedactedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return []string{
+		strconv.FormatInt(e.AuditID, 10), e.Timestamp.UTC().Format(time.RFC3339Nano), str(e.TenantID), e.EventType, e.EventCategory,
+		e.Severity, e.ActorType, str(e.ActorID), str(e.UserID), str(e.APIKeyID), str(e.DeviceID), str(e.ResourceType),
+		str(e.ResourceID), e.Action, e.Result, str(e.IPAddress), str(e.UserAgent), str(e.RequestMethod), str(e.RequestPath),
+		num(e.ResponseStatus), num(e.DurationMS), str(e.ErrorCode), str(e.ErrorMessage), string(e.Metadata),
+		seq, str(e.RowHash), redacted,
+	}
+}
+
+// ExportAudit streams the filtered entries (newest first, up to
+// maxAuditExportRows or ?limit) as CSV (default) or NDJSON
+// (?format=ndjson). The export itself is audited.
+func (h *AuditHandler) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	format := strings.TrimSpace(r.URL.Query().Get("format"))
+	switch format {
+	case "":
+		format = "csv"
+	case "csv", "ndjson":
+	default:
+		utils.WriteError(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+	f, status, msg := parseAuditFilter(r, maxAuditExportRows, maxAuditExportRows)
+	if status != 0 {
+		utils.WriteError(w, status, msg)
+		return
+	}
+
+	rows, err := queryAudit(r.Context(), h.DB, f, f.Limit)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer rows.Close()
+
+	filename := "audit-" + time.Now().UTC().Format("20060102T150405Z") + "." + format
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	// Headers are sent: a failure past this point can only cut the stream.
+	exported := 0
+	csvWriter := csv.NewWriter(w)
+	encoder := json.NewEncoder(w)
+	if format == "csv" {
+		_ = csvWriter.Write(auditCSVHeader)
+	}
+	for rows.Next() {
+		var e AuditEntry
+		if err := scanAuditEntry(rows, &e); err != nil {
+			break
+		}
+		if format == "csv" {
+			err = csvWriter.Write(e.csvRecord())
+		} else {
+			err = encoder.Encode(e)
+		}
+		if err != nil {
+			break
+		}
+		exported++
+	}
+	csvWriter.Flush()
+
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	recordAuditExport(r.Context(), h.DB, f, actorUserID, format, exported)
+}
+
+func recordAuditExport(ctx context.Context, db *pgxpool.Pool, f auditFilter, userID, format string, rows int) {
+	if db == nil {
+		return
+	}
+	apiKeyID := auditAPIKeyID(ctx)
+	actorType := "user"
+	if apiKeyID != "" {
+		actorType = "api_key"
+	}
+	_, _ = db.Exec(context.Background(), `
+		INSERT INTO audit_log (tenant_id, user_id, api_key_id, event_type, event_category, severity, actor_type, actor_id, action, result, metadata, timestamp)
+		VALUES (NULLIF($1,'')::uuid, NULLIF($2,'')::uuid, NULLIF($3,'')::uuid, 'audit.exported', 'security', 'info', $4, COALESCE(NULLIF($2,''), NULLIF($3,''))::uuid, 'export_audit', 'success', $5::jsonb, NOW())
+	`, f.TenantID, userID, apiKeyID, actorType, toJSONB(map[string]interface{}{
+		"format":     format,
+		"rows":       rows,
+		"event_type": f.EventType,
+		"category":   f.Category,
+		"severity":   f.Severity,
+		"from":       f.From,
+		"to":         f.To,
+	}))
+}
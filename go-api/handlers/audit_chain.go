@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"iiot-go-api/config"
+	"iiot-go-api/utils"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// auditCheckpointLockKey is the pg advisory lock held by the replica
+// writing audit checkpoints.
+const auditCheckpointLockKey = 1005
+
+// maxAuditVerifyRows caps one verification; continue from next_from_seq.
+const maxAuditVerifyRows = 100000
+
+// auditChainKey is the chain of a tenant: its id, or the nil UUID for the
+// platform chain (rows without a tenant). Must match migration 022.
+func auditChainKey(tenantID string) string {
+	if tenantID == "" {
+		return uuid.Nil.String()
+	}
+	return tenantID
+}
+
+func auditCheckpointKey(cfg *config.Config) string {
+	if cfg.AuditCheckpointKey != "" {
+		return cfg.AuditCheckpointKey
+	}
+	return "auditchain:" + cfg.JWTSecret
+}
+
+// RunAuditCheckpoints signs the head of every audit chain that grew since
+// its last checkpoint, each interval. Only the replica holding the advisory
+// lock does work.
+func RunAuditCheckpoints(ctx context.Context, db *pgxpool.Pool, cfg *config.Config, interval time.Duration) {
+	if db == nil || interval <= 0 {
+		return
+	}
+	run := func() {
+		_, err := withAdvisoryLock(ctx, db, auditCheckpointLockKey, func() error {
+			return writeAuditCheckpoints(ctx, db, auditCheckpointKey(cfg), time.Now().UTC())
+		})
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("audit_checkpoint_error", slog.Any("error", err))
+		}
+	}
+
+	run()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run()
+		}
+	}
+}
+
+func writeAuditCheckpoints(ctx context.Context, db *pgxpool.Pool, key string, now time.Time) error {
+	type head struct {
+		chainKey string
+		seq      int64
+		hash     string
+	}
+	rows, err := db.Query(ctx, `
+		SELECT h.chain_key::text, h.last_seq, h.last_hash
+		FROM audit_chain_heads h
+		WHERE h.last_seq > COALESCE((SELECT MAX(c.chain_seq) FROM audit_checkpoints c WHERE c.chain_key = h.chain_key), 0)
+	`)
+	if err != nil {
+		return err
+	}
+	heads := []head{}
+	for rows.Next() {
+		var h head
+		if err := rows.Scan(&h.chainKey, &h.seq, &h.hash); err != nil {
+			rows.Close()
+			return err
+		}
+		heads = append(heads, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	keyID := utils.AuditCheckpointKeyID(key)
+	at := now.Truncate(time.Microsecond)
+	for _, h := range heads {
+		if _, err := db.Exec(ctx, `
+			INSERT INTO audit_checkpoints (chain_key, chain_seq, row_hash, key_id, signature, created_at)
+			VALUES ($1::uuid, $2, $3, $4, $5, $6)
+			ON CONFLICT (chain_key, chain_seq) DO NOTHING
+		`, h.chainKey, h.seq, h.hash, keyID, utils.SignAuditCheckpoint(key, h.chainKey, h.seq, h.hash, at), at); err != nil {
+			return err
+		}
+	}
+	if len(heads) > 0 {
+		slog.Info("audit_checkpoints_written", slog.Int("chains", len(heads)))
+	}
+	return nil
+}
+
+// auditChainRow is one chained audit_log row; Computed is the hash of its
+// current content (audit_row_hash). Redacted rows (LGPD erasure) no longer
+// match row_hash; their content is checked against RedactedHash and the
+// hash recorded by the chain's privacy.erasure_completed row.
+type auditChainRow struct {
+	Seq          int64
+	AuditID      int64
+	PrevHash     string
+	RowHash      string
+	Computed     string
+	Redacted     bool
+	RedactedHash string
+}
+
+type auditCheckpoint struct {
+	Seq       int64
+	RowHash   string
+	KeyID     string
+	Signature string
+	CreatedAt time.Time
+}
+
+// AuditChainBreak is the first link that does not verify:
+//   - missing_row: the row at chain_seq was deleted;
+//   - prev_hash_mismatch: the row does not follow the previous one;
+//   - hash_mismatch: the row was edited after it was written;
+//   - redaction_unrecorded: the row is marked redacted but no erasure
+//     record of the chain lists it;
+//   - redaction_mismatch: the redacted row was edited after the erasure;
+//   - checkpoint_mismatch: the chain was rewritten after a checkpoint;
+//   - checkpoint_signature_invalid: the checkpoint itself was altered.
+type AuditChainBreak struct {
+	ChainSeq int64  `json:"chain_seq"`
+	AuditID  *int64 `json:"audit_id,omitempty"`
+	Reason   string `json:"reason"`
+	Expected string `json:"expected,omitempty"`
+	Found    string `json:"found,omitempty"`
+}
+
+// auditChainWalk verifies rows fed in chain_seq order, starting at from. A
+// row before from (the anchor) only provides the previous hash.
+type auditChainWalk struct {
+	key         string
+	keyID       string
+	chainKey    string
+	checkpoints map[int64]auditCheckpoint
+	redactions  map[int64]string
+
+	next      int64
+	prevHash  string
+	prevKnown bool
+
+	Rows               int
+	RowsRedacted       int
+	CheckpointsChecked int
+	CheckpointsSkipped int
+	Break              *AuditChainBreak
+}
+
+// redactions maps audit_id to the redacted-content hash recorded by the
+// chain's erasure records (loadAuditRedactions).
+func newAuditChainWalk(key, chainKey string, from int64, checkpoints []auditCheckpoint, redactions map[int64]string) *auditChainWalk {
+	w := &auditChainWalk{
+		key:         key,
+		keyID:       utils.AuditCheckpointKeyID(key),
+		chainKey:    chainKey,
+		checkpoints: map[int64]auditCheckpoint{},
+		redactions:  redactions,
+		next:        from,
+		prevKnown:   from == 1,
+	}
+	for _, cp := range checkpoints {
+		w.checkpoints[cp.Seq] = cp
+	}
+	return w
+}
+
+// add checks one row and reports whether the walk should go on.
+func (w *auditChainWalk) add(r auditChainRow) bool {
+	if w.Break != nil {
+		return false
+	}
+	if r.Seq < w.next {
+		w.prevHash, w.prevKnown = r.RowHash, true
+		return true
+	}
+	auditID := r.AuditID
+	fail := func(reason, expected, found string) bool {
+		w.Break = &AuditChainBreak{ChainSeq: r.Seq, AuditID: &auditID, Reason: reason, Expected: expected, Found: found}
+		return false
+	}
+	if r.Seq != w.next {
+		w.Break = &AuditChainBreak{ChainSeq: w.next, Reason: "missing_row"}
+		return false
+	}
+	if w.prevKnown && r.PrevHash != w.prevHash {
+		return fail("prev_hash_mismatch", w.prevHash, r.PrevHash)
+	}
+	if r.Redacted {
+		recorded, ok := w.redactions[r.AuditID]
+		if !ok {
+			return fail("redaction_unrecorded", "", r.Computed)
+		}
+		if r.Computed != recorded || r.RedactedHash != recorded {
+			return fail("redaction_mismatch", recorded, r.Computed)
+		}
+		w.RowsRedacted++
+	} else if r.Computed != r.RowHash {
+		return fail("hash_mismatch", r.RowHash, r.Computed)
+	}
+	if cp, ok := w.checkpoints[r.Seq]; ok {
+		switch {
+		case cp.KeyID != w.keyID:
+			w.CheckpointsSkipped++
+		case !utils.VerifyAuditCheckpoint(w.key, w.chainKey, cp.Seq, cp.RowHash, cp.CreatedAt, cp.Signature):
+			return fail("checkpoint_signature_invalid", "", "")
+		case cp.RowHash != r.RowHash:
+			return fail("checkpoint_mismatch", cp.RowHash, r.RowHash)
+		default:
+			w.CheckpointsChecked++
+		}
+	}
+	w.Rows++
+	w.prevHash, w.prevKnown = r.RowHash, true
+	w.next++
+	return true
+}
+
+// finish reports the rows missing at the end of the range.
+func (w *auditChainWalk) finish(to int64) {
+	if w.Break == nil && w.next <= to {
+		w.Break = &AuditChainBreak{ChainSeq: w.next, Reason: "missing_row"}
+	}
+}
+
+// parseAuditVerifyRange reads ?from_seq and ?to_seq (0 when absent).
+func parseAuditVerifyRange(r *http.Request) (int64, int64, int, string) {
+	var bounds [2]int64
+	for i, name := range []string{"from_seq", "to_seq"} {
+		value := strings.TrimSpace(r.URL.Query().Get(name))
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 1 {
+			return 0, 0, http.StatusBadRequest, name + " must be a positive integer"
+		}
+		bounds[i] = n
+	}
+	if bounds[0] != 0 && bounds[1] != 0 && bounds[0] > bounds[1] {
+		return 0, 0, http.StatusBadRequest, "from_seq must not be after to_seq"
+	}
+	return bounds[0], bounds[1], 0, ""
+}
+
+// VerifyAudit re-walks the caller's audit chain between ?from_seq (default
+// 1) and ?to_seq (default the head), up to maxAuditVerifyRows rows, and
+// reports the first broken link. A super admin verifies ?tenant_id, or the
+// platform chain without it.
+func (h *AuditHandler) VerifyAudit(w http.ResponseWriter, r *http.Request) {
+	tenantID, status, msg := auditTenantScope(r)
+	if status != 0 {
+		utils.WriteError(w, status, msg)
+		return
+	}
+	if tenantID != "" {
+		if _, err := uuid.Parse(tenantID); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "Invalid tenant_id")
+			return
+		}
+	}
+	from, to, status, msg := parseAuditVerifyRange(r)
+	if status != 0 {
+		utils.WriteError(w, status, msg)
+		return
+	}
+	ctx := r.Context()
+	chainKey := auditChainKey(tenantID)
+
+	// The head is the furthest of the chain head and its last checkpoint, so
+	// rows deleted together with a rewound head are still reported.
+	var head int64
+	if err := h.DB.QueryRow(ctx, `
+		SELECT GREATEST(
+			COALESCE((SELECT last_seq FROM audit_chain_heads WHERE chain_key = $1::uuid), 0),
+			COALESCE((SELECT MAX(chain_seq) FROM audit_checkpoints WHERE chain_key = $1::uuid), 0))
+	`, chainKey).Scan(&head); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if from == 0 {
+		from = 1
+	}
+	if to == 0 || to > head {
+		to = head
+	}
+	complete := true
+	if to-from+1 > maxAuditVerifyRows {
+		to = from + maxAuditVerifyRows - 1
+		complete = false
+	}
+
+	var checkpoints []auditCheckpoint
+	if from <= to {
+		var err error
+		if checkpoints, err = loadAuditCheckpoints(ctx, h.DB, chainKey, from, to); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+	}
+	var redactions map[int64]string
+	if from <= to {
+		var err error
+		if redactions, err = loadAuditRedactions(ctx, h.DB, chainKey, from); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+	}
+	walk := newAuditChainWalk(auditCheckpointKey(h.Config), chainKey, from, checkpoints, redactions)
+	if from <= to {
+		rows, err := h.DB.Query(ctx, `
+			SELECT a.chain_seq, a.audit_id, COALESCE(a.prev_hash, ''), COALESCE(a.row_hash, ''), audit_row_hash(a),
+			       a.redacted_at IS NOT NULL, COALESCE(a.redacted_hash, '')
+			FROM audit_log a
+			WHERE COALESCE(a.tenant_id, '00000000-0000-0000-0000-000000000000'::uuid) = $1::uuid
+			  AND a.chain_seq BETWEEN $2 AND $3
+			ORDER BY a.chain_seq
+		`, chainKey, from-1, to)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		for rows.Next() {
+			var row auditChainRow
+			if err := rows.Scan(&row.Seq, &row.AuditID, &row.PrevHash, &row.RowHash, &row.Computed, &row.Redacted, &row.RedactedHash); err != nil {
+				rows.Close()
+				utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+				return
+			}
+			if !walk.add(row) {
+				break
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		walk.finish(to)
+	}
+
+	var tenant, nextFrom interface{}
+	if tenantID != "" {
+		tenant = tenantID
+	}
+	if !complete && walk.Break == nil {
+		nextFrom = to + 1
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"tenant_id":           tenant,
+		"from_seq":            from,
+		"to_seq":              to,
+		"head_seq":            head,
+		"rows_checked":        walk.Rows,
+		"rows_redacted":       walk.RowsRedacted,
+		"checkpoints_checked": walk.CheckpointsChecked,
+		"checkpoints_skipped": walk.CheckpointsSkipped,
+		"valid":               walk.Break == nil,
+		"complete":            complete,
+		"next_from_seq":       nextFrom,
+		"first_broken":        walk.Break,
+	})
+}
+
+// loadAuditRedactions reads the redacted-content hashes listed by the
+// chain's privacy.erasure_completed rows from seq on (a record always
+// follows the rows it lists); a later record wins for a row redacted twice.
+func loadAuditRedactions(ctx context.Context, db *pgxpool.Pool, chainKey string, from int64) (map[int64]string, error) {
+	rows, err := db.Query(ctx, `
+		SELECT metadata->'redacted'
+		FROM audit_log
+		WHERE COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'::uuid) = $1::uuid
+		  AND chain_seq >= $2
+		  AND event_type = 'privacy.erasure_completed'
+		  AND jsonb_typeof(metadata->'redacted') = 'object'
+		ORDER BY chain_seq
+	`, chainKey, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	redactions := map[int64]string{}
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var recorded map[string]string
+		if err := json.Unmarshal(raw, &recorded); err != nil {
+			return nil, err
+		}
+		for id, hash := range recorded {
+			if auditID, err := strconv.ParseInt(id, 10, 64); err == nil {
+				redactions[auditID] = hash
+			}
+		}
+	}
+	return redactions, rows.Err()
+}
+
+func loadAuditCheckpoints(ctx context.Context, db *pgxpool.Pool, chainKey string, from, to int64) ([]auditCheckpoint, error) {
+	rows, err := db.Query(ctx, `
+		SELECT chain_seq, row_hash, key_id, signature, created_at
+		FROM audit_checkpoints
+		WHERE chain_key = $1::uuid AND chain_seq BETWEEN $2 AND $3
+	`, chainKey, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := []auditCheckpoint{}
+	for rows.Next() {
+		var cp auditCheckpoint
+		if err := rows.Scan(&cp.Seq, &cp.RowHash, &cp.KeyID, &cp.Signature, &cp.CreatedAt); err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	return checkpoints, rows.Err()
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"iiot-go-api/utils"
+)
+
+const testChainKey = "7f1f8f3e-9d7c-4c1a-8a43-2a4d8c0c1e11"
+
+// testChain builds n consistent rows starting at seq 1.
+func testChain(n int) []auditChainRow {
+	rows := make([]auditChainRow, n)
+	prev := ""
+	for i := range rows {
+		hash := "h" + strconv.Itoa(i+1)
+		rows[i] = auditChainRow{Seq: int64(i + 1), AuditID: int64(100 + i), PrevHash: prev, RowHash: hash, Computed: hash}
+		prev = hash
+	}
+	return rows
+}
+
+// walkChain verifies rows; redacted rows with a RedactedHash are listed in
+// the erasure record, as eraseUserData writes it.
+func walkChain(from, to int64, rows []auditChainRow, checkpoints ...auditCheckpoint) *auditChainWalk {
+	redactions := map[int64]string{}
+	for _, r := range rows {
+		if r.Redacted && r.RedactedHash != "" {
+			redactions[r.AuditID] = r.RedactedHash
+		}
+	}
+	w := newAuditChainWalk("checkpoint-key", testChainKey, from, checkpoints, redactions)
+	for _, r := range rows {
+		if r.Seq < from-1 || r.Seq > to {
+			continue
+		}
+		if !w.add(r) {
+			break
+		}
+	}
+	w.finish(to)
+	return w
+}
+
+func signedCheckpoint(key string, seq int64, hash string) auditCheckpoint {
+	at := time.Now().UTC().Truncate(time.Microsecond)
+	return auditCheckpoint{
+		Seq:       seq,
+		RowHash:   hash,
+		KeyID:     utils.AuditCheckpointKeyID(key),
+		Signature: utils.SignAuditCheckpoint(key, testChainKey, seq, hash, at),
+		CreatedAt: at,
+	}
+}
+
+func TestAuditChainWalkValid(t *testing.T) {
+	t.Parallel()
+
+	rows := testChain(5)
+	w := walkChain(1, 5, rows, signedCheckpoint("checkpoint-key", 3, "h3"), signedCheckpoint("old-key", 5, "h5"))
+	if w.Break != nil || w.Rows != 5 || w.CheckpointsChecked != 1 || w.CheckpointsSkipped != 1 {
+		t.Fatalf("walk = %+v (break %+v), want 5 valid rows, 1 checked and 1 skipped checkpoint", w, w.Break)
+	}
+
+	// Erased (redacted) rows no longer match row_hash; they match the hash
+	// recorded by the erasure.
+	redacted := testChain(5)
+	redacted[1].Computed, redacted[1].Redacted, redacted[1].RedactedHash = "scrubbed", true, "scrubbed"
+	if w := walkChain(1, 5, redacted); w.Break != nil || w.RowsRedacted != 1 {
+		t.Fatalf("redacted row: rows redacted %d, break %+v", w.RowsRedacted, w.Break)
+	}
+
+	// A range starting mid-chain links to the row before it.
+	if w := walkChain(3, 5, rows); w.Break != nil || w.Rows != 3 {
+		t.Fatalf("partial walk: rows %d, break %+v", w.Rows, w.Break)
+	}
+}
+
+func TestAuditChainWalkChecksRedactedRows(t *testing.T) {
+	t.Parallel()
+
+	// Edited after the erasure: the content no longer matches the record.
+	edited := testChain(5)
+	edited[1].Computed, edited[1].Redacted, edited[1].RedactedHash = "edited", true, "scrubbed"
+	if w := walkChain(1, 5, edited); w.Break == nil || w.Break.Reason != "redaction_mismatch" || w.Break.ChainSeq != 2 || w.Break.Expected != "scrubbed" {
+		t.Fatalf("edited redacted row: break = %+v", w.Break)
+	}
+
+	// redacted_hash rewritten to match the edit; the chained record still holds.
+	rewritten := testChain(5)
+	rewritten[1].Computed, rewritten[1].Redacted, rewritten[1].RedactedHash = "edited", true, "edited"
+	w := newAuditChainWalk("checkpoint-key", testChainKey, 1, nil, map[int64]string{101: "scrubbed"})
+	for _, r := range rewritten {
+		if !w.add(r) {
+			break
+		}
+	}
+	if w.Break == nil || w.Break.Reason != "redaction_mismatch" {
+		t.Fatalf("rewritten redacted_hash: break = %+v", w.Break)
+	}
+
+	// Marked redacted without any erasure record listing it.
+	unrecorded := testChain(5)
+	unrecorded[3].Computed, unrecorded[3].Redacted = "scrubbed", true
+	if w := walkChain(1, 5, unrecorded); w.Break == nil || w.Break.Reason != "redaction_unrecorded" || w.Break.ChainSeq != 4 {
+		t.Fatalf("unrecorded redaction: break = %+v", w.Break)
+	}
+}
+
+func TestAuditChainWalkReportsFirstBreak(t *testing.T) {
+	t.Parallel()
+
+	edited := testChain(5)
+	edited[2].Computed = "tampered"
+	if w := walkChain(1, 5, edited); w.Break == nil || w.Break.Reason != "hash_mismatch" || w.Break.ChainSeq != 3 || *w.Break.AuditID != 102 {
+		t.Fatalf("edited row: break = %+v", w.Break)
+	}
+
+	deleted := append(testChain(5)[:1], testChain(5)[2:]...)
+	if w := walkChain(1, 5, deleted); w.Break == nil || w.Break.Reason != "missing_row" || w.Break.ChainSeq != 2 {
+		t.Fatalf("deleted row: break = %+v", w.Break)
+	}
+
+	truncated := testChain(3)
+	if w := walkChain(1, 5, truncated); w.Break == nil || w.Break.Reason != "missing_row" || w.Break.ChainSeq != 4 {
+		t.Fatalf("truncated chain: break = %+v", w.Break)
+	}
+
+	// Row 3 replaced by a self-consistent row that does not link to row 2.
+	relinked := testChain(5)
+	relinked[2].PrevHash = "forged"
+	if w := walkChain(2, 5, relinked); w.Break == nil || w.Break.Reason != "prev_hash_mismatch" || w.Break.ChainSeq != 3 {
+		t.Fatalf("relinked row: break = %+v", w.Break)
+	}
+
+	// A fully recomputed chain still contradicts the signed checkpoint.
+	rewritten := testChain(5)
+	if w := walkChain(1, 5, rewritten, signedCheckpoint("checkpoint-key", 4, "h4-original")); w.Break == nil || w.Break.Reason != "checkpoint_mismatch" || w.Break.ChainSeq != 4 {
+		t.Fatalf("rewritten chain: break = %+v", w.Break)
+	}
+
+	forged := signedCheckpoint("checkpoint-key", 2, "h2")
+	forged.Signature = utils.SignAuditCheckpoint("guessed-key", testChainKey, 2, "h2", forged.CreatedAt)
+	if w := walkChain(1, 5, testChain(5), forged); w.Break == nil || w.Break.Reason != "checkpoint_signature_invalid" || w.Break.ChainSeq != 2 {
+		t.Fatalf("forged checkpoint: break = %+v", w.Break)
+	}
+}
+
+func TestParseAuditVerifyRange(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/audit/verify?from_seq=10&to_seq=20", nil)
+	if from, to, status, _ := parseAuditVerifyRange(req); status != 0 || from != 10 || to != 20 {
+		t.Fatalf("range = %d..%d (status %d), want 10..20", from, to, status)
+	}
+	for _, q := range []string{"from_seq=0", "to_seq=x", "from_seq=5&to_seq=4"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/audit/verify?"+q, nil)
+		if _, _, status, _ := parseAuditVerifyRange(req); status != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", q, status)
+		}
+	}
+	if got := auditChainKey(""); got != "00000000-0000-0000-0000-000000000000" {
+		t.Fatalf("platform chain key = %s", got)
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func auditRequest(query, tenantID, role string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/audit?"+query, nil)
+	ctx := context.WithValue(req.Context(), "tenant_id", tenantID)
+	ctx = context.WithValue(ctx, "role", role)
+	return req.WithContext(ctx)
+}
+
+func TestParseAuditFilterScopesTenant(t *testing.T) {
+	t.Parallel()
+
+	own := "7f1f8f3e-9d7c-4c1a-8a43-2a4d8c0c1e11"
+	other := "0b7d2a9c-3f4e-4d5a-9b6c-7d8e9f0a1b2c"
+
+	f, status, _ := parseAuditFilter(auditRequest("tenant_id="+other, own, "tenant_admin"), defaultAuditPageSize, maxAuditPageSize)
+	if status != 0 || f.TenantID != own {
+		t.Fatalf("tenant admin: tenant = %q (status %d), want own tenant", f.TenantID, status)
+	}
+	if _, status, _ := parseAuditFilter(auditRequest("", "", "tenant_admin"), defaultAuditPageSize, maxAuditPageSize); status != http.StatusUnauthorized {
+		t.Fatalf("missing tenant: status = %d, want 401", status)
+	}
+	f, _, _ = parseAuditFilter(auditRequest("", "", "super_admin"), defaultAuditPageSize, maxAuditPageSize)
+	if f.TenantID != "" {
+		t.Fatalf("super admin without filter: tenant = %q, want all", f.TenantID)
+	}
+	f, _, _ = parseAuditFilter(auditRequest("tenant_id="+other, "", "super_admin"), defaultAuditPageSize, maxAuditPageSize)
+	if f.TenantID != other {
+		t.Fatalf("super admin filter: tenant = %q, want %q", f.TenantID, other)
+	}
+}
+
+func TestParseAuditFilterValidation(t *testing.T) {
+	t.Parallel()
+
+	tenant := "7f1f8f3e-9d7c-4c1a-8a43-2a4d8c0c1e11"
+	for _, q := range []string{
+		"actor_id=nope",
+		"device_id=nope",
+		"from=yesterday",
+		"from=2026-02-02T00:00:00Z&to=2026-02-01T00:00:00Z",
+		"limit=0",
+		"limit=5000",
+		"cursor=bm9wZQ",
+	} {
+		if _, status, _ := parseAuditFilter(auditRequest(q, tenant, "tenant_admin"), defaultAuditPageSize, maxAuditPageSize); status != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", q, status)
+		}
+	}
+
+	f, status, msg := parseAuditFilter(auditRequest("event_type=quota.devices_exceeded&severity=warning&from=2026-02-01T00:00:00Z&limit=50", tenant, "tenant_admin"), defaultAuditPageSize, maxAuditPageSize)
+	if status != 0 {
+		t.Fatalf("valid filter rejected: %s", msg)
+	}
+	if f.EventType != "quota.devices_exceeded" || f.Severity != "warning" || f.From == nil || f.Limit != 50 {
+		t.Fatalf("unexpected filter %+v", f)
+	}
+}
+
+func TestAuditCursorRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	c := auditCursor{Timestamp: time.Date(2026, 2, 1, 12, 30, 0, 123456000, time.UTC), AuditID: 42}
+	got, err := decodeAuditCursor(c.encode())
+	if err != nil {
+		t.Fatalf("decodeAuditCursor error: %v", err)
+	}
+	if !got.Timestamp.Equal(c.Timestamp) || got.AuditID != 42 {
+		t.Fatalf("cursor = %+v, want %+v", got, c)
+	}
+}
+
+func TestAuditWhere(t *testing.T) {
+	t.Parallel()
+
+	from := time.Now()
+	where, args := auditWhere(auditFilter{
+		TenantID: "tenant-1",
+		ActorID:  "actor-1",
+		From:     &from,
+		Cursor:   &auditCursor{Timestamp: from, AuditID: 7},
+	})
+	want := "TRUE AND tenant_id = $1::uuid AND $2::uuid IN (actor_id, user_id, api_key_id) AND timestamp >= $3 AND (timestamp, audit_id) < ($4, $5)"
+	if where != want || len(args) != 5 {
+		t.Fatalf("where = %q (%d args), want %q", where, len(args), want)
+	}
+	if where, args := auditWhere(auditFilter{}); where != "TRUE" || len(args) != 0 {
+		t.Fatalf("empty filter: %q %v", where, args)
+	}
+}
+
+func TestAuditCSVRecord(t *testing.T) {
+	t.Parallel()
+
+	tenant := "tenant-1"
+	status := int32(204)
+	e := AuditEntry{AuditID: 1, TenantID: &tenant, EventType: "device.reset", ResponseStatus: &status, Metadata: []byte(`{"a":1}`), Timestamp: time.Now()}
+	record := e.csvRecord()
+	if len(record) != len(auditCSVHeader) {
+		t.Fatalf("record has %d fields, header %d", len(record), len(auditCSVHeader))
+	}
+	if record[2] != "tenant-1" || !strings.Contains(strings.Join(record, ","), "204") {
+		t.Fatalf("unexpected record %v", record)
+	}
+}
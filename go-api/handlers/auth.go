@@ -21,16 +21,18 @@ import (
 )
 
 type AuthHandler struct {
-	DB     *pgxpool.Pool
-	Redis  *redis.Client
-	Config *config.Config
+	DB       *pgxpool.Pool
+	Redis    *redis.Client
+	Config   *config.Config
+	Notifier Notifier
 }
 
 func NewAuthHandler(db *pgxpool.Pool, redisClient *redis.Client, cfg *config.Config) *AuthHandler {
 	return &AuthHandler{
-		DB:     db,
-		Redis:  redisClient,
-		Config: cfg,
+		DB:       db,
+		Redis:    redisClient,
+		Config:   cfg,
+		Notifier: NewNotifier(cfg),
 	}
 }
 
@@ -88,7 +90,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Hash password
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), 12)
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptCost)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
@@ -104,11 +106,14 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	role := "tenant_user"
 	var tenantID *string
+	// Only the bootstrap super_admin skips e-mail verification.
+	emailVerified := false
 
 	if userCount == 0 {
 		// First user becomes super_admin with no tenant
 		role = "super_admin"
 		tenantID = nil
+		emailVerified = true
 	} else {
 		// Create or get tenant for new user
 		// For now, create a personal tenant per user
@@ -132,10 +137,10 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	// Create user
 	userID := uuid.New().String()
 	err = tx.QueryRow(ctx, `
-		INSERT INTO users (user_id, tenant_id, email, password_hash, role, status, email_verified)
-		VALUES ($1, $2, $3, $4, $5, 'active', true)
+		INSERT INTO users (user_id, tenant_id, email, password_hash, role, status, email_verified, email_verified_at)
+		VALUES ($1, $2, $3, $4, $5, 'active', $6, CASE WHEN $6 THEN NOW() END)
 		RETURNING user_id
-	`, userID, tenantID, req.Email, string(passwordHash), role).Scan(&userID)
+	`, userID, tenantID, req.Email, string(passwordHash), role, emailVerified).Scan(&userID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
@@ -149,45 +154,46 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	// Best effort operational notification (Telegram + audit).
 	notifyUserRegistered(h.DB, h.Config, userID, tenantIDStrOrEmpty(tenantID), req.Email, role)
+	if !emailVerified {
+		h.sendVerificationEmail(userID, req.Email)
+	}
+
+	newUser := models.User{
+		UserID:        userID,
+		TenantID:      tenantID,
+		Email:         req.Email,
+		Role:          role,
+		Status:        "active",
+		EmailVerified: emailVerified,
+	}
 
-	// Get permissions
-	permissions, err := h.getPermissions(role)
+	// Get permissions (reduced or none while unverified, per policy)
+	tenantIDStr := tenantIDStrOrEmpty(tenantID)
+	permissions, denied, err := h.permissionsFor(ctx, userID, role, tenantIDStr, emailVerified)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
 	}
-
-	// Generate JWT tokens
-	tenantIDStr := ""
-	if tenantID != nil {
-		tenantIDStr = *tenantID
+	if denied {
+		utils.WriteJSON(w, http.StatusCreated, models.RegisterResponse{
+			User:    newUser,
+			Message: "Account created. Verify your email to log in.",
+		})
+		return
 	}
 
-	accessToken, err := utils.GenerateJWT(
-		h.Config.JWTSecret,
-		"access",
-		userID,
-		tenantIDStr,
-		req.Email,
-		role,
-		permissions,
-		h.Config.JWTAccessExpiration,
-	)
+	// Super admins (and tenants with MFA mandatory) must enrol first
+	mfa, err := h.loadMFAState(ctx, userID, role, tenantIDStr)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
 	}
+	if mfa.enrollmentPending() {
+		permissions = []string{}
+	}
 
-	refreshToken, err := utils.GenerateJWT(
-		h.Config.JWTSecret,
-		"refresh",
-		userID,
-		tenantIDStr,
-		req.Email,
-		role,
-		permissions,
-		h.Config.JWTRefreshExpiration,
-	)
+	// Generate JWT tokens (opens the first session)
+	accessToken, refreshToken, err := h.issueTokens(ctx, r, "", userID, tenantIDStr, req.Email, role, permissions)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
@@ -195,16 +201,11 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	// Return response
 	utils.WriteJSON(w, http.StatusCreated, models.RegisterResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    int64(h.Config.JWTAccessExpiration.Seconds()),
-		User: models.User{
-			UserID:   userID,
-			TenantID: tenantID,
-			Email:    req.Email,
-			Role:     role,
-			Status:   "active",
-		},
+		AccessToken:           accessToken,
+		RefreshToken:          refreshToken,
+		ExpiresIn:             int64(h.Config.JWTAccessExpiration.Seconds()),
+		User:                  newUser,
+		MFAEnrollmentRequired: mfa.enrollmentPending(),
 	})
 }
 
@@ -229,82 +230,76 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	// Normalize email
 	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
 
+	// Per-account lockout / progressive delay (same answer for unknown e-mails)
+	if h.loginBlocked(w, r.Context(), req.Email) {
+		return
+	}
+
 	// Find user
 	var user models.User
+	var authProvider string
 	err := h.DB.QueryRow(context.Background(), `
-		SELECT user_id, tenant_id, email, password_hash, role, status
+		SELECT user_id, tenant_id, email, password_hash, role, status, COALESCE(email_verified, false), auth_provider
 		FROM users
 		WHERE email = $1
-	`, req.Email).Scan(&user.UserID, &user.TenantID, &user.Email, &user.PasswordHash, &user.Role, &user.Status)
+	`, req.Email).Scan(&user.UserID, &user.TenantID, &user.Email, &user.PasswordHash, &user.Role, &user.Status, &user.EmailVerified, &authProvider)
 
+	// Verify password before branching: bcrypt runs for unknown e-mails too
+	// (dummy hash), so response timing does not reveal which ones exist.
+	passwordOK := compareLoginPassword(user.PasswordHash, req.Password)
 	if err != nil {
+		h.recordLoginFailure(r, req.Email, nil)
 		utils.WriteError(w, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
 
-	if user.Status != "active" {
-		utils.WriteError(w, http.StatusForbidden, "Account is not active")
+	// Same 401 for every wrong password, whatever the account state: status
+	// and SSO answers would otherwise reveal which e-mails exist.
+	if !passwordOK {
+		h.recordLoginFailure(r, req.Email, &user)
+		utils.WriteError(w, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
-
-	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		utils.WriteError(w, http.StatusUnauthorized, "Invalid credentials")
+	if user.Status != "active" {
+		utils.WriteError(w, http.StatusForbidden, "Account is not active")
 		return
 	}
-
-	// Get permissions
-	permissions, err := h.getPermissions(user.Role)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+	// Accounts provisioned by SSO have no usable password
+	if authProvider == "oidc" {
+		utils.WriteErrorWithCode(w, http.StatusForbidden, "sso_required", "Log in through your organization's SSO")
 		return
 	}
-
 	// Generate JWT
 	tenantID := ""
 	if user.TenantID != nil {
 		tenantID = *user.TenantID
 	}
 
-	accessToken, err := utils.GenerateJWT(
-		h.Config.JWTSecret,
-		"access",
-		user.UserID,
-		tenantID,
-		user.Email,
-		user.Role,
-		permissions,
-		h.Config.JWTAccessExpiration,
-	)
+	// Get permissions (reduced or none while unverified, per policy)
+	permissions, denied, err := h.permissionsFor(context.Background(), user.UserID, user.Role, tenantID, user.EmailVerified)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
 	}
+	if denied {
+		utils.WriteErrorWithCode(w, http.StatusForbidden, "email_not_verified", "Email not verified")
+		return
+	}
 
-	refreshToken, err := utils.GenerateJWT(
-		h.Config.JWTSecret,
-		"refresh",
-		user.UserID,
-		tenantID,
-		user.Email,
-		user.Role,
-		permissions,
-		h.Config.JWTRefreshExpiration,
-	)
+	// Second factor: with TOTP enabled the password only yields a challenge
+	mfa, err := h.loadMFAState(r.Context(), user.UserID, user.Role, tenantID)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
 	}
+	// With MFA the failure counters are only reset once the code is verified.
+	if mfa.Enabled {
+		h.writeMFAChallenge(w, r, user.UserID)
+		return
+	}
+	h.clearLoginFailures(req.Email)
 
-	// Update last_login_at
-	h.DB.Exec(context.Background(), "UPDATE users SET last_login_at = NOW() WHERE user_id = $1", user.UserID)
-
-	utils.WriteJSON(w, http.StatusOK, models.LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    int64(h.Config.JWTAccessExpiration.Seconds()),
-		User:         user,
-	})
+	h.writeLoginTokens(w, r, user, tenantID, permissions, mfa.enrollmentPending())
 }
 
 // Refresh generates new access token from refresh token with revocation
@@ -331,16 +326,42 @@ func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if token is blacklisted (already used)
-	blacklisted, err := utils.IsTokenBlacklisted(h.Redis, claims.JTI)
+	// Spend the token before anything is issued: SET NX blacklists the JTI
+	// atomically, so of two concurrent presentations only one can win. A
+	// spent token stays spent even if the refresh fails below.
+	claimed, err := utils.ClaimToken(h.Redis, claims.JTI, time.Until(time.Unix(claims.ExpiresAt, 0)))
 	if err != nil {
 		// Fail-closed for refresh security
-		log.Printf("Error checking token blacklist: %v", err)
+		log.Printf("Error claiming refresh token: %v", err)
 		utils.WriteError(w, http.StatusServiceUnavailable, "Token validation unavailable")
 		return
 	}
-	if blacklisted {
-		utils.WriteError(w, http.StatusUnauthorized, "Refresh token already used")
+	if !claimed {
+		// Only a rotated-out token coming back is a replay; one revoked by
+		// logout is simply refused.
+		rotated, err := utils.IsTokenRotated(h.Redis, claims.JTI)
+		if err != nil {
+			log.Printf("Error checking refresh token state: %v", err)
+			utils.WriteError(w, http.StatusServiceUnavailable, "Token validation unavailable")
+			return
+		}
+		if !rotated {
+			utils.WriteError(w, http.StatusUnauthorized, "Refresh token revoked")
+			return
+		}
+		// Revoke the whole family.
+		h.handleRefreshReuse(r, claims)
+		utils.WriteErrorWithCode(w, http.StatusUnauthorized, "refresh_token_reused", "Refresh token already used")
+		return
+	}
+	revoked, err := utils.IsUserTokenRevoked(h.Redis, claims.UserID, claims.IssuedAt)
+	if err != nil {
+		log.Printf("Error checking user token revocation: %v", err)
+		utils.WriteError(w, http.StatusServiceUnavailable, "Token validation unavailable")
+		return
+	}
+	if revoked {
+		utils.WriteError(w, http.StatusUnauthorized, "Refresh token revoked")
 		return
 	}
 
@@ -349,10 +370,11 @@ func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
 	var role string
 	var tenantID *string
 	var email string
+	var emailVerified bool
 	err = h.DB.QueryRow(context.Background(),
-		"SELECT status, role, tenant_id, email FROM users WHERE user_id = $1",
+		"SELECT status, role, tenant_id, email, COALESCE(email_verified, false) FROM users WHERE user_id = $1",
 		claims.UserID,
-	).Scan(&status, &role, &tenantID, &email)
+	).Scan(&status, &role, &tenantID, &email, &emailVerified)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			utils.WriteError(w, http.StatusUnauthorized, "User not found")
@@ -367,75 +389,80 @@ func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get fresh permissions (in case they changed)
-	permissions, err := h.getPermissions(role)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
-		return
-	}
-
 	tenantIDStr := ""
 	if tenantID != nil {
 		tenantIDStr = *tenantID
 	}
 
-	// Generate new access token
-	accessToken, err := utils.GenerateJWT(
-		h.Config.JWTSecret,
-		"access",
-		claims.UserID,
-		tenantIDStr,
-		email,
-		role,
-		permissions,
-		h.Config.JWTAccessExpiration,
-	)
+	// Get fresh permissions (in case they changed, e.g. e-mail verified since)
+	permissions, denied, err := h.permissionsFor(context.Background(), claims.UserID, role, tenantIDStr, emailVerified)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
 	}
-
-	// Generate new refresh token (token rotation)
-	newRefreshToken, err := utils.GenerateJWT(
-		h.Config.JWTSecret,
-		"refresh",
-		claims.UserID,
-		tenantIDStr,
-		email,
-		role,
-		permissions,
-		h.Config.JWTRefreshExpiration,
-	)
+	if denied {
+		utils.WriteErrorWithCode(w, http.StatusForbidden, "email_not_verified", "Email not verified")
+		return
+	}
+	mfa, err := h.loadMFAState(r.Context(), claims.UserID, role, tenantIDStr)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
 	}
+	if mfa.enrollmentPending() {
+		permissions = []string{}
+	}
 
-	// Blacklist the old refresh token to prevent reuse
-	ttl := time.Until(time.Unix(claims.ExpiresAt, 0))
-	if ttl > 0 {
-		if err := utils.BlacklistToken(h.Redis, claims.JTI, ttl); err != nil {
-			// Fail-closed for refresh security
-			log.Printf("Error blacklisting token: %v", err)
-			utils.WriteError(w, http.StatusServiceUnavailable, "Token validation unavailable")
+	// Keep the refresh chain in its session: a revoked or expired session
+	// ends here even if the token itself is still valid. Tokens issued before
+	// sessions existed get one now.
+	sessionID := claims.SessionID
+	if sessionID != "" {
+		active, err := extendSession(r.Context(), h.DB, sessionID, claims.UserID, h.Config.JWTRefreshExpiration)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		if !active {
+			utils.WriteError(w, http.StatusUnauthorized, "Session revoked")
 			return
 		}
 	}
 
+	// Generate new access and refresh tokens (token rotation)
+	accessToken, newRefreshToken, err := h.issueTokens(r.Context(), r, sessionID, claims.UserID, tenantIDStr, email, role, permissions)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
 	utils.WriteJSON(w, http.StatusOK, models.RefreshResponse{
-		AccessToken:  accessToken,
-		RefreshToken: newRefreshToken,
-		ExpiresIn:    int64(h.Config.JWTAccessExpiration.Seconds()),
+		AccessToken:           accessToken,
+		RefreshToken:          newRefreshToken,
+		ExpiresIn:             int64(h.Config.JWTAccessExpiration.Seconds()),
+		MFAEnrollmentRequired: mfa.enrollmentPending(),
 	})
 }
 
-func (h *AuthHandler) getPermissions(role string) ([]string, error) {
+// getPermissions resolves the permissions of userID: a tenant_user with a
+// custom role gets the role's (grantable) set, everyone else the built-in
+// permissions of role.
+func (h *AuthHandler) getPermissions(userID, role string) ([]string, error) {
 	rows, err := h.DB.Query(context.Background(), `
+		WITH custom AS (
+			SELECT custom_role_id AS role_id FROM users
+			WHERE user_id = $1::uuid AND role = 'tenant_user' AND custom_role_id IS NOT NULL
+		)
+		SELECT p.name
+		FROM tenant_role_permissions trp
+		JOIN permissions p ON trp.permission_id = p.permission_id
+		WHERE trp.role_id = (SELECT role_id FROM custom) AND p.tenant_grantable
+		UNION ALL
 		SELECT p.name
 		FROM role_permissions rp
 		JOIN permissions p ON rp.permission_id = p.permission_id
-		WHERE rp.role = $1
-	`, role)
+		WHERE rp.role = $2 AND NOT EXISTS (SELECT 1 FROM custom)
+	`, userID, role)
 	if err != nil {
 		return nil, err
 	}
@@ -500,3 +527,14 @@ func validatePassword(password string) error {
 
 	return nil
 }
+
+// recordAuthEvent writes an account-level audit event (actor = the user).
+func recordAuthEvent(db *pgxpool.Pool, tenantID, userID, eventType, action, result string, metadata map[string]interface{}) {
+	if db == nil {
+		return
+	}
+	_, _ = db.Exec(context.Background(), `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, resource_type, resource_id, metadata, timestamp)
+		VALUES (NULLIF($1,'')::uuid, NULLIF($2,'')::uuid, $3, 'auth', 'info', 'user', NULLIF($2,'')::uuid, $4, $5, 'user', NULLIF($2,'')::uuid, $6::jsonb, NOW())
+	`, tenantID, userID, eventType, action, result, toJSONB(metadata))
+}
@@ -0,0 +1,763 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"iiot-go-api/config"
+	"iiot-go-api/utils"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	errInvoiceExists       = errors.New("invoice already exists for period")
+	errPricingNotFound     = errors.New("pricing not found for plan")
+	errInvalidInvoiceState = errors.New("invalid invoice status transition")
+	errInvoicePeriodOpen   = errors.New("invoice period has not ended")
+)
+
+type BillingHandler struct {
+	DB        *pgxpool.Pool
+	Timescale *pgxpool.Pool
+	Config    *config.Config
+}
+
+type PricingPlan struct {
+	PlanType                string    `json:"plan_type"`
+	Currency                string    `json:"currency"`
+	BaseFeeCents            int64     `json:"base_fee_cents"`
+	IncludedMessages        int64     `json:"included_messages"`
+	IncludedStorageMB       int       `json:"included_storage_mb"`
+	IncludedDevices         int       `json:"included_devices"`
+	PricePer1kMessagesCents int64     `json:"price_per_1k_messages_cents"`
+	PricePerGBStorageCents  int64     `json:"price_per_gb_storage_cents"`
+	PricePerDeviceCents     int64     `json:"price_per_device_cents"`
+	UpdatedAt               time.Time `json:"updated_at"`
+}
+
+type InvoiceLine struct {
+	Kind           string  `json:"kind"`
+	Description    string  `json:"description"`
+	Quantity       float64 `json:"quantity"`
+	UnitPriceCents int64   `json:"unit_price_cents"`
+	AmountCents    int64   `json:"amount_cents"`
+}
+
+type Invoice struct {
+	InvoiceID     string        `json:"invoice_id"`
+	TenantID      string        `json:"tenant_id"`
+	SnapshotID    *int64        `json:"snapshot_id,omitempty"`
+	InvoiceNumber string        `json:"invoice_number"`
+	PeriodStart   time.Time     `json:"period_start"`
+	PeriodEnd     time.Time     `json:"period_end"`
+	PlanType      string        `json:"plan_type"`
+	Currency      string        `json:"currency"`
+	SubtotalCents int64         `json:"subtotal_cents"`
+	TotalCents    int64         `json:"total_cents"`
+	Status        string        `json:"status"`
+	IssuedAt      *time.Time    `json:"issued_at,omitempty"`
+	PaidAt        *time.Time    `json:"paid_at,omitempty"`
+	VoidedAt      *time.Time    `json:"voided_at,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Lines         []InvoiceLine `json:"lines,omitempty"`
+}
+
+type GenerateInvoiceRequest struct {
+	// Period in YYYY-MM; defaults to the previous calendar month.
+	Period string `json:"period,omitempty"`
+}
+
+type InvoiceStatusRequest struct {
+	Status string `json:"status" validate:"required,oneof=issued paid void"`
+}
+
+// invoiceUsage is the frozen snapshot usage an invoice is computed from.
+type invoiceUsage struct {
+	Messages  int64
+	StorageMB float64
+	Devices   int
+}
+
+func NewBillingHandler(db, ts *pgxpool.Pool, cfg *config.Config) *BillingHandler {
+	return &BillingHandler{DB: db, Timescale: ts, Config: cfg}
+}
+
+// ListPricing returns the pricing catalog (super admin).
+func (h *BillingHandler) ListPricing(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(context.Background(), `
+		SELECT plan_type, currency, base_fee_cents, included_messages, included_storage_mb, included_devices,
+			price_per_1k_messages_cents, price_per_gb_storage_cents, price_per_device_cents, updated_at
+		FROM pricing_catalog
+		ORDER BY base_fee_cents
+	`)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer rows.Close()
+
+	plans := []PricingPlan{}
+	for rows.Next() {
+		var p PricingPlan
+		if err := rows.Scan(&p.PlanType, &p.Currency, &p.BaseFeeCents, &p.IncludedMessages, &p.IncludedStorageMB, &p.IncludedDevices,
+			&p.PricePer1kMessagesCents, &p.PricePerGBStorageCents, &p.PricePerDeviceCents, &p.UpdatedAt); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		plans = append(plans, p)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, plans)
+}
+
+// PutPricing creates or replaces the pricing of a plan (super admin).
+func (h *BillingHandler) PutPricing(w http.ResponseWriter, r *http.Request) {
+	planType := r.PathValue("plan_type")
+	if planType == "" {
+		utils.WriteError(w, http.StatusBadRequest, "plan_type is required")
+		return
+	}
+
+	var p PricingPlan
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	p.PlanType = planType
+	p.Currency = strings.ToUpper(strings.TrimSpace(p.Currency))
+	if p.Currency == "" {
+		p.Currency = "BRL"
+	}
+	if len(p.Currency) != 3 {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid currency")
+		return
+	}
+	if p.BaseFeeCents < 0 || p.IncludedMessages < 0 || p.IncludedStorageMB < 0 || p.IncludedDevices < 0 ||
+		p.PricePer1kMessagesCents < 0 || p.PricePerGBStorageCents < 0 || p.PricePerDeviceCents < 0 {
+		utils.WriteError(w, http.StatusBadRequest, "Pricing values must be >= 0")
+		return
+	}
+
+	var planExists bool
+	if err := h.DB.QueryRow(context.Background(), `SELECT EXISTS(SELECT 1 FROM plans WHERE code = $1)`, p.PlanType).Scan(&planExists); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if !planExists {
+		utils.WriteError(w, http.StatusNotFound, "Plan not found")
+		return
+	}
+
+	err := h.DB.QueryRow(context.Background(), `
+		INSERT INTO pricing_catalog (
+			plan_type, currency, base_fee_cents, included_messages, included_storage_mb, included_devices,
+			price_per_1k_messages_cents, price_per_gb_storage_cents, price_per_device_cents, updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+		ON CONFLICT (plan_type) DO UPDATE SET
+			currency = EXCLUDED.currency,
+			base_fee_cents = EXCLUDED.base_fee_cents,
+			included_messages = EXCLUDED.included_messages,
+			included_storage_mb = EXCLUDED.included_storage_mb,
+			included_devices = EXCLUDED.included_devices,
+			price_per_1k_messages_cents = EXCLUDED.price_per_1k_messages_cents,
+			price_per_gb_storage_cents = EXCLUDED.price_per_gb_storage_cents,
+			price_per_device_cents = EXCLUDED.price_per_device_cents,
+			updated_at = NOW()
+		RETURNING updated_at
+	`, p.PlanType, p.Currency, p.BaseFeeCents, p.IncludedMessages, p.IncludedStorageMB, p.IncludedDevices,
+		p.PricePer1kMessagesCents, p.PricePerGBStorageCents, p.PricePerDeviceCents).Scan(&p.UpdatedAt)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	recordBillingEvent(h.DB, "", actorUserID, "billing.pricing_updated", "update", map[string]interface{}{
+		"pricing": p,
+	})
+
+	utils.WriteJSON(w, http.StatusOK, p)
+}
+
+// GenerateInvoice freezes the usage snapshot of a period and creates a draft invoice (super admin).
+func (h *BillingHandler) GenerateInvoice(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.PathValue("tenant_id")
+	if tenantID == "" {
+		utils.WriteError(w, http.StatusBadRequest, "tenant_id is required")
+		return
+	}
+
+	var req GenerateInvoiceRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	start, end, err := invoicePeriod(req.Period, time.Now().UTC())
+	if errors.Is(err, errInvoicePeriodOpen) {
+		utils.WriteErrorWithCode(w, http.StatusBadRequest, "period_not_closed", "Period has not ended yet")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid period (expected YYYY-MM)")
+		return
+	}
+
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	inv, err := generateInvoice(context.Background(), h.DB, h.Timescale, tenantID, start, end, actorUserID)
+	switch {
+	case errors.Is(err, errInvoiceExists):
+		utils.WriteError(w, http.StatusConflict, "Invoice already exists for period")
+		return
+	case errors.Is(err, errPricingNotFound):
+		utils.WriteErrorWithCode(w, http.StatusUnprocessableEntity, "pricing_not_found", "No pricing configured for tenant plan")
+		return
+	case errors.Is(err, pgx.ErrNoRows):
+		utils.WriteError(w, http.StatusNotFound, "Tenant not found")
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, inv)
+}
+
+// ListTenantInvoices lists invoices of any tenant (super admin).
+func (h *BillingHandler) ListTenantInvoices(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.PathValue("tenant_id")
+	if tenantID == "" {
+		utils.WriteError(w, http.StatusBadRequest, "tenant_id is required")
+		return
+	}
+	h.writeInvoiceList(w, tenantID, false)
+}
+
+// UpdateInvoiceStatus moves an invoice through draft -> issued -> paid, or to void (super admin).
+func (h *BillingHandler) UpdateInvoiceStatus(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.PathValue("tenant_id")
+	invoiceID := r.PathValue("invoice_id")
+	if tenantID == "" || invoiceID == "" {
+		utils.WriteError(w, http.StatusBadRequest, "tenant_id and invoice_id are required")
+		return
+	}
+
+	var req InvoiceStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	err := setInvoiceStatus(context.Background(), h.DB, tenantID, invoiceID, req.Status, actorUserID)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		utils.WriteError(w, http.StatusNotFound, "Invoice not found")
+		return
+	case errors.Is(err, errInvalidInvoiceState):
+		utils.WriteError(w, http.StatusConflict, "Invalid invoice status transition")
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	inv, err := loadInvoice(context.Background(), h.DB, tenantID, invoiceID, false)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, inv)
+}
+
+// ListInvoices lists the issued, paid and void invoices of the JWT tenant;
+// drafts stay with the super admin until issued.
+func (h *BillingHandler) ListInvoices(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	h.writeInvoiceList(w, tenantID, true)
+}
+
+// GetInvoice returns an invoice of the JWT tenant with its line items
+// (drafts only to a super admin).
+func (h *BillingHandler) GetInvoice(w http.ResponseWriter, r *http.Request) {
+	inv, ok := h.invoiceForRequest(w, r)
+	if !ok {
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, inv)
+}
+
+// GetInvoiceDocument renders a printable HTML invoice (browser "print to PDF").
+func (h *BillingHandler) GetInvoiceDocument(w http.ResponseWriter, r *http.Request) {
+	inv, ok := h.invoiceForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var tenantName string
+	_ = h.DB.QueryRow(context.Background(), `SELECT name FROM tenants WHERE tenant_id = $1::uuid`, inv.TenantID).Scan(&tenantName)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", inv.InvoiceNumber+".html"))
+	w.WriteHeader(http.StatusOK)
+	_ = invoiceDocumentTemplate.Execute(w, map[string]interface{}{
+		"Invoice":    inv,
+		"TenantName": tenantName,
+	})
+}
+
+func (h *BillingHandler) invoiceForRequest(w http.ResponseWriter, r *http.Request) (*Invoice, bool) {
+	invoiceID := r.PathValue("invoice_id")
+	if invoiceID == "" {
+		utils.WriteError(w, http.StatusBadRequest, "invoice_id is required")
+		return nil, false
+	}
+	tenantID, _ := r.Context().Value("tenant_id").(string)
+	role, _ := r.Context().Value("role").(string)
+	if tenantID == "" && role != "super_admin" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return nil, false
+	}
+
+	inv, err := loadInvoice(context.Background(), h.DB, tenantID, invoiceID, role != "super_admin")
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, "Invoice not found")
+		return nil, false
+	}
+	return inv, true
+}
+
+// tenantVisibleInvoiceStatuses are the invoice states a tenant may see.
+const tenantVisibleInvoiceStatuses = `('issued', 'paid', 'void')`
+
+func (h *BillingHandler) writeInvoiceList(w http.ResponseWriter, tenantID string, tenantView bool) {
+	rows, err := h.DB.Query(context.Background(), `
+		SELECT invoice_id::text, tenant_id::text, snapshot_id, invoice_number, period_start, period_end, plan_type, currency,
+			subtotal_cents, total_cents, status, issued_at, paid_at, voided_at, created_at
+		FROM invoices
+		WHERE tenant_id = $1::uuid AND (NOT $2 OR status IN `+tenantVisibleInvoiceStatuses+`)
+		ORDER BY period_start DESC, created_at DESC
+	`, tenantID, tenantView)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer rows.Close()
+
+	invoices := []Invoice{}
+	for rows.Next() {
+		var inv Invoice
+		if err := rows.Scan(&inv.InvoiceID, &inv.TenantID, &inv.SnapshotID, &inv.InvoiceNumber, &inv.PeriodStart, &inv.PeriodEnd,
+			&inv.PlanType, &inv.Currency, &inv.SubtotalCents, &inv.TotalCents, &inv.Status, &inv.IssuedAt, &inv.PaidAt, &inv.VoidedAt, &inv.CreatedAt); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		invoices = append(invoices, inv)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, invoices)
+}
+
+// generateInvoice refreshes and freezes the period snapshot, then stores a
+// draft invoice priced with the tenant's current plan.
+func generateInvoice(ctx context.Context, db, ts *pgxpool.Pool, tenantID string, start, end time.Time, actorUserID string) (*Invoice, error) {
+	snapshotID, err := createUsageSnapshotForPeriod(ctx, db, ts, tenantID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var usage invoiceUsage
+	err = tx.QueryRow(ctx, `
+		SELECT messages_ingested, storage_mb::float8, devices_total
+		FROM tenant_usage_snapshots
+		WHERE snapshot_id = $1
+		FOR UPDATE
+	`, snapshotID).Scan(&usage.Messages, &usage.StorageMB, &usage.Devices)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM invoices
+			WHERE tenant_id = $1::uuid AND period_start = $2 AND period_end = $3 AND status <> 'void'
+		)
+	`, tenantID, start, end).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, errInvoiceExists
+	}
+
+	var planType string
+	if err := tx.QueryRow(ctx, `SELECT plan_type FROM tenants WHERE tenant_id = $1::uuid`, tenantID).Scan(&planType); err != nil {
+		return nil, err
+	}
+	pricing, err := fetchPricing(ctx, tx, planType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Voided invoices keep their number; regenerations get a revision suffix.
+	var revisions int
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM invoices WHERE tenant_id = $1::uuid AND period_start = $2 AND period_end = $3
+	`, tenantID, start, end).Scan(&revisions); err != nil {
+		return nil, err
+	}
+	number := invoiceNumber(tenantID, start)
+	if revisions > 0 {
+		number = fmt.Sprintf("%s-R%d", number, revisions+1)
+	}
+
+	lines := computeInvoiceLines(*pricing, usage)
+	total := invoiceTotal(lines)
+	inv := &Invoice{
+		TenantID:      tenantID,
+		SnapshotID:    &snapshotID,
+		InvoiceNumber: number,
+		PeriodStart:   start,
+		PeriodEnd:     end,
+		PlanType:      planType,
+		Currency:      pricing.Currency,
+		SubtotalCents: total,
+		TotalCents:    total,
+		Status:        "draft",
+		Lines:         lines,
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO invoices (tenant_id, snapshot_id, invoice_number, period_start, period_end, plan_type, currency, subtotal_cents, total_cents, status)
+		VALUES ($1::uuid, $2, $3, $4, $5, $6, $7, $8, $9, 'draft')
+		RETURNING invoice_id::text, created_at
+	`, tenantID, snapshotID, inv.InvoiceNumber, start, end, planType, inv.Currency, inv.SubtotalCents, inv.TotalCents).Scan(&inv.InvoiceID, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range lines {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO invoice_line_items (invoice_id, kind, description, quantity, unit_price_cents, amount_cents)
+			VALUES ($1::uuid, $2, $3, $4, $5, $6)
+		`, inv.InvoiceID, l.Kind, l.Description, l.Quantity, l.UnitPriceCents, l.AmountCents); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE tenant_usage_snapshots SET frozen_at = NOW() WHERE snapshot_id = $1`, snapshotID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	recordBillingEvent(db, tenantID, actorUserID, "billing.invoice_generated", "generate", map[string]interface{}{
+		"invoice_id":     inv.InvoiceID,
+		"invoice_number": inv.InvoiceNumber,
+		"snapshot_id":    snapshotID,
+		"period_start":   start,
+		"period_end":     end,
+		"total_cents":    total,
+		"currency":       inv.Currency,
+		"status":         inv.Status,
+	})
+
+	return inv, nil
+}
+
+func setInvoiceStatus(ctx context.Context, db *pgxpool.Pool, tenantID, invoiceID, status, actorUserID string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var current string
+	var snapshotID *int64
+	err = tx.QueryRow(ctx, `
+		SELECT status, snapshot_id FROM invoices
+		WHERE invoice_id = $1::uuid AND tenant_id = $2::uuid
+		FOR UPDATE
+	`, invoiceID, tenantID).Scan(&current, &snapshotID)
+	if err != nil {
+		return err
+	}
+	if !canTransitionInvoice(current, status) {
+		return errInvalidInvoiceState
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE invoices
+		SET status = $2,
+			issued_at = CASE WHEN $2 = 'issued' THEN NOW() ELSE issued_at END,
+			paid_at = CASE WHEN $2 = 'paid' THEN NOW() ELSE paid_at END,
+			voided_at = CASE WHEN $2 = 'void' THEN NOW() ELSE voided_at END
+		WHERE invoice_id = $1::uuid
+	`, invoiceID, status)
+	if err != nil {
+		return err
+	}
+
+	// Voiding unfreezes the snapshot so a corrected invoice can be generated
+	// by hand (POST .../invoices). A snapshot already closed by the scheduler
+	// keeps its figures, so the new invoice reprices the same usage; and the
+	// scheduler never re-invoices a period that has any invoice, void or not.
+	if status == "void" && snapshotID != nil {
+		if _, err := tx.Exec(ctx, `UPDATE tenant_usage_snapshots SET frozen_at = NULL WHERE snapshot_id = $1`, *snapshotID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	recordBillingEvent(db, tenantID, actorUserID, "billing.invoice_"+status, status, map[string]interface{}{
+		"invoice_id":  invoiceID,
+		"from_status": current,
+		"to_status":   status,
+	})
+	return nil
+}
+
+// loadInvoice fetches an invoice with its lines; tenantView hides drafts.
+func loadInvoice(ctx context.Context, db *pgxpool.Pool, tenantID, invoiceID string, tenantView bool) (*Invoice, error) {
+	var inv Invoice
+	err := db.QueryRow(ctx, `
+		SELECT invoice_id::text, tenant_id::text, snapshot_id, invoice_number, period_start, period_end, plan_type, currency,
+			subtotal_cents, total_cents, status, issued_at, paid_at, voided_at, created_at
+		FROM invoices
+		WHERE invoice_id = $1::uuid AND ($2 = '' OR tenant_id = NULLIF($2,'')::uuid)
+		  AND (NOT $3 OR status IN `+tenantVisibleInvoiceStatuses+`)
+	`, invoiceID, tenantID, tenantView).Scan(&inv.InvoiceID, &inv.TenantID, &inv.SnapshotID, &inv.InvoiceNumber, &inv.PeriodStart, &inv.PeriodEnd,
+		&inv.PlanType, &inv.Currency, &inv.SubtotalCents, &inv.TotalCents, &inv.Status, &inv.IssuedAt, &inv.PaidAt, &inv.VoidedAt, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT kind, description, quantity::float8, unit_price_cents, amount_cents
+		FROM invoice_line_items
+		WHERE invoice_id = $1::uuid
+		ORDER BY line_id
+	`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l InvoiceLine
+		if err := rows.Scan(&l.Kind, &l.Description, &l.Quantity, &l.UnitPriceCents, &l.AmountCents); err != nil {
+			return nil, err
+		}
+		inv.Lines = append(inv.Lines, l)
+	}
+	return &inv, rows.Err()
+}
+
+func fetchPricing(ctx context.Context, tx pgx.Tx, planType string) (*PricingPlan, error) {
+	var p PricingPlan
+	err := tx.QueryRow(ctx, `
+		SELECT plan_type, currency, base_fee_cents, included_messages, included_storage_mb, included_devices,
+			price_per_1k_messages_cents, price_per_gb_storage_cents, price_per_device_cents, updated_at
+		FROM pricing_catalog
+		WHERE plan_type = $1
+	`, planType).Scan(&p.PlanType, &p.Currency, &p.BaseFeeCents, &p.IncludedMessages, &p.IncludedStorageMB, &p.IncludedDevices,
+		&p.PricePer1kMessagesCents, &p.PricePerGBStorageCents, &p.PricePerDeviceCents, &p.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, errPricingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// computeInvoiceLines prices a usage snapshot: base fee plus overage above
+// the included units (messages per started 1k, storage pro rata per GB,
+// devices per unit).
+func computeInvoiceLines(p PricingPlan, u invoiceUsage) []InvoiceLine {
+	lines := []InvoiceLine{{
+		Kind:           "base",
+		Description:    fmt.Sprintf("Plano %s", p.PlanType),
+		Quantity:       1,
+		UnitPriceCents: p.BaseFeeCents,
+		AmountCents:    p.BaseFeeCents,
+	}}
+
+	if over := u.Messages - p.IncludedMessages; over > 0 && p.PricePer1kMessagesCents > 0 {
+		blocks := (over + 999) / 1000
+		lines = append(lines, InvoiceLine{
+			Kind:           "messages",
+			Description:    fmt.Sprintf("Mensagens excedentes (%d acima de %d)", over, p.IncludedMessages),
+			Quantity:       float64(blocks),
+			UnitPriceCents: p.PricePer1kMessagesCents,
+			AmountCents:    blocks * p.PricePer1kMessagesCents,
+		})
+	}
+
+	if over := u.StorageMB - float64(p.IncludedStorageMB); over > 0 && p.PricePerGBStorageCents > 0 {
+		gb := math.Round(over/1024.0*100) / 100
+		lines = append(lines, InvoiceLine{
+			Kind:           "storage",
+			Description:    fmt.Sprintf("Armazenamento excedente (%.2f MB acima de %d MB)", over, p.IncludedStorageMB),
+			Quantity:       gb,
+			UnitPriceCents: p.PricePerGBStorageCents,
+			AmountCents:    int64(math.Round(gb * float64(p.PricePerGBStorageCents))),
+		})
+	}
+
+	if over := u.Devices - p.IncludedDevices; over > 0 && p.PricePerDeviceCents > 0 {
+		lines = append(lines, InvoiceLine{
+			Kind:           "devices",
+			Description:    fmt.Sprintf("Devices excedentes (%d acima de %d)", over, p.IncludedDevices),
+			Quantity:       float64(over),
+			UnitPriceCents: p.PricePerDeviceCents,
+			AmountCents:    int64(over) * p.PricePerDeviceCents,
+		})
+	}
+
+	return lines
+}
+
+func invoiceTotal(lines []InvoiceLine) int64 {
+	var total int64
+	for _, l := range lines {
+		total += l.AmountCents
+	}
+	return total
+}
+
+func invoiceNumber(tenantID string, periodStart time.Time) string {
+	short := strings.ReplaceAll(tenantID, "-", "")
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	return fmt.Sprintf("INV-%s-%s", periodStart.UTC().Format("200601"), strings.ToUpper(short))
+}
+
+// canTransitionInvoice allows draft -> issued -> paid, and void from draft or issued.
+func canTransitionInvoice(from, to string) bool {
+	switch from {
+	case "draft":
+		return to == "issued" || to == "void"
+	case "issued":
+		return to == "paid" || to == "void"
+	default:
+		return false
+	}
+}
+
+// invoicePeriod parses YYYY-MM; empty means the previous calendar month.
+// Only ended months can be invoiced (errInvoicePeriodOpen otherwise): the
+// usage of an open month is still growing.
+func invoicePeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	if strings.TrimSpace(period) == "" {
+		start, _ := currentMonthRange(now)
+		return start.AddDate(0, -1, 0), start, nil
+	}
+	start, err := time.ParseInLocation("2006-01", strings.TrimSpace(period), time.UTC)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end := start.AddDate(0, 1, 0)
+	if end.After(now) {
+		return time.Time{}, time.Time{}, errInvoicePeriodOpen
+	}
+	return start, end, nil
+}
+
+func formatCents(cents int64, currency string) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%s %d,%02d", sign, currency, cents/100, cents%100)
+}
+
+func recordBillingEvent(db *pgxpool.Pool, tenantID, userID, eventType, action string, metadata map[string]interface{}) {
+	if db == nil {
+		return
+	}
+	actorType := "system"
+	if userID != "" {
+		actorType = "user"
+	}
+	_, _ = db.Exec(context.Background(), `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, resource_type, metadata, timestamp)
+		VALUES (NULLIF($1,'')::uuid, NULLIF($2,'')::uuid, $3, 'billing', 'info', $4, NULLIF($2,'')::uuid, $5, 'success', 'invoice', $6::jsonb, NOW())
+	`, tenantID, userID, eventType, actorType, action, toJSONB(metadata))
+}
+
+var invoiceDocumentTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"money": formatCents,
+	"date":  func(t time.Time) string { return t.UTC().Format("02/01/2006") },
+	"qty":   func(q float64) string { return strconv.FormatFloat(q, 'f', -1, 64) },
+}).Parse(`<!DOCTYPE html>
+<html lang="pt-BR">
+<head>
+<meta charset="utf-8">
+<title>{{.Invoice.InvoiceNumber}}</title>
+<style>
+  body { font-family: Helvetica, Arial, sans-serif; margin: 40px; color: #222; }
+  h1 { font-size: 22px; margin-bottom: 4px; }
+  .meta { color: #555; font-size: 13px; margin-bottom: 24px; }
+  table { width: 100%; border-collapse: collapse; font-size: 13px; }
+  th, td { padding: 8px; border-bottom: 1px solid #ddd; text-align: left; }
+  td.num, th.num { text-align: right; }
+  tfoot td { font-weight: bold; border-top: 2px solid #222; }
+  .status { text-transform: uppercase; font-weight: bold; }
+  @media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>Fatura {{.Invoice.InvoiceNumber}}</h1>
+<div class="meta">
+  Cliente: {{.TenantName}} ({{.Invoice.TenantID}})<br>
+  Período: {{date .Invoice.PeriodStart}} a {{date .Invoice.PeriodEnd}}<br>
+  Plano: {{.Invoice.PlanType}} &middot; Status: <span class="status">{{.Invoice.Status}}</span>
+</div>
+<table>
+  <thead>
+    <tr><th>Descrição</th><th class="num">Qtd</th><th class="num">Preço unit.</th><th class="num">Valor</th></tr>
+  </thead>
+  <tbody>
+  {{- range .Invoice.Lines}}
+    <tr><td>{{.Description}}</td><td class="num">{{qty .Quantity}}</td><td class="num">{{money .UnitPriceCents $.Invoice.Currency}}</td><td class="num">{{money .AmountCents $.Invoice.Currency}}</td></tr>
+  {{- end}}
+  </tbody>
+  <tfoot>
+    <tr><td colspan="3">Total</td><td class="num">{{money .Invoice.TotalCents .Invoice.Currency}}</td></tr>
+  </tfoot>
+</table>
+</body>
+</html>
+`))
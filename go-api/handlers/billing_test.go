@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestComputeInvoiceLines(t *testing.T) {
+	t.Parallel()
+
+	p := PricingPlan{
+		PlanType:                "starter",
+		Currency:                "BRL",
+		BaseFeeCents:            9900,
+		IncludedMessages:        1000,
+		IncludedStorageMB:       1024,
+		IncludedDevices:         2,
+		PricePer1kMessagesCents: 50,
+		PricePerGBStorageCents:  2000,
+		PricePerDeviceCents:     990,
+	}
+
+	lines := computeInvoiceLines(p, invoiceUsage{Messages: 2001, StorageMB: 1536, Devices: 3})
+	if len(lines) != 4 {
+		t.Fatalf("lines = %d, want 4 (%+v)", len(lines), lines)
+	}
+
+	want := map[string]int64{
+		"base":     9900,
+		"messages": 2 * 50, // 1001 over -> 2 started blocks of 1k
+		"storage":  1000,   // 0.5 GB over
+		"devices":  990,
+	}
+	for _, l := range lines {
+		if l.AmountCents != want[l.Kind] {
+			t.Fatalf("%s amount = %d, want %d", l.Kind, l.AmountCents, want[l.Kind])
+		}
+	}
+	if got := invoiceTotal(lines); got != 9900+100+1000+990 {
+		t.Fatalf("invoiceTotal = %d", got)
+	}
+}
+
+func TestComputeInvoiceLinesStorageAmountMatchesQuantity(t *testing.T) {
+	t.Parallel()
+
+	p := PricingPlan{PlanType: "starter", IncludedStorageMB: 1024, PricePerGBStorageCents: 2000}
+
+	// 100 MB over is shown as 0.10 GB, so it is charged as 0.10 GB.
+	lines := computeInvoiceLines(p, invoiceUsage{StorageMB: 1124})
+	if len(lines) != 2 || lines[1].Kind != "storage" {
+		t.Fatalf("lines = %+v, want base and storage", lines)
+	}
+	if l := lines[1]; l.Quantity != 0.1 || l.AmountCents != 200 {
+		t.Fatalf("storage line = %v GB, %d cents; want 0.1 GB, 200 cents", l.Quantity, l.AmountCents)
+	}
+}
+
+func TestComputeInvoiceLinesWithinIncludedUnits(t *testing.T) {
+	t.Parallel()
+
+	p := PricingPlan{PlanType: "pro", BaseFeeCents: 49900, IncludedMessages: 100, IncludedStorageMB: 10, IncludedDevices: 5,
+		PricePer1kMessagesCents: 30, PricePerGBStorageCents: 1500, PricePerDeviceCents: 690}
+
+	lines := computeInvoiceLines(p, invoiceUsage{Messages: 100, StorageMB: 9.5, Devices: 5})
+	if len(lines) != 1 || lines[0].Kind != "base" {
+		t.Fatalf("lines = %+v, want base fee only", lines)
+	}
+}
+
+func TestCanTransitionInvoice(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"draft", "issued", true},
+		{"draft", "void", true},
+		{"draft", "paid", false},
+		{"issued", "paid", true},
+		{"issued", "void", true},
+		{"paid", "void", false},
+		{"void", "issued", false},
+	}
+	for _, tt := range tests {
+		if got := canTransitionInvoice(tt.from, tt.to); got != tt.want {
+			t.Fatalf("canTransitionInvoice(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestInvoicePeriod(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, time.January, 10, 0, 0, 0, 0, time.UTC)
+	start, end, err := invoicePeriod("", now)
+	if err != nil {
+		t.Fatalf("invoicePeriod default error: %v", err)
+	}
+	if !start.Equal(time.Date(2025, time.December, 1, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("default period = %v..%v, want previous month", start, end)
+	}
+
+	start, _, err = invoicePeriod("2025-11", now)
+	if err != nil || !start.Equal(time.Date(2025, time.November, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("invoicePeriod(2025-11) = %v, %v", start, err)
+	}
+
+	for _, open := range []string{"2026-01", "2026-03"} {
+		if _, _, err := invoicePeriod(open, now); !errors.Is(err, errInvoicePeriodOpen) {
+			t.Fatalf("invoicePeriod(%s) error = %v, want errInvoicePeriodOpen", open, err)
+		}
+	}
+
+	if _, _, err := invoicePeriod("03/2026", now); err == nil {
+		t.Fatalf("invoicePeriod should reject invalid format")
+	}
+}
+
+func TestInvoiceFormatting(t *testing.T) {
+	t.Parallel()
+
+	if got := formatCents(123456, "BRL"); got != "BRL 1234,56" {
+		t.Fatalf("formatCents = %q", got)
+	}
+	if got := invoiceNumber("83409caf-43f8-40b3-8ffe-32b8f0c16a94", time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)); got != "INV-202602-83409CAF" {
+		t.Fatalf("invoiceNumber = %q", got)
+	}
+}
@@ -25,11 +25,12 @@ import (
 type DeviceHandler struct {
 	DB     *pgxpool.Pool
 	Redis  *redis.Client
+	Cache  *IngestCache
 	Config *config.Config
 }
 
-func NewDeviceHandler(db *pgxpool.Pool, rdb *redis.Client, cfg *config.Config) *DeviceHandler {
-	return &DeviceHandler{DB: db, Redis: rdb, Config: cfg}
+func NewDeviceHandler(db *pgxpool.Pool, rdb *redis.Client, cache *IngestCache, cfg *config.Config) *DeviceHandler {
+	return &DeviceHandler{DB: db, Redis: rdb, Cache: cache, Config: cfg}
 }
 
 // ProvisionDevice creates a claimed device and returns MQTT credentials.
@@ -76,7 +77,7 @@ func (h *DeviceHandler) ProvisionDevice(w http.ResponseWriter, r *http.Request)
 		userEmail = fetchUserEmailByID(context.Background(), h.DB, userID)
 	}
 
-	allowed, err := enforceDeviceQuota(context.Background(), h.DB, h.Config, tenantID, userID, userEmail)
+	allowed, err := enforceDeviceQuota(context.Background(), h.DB, h.Redis, h.Config, tenantID, userID, userEmail)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
@@ -91,7 +92,7 @@ func (h *DeviceHandler) ProvisionDevice(w http.ResponseWriter, r *http.Request)
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
 	}
-	secretHash, err := bcrypt.GenerateFromPassword([]byte(deviceSecret), 12)
+	secretHash, err := bcrypt.GenerateFromPassword([]byte(deviceSecret), bcryptCost)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
@@ -132,6 +133,7 @@ func (h *DeviceHandler) ProvisionDevice(w http.ResponseWriter, r *http.Request)
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
 	}
+	h.Cache.InvalidateDevice(deviceID)
 
 	utils.WriteJSON(w, http.StatusCreated, models.ProvisionDeviceResponse{
 		TenantID:     tenantID,
@@ -173,7 +175,7 @@ func (h *DeviceHandler) ClaimDevice(w http.ResponseWriter, r *http.Request) {
 		userEmail = fetchUserEmailByID(context.Background(), h.DB, userID)
 	}
 
-	allowed, err := enforceDeviceQuota(context.Background(), h.DB, h.Config, tenantID, userID, userEmail)
+	allowed, err := enforceDeviceQuota(context.Background(), h.DB, h.Redis, h.Config, tenantID, userID, userEmail)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
@@ -226,7 +228,7 @@ func (h *DeviceHandler) ClaimDevice(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
 	}
-	secretHash, err := bcrypt.GenerateFromPassword([]byte(deviceSecret), 12)
+	secretHash, err := bcrypt.GenerateFromPassword([]byte(deviceSecret), bcryptCost)
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
@@ -254,6 +256,7 @@ func (h *DeviceHandler) ClaimDevice(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
 	}
+	h.Cache.InvalidateDevice(req.DeviceID)
 
 	// Cache secret for one-time retrieval
 	if h.Redis != nil {
@@ -479,6 +482,7 @@ func (h *DeviceHandler) ResetDevice(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, http.StatusNotFound, "Device not found or not authorized")
 		return
 	}
+	h.Cache.InvalidateDevice(req.DeviceID)
 
 	utils.WriteJSON(w, http.StatusOK, map[string]string{
 		"status":  "ok",
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iiot-go-api/config"
+	"iiot-go-api/utils"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const emailVerifyPurpose = "email_verify"
+
+// Unverified-login policies (UNVERIFIED_LOGIN_POLICY / tenants.unverified_login_policy).
+const (
+	unverifiedAllow      = "allow"
+	unverifiedRestricted = "restricted"
+	unverifiedDeny       = "deny"
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// VerifyEmail confirms an address from the signed link (GET ?token= from the
+// e-mail, or POST {"token"} from the web app).
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req VerifyEmailRequest
+	if r.Method == http.MethodGet {
+		req.Token = r.URL.Query().Get("token")
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+
+	fields, err := utils.VerifySignedToken(h.Config.JWTSecret, emailVerifyPurpose, req.Token, time.Now())
+	if err != nil || len(fields) != 2 {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid or expired verification link")
+		return
+	}
+	userID, email := fields[0], fields[1]
+
+	// The e-mail is part of the signature: a link sent before an address
+	// change no longer matches.
+	var tenantID *string
+	var alreadyVerified bool
+	err = h.DB.QueryRow(context.Background(), `
+		UPDATE users SET email_verified = true, email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		WHERE user_id = $1::uuid AND email = $2 AND status <> 'deleted'
+		RETURNING tenant_id::text, email_verified_at <> NOW()
+	`, userID, email).Scan(&tenantID, &alreadyVerified)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid or expired verification link")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	if !alreadyVerified {
+		recordAuthEvent(h.DB, tenantIDStrOrEmpty(tenantID), userID, "auth.email_verified", "verify_email", "success", map[string]interface{}{
+			"email": email,
+		})
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message":        "Email verified",
+		"email_verified": true,
+	})
+}
+
+// ResendVerification sends a new link to an unverified account. The response
+// does not reveal whether the account exists.
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req ResendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+
+	var userID string
+	err := h.DB.QueryRow(context.Background(), `
+		SELECT user_id::text FROM users WHERE email = $1 AND status = 'active' AND NOT COALESCE(email_verified, false)
+	`, req.Email).Scan(&userID)
+	if err != nil && err != pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if err == nil {
+		h.sendVerificationEmail(userID, req.Email)
+	}
+	utils.WriteJSON(w, http.StatusAccepted, map[string]string{"message": "If the account needs verification, a new link has been sent"})
+}
+
+func (h *AuthHandler) sendVerificationEmail(userID, email string) {
+	expiresAt := time.Now().Add(time.Duration(h.Config.EmailVerificationTTLHours) * time.Hour)
+	token := utils.SignToken(h.Config.JWTSecret, emailVerifyPurpose, []string{userID, email}, expiresAt)
+	notifyAsync(h.Notifier, Notification{
+		To:      []string{email},
+		Subject: "Confirme seu e-mail",
+		Body: fmt.Sprintf(
+			"Confirme o e-mail da sua conta na plataforma IIoT acessando:\n%s\n\nO link expira em %d horas.",
+			emailVerificationURL(h.Config.AppBaseURL, token), h.Config.EmailVerificationTTLHours,
+		),
+	})
+}
+
+func emailVerificationURL(baseURL, token string) string {
+	return strings.TrimRight(baseURL, "/") + "/verify-email?token=" + url.QueryEscape(token)
+}
+
+// permissionsFor returns the permissions to put in a user's tokens, applying
+// the unverified-login policy. denied is true when the policy blocks login.
+func (h *AuthHandler) permissionsFor(ctx context.Context, userID, role, tenantID string, emailVerified bool) (perms []string, denied bool, err error) {
+	perms, err = h.getPermissions(userID, role)
+	if err != nil || emailVerified {
+		return perms, false, err
+	}
+	switch unverifiedLoginPolicy(ctx, h.DB, h.Config, tenantID) {
+	case unverifiedDeny:
+		return nil, true, nil
+	case unverifiedRestricted:
+		return restrictPermissions(perms, h.Config.UnverifiedPermissions), false, nil
+	default:
+		return perms, false, nil
+	}
+}
+
+// unverifiedLoginPolicy resolves the tenant override, falling back to the
+// global policy (unknown values behave as "allow").
+func unverifiedLoginPolicy(ctx context.Context, db *pgxpool.Pool, cfg *config.Config, tenantID string) string {
+	policy := ""
+	if db != nil && tenantID != "" {
+		var override *string
+		if err := db.QueryRow(ctx, `SELECT unverified_login_policy FROM tenants WHERE tenant_id = $1::uuid`, tenantID).Scan(&override); err == nil && override != nil {
+			policy = *override
+		}
+	}
+	if policy == "" && cfg != nil {
+		policy = cfg.UnverifiedLoginPolicy
+	}
+	switch policy {
+	case unverifiedRestricted, unverifiedDeny:
+		return policy
+	default:
+		return unverifiedAllow
+	}
+}
+
+// restrictPermissions keeps only the permissions of perms present in allowed.
+func restrictPermissions(perms, allowed []string) []string {
+	allowedSet := make(map[string]bool, len(allowed))
+	for _, p := range allowed {
+		allowedSet[p] = true
+	}
+	out := []string{}
+	for _, p := range perms {
+		if allowedSet[p] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"iiot-go-api/config"
+	"iiot-go-api/utils"
+)
+
+func TestRestrictPermissions(t *testing.T) {
+	t.Parallel()
+
+	got := restrictPermissions([]string{"devices:read", "devices:write", "telemetry:read"}, []string{"telemetry:read", "devices:read", "users:read"})
+	if want := []string{"devices:read", "telemetry:read"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("restrictPermissions = %v, want %v", got, want)
+	}
+	if got := restrictPermissions([]string{"devices:read"}, nil); len(got) != 0 {
+		t.Fatalf("empty allow-list should drop everything, got %v", got)
+	}
+}
+
+func TestUnverifiedLoginPolicyFallsBackToConfig(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cases := map[string]string{
+		"":           unverifiedAllow,
+		"allow":      unverifiedAllow,
+		"restricted": unverifiedRestricted,
+		"deny":       unverifiedDeny,
+		"bogus":      unverifiedAllow,
+	}
+	for configured, want := range cases {
+		if got := unverifiedLoginPolicy(ctx, nil, &config.Config{UnverifiedLoginPolicy: configured}, "t1"); got != want {
+			t.Fatalf("policy %q resolved to %q, want %q", configured, got, want)
+		}
+	}
+}
+
+func TestVerifyEmailRejectsInvalidAndExpiredTokens(t *testing.T) {
+	t.Parallel()
+
+	h := testAuthHandler()
+	expired := utils.SignToken(h.Config.JWTSecret, emailVerifyPurpose, []string{"u1", "a@example.com"}, time.Now().Add(-time.Minute))
+	wrongPurpose := utils.SignToken(h.Config.JWTSecret, "password_reset", []string{"u1", "a@example.com"}, time.Now().Add(time.Hour))
+
+	for _, token := range []string{"", "garbage", expired, wrongPurpose} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/verify-email?token="+token, nil)
+		w := httptest.NewRecorder()
+
+		h.VerifyEmail(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("token %q: status = %d, want %d", token, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestEmailVerificationURL(t *testing.T) {
+	t.Parallel()
+
+	if got := emailVerificationURL("https://app.example.com/", "a.b+c"); got != "https://app.example.com/verify-email?token=a.b%2Bc" {
+		t.Fatalf("emailVerificationURL = %q", got)
+	}
+}
+
+func TestResendVerificationRejectsInvalidEmail(t *testing.T) {
+	t.Parallel()
+
+	h := testAuthHandler()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/verify-email/resend", strings.NewReader(`{"email":"nope"}`))
+	w := httptest.NewRecorder()
+
+	h.ResendVerification(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
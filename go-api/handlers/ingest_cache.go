@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"iiot-go-api/config"
+	"iiot-go-api/metrics"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// IngestCacheChannel is the Redis Pub/Sub channel used to propagate
+// invalidations between API replicas.
+const IngestCacheChannel = "cache:ingest:invalidate"
+
+const (
+	invalidateDevice = "device"
+	invalidateTenant = "tenant"
+)
+
+// ingestDevice is the cached device -> tenant/status resolution.
+type ingestDevice struct {
+	DeviceID string
+	TenantID string
+	Status   string
+}
+
+type cacheInvalidation struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+}
+
+// IngestCache keeps short-lived device and tenant quota lookups in process so
+// the telemetry webhook does not hit Postgres for every message.
+// A nil *IngestCache is valid and always falls through to the database.
+type IngestCache struct {
+	rdb     *redis.Client
+	devices *lruCache[ingestDevice]
+	quotas  *lruCache[TenantQuota]
+}
+
+func NewIngestCache(rdb *redis.Client, cfg *config.Config) *IngestCache {
+	if cfg.IngestCacheMaxEntries <= 0 {
+		return nil
+	}
+	return &IngestCache{
+		rdb:     rdb,
+		devices: newLRUCache[ingestDevice](int(cfg.IngestCacheMaxEntries), time.Duration(cfg.IngestCacheDeviceTTLSecs)*time.Second),
+		quotas:  newLRUCache[TenantQuota](int(cfg.IngestCacheMaxEntries), time.Duration(cfg.IngestCacheQuotaTTLSecs)*time.Second),
+	}
+}
+
+// Subscribe consumes invalidations published by other replicas until ctx is done.
+func (c *IngestCache) Subscribe(ctx context.Context) {
+	if c == nil || c.rdb == nil {
+		return
+	}
+	sub := c.rdb.Subscribe(ctx, IngestCacheChannel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var inv cacheInvalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+				slog.Warn("ingest_cache_invalid_message", slog.String("payload", msg.Payload))
+				continue
+			}
+			c.evict(inv)
+		}
+	}
+}
+
+// InvalidateDevice drops a device locally and on every other replica.
+func (c *IngestCache) InvalidateDevice(deviceID string) {
+	c.publish(cacheInvalidation{Kind: invalidateDevice, ID: deviceID})
+}
+
+// InvalidateTenant drops a tenant quota locally and on every other replica.
+func (c *IngestCache) InvalidateTenant(tenantID string) {
+	c.publish(cacheInvalidation{Kind: invalidateTenant, ID: tenantID})
+}
+
+func (c *IngestCache) publish(inv cacheInvalidation) {
+	if c == nil || strings.TrimSpace(inv.ID) == "" {
+		return
+	}
+	c.evict(inv)
+	if c.rdb == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.rdb.Publish(ctx, IngestCacheChannel, toJSONB(inv)).Err(); err != nil {
+		slog.Warn("ingest_cache_publish_failed", slog.String("kind", inv.Kind), slog.Any("error", err))
+	}
+}
+
+func (c *IngestCache) evict(inv cacheInvalidation) {
+	switch inv.Kind {
+	case invalidateDevice:
+		c.devices.Delete(inv.ID)
+	case invalidateTenant:
+		c.quotas.Delete(inv.ID)
+	}
+}
+
+// Device resolves a device to its tenant and status, using the cache when possible.
+func (c *IngestCache) Device(ctx context.Context, db *pgxpool.Pool, deviceID string) (*ingestDevice, error) {
+	if c != nil {
+		if d, ok := c.devices.Get(deviceID); ok {
+			metrics.IngestCacheLookup("device", "hit")
+			return &d, nil
+		}
+		metrics.IngestCacheLookup("device", "miss")
+	}
+
+	var d ingestDevice
+	err := db.QueryRow(ctx, `
+		SELECT device_id::text, COALESCE(tenant_id::text, ''), status::text
+		FROM devices
+		WHERE device_id = $1::uuid
+	`, deviceID).Scan(&d.DeviceID, &d.TenantID, &d.Status)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		c.devices.Set(deviceID, d)
+	}
+	return &d, nil
+}
+
+// TenantQuota resolves the quota row of a tenant, using the cache when possible.
+func (c *IngestCache) TenantQuota(ctx context.Context, db *pgxpool.Pool, tenantID string) (*TenantQuota, error) {
+	if c != nil {
+		if q, ok := c.quotas.Get(tenantID); ok {
+			metrics.IngestCacheLookup("quota", "hit")
+			return &q, nil
+		}
+		metrics.IngestCacheLookup("quota", "miss")
+	}
+
+	q, err := fetchTenantQuota(ctx, db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		c.quotas.Set(tenantID, *q)
+	}
+	return q, nil
+}
+
+// lruCache is a size-bounded LRU map whose entries also expire after ttl.
+type lruCache[V any] struct {
+	mu      sync.Mutex
+	max     int
+	ttl     time.Duration
+	ll      *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+type lruEntry[V any] struct {
+	key       string
+	value     V
+	expiresAt time.Time
+}
+
+func newLRUCache[V any](max int, ttl time.Duration) *lruCache[V] {
+	return &lruCache[V]{
+		max:     max,
+		ttl:     ttl,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+func (c *lruCache[V]) Get(key string) (V, bool) {
+	var zero V
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+	entry := el.Value.(*lruEntry[V])
+	if c.now().After(entry.expiresAt) {
+		c.ll.Remove(el)
+		delete(c.entries, key)
+		return zero, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+func (c *lruCache[V]) Set(key string, value V) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*lruEntry[V])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.ll.PushFront(&lruEntry[V]{key: key, value: value, expiresAt: expiresAt})
+	for c.max > 0 && c.ll.Len() > c.max {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+func (c *lruCache[V]) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.ll.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+func (c *lruCache[V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	c := newLRUCache[string](2, time.Minute)
+	c.Set("a", "1")
+	c.Set("b", "2")
+
+	// Touch "a" so "b" becomes the eviction candidate.
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("Get(a) should hit")
+	}
+	c.Set("c", "3")
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("Get(b) should miss after eviction")
+	}
+	if v, ok := c.Get("a"); !ok || v != "1" {
+		t.Fatalf("Get(a) = (%q, %v), want (1, true)", v, ok)
+	}
+	if c.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", c.Len())
+	}
+}
+
+func TestLRUCacheExpiresEntries(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	c := newLRUCache[int](10, 30*time.Second)
+	c.now = func() time.Time { return now }
+	c.Set("dev", 1)
+
+	now = now.Add(29 * time.Second)
+	if _, ok := c.Get("dev"); !ok {
+		t.Fatalf("Get before ttl should hit")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok := c.Get("dev"); ok {
+		t.Fatalf("Get after ttl should miss")
+	}
+}
+
+func TestIngestCacheInvalidateEvictsLocally(t *testing.T) {
+	t.Parallel()
+
+	c := &IngestCache{
+		devices: newLRUCache[ingestDevice](10, time.Minute),
+		quotas:  newLRUCache[TenantQuota](10, time.Minute),
+	}
+	c.devices.Set("d1", ingestDevice{DeviceID: "d1", TenantID: "t1", Status: "active"})
+	c.quotas.Set("t1", TenantQuota{TenantID: "t1"})
+
+	c.InvalidateDevice("d1")
+	c.InvalidateTenant("t1")
+
+	if _, ok := c.devices.Get("d1"); ok {
+		t.Fatalf("device should be evicted")
+	}
+	if _, ok := c.quotas.Get("t1"); ok {
+		t.Fatalf("tenant quota should be evicted")
+	}
+}
+
+func TestIngestCacheNilIsSafe(t *testing.T) {
+	t.Parallel()
+
+	var c *IngestCache
+	c.InvalidateDevice("d1")
+	c.InvalidateTenant("t1")
+}
@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"iiot-go-api/config"
+	"iiot-go-api/models"
+	"iiot-go-api/utils"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type InvitationHandler struct {
+	DB       *pgxpool.Pool
+	Config   *config.Config
+	Notifier Notifier
+}
+
+func NewInvitationHandler(db *pgxpool.Pool, cfg *config.Config) *InvitationHandler {
+	return &InvitationHandler{
+		DB:       db,
+		Config:   cfg,
+		Notifier: NewNotifier(cfg),
+	}
+}
+
+// Invitation is a tenant invite as shown to admins (the token is never
+// returned; it only travels in the notification).
+type Invitation struct {
+	InviteID   string     `json:"invite_id"`
+	TenantID   string     `json:"tenant_id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	Status     string     `json:"status"`
+	InvitedBy  *string    `json:"invited_by,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CreateInvitationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required,oneof=tenant_admin tenant_user"`
+}
+
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// CreateInvitation invites an e-mail into the JWT tenant with the given role.
+// Only tenant admins invite new tenant admins.
+func (h *InvitationHandler) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+
+	var req CreateInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	if !isValidEmail(req.Email) {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid email format")
+		return
+	}
+	if req.Role == "tenant_admin" && !requireTenantAdmin(w, r) {
+		return
+	}
+
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	expiresAt := time.Now().UTC().Add(time.Duration(h.Config.InviteTTLHours) * time.Hour)
+
+	ctx := context.Background()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`, req.Email).Scan(&exists); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if exists {
+		utils.WriteError(w, http.StatusConflict, "Email already registered")
+		return
+	}
+
+	// Expired invites no longer block a fresh one.
+	if _, err := tx.Exec(ctx, `
+		UPDATE user_invitations SET revoked_at = NOW()
+		WHERE tenant_id = $1::uuid AND email = $2 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at <= NOW()
+	`, tenantID, req.Email); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM user_invitations
+		WHERE tenant_id = $1::uuid AND email = $2 AND accepted_at IS NULL AND revoked_at IS NULL)
+	`, tenantID, req.Email).Scan(&exists); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if exists {
+		utils.WriteError(w, http.StatusConflict, "Pending invitation already exists")
+		return
+	}
+
+	var inv Invitation
+	err = tx.QueryRow(ctx, `
+		INSERT INTO user_invitations (tenant_id, email, role, token_hash, invited_by, expires_at)
+		VALUES ($1::uuid, $2, $3, $4, NULLIF($5,'')::uuid, $6)
+		RETURNING `+invitationColumns,
+		tenantID, req.Email, req.Role, tokenHash, actorUserID, expiresAt,
+	).Scan(invitationScanDest(&inv)...)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	inv.Status = invitationStatus(inv, time.Now())
+
+	recordInvitationEvent(h.DB, tenantID, actorUserID, inv.InviteID, "invite.created", "create_invitation", map[string]interface{}{
+		"email":      inv.Email,
+		"role":       inv.Role,
+		"expires_at": inv.ExpiresAt,
+	})
+	notifyAsync(h.Notifier, Notification{
+		To:      []string{inv.Email},
+		Subject: "Convite para a plataforma IIoT",
+		Body: fmt.Sprintf(
+			"Você foi convidado para participar de um tenant na plataforma IIoT com o papel %s.\n\nPara aceitar, acesse:\n%s\n\nO convite expira em %s.",
+			inv.Role, inviteAcceptURL(h.Config.AppBaseURL, token), inv.ExpiresAt.Format("02/01/2006 15:04 UTC"),
+		),
+	})
+
+	utils.WriteJSON(w, http.StatusCreated, inv)
+}
+
+// ListInvitations lists invitations of the JWT tenant (pending by default;
+// ?status=accepted|revoked|expired|all widens the list).
+func (h *InvitationHandler) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	if status == "" {
+		status = "pending"
+	}
+	switch status {
+	case "pending", "accepted", "revoked", "expired", "all":
+	default:
+		utils.WriteError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	rows, err := h.DB.Query(context.Background(), `
+		SELECT `+invitationColumns+`
+		FROM user_invitations
+		WHERE tenant_id = $1::uuid
+		ORDER BY created_at DESC
+		LIMIT 500
+	`, tenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	invitations := []Invitation{}
+	for rows.Next() {
+		var inv Invitation
+		if err := rows.Scan(invitationScanDest(&inv)...); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		inv.Status = invitationStatus(inv, now)
+		if status == "all" || inv.Status == status {
+			invitations = append(invitations, inv)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"invitations": invitations})
+}
+
+// RevokeInvitation cancels a pending invitation of the JWT tenant.
+func (h *InvitationHandler) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	inviteID := r.PathValue("invite_id")
+	if _, err := uuid.Parse(inviteID); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid invite_id")
+		return
+	}
+
+	var inv Invitation
+	err := h.DB.QueryRow(context.Background(), `
+		UPDATE user_invitations SET revoked_at = NOW(), revoked_by = NULLIF($3,'')::uuid
+		WHERE invite_id = $1::uuid AND tenant_id = $2::uuid AND accepted_at IS NULL AND revoked_at IS NULL
+		RETURNING `+invitationColumns,
+		inviteID, tenantID, actorUserID,
+	).Scan(invitationScanDest(&inv)...)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, "Pending invitation not found")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	recordInvitationEvent(h.DB, tenantID, actorUserID, inv.InviteID, "invite.revoked", "revoke_invitation", map[string]interface{}{
+		"email": inv.Email,
+		"role":  inv.Role,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvite consumes an invitation token and creates the user inside the
+// inviting tenant, returning tokens like Register.
+func (h *AuthHandler) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	var req AcceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	if err := validatePassword(req.Password); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := context.Background()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Row lock makes the token single-use under concurrent accepts.
+	var inv Invitation
+	var tenantStatus string
+	err = tx.QueryRow(ctx, `
+		SELECT i.invite_id::text, i.tenant_id::text, i.email, i.role::text, i.expires_at, i.accepted_at, i.revoked_at, t.status
+		FROM user_invitations i
+		JOIN tenants t ON t.tenant_id = i.tenant_id
+		WHERE i.token_hash = $1
+		FOR UPDATE OF i
+	`, hashOpaqueToken(req.Token)).Scan(&inv.InviteID, &inv.TenantID, &inv.Email, &inv.Role, &inv.ExpiresAt, &inv.AcceptedAt, &inv.RevokedAt, &tenantStatus)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid or expired invitation")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if invitationStatus(inv, time.Now()) != "pending" {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid or expired invitation")
+		return
+	}
+	if tenantStatus != "active" {
+		utils.WriteError(w, http.StatusForbidden, "Tenant is not active")
+		return
+	}
+
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`, inv.Email).Scan(&exists); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if exists {
+		utils.WriteError(w, http.StatusConflict, "Email already registered")
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptCost)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	// The token reached the invited mailbox, so the e-mail counts as verified.
+	var userID string
+	err = tx.QueryRow(ctx, `
+		INSERT INTO users (tenant_id, email, password_hash, role, status, email_verified, email_verified_at)
+		VALUES ($1::uuid, $2, $3, $4, 'active', true, NOW())
+		RETURNING user_id::text
+	`, inv.TenantID, inv.Email, string(passwordHash), inv.Role).Scan(&userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE user_invitations SET accepted_at = NOW(), accepted_user_id = $2::uuid WHERE invite_id = $1::uuid
+	`, inv.InviteID, userID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	recordInvitationEvent(h.DB, inv.TenantID, userID, inv.InviteID, "invite.accepted", "accept_invitation", map[string]interface{}{
+		"email": inv.Email,
+		"role":  inv.Role,
+	})
+	notifyUserRegistered(h.DB, h.Config, userID, inv.TenantID, inv.Email, inv.Role)
+
+	permissions, err := h.getPermissions(userID, inv.Role)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	mfa, err := h.loadMFAState(ctx, userID, inv.Role, inv.TenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if mfa.enrollmentPending() {
+		permissions = []string{}
+	}
+	accessToken, refreshToken, err := h.issueTokens(ctx, r, "", userID, inv.TenantID, inv.Email, inv.Role, permissions)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	tenantID := inv.TenantID
+	utils.WriteJSON(w, http.StatusCreated, models.RegisterResponse{
+		AccessToken:           accessToken,
+		RefreshToken:          refreshToken,
+		ExpiresIn:             int64(h.Config.JWTAccessExpiration.Seconds()),
+		MFAEnrollmentRequired: mfa.enrollmentPending(),
+		User: models.User{
+			UserID:        userID,
+			TenantID:      &tenantID,
+			Email:         inv.Email,
+			Role:          inv.Role,
+			Status:        "active",
+			EmailVerified: true,
+		},
+	})
+}
+
+const invitationColumns = `invite_id::text, tenant_id::text, email, role::text, invited_by::text, expires_at, accepted_at, revoked_at, created_at`
+
+func invitationScanDest(inv *Invitation) []interface{} {
+	return []interface{}{&inv.InviteID, &inv.TenantID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.ExpiresAt, &inv.AcceptedAt, &inv.RevokedAt, &inv.CreatedAt}
+}
+
+func invitationStatus(inv Invitation, now time.Time) string {
+	switch {
+	case inv.AcceptedAt != nil:
+		return "accepted"
+	case inv.RevokedAt != nil:
+		return "revoked"
+	case !now.Before(inv.ExpiresAt):
+		return "expired"
+	default:
+		return "pending"
+	}
+}
+
+// newOpaqueToken returns a random token and the SHA-256 stored in its place
+// (invitations, password resets).
+func newOpaqueToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	return token, hashOpaqueToken(token), nil
+}
+
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(sum[:])
+}
+
+func inviteAcceptURL(baseURL, token string) string {
+	return strings.TrimRight(baseURL, "/") + "/accept-invite?token=" + url.QueryEscape(token)
+}
+
+func recordInvitationEvent(db *pgxpool.Pool, tenantID, userID, inviteID, eventType, action string, metadata map[string]interface{}) {
+	if db == nil {
+		return
+	}
+	_, _ = db.Exec(context.Background(), `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, resource_type, resource_id, metadata, timestamp)
+		VALUES ($1::uuid, NULLIF($2,'')::uuid, $3, 'auth', 'info', 'user', NULLIF($2,'')::uuid, $4, 'success', 'invitation', $5::uuid, $6::jsonb, NOW())
+	`, tenantID, userID, eventType, action, inviteID, toJSONB(metadata))
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"iiot-go-api/config"
+)
+
+func TestInvitationStatus(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	cases := []struct {
+		name string
+		inv  Invitation
+		want string
+	}{
+		{"pending", Invitation{ExpiresAt: now.Add(time.Hour)}, "pending"},
+		{"expired", Invitation{ExpiresAt: now}, "expired"},
+		{"accepted wins over expiry", Invitation{ExpiresAt: past, AcceptedAt: &past}, "accepted"},
+		{"revoked", Invitation{ExpiresAt: now.Add(time.Hour), RevokedAt: &past}, "revoked"},
+	}
+	for _, tc := range cases {
+		if got := invitationStatus(tc.inv, now); got != tc.want {
+			t.Fatalf("%s: status = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestNewOpaqueTokenStoresOnlyHash(t *testing.T) {
+	t.Parallel()
+
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		t.Fatalf("newOpaqueToken: %v", err)
+	}
+	if len(token) != 64 || len(hash) != 64 || token == hash {
+		t.Fatalf("token=%q hash=%q", token, hash)
+	}
+	if hashOpaqueToken(token) != hash || hashOpaqueToken(" "+token+"\n") != hash {
+		t.Fatal("hashOpaqueToken should be deterministic and ignore surrounding whitespace")
+	}
+}
+
+func TestInviteAcceptURL(t *testing.T) {
+	t.Parallel()
+
+	if got := inviteAcceptURL("https://app.example.com/", "abc"); got != "https://app.example.com/accept-invite?token=abc" {
+		t.Fatalf("inviteAcceptURL = %q", got)
+	}
+}
+
+func TestCreateInvitationRequiresTenant(t *testing.T) {
+	t.Parallel()
+
+	h := &InvitationHandler{Config: &config.Config{InviteTTLHours: 72}}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/invitations", strings.NewReader(`{"email":"op@example.com","role":"tenant_user"}`))
+	w := httptest.NewRecorder()
+
+	h.CreateInvitation(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestCreateInvitationRejectsSuperAdminRole(t *testing.T) {
+	t.Parallel()
+
+	h := &InvitationHandler{Config: &config.Config{InviteTTLHours: 72}}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/invitations", strings.NewReader(`{"email":"op@example.com","role":"super_admin"}`))
+	req = req.WithContext(context.WithValue(req.Context(), "tenant_id", "83409caf-43f8-40b3-8ffe-32b8f0c16a94"))
+	w := httptest.NewRecorder()
+
+	h.CreateInvitation(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAcceptInviteRejectsWeakPassword(t *testing.T) {
+	t.Parallel()
+
+	h := testAuthHandler()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/accept-invite", strings.NewReader(`{"token":"abc","password":"weak"}`))
+	w := httptest.NewRecorder()
+
+	h.AcceptInvite(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
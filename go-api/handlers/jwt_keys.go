@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"crypto"
+	"iiot-go-api/config"
+	"iiot-go-api/utils"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// jwtKeyLockKey serializes key rotation across replicas (transaction-level
+// lock, so every replica waits for the first key instead of skipping).
+const jwtKeyLockKey = 1004
+
+// jwtKeyReloadInterval is how often replicas reload the key set; keys are
+// published JWTKeyPrepublishHours ahead, far longer than this.
+const jwtKeyReloadInterval = 5 * time.Minute
+
+// LoadJWTKeys runs one rotation pass and loads the unexpired keys into ks.
+// Called at startup so the first token is never signed without a key.
+func LoadJWTKeys(ctx context.Context, db *pgxpool.Pool, cfg *config.Config, ks *utils.JWTKeySet) error {
+	if err := rotateJWTKeys(ctx, db, cfg, ks.Algorithm(), time.Now().UTC()); err != nil {
+		return err
+	}
+	keys, err := loadJWTKeys(ctx, db, cfg, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	ks.Replace(keys)
+	return nil
+}
+
+// RunJWTKeyRotation keeps ks current: every jwtKeyReloadInterval it creates
+// the next key ahead of time, schedules the retirement of the old one and
+// reloads the set.
+func RunJWTKeyRotation(ctx context.Context, db *pgxpool.Pool, cfg *config.Config, ks *utils.JWTKeySet) {
+	if db == nil || ks == nil {
+		return
+	}
+	ticker := time.NewTicker(jwtKeyReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := LoadJWTKeys(ctx, db, cfg, ks); err != nil && ctx.Err() == nil {
+				slog.Warn("jwt_key_rotation_error", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// rotateJWTKeys maintains jwt_signing_keys for algorithm:
+//   - no current key, or one that cannot be opened: one is created, signing
+//     immediately (the unreadable key is expired);
+//   - current key due for rotation: the next key is created with not_before
+//     at the rotation time (published JWTKeyPrepublishHours before that) and
+//     the current key expires a refresh token lifetime after it;
+//   - keys of other algorithms (or all keys in HS256 mode) expire a refresh
+//     token lifetime from now;
+//   - expired keys are deleted.
+func rotateJWTKeys(ctx context.Context, db *pgxpool.Pool, cfg *config.Config, algorithm string, now time.Time) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, jwtKeyLockKey); err != nil {
+		return err
+	}
+	overlap := cfg.JWTRefreshExpiration
+	if _, err := tx.Exec(ctx, `DELETE FROM jwt_signing_keys WHERE expires_at <= $1`, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE jwt_signing_keys SET expires_at = $2
+		WHERE algorithm <> $1 AND expires_at IS NULL
+	`, algorithm, now.Add(overlap)); err != nil {
+		return err
+	}
+	if algorithm == utils.JWTAlgHS256 {
+		return tx.Commit(ctx)
+	}
+
+	var currentKID, currentSealed string
+	var currentNotBefore time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT kid, not_before, private_key_encrypted FROM jwt_signing_keys
+		WHERE algorithm = $1 AND not_before <= $2 AND expires_at IS NULL
+		ORDER BY not_before DESC LIMIT 1
+	`, algorithm, now).Scan(&currentKID, &currentNotBefore, &currentSealed)
+	if err == pgx.ErrNoRows {
+		if err := insertJWTKey(ctx, tx, cfg, algorithm, now); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	// A current key nobody can open (encryption key changed) signs nothing:
+	// retire it and sign with a new one right away.
+	if _, err := openJWTPrivateKey(cfg, currentSealed); err != nil {
+		slog.Warn("jwt_key_unreadable_replaced", slog.String("kid", currentKID), slog.Any("error", err))
+		if _, err := tx.Exec(ctx, `UPDATE jwt_signing_keys SET expires_at = $2 WHERE kid = $1`, currentKID, now); err != nil {
+			return err
+		}
+		if err := insertJWTKey(ctx, tx, cfg, algorithm, now); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+
+	// A key already scheduled to take over: nothing to do until it does.
+	var pending bool
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM jwt_signing_keys WHERE algorithm = $1 AND not_before > $2 AND expires_at IS NULL)
+	`, algorithm, now).Scan(&pending); err != nil {
+		return err
+	}
+	if pending {
+		return tx.Commit(ctx)
+	}
+
+	rotation := time.Duration(cfg.JWTKeyRotationDays) * 24 * time.Hour
+	prepublish := time.Duration(cfg.JWTKeyPrepublishHours) * time.Hour
+	if rotation <= 0 {
+		return tx.Commit(ctx)
+	}
+	rotateAt := currentNotBefore.Add(rotation)
+	if !now.Before(rotateAt.Add(-prepublish)) {
+		if rotateAt.Before(now) {
+			rotateAt = now
+		}
+		if err := insertJWTKey(ctx, tx, cfg, algorithm, rotateAt); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE jwt_signing_keys SET expires_at = $2 WHERE kid = $1
+		`, currentKID, rotateAt.Add(overlap)); err != nil {
+			return err
+		}
+		slog.Info("jwt_key_rotation_scheduled", slog.String("retiring_kid", currentKID), slog.Time("rotate_at", rotateAt))
+	}
+	return tx.Commit(ctx)
+}
+
+func insertJWTKey(ctx context.Context, tx pgx.Tx, cfg *config.Config, algorithm string, notBefore time.Time) error {
+	key, err := utils.GenerateJWTKey(algorithm, notBefore)
+	if err != nil {
+		return err
+	}
+	pemKey, err := utils.MarshalJWTPrivateKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	sealed, err := utils.EncryptSecret(jwtKeyEncryptionKey(cfg), pemKey)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO jwt_signing_keys (kid, algorithm, private_key_encrypted, not_before)
+		VALUES ($1, $2, $3, $4)
+	`, key.KID, algorithm, sealed, notBefore)
+	return err
+}
+
+// loadJWTKeys reads and opens the unexpired keys.
+func loadJWTKeys(ctx context.Context, db *pgxpool.Pool, cfg *config.Config, now time.Time) ([]utils.JWTKey, error) {
+	rows, err := db.Query(ctx, `
+		SELECT kid, algorithm, private_key_encrypted, not_before, expires_at
+		FROM jwt_signing_keys
+		WHERE expires_at IS NULL OR expires_at > $1
+		ORDER BY not_before
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []utils.JWTKey
+	for rows.Next() {
+		var k utils.JWTKey
+		var sealed string
+		if err := rows.Scan(&k.KID, &k.Algorithm, &sealed, &k.NotBefore, &k.ExpiresAt); err != nil {
+			return nil, err
+		}
+		if k.PrivateKey, err = openJWTPrivateKey(cfg, sealed); err != nil {
+			slog.Warn("jwt_key_open_failed", slog.String("kid", k.KID), slog.Any("error", err))
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// openJWTPrivateKey decrypts and parses a stored key. Keys sealed before
+// JWT_KEY_ENCRYPTION_KEY was required used the key derived from JWT_SECRET
+// and still open with it while that secret is unchanged.
+func openJWTPrivateKey(cfg *config.Config, sealed string) (crypto.Signer, error) {
+	pemKey, err := utils.DecryptSecret(jwtKeyEncryptionKey(cfg), sealed)
+	if err != nil && cfg.JWTKeyEncryptionKey != "" {
+		pemKey, err = utils.DecryptSecret(legacyJWTKeyEncryptionKey(cfg), sealed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return utils.ParseJWTPrivateKey(pemKey)
+}
+
+func jwtKeyEncryptionKey(cfg *config.Config) string {
+	if cfg.JWTKeyEncryptionKey != "" {
+		return cfg.JWTKeyEncryptionKey
+	}
+	return legacyJWTKeyEncryptionKey(cfg)
+}
+
+func legacyJWTKeyEncryptionKey(cfg *config.Config) string {
+	return "jwtkeys:" + cfg.JWTSecret
+}
+
+// JWKSHandler serves the public verification keys (RFC 7517), including
+// keys published ahead of rotation and retired keys still in their overlap.
+func JWKSHandler(ks *utils.JWTKeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		set := utils.JWKS{Keys: []utils.JWK{}}
+		if ks != nil {
+			set = ks.JWKS(time.Now())
+		}
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		utils.WriteJSON(w, http.StatusOK, set)
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"iiot-go-api/config"
+	"iiot-go-api/utils"
+)
+
+func TestJWKSHandlerPublishesKeys(t *testing.T) {
+	t.Parallel()
+
+	ks, err := utils.NewJWTKeySet(utils.JWTAlgEdDSA, false)
+	if err != nil {
+		t.Fatalf("NewJWTKeySet error: %v", err)
+	}
+	key, err := utils.GenerateJWTKey(utils.JWTAlgEdDSA, time.Now())
+	if err != nil {
+		t.Fatalf("GenerateJWTKey error: %v", err)
+	}
+	ks.Replace([]utils.JWTKey{key})
+
+	w := httptest.NewRecorder()
+	JWKSHandler(ks)(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w.Header().Get("Cache-Control") == "" {
+		t.Fatal("JWKS should be cacheable")
+	}
+	var set utils.JWKS
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if len(set.Keys) != 1 || set.Keys[0].Kid != key.KID || set.Keys[0].Kty != "OKP" || set.Keys[0].Crv != "Ed25519" {
+		t.Fatalf("keys = %+v, want the Ed25519 key %s", set.Keys, key.KID)
+	}
+}
+
+func TestJWKSHandlerWithoutKeySet(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	JWKSHandler(nil)(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if w.Code != http.StatusOK || w.Body.String() == "" {
+		t.Fatalf("status = %d, body = %q", w.Code, w.Body.String())
+	}
+	var set utils.JWKS
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil || set.Keys == nil {
+		t.Fatalf("want empty key list, got %q (%v)", w.Body.String(), err)
+	}
+}
+
+func TestOpenJWTPrivateKeyAfterSecretRotation(t *testing.T) {
+	t.Parallel()
+
+	key, err := utils.GenerateJWTKey(utils.JWTAlgEdDSA, time.Now())
+	if err != nil {
+		t.Fatalf("GenerateJWTKey error: %v", err)
+	}
+	pemKey, err := utils.MarshalJWTPrivateKey(key.PrivateKey)
+	if err != nil {
+		t.Fatalf("MarshalJWTPrivateKey error: %v", err)
+	}
+	old := &config.Config{JWTSecret: "old-secret"}
+	sealed, err := utils.EncryptSecret(jwtKeyEncryptionKey(old), pemKey)
+	if err != nil {
+		t.Fatalf("EncryptSecret error: %v", err)
+	}
+
+	if _, err := openJWTPrivateKey(old, sealed); err != nil {
+		t.Fatalf("open with the sealing config: %v", err)
+	}
+	// Sealed with the JWT_SECRET-derived key, then JWT_SECRET rotated: the
+	// key cannot be opened, so rotation must treat it as missing.
+	if _, err := openJWTPrivateKey(&config.Config{JWTSecret: "new-secret"}, sealed); err == nil {
+		t.Fatal("expected a key sealed under the old secret to be unreadable")
+	}
+	// Setting JWT_KEY_ENCRYPTION_KEY keeps legacy keys readable meanwhile.
+	if _, err := openJWTPrivateKey(&config.Config{JWTSecret: "old-secret", JWTKeyEncryptionKey: "kek"}, sealed); err != nil {
+		t.Fatalf("legacy key with JWT_KEY_ENCRYPTION_KEY set: %v", err)
+	}
+}
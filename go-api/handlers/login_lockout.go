@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"iiot-go-api/models"
+	"iiot-go-api/utils"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Per-account login protection, keyed by a hash of the e-mail so it applies
+// the same way whether or not the account exists:
+//   - auth:login:fail:{hash}   failures in the current window
+//   - auth:login:next:{hash}   progressive delay before the next attempt
+//   - auth:login:locked:{hash} temporary lockout
+const (
+	loginFailKeyPrefix   = "auth:login:fail:"
+	loginNextKeyPrefix   = "auth:login:next:"
+	loginLockedKeyPrefix = "auth:login:locked:"
+	loginMaxDelay        = time.Minute
+)
+
+// bcryptCost is the work factor of every bcrypt hash the API writes
+// (passwords, device secrets, API keys). The login fallback hash uses it
+// too, so a missing account costs as much to check as a real one.
+const bcryptCost = 12
+
+var (
+	dummyPasswordHashOnce sync.Once
+	dummyPasswordHash     []byte
+)
+
+// compareLoginPassword runs bcrypt even for unknown accounts (against a
+// dummy hash), so timing does not reveal whether the e-mail exists.
+func compareLoginPassword(passwordHash, password string) bool {
+	if passwordHash == "" {
+		dummyPasswordHashOnce.Do(func() {
+			dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password-for-timing"), bcryptCost)
+		})
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil
+}
+
+// loginBlocked reports whether the account is locked or still inside its
+// progressive delay, writing 429 with Retry-After. Redis errors fail open.
+func (h *AuthHandler) loginBlocked(w http.ResponseWriter, ctx context.Context, email string) bool {
+	if h.Redis == nil {
+		return false
+	}
+	hash := loginEmailHash(email)
+	if ttl, err := h.Redis.PTTL(ctx, loginLockedKeyPrefix+hash).Result(); err == nil && ttl > 0 {
+		writeRetryAfter(w, ttl)
+		utils.WriteErrorWithCode(w, http.StatusTooManyRequests, "account_locked", "Account temporarily locked after too many failed logins")
+		return true
+	}
+	if ttl, err := h.Redis.PTTL(ctx, loginNextKeyPrefix+hash).Result(); err == nil && ttl > 0 {
+		writeRetryAfter(w, ttl)
+		utils.WriteErrorWithCode(w, http.StatusTooManyRequests, "login_throttled", "Too many failed logins, wait before retrying")
+		return true
+	}
+	return false
+}
+
+// recordLoginFailure counts a failed login for email. From
+// LoginDelayAfterFailures on, each failure doubles the wait before the next
+// attempt (capped at loginMaxDelay); at LoginLockoutThreshold the account
+// is locked for LoginLockoutMins. user is nil for unknown e-mails.
+func (h *AuthHandler) recordLoginFailure(r *http.Request, email string, user *models.User) {
+	if h.Redis == nil {
+		return
+	}
+	ctx := context.Background()
+	hash := loginEmailHash(email)
+	failKey := loginFailKeyPrefix + hash
+
+	failures, err := h.Redis.Incr(ctx, failKey).Result()
+	if err != nil {
+		slog.Warn("login_failure_tracking_failed", slog.Any("error", err))
+		return
+	}
+	if failures == 1 {
+		_ = h.Redis.Expire(ctx, failKey, time.Duration(h.Config.LoginFailureWindowMins)*time.Minute).Err()
+	}
+
+	if h.Config.LoginLockoutThreshold > 0 && failures >= h.Config.LoginLockoutThreshold {
+		lockout := time.Duration(h.Config.LoginLockoutMins) * time.Minute
+		pipe := h.Redis.TxPipeline()
+		pipe.Set(ctx, loginLockedKeyPrefix+hash, failures, lockout)
+		pipe.Del(ctx, failKey, loginNextKeyPrefix+hash)
+		if _, err := pipe.Exec(ctx); err != nil {
+			slog.Warn("login_lockout_failed", slog.Any("error", err))
+			return
+		}
+		h.onAccountLocked(r, email, user, failures, lockout)
+		return
+	}
+	if delay := loginDelay(failures, h.Config.LoginDelayAfterFailures); delay > 0 {
+		_ = h.Redis.Set(ctx, loginNextKeyPrefix+hash, failures, delay).Err()
+	}
+}
+
+// clearLoginFailures resets the counters after a successful login.
+func (h *AuthHandler) clearLoginFailures(email string) {
+	if h.Redis == nil {
+		return
+	}
+	hash := loginEmailHash(email)
+	_ = h.Redis.Del(context.Background(), loginFailKeyPrefix+hash, loginNextKeyPrefix+hash).Err()
+}
+
+func (h *AuthHandler) onAccountLocked(r *http.Request, email string, user *models.User, failures int64, lockout time.Duration) {
+	ip := utils.ClientIP(r)
+	slog.Warn("account_locked", slog.String("email_hash", loginEmailHash(email)), slog.Int64("failures", failures), slog.String("ip", ip))
+	if user == nil || h.DB == nil {
+		return
+	}
+	_, _ = h.DB.Exec(context.Background(), `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, resource_type, resource_id, user_agent, metadata, timestamp)
+		VALUES (NULLIF($1,'')::uuid, $2::uuid, 'auth.account_locked', 'security', 'warning', 'system', NULL, 'lock_account', 'blocked', 'user', $2::uuid, NULLIF($3,''), $4::jsonb, NOW())
+	`, tenantIDStrOrEmpty(user.TenantID), user.UserID, r.UserAgent(), toJSONB(map[string]interface{}{
+		"failures":     failures,
+		"lockout_mins": int64(lockout.Minutes()),
+		"ip":           ip,
+	}))
+	notifyAsync(h.Notifier, Notification{
+		To:      []string{user.Email},
+		Subject: "Conta bloqueada temporariamente",
+		Body:    "Sua conta na plataforma IIoT foi bloqueada temporariamente após várias tentativas de login com senha ou código de verificação incorretos.\n\nO bloqueio termina sozinho; o administrador do seu tenant também pode desbloqueá-la. Se não foi você, considere redefinir sua senha.",
+	})
+}
+
+type UnlockUserResponse struct {
+	WasLocked bool `json:"was_locked"`
+}
+
+// UnlockUser lifts the login lockout (and failure counters) of a user of the
+// JWT tenant.
+func (h *UserHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	tenantID, tx, ok := tenantTx(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	if h.Redis == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "Lockout store unavailable")
+		return
+	}
+
+	ctx := r.Context()
+	target, ok := h.lockTenantUser(w, ctx, tx, tenantID, userID)
+	if !ok {
+		return
+	}
+	wasLocked, err := unlockLogin(ctx, h.Redis, target.Email)
+	if err != nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "Lockout store unavailable")
+		return
+	}
+	recordUserEvent(ctx, tx, tenantID, actorUserID, userID, "auth.account_unlocked", "unlock_account", map[string]interface{}{
+		"was_locked": wasLocked,
+	})
+	utils.WriteJSON(w, http.StatusOK, UnlockUserResponse{WasLocked: wasLocked})
+}
+
+// unlockLogin removes the lockout and failure counters of email. Reports
+// whether the account was locked.
+func unlockLogin(ctx context.Context, rdb *redis.Client, email string) (bool, error) {
+	hash := loginEmailHash(email)
+	locked, err := rdb.Exists(ctx, loginLockedKeyPrefix+hash).Result()
+	if err != nil {
+		return false, err
+	}
+	if err := rdb.Del(ctx, loginLockedKeyPrefix+hash, loginFailKeyPrefix+hash, loginNextKeyPrefix+hash).Err(); err != nil {
+		return false, err
+	}
+	return locked == 1, nil
+}
+
+// loginDelay is the wait imposed after the given number of failures: 1s at
+// delayAfter, doubling up to loginMaxDelay.
+func loginDelay(failures, delayAfter int64) time.Duration {
+	if delayAfter <= 0 || failures < delayAfter {
+		return 0
+	}
+	shift := failures - delayAfter
+	if shift > 6 {
+		return loginMaxDelay
+	}
+	if d := time.Second << shift; d < loginMaxDelay {
+		return d
+	}
+	return loginMaxDelay
+}
+
+func loginEmailHash(email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeRetryAfter(w http.ResponseWriter, ttl time.Duration) {
+	secs := int64((ttl + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", secs))
+}
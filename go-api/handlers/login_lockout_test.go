@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginDelayIsProgressive(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{9, loginMaxDelay},
+		{40, loginMaxDelay},
+	}
+	for _, c := range cases {
+		if got := loginDelay(c.failures, 3); got != c.want {
+			t.Errorf("loginDelay(%d) = %v, want %v", c.failures, got, c.want)
+		}
+	}
+	if got := loginDelay(10, 0); got != 0 {
+		t.Errorf("delay disabled: got %v", got)
+	}
+}
+
+func TestRecordLoginFailureThrottlesThenLocks(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	h := testAuthHandler()
+	h.Redis = rdb
+	h.Config.LoginDelayAfterFailures = 3
+	h.Config.LoginLockoutThreshold = 5
+	h.Config.LoginFailureWindowMins = 15
+	h.Config.LoginLockoutMins = 15
+
+	email := "ana@empresa.com"
+	hash := loginEmailHash(email)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", nil)
+
+	for i := 0; i < 2; i++ {
+		h.recordLoginFailure(req, email, nil)
+	}
+	if mr.Exists(loginNextKeyPrefix + hash) {
+		t.Fatal("no delay expected before LoginDelayAfterFailures")
+	}
+
+	h.recordLoginFailure(req, email, nil)
+	w := httptest.NewRecorder()
+	if !h.loginBlocked(w, context.Background(), email) {
+		t.Fatal("expected progressive delay after 3 failures")
+	}
+	assertErrorCode(t, w, http.StatusTooManyRequests, "login_throttled")
+	if w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Retry-After = %q, want 1", w.Header().Get("Retry-After"))
+	}
+
+	for i := 0; i < 2; i++ {
+		h.recordLoginFailure(req, email, nil)
+	}
+	w = httptest.NewRecorder()
+	if !h.loginBlocked(w, context.Background(), email) {
+		t.Fatal("expected lockout at threshold")
+	}
+	assertErrorCode(t, w, http.StatusTooManyRequests, "account_locked")
+	if mr.Exists(loginFailKeyPrefix + hash) {
+		t.Fatal("failure counter should restart after lockout")
+	}
+
+	wasLocked, err := unlockLogin(context.Background(), rdb, email)
+	if err != nil || !wasLocked {
+		t.Fatalf("unlockLogin = %v, %v; want true", wasLocked, err)
+	}
+	if h.loginBlocked(httptest.NewRecorder(), context.Background(), email) {
+		t.Fatal("account should be unlocked")
+	}
+}
+
+func TestClearLoginFailuresKeepsLockout(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	h := testAuthHandler()
+	h.Redis = rdb
+	hash := loginEmailHash("ana@empresa.com")
+	mr.Set(loginFailKeyPrefix+hash, "2")
+	mr.Set(loginLockedKeyPrefix+hash, "10")
+
+	h.clearLoginFailures("ana@empresa.com")
+	if mr.Exists(loginFailKeyPrefix + hash) {
+		t.Fatal("failure counter should be cleared")
+	}
+	if !mr.Exists(loginLockedKeyPrefix + hash) {
+		t.Fatal("lockout is only lifted by expiry or admin unlock")
+	}
+}
+
+func TestCompareLoginPassword(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("Abcdef1!"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword error: %v", err)
+	}
+	if !compareLoginPassword(string(hash), "Abcdef1!") {
+		t.Fatal("expected match")
+	}
+	if compareLoginPassword(string(hash), "wrong") {
+		t.Fatal("expected mismatch")
+	}
+	if compareLoginPassword("", "Abcdef1!") {
+		t.Fatal("unknown account must never match")
+	}
+}
+
+func TestDummyPasswordHashCostMatchesRealHashes(t *testing.T) {
+	t.Parallel()
+
+	compareLoginPassword("", "Abcdef1!")
+	cost, err := bcrypt.Cost(dummyPasswordHash)
+	if err != nil {
+		t.Fatalf("Cost error: %v", err)
+	}
+	if cost != bcryptCost {
+		t.Fatalf("fallback hash cost = %d, want %d (real password hashes)", cost, bcryptCost)
+	}
+}
+
+func TestUnlockUserRequiresTenantContext(t *testing.T) {
+	t.Parallel()
+
+	h := &UserHandler{Config: testAuthHandler().Config}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/0b8c7f4e-5d6a-4f3b-9e2d-1a2b3c4d5e6f/unlock", nil)
+	req.SetPathValue("user_id", "0b8c7f4e-5d6a-4f3b-9e2d-1a2b3c4d5e6f")
+	w := httptest.NewRecorder()
+	h.UnlockUser(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func assertErrorCode(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d", w.Code, status)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body["code"] != code {
+		t.Fatalf("code = %v, want %s", body["code"], code)
+	}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// Monthly message counters live in Redis (hot path) and are flushed to
+// tenant_message_counters so they survive a Redis restart. The period is
+// always the UTC calendar month (currentMonthRange): annual billing still
+// grants a monthly allowance, so billing_cycle does not shift the window.
+const messageCounterKeyPrefix = "quota:tenant:"
+
+func messageCounterKey(tenantID string, periodStart time.Time) string {
+	return fmt.Sprintf("%s%s:msgs:%s", messageCounterKeyPrefix, tenantID, periodStart.UTC().Format("200601"))
+}
+
+// parseMessageCounterKey extracts tenant and period from a counter key.
+func parseMessageCounterKey(key string) (string, time.Time, bool) {
+	rest := strings.TrimPrefix(key, messageCounterKeyPrefix)
+	if rest == key {
+		return "", time.Time{}, false
+	}
+	parts := strings.Split(rest, ":")
+	if len(parts) != 3 || parts[1] != "msgs" || parts[0] == "" {
+		return "", time.Time{}, false
+	}
+	start, err := time.ParseInLocation("200601", parts[2], time.UTC)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return parts[0], start, true
+}
+
+// monthlyMessageCount returns the number of messages accepted for the tenant
+// in the current period. A missing Redis key is seeded from Postgres.
+func monthlyMessageCount(ctx context.Context, db *pgxpool.Pool, rdb *redis.Client, tenantID string) (int64, error) {
+	start, end := currentMonthRange(time.Now().UTC())
+	key := messageCounterKey(tenantID, start)
+
+	count, err := rdb.Get(ctx, key).Int64()
+	if err == nil {
+		return count, nil
+	}
+	if err != redis.Nil {
+		return 0, err
+	}
+
+	var persisted int64
+	if db != nil {
+		_ = db.QueryRow(ctx, `
+			SELECT messages FROM tenant_message_counters
+			WHERE tenant_id = $1::uuid AND period_start = $2
+		`, tenantID, start).Scan(&persisted)
+	}
+	// SETNX keeps a concurrent replica's increments if it seeded first.
+	if ok, err := rdb.SetNX(ctx, key, persisted, time.Until(end)+35*24*time.Hour).Result(); err == nil && !ok {
+		return rdb.Get(ctx, key).Int64()
+	}
+	return persisted, nil
+}
+
+// recordMonthlyMessage counts one accepted message for the tenant period.
+func recordMonthlyMessage(ctx context.Context, rdb *redis.Client, tenantID string) {
+	if rdb == nil || tenantID == "" {
+		return
+	}
+	start, end := currentMonthRange(time.Now().UTC())
+	key := messageCounterKey(tenantID, start)
+	count, err := rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return
+	}
+	if count == 1 {
+		_ = rdb.Expire(ctx, key, time.Until(end)+35*24*time.Hour).Err()
+	}
+}
+
+// FlushMessageCounters persists every Redis period counter to Postgres.
+func FlushMessageCounters(ctx context.Context, db *pgxpool.Pool, rdb *redis.Client) error {
+	if db == nil || rdb == nil {
+		return nil
+	}
+	var cursor uint64
+	for {
+		keys, next, err := rdb.Scan(ctx, cursor, messageCounterKeyPrefix+"*:msgs:*", 200).Result()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			tenantID, start, ok := parseMessageCounterKey(key)
+			if !ok {
+				continue
+			}
+			count, err := rdb.Get(ctx, key).Int64()
+			if err != nil {
+				continue
+			}
+			_, err = db.Exec(ctx, `
+				INSERT INTO tenant_message_counters (tenant_id, period_start, period_end, messages, updated_at)
+				VALUES ($1::uuid, $2, $3, $4, NOW())
+				ON CONFLICT (tenant_id, period_start)
+				DO UPDATE SET
+					messages = GREATEST(tenant_message_counters.messages, EXCLUDED.messages),
+					updated_at = NOW()
+			`, tenantID, start, start.AddDate(0, 1, 0), count)
+			if err != nil {
+				slog.Warn("message_counter_flush_failed", slog.String("tenant_id", tenantID), slog.Any("error", err))
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// RunMessageCounterFlusher flushes counters every interval until ctx is done.
+func RunMessageCounterFlusher(ctx context.Context, db *pgxpool.Pool, rdb *redis.Client, interval time.Duration) {
+	if db == nil || rdb == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_ = FlushMessageCounters(flushCtx, db, rdb)
+			cancel()
+			return
+		case <-ticker.C:
+			if err := FlushMessageCounters(ctx, db, rdb); err != nil {
+				slog.Warn("message_counter_flush_error", slog.Any("error", err))
+			}
+		}
+	}
+}
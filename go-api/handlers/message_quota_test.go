@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMessageCounterKeyRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	key := messageCounterKey("t1", start)
+	if key != "quota:tenant:t1:msgs:202603" {
+		t.Fatalf("messageCounterKey = %q", key)
+	}
+
+	tenantID, got, ok := parseMessageCounterKey(key)
+	if !ok || tenantID != "t1" || !got.Equal(start) {
+		t.Fatalf("parseMessageCounterKey = (%q, %v, %v)", tenantID, got, ok)
+	}
+
+	// Per-minute device counters share the prefix and must be ignored.
+	if _, _, ok := parseMessageCounterKey("quota:tenant:t1:device:d1:m"); ok {
+		t.Fatalf("parseMessageCounterKey should reject per-minute keys")
+	}
+}
+
+func TestMessagePeriodIsCalendarMonth(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, time.February, 17, 13, 0, 0, 0, time.UTC)
+	start, end := currentMonthRange(now)
+	if !start.Equal(time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("start = %v", start)
+	}
+	if !end.Equal(time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("end = %v", end)
+	}
+	if got := messageCounterKey("t1", start); got != "quota:tenant:t1:msgs:202602" {
+		t.Fatalf("counter key = %s", got)
+	}
+}
+
+func TestMonthlyMessageCounter(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	ctx := context.Background()
+	count, err := monthlyMessageCount(ctx, nil, rdb, "t1")
+	if err != nil || count != 0 {
+		t.Fatalf("initial count = (%d, %v), want (0, nil)", count, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		recordMonthlyMessage(ctx, rdb, "t1")
+	}
+	count, err = monthlyMessageCount(ctx, nil, rdb, "t1")
+	if err != nil || count != 3 {
+		t.Fatalf("count = (%d, %v), want (3, nil)", count, err)
+	}
+}
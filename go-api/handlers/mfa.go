@@ -0,0 +1,611 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"iiot-go-api/models"
+	"iiot-go-api/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	mfaChallengeKeyPrefix = "auth:mfa:challenge:"
+	mfaAttemptsKeyPrefix  = "auth:mfa:attempts:"
+	mfaMaxAttempts        = 5
+	mfaRecoveryCodeCount  = 10
+	mfaTOTPSkew           = 1
+)
+
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+type MFADisableRequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+// MFAChallengeResponse is the first step of a login with MFA: no JWTs yet,
+// only the challenge to complete at /auth/mfa/verify.
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type MFAEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type MFARecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFAStatus struct {
+	Enabled                bool `json:"enabled"`
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// mfaState is the MFA situation of a user when tokens are issued.
+type mfaState struct {
+	Enabled  bool
+	Required bool
+}
+
+// enrollmentPending reports MFA mandatory but not set up: tokens carry no
+// permissions until the user enrols.
+func (s mfaState) enrollmentPending() bool {
+	return s.Required && !s.Enabled
+}
+
+// GetMFAStatus returns the caller's MFA state.
+func (h *AuthHandler) GetMFAStatus(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("jwt_claims").(*models.JWTClaims)
+	if !ok || claims == nil {
+		utils.WriteError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	ctx := r.Context()
+	state, err := h.loadMFAState(ctx, claims.UserID, claims.Role, claims.TenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	status := MFAStatus{Enabled: state.Enabled, Required: state.Required}
+	if err := h.DB.QueryRow(ctx, `
+		SELECT COUNT(*) FROM user_mfa_recovery_codes WHERE user_id = $1::uuid AND used_at IS NULL
+	`, claims.UserID).Scan(&status.RecoveryCodesRemaining); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, status)
+}
+
+// EnrollMFA starts (or restarts) TOTP enrolment and returns the secret and
+// its otpauth:// URI for the QR code. Nothing changes at login until the
+// enrolment is confirmed.
+func (h *AuthHandler) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("jwt_claims").(*models.JWTClaims)
+	if !ok || claims == nil {
+		utils.WriteError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	sealed, err := utils.EncryptSecret(h.mfaKey(), secret)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	tag, err := h.DB.Exec(r.Context(), `
+		INSERT INTO user_mfa (user_id, secret_encrypted) VALUES ($1::uuid, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret_encrypted = EXCLUDED.secret_encrypted, last_used_step = 0, updated_at = NOW()
+		WHERE user_mfa.confirmed_at IS NULL
+	`, claims.UserID, sealed)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		utils.WriteErrorWithCode(w, http.StatusConflict, "mfa_already_enabled", "MFA is already enabled")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, MFAEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: utils.TOTPProvisioningURI(h.Config.MFAIssuer, claims.Email, secret),
+	})
+}
+
+// ConfirmMFA completes enrolment with a first valid code and returns the
+// recovery codes (shown only once).
+func (h *AuthHandler) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("jwt_claims").(*models.JWTClaims)
+	if !ok || claims == nil {
+		utils.WriteError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var sealed string
+	err = tx.QueryRow(ctx, `
+		SELECT secret_encrypted FROM user_mfa WHERE user_id = $1::uuid AND confirmed_at IS NULL FOR UPDATE
+	`, claims.UserID).Scan(&sealed)
+	if err == pgx.ErrNoRows {
+		utils.WriteErrorWithCode(w, http.StatusConflict, "mfa_not_pending", "No pending MFA enrollment")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	secret, err := utils.DecryptSecret(h.mfaKey(), sealed)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	step, ok := utils.ValidateTOTP(secret, normalizeTOTPCode(req.Code), time.Now(), mfaTOTPSkew)
+	if !ok {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid MFA code")
+		return
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE user_mfa SET confirmed_at = NOW(), last_used_step = $2, updated_at = NOW() WHERE user_id = $1::uuid
+	`, claims.UserID, step); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, claims.UserID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	recordAuthEvent(h.DB, claims.TenantID, claims.UserID, "auth.mfa_enabled", "enable_mfa", "success", map[string]interface{}{
+		"method": "totp",
+	})
+	utils.WriteJSON(w, http.StatusOK, MFARecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableMFA removes TOTP after re-checking the password and a code. Not
+// allowed while MFA is mandatory for the account.
+func (h *AuthHandler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("jwt_claims").(*models.JWTClaims)
+	if !ok || claims == nil {
+		utils.WriteError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var req MFADisableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+
+	ctx := r.Context()
+	state, err := h.loadMFAState(ctx, claims.UserID, claims.Role, claims.TenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if !state.Enabled {
+		utils.WriteErrorWithCode(w, http.StatusConflict, "mfa_not_enabled", "MFA is not enabled")
+		return
+	}
+	if state.Required {
+		utils.WriteErrorWithCode(w, http.StatusConflict, "mfa_required", "MFA is mandatory for this account")
+		return
+	}
+
+	var passwordHash, email string
+	if err := h.DB.QueryRow(ctx, `SELECT password_hash, email FROM users WHERE user_id = $1::uuid`, claims.UserID).Scan(&passwordHash, &email); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)) != nil {
+		utils.WriteError(w, http.StatusForbidden, "Invalid password or MFA code")
+		return
+	}
+	if _, ok, err := h.checkMFACode(ctx, claims.UserID, req.Code); err != nil || !ok {
+		utils.WriteError(w, http.StatusForbidden, "Invalid password or MFA code")
+		return
+	}
+
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `DELETE FROM user_mfa_recovery_codes WHERE user_id = $1::uuid`, claims.UserID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_mfa WHERE user_id = $1::uuid`, claims.UserID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	recordAuthEvent(h.DB, claims.TenantID, claims.UserID, "auth.mfa_disabled", "disable_mfa", "success", nil)
+	notifyAsync(h.Notifier, Notification{
+		To:      []string{email},
+		Subject: "Autenticação em dois fatores desativada",
+		Body:    "A autenticação em dois fatores da sua conta na plataforma IIoT foi desativada.\n\nSe não foi você, altere sua senha e avise o administrador do seu tenant imediatamente.",
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after a valid code.
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("jwt_claims").(*models.JWTClaims)
+	if !ok || claims == nil {
+		utils.WriteError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+
+	ctx := r.Context()
+	if _, ok, err := h.checkMFACode(ctx, claims.UserID, req.Code); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	} else if !ok {
+		utils.WriteError(w, http.StatusForbidden, "Invalid MFA code")
+		return
+	}
+
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer tx.Rollback(ctx)
+	codes, err := replaceRecoveryCodes(ctx, tx, claims.UserID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	recordAuthEvent(h.DB, claims.TenantID, claims.UserID, "auth.mfa_recovery_codes_regenerated", "regenerate_recovery_codes", "success", nil)
+	utils.WriteJSON(w, http.StatusOK, MFARecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// VerifyMFA completes a login challenge with a TOTP or recovery code and
+// issues the JWTs. Each challenge allows mfaMaxAttempts tries.
+func (h *AuthHandler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req MFAVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	if h.Redis == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "MFA unavailable")
+		return
+	}
+
+	ctx := r.Context()
+	hash := hashOpaqueToken(req.MFAToken)
+	challengeKey, attemptsKey := mfaChallengeKeyPrefix+hash, mfaAttemptsKeyPrefix+hash
+	userID, err := h.Redis.Get(ctx, challengeKey).Result()
+	if err == redis.Nil {
+		utils.WriteError(w, http.StatusUnauthorized, "Invalid or expired MFA challenge")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "MFA unavailable")
+		return
+	}
+	attempts, err := h.Redis.Incr(ctx, attemptsKey).Result()
+	if err != nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "MFA unavailable")
+		return
+	}
+	if attempts == 1 {
+		_ = h.Redis.Expire(ctx, attemptsKey, h.mfaChallengeTTL()).Err()
+	}
+	if attempts > mfaMaxAttempts {
+		_ = h.Redis.Del(ctx, challengeKey, attemptsKey).Err()
+		recordAuthEvent(h.DB, "", userID, "auth.mfa_failed", "verify_mfa", "blocked", map[string]interface{}{
+			"reason": "too_many_attempts",
+		})
+		utils.WriteErrorWithCode(w, http.StatusUnauthorized, "mfa_attempts_exceeded", "Too many attempts, log in again")
+		return
+	}
+
+	var user models.User
+	err = h.DB.QueryRow(ctx, `
+		SELECT user_id, tenant_id, email, role, status, COALESCE(email_verified, false)
+		FROM users
+		WHERE user_id = $1::uuid
+	`, userID).Scan(&user.UserID, &user.TenantID, &user.Email, &user.Role, &user.Status, &user.EmailVerified)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, "Invalid or expired MFA challenge")
+		return
+	}
+	if user.Status != "active" {
+		utils.WriteError(w, http.StatusForbidden, "Account is not active")
+		return
+	}
+	tenantID := tenantIDStrOrEmpty(user.TenantID)
+	// Wrong codes feed the account lockout, which a new challenge does not reset.
+	if h.loginBlocked(w, ctx, user.Email) {
+		return
+	}
+
+	method, ok, err := h.checkMFACode(ctx, user.UserID, req.Code)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if !ok {
+		h.rejectMFACode(w, r, user, tenantID, attempts)
+		return
+	}
+	_ = h.Redis.Del(ctx, challengeKey, attemptsKey).Err()
+	h.clearLoginFailures(user.Email)
+
+	permissions, denied, err := h.permissionsFor(ctx, user.UserID, user.Role, tenantID, user.EmailVerified)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if denied {
+		utils.WriteErrorWithCode(w, http.StatusForbidden, "email_not_verified", "Email not verified")
+		return
+	}
+
+	recordAuthEvent(h.DB, tenantID, user.UserID, "auth.mfa_verified", "verify_mfa", "success", map[string]interface{}{
+		"method": method,
+	})
+	h.writeLoginTokens(w, r, user, tenantID, permissions, false)
+}
+
+// rejectMFACode answers a wrong second factor. Besides the per-challenge
+// attempts, it counts as a failed login of the account (login lockout), so
+// fresh password logins do not buy more guesses.
+func (h *AuthHandler) rejectMFACode(w http.ResponseWriter, r *http.Request, user models.User, tenantID string, attempts int64) {
+	recordAuthEvent(h.DB, tenantID, user.UserID, "auth.mfa_failed", "verify_mfa", "failure", map[string]interface{}{
+		"attempt": attempts,
+	})
+	h.recordLoginFailure(r, user.Email, &user)
+	utils.WriteError(w, http.StatusUnauthorized, "Invalid MFA code")
+}
+
+// writeMFAChallenge answers the password step of a login with MFA enabled.
+func (h *AuthHandler) writeMFAChallenge(w http.ResponseWriter, r *http.Request, userID string) {
+	if h.Redis == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "MFA unavailable")
+		return
+	}
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	ttl := h.mfaChallengeTTL()
+	if err := h.Redis.Set(r.Context(), mfaChallengeKeyPrefix+hash, userID, ttl).Err(); err != nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "MFA unavailable")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(ttl.Seconds()),
+	})
+}
+
+// writeLoginTokens opens a session and writes the login response. While MFA
+// enrolment is pending the tokens carry no permissions, only enough to enrol.
+func (h *AuthHandler) writeLoginTokens(w http.ResponseWriter, r *http.Request, user models.User, tenantID string, permissions []string, enrollmentPending bool) {
+	if enrollmentPending {
+		permissions = []string{}
+	}
+	accessToken, refreshToken, err := h.issueTokens(r.Context(), r, "", user.UserID, tenantID, user.Email, user.Role, permissions)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	// Update last_login_at
+	h.DB.Exec(context.Background(), "UPDATE users SET last_login_at = NOW() WHERE user_id = $1", user.UserID)
+
+	utils.WriteJSON(w, http.StatusOK, models.LoginResponse{
+		AccessToken:           accessToken,
+		RefreshToken:          refreshToken,
+		ExpiresIn:             int64(h.Config.JWTAccessExpiration.Seconds()),
+		User:                  user,
+		MFAEnrollmentRequired: enrollmentPending,
+	})
+}
+
+// loadMFAState reads whether the user has confirmed TOTP and whether MFA is
+// mandatory (always for super admins, or by tenant setting).
+func (h *AuthHandler) loadMFAState(ctx context.Context, userID, role, tenantID string) (mfaState, error) {
+	var state mfaState
+	if h.DB == nil {
+		return state, nil
+	}
+	err := h.DB.QueryRow(ctx, `
+		SELECT
+			EXISTS(SELECT 1 FROM user_mfa WHERE user_id = $1::uuid AND confirmed_at IS NOT NULL),
+			COALESCE((SELECT mfa_required FROM tenants WHERE tenant_id = NULLIF($2,'')::uuid), false)
+	`, userID, tenantID).Scan(&state.Enabled, &state.Required)
+	if role == "super_admin" {
+		state.Required = true
+	}
+	return state, err
+}
+
+// checkMFACode accepts a TOTP code (each time step once) or an unused
+// recovery code, consuming it.
+func (h *AuthHandler) checkMFACode(ctx context.Context, userID, code string) (method string, ok bool, err error) {
+	if totp := normalizeTOTPCode(code); isTOTPCode(totp) {
+		var sealed string
+		var lastStep int64
+		err := h.DB.QueryRow(ctx, `
+			SELECT secret_encrypted, last_used_step FROM user_mfa WHERE user_id = $1::uuid AND confirmed_at IS NOT NULL
+		`, userID).Scan(&sealed, &lastStep)
+		if err == pgx.ErrNoRows {
+			return "totp", false, nil
+		}
+		if err != nil {
+			return "totp", false, err
+		}
+		secret, err := utils.DecryptSecret(h.mfaKey(), sealed)
+		if err != nil {
+			return "totp", false, err
+		}
+		step, valid := utils.ValidateTOTP(secret, totp, time.Now(), mfaTOTPSkew)
+		if !valid || step <= lastStep {
+			return "totp", false, nil
+		}
+		tag, err := h.DB.Exec(ctx, `
+			UPDATE user_mfa SET last_used_step = $2, updated_at = NOW() WHERE user_id = $1::uuid AND last_used_step < $2
+		`, userID, step)
+		if err != nil {
+			return "totp", false, err
+		}
+		return "totp", tag.RowsAffected() == 1, nil
+	}
+
+	tag, err := h.DB.Exec(ctx, `
+		UPDATE user_mfa_recovery_codes SET used_at = NOW()
+		WHERE used_at IS NULL AND code_id = (
+			SELECT code_id FROM user_mfa_recovery_codes
+			WHERE user_id = $1::uuid AND code_hash = $2 AND used_at IS NULL
+			LIMIT 1
+		)
+	`, userID, hashOpaqueToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return "recovery_code", false, err
+	}
+	return "recovery_code", tag.RowsAffected() == 1, nil
+}
+
+// replaceRecoveryCodes swaps the user's recovery codes for a fresh set and
+// returns them in clear (only hashes are stored).
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID string) ([]string, error) {
+	if _, err := tx.Exec(ctx, `DELETE FROM user_mfa_recovery_codes WHERE user_id = $1::uuid`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, mfaRecoveryCodeCount)
+	for i := 0; i < mfaRecoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO user_mfa_recovery_codes (user_id, code_hash) VALUES ($1::uuid, $2)
+		`, userID, hashOpaqueToken(normalizeRecoveryCode(code))); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns a random code formatted as "xxxxx-xxxxx".
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return s[:5] + "-" + s[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+func normalizeTOTPCode(code string) string {
+	return strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *AuthHandler) mfaKey() string {
+	if h.Config.MFAEncryptionKey != "" {
+		return h.Config.MFAEncryptionKey
+	}
+	return "mfa:" + h.Config.JWTSecret
+}
+
+func (h *AuthHandler) mfaChallengeTTL() time.Duration {
+	return time.Duration(h.Config.MFAChallengeTTLMins) * time.Minute
+}
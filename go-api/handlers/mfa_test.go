@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"iiot-go-api/models"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestVerifyMFAWithoutRedisIsUnavailable(t *testing.T) {
+	t.Parallel()
+
+	h := testAuthHandler()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/mfa/verify", strings.NewReader(`{"mfa_token":"abc","code":"123456"}`))
+	w := httptest.NewRecorder()
+	h.VerifyMFA(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestVerifyMFARejectsUnknownChallenge(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	h := testAuthHandler()
+	h.Redis = rdb
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/mfa/verify", strings.NewReader(`{"mfa_token":"unknown","code":"123456"}`))
+	w := httptest.NewRecorder()
+	h.VerifyMFA(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestVerifyMFABlocksAfterMaxAttempts(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	h := testAuthHandler()
+	h.Config.MFAChallengeTTLMins = 5
+	h.Redis = rdb
+
+	hash := hashOpaqueToken("challenge")
+	mr.Set(mfaChallengeKeyPrefix+hash, "0b8c7f4e-5d6a-4f3b-9e2d-1a2b3c4d5e6f")
+	mr.Set(mfaAttemptsKeyPrefix+hash, "5")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/mfa/verify", strings.NewReader(`{"mfa_token":"challenge","code":"123456"}`))
+	w := httptest.NewRecorder()
+	h.VerifyMFA(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body["code"] != "mfa_attempts_exceeded" {
+		t.Fatalf("code = %v, want mfa_attempts_exceeded", body["code"])
+	}
+	if mr.Exists(mfaChallengeKeyPrefix + hash) {
+		t.Fatal("challenge should be discarded after too many attempts")
+	}
+}
+
+func TestWrongMFACodesLockTheAccount(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	h := testAuthHandler()
+	h.Redis = rdb
+	h.Config.LoginLockoutThreshold = 5
+	h.Config.LoginFailureWindowMins = 15
+	h.Config.LoginLockoutMins = 15
+
+	user := models.User{UserID: "0b8c7f4e-5d6a-4f3b-9e2d-1a2b3c4d5e6f", Email: "ana@empresa.com"}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/mfa/verify", nil)
+	// Spread over several challenges (fresh logins): the counter keeps going.
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		h.rejectMFACode(w, req, user, "", 1)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want 401", i+1, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	if !h.loginBlocked(w, context.Background(), user.Email) || w.Code != http.StatusTooManyRequests {
+		t.Fatalf("account not locked after wrong MFA codes (status %d)", w.Code)
+	}
+}
+
+func TestWriteMFAChallengeStoresHashedToken(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	h := testAuthHandler()
+	h.Config.MFAChallengeTTLMins = 5
+	h.Redis = rdb
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", nil)
+	w := httptest.NewRecorder()
+	h.writeMFAChallenge(w, req, "u1")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp MFAChallengeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if !resp.MFARequired || resp.MFAToken == "" || resp.ExpiresIn != 300 {
+		t.Fatalf("unexpected challenge: %+v", resp)
+	}
+	got, err := mr.Get(mfaChallengeKeyPrefix + hashOpaqueToken(resp.MFAToken))
+	if err != nil || got != "u1" {
+		t.Fatalf("challenge key = %q, %v; want u1", got, err)
+	}
+}
+
+func TestEnrollMFARequiresClaims(t *testing.T) {
+	t.Parallel()
+
+	h := testAuthHandler()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/mfa/enroll", nil)
+	w := httptest.NewRecorder()
+	h.EnrollMFA(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRecoveryCodeFormatAndNormalization(t *testing.T) {
+	t.Parallel()
+
+	code, err := newRecoveryCode()
+	if err != nil {
+		t.Fatalf("newRecoveryCode error: %v", err)
+	}
+	if len(code) != 11 || code[5] != '-' {
+		t.Fatalf("code = %q, want xxxxx-xxxxx", code)
+	}
+	if isTOTPCode(normalizeTOTPCode(code)) {
+		t.Fatalf("recovery code %q must not look like a TOTP code", code)
+	}
+	if normalizeRecoveryCode(" "+strings.ToUpper(code)+" ") != normalizeRecoveryCode(code) {
+		t.Fatal("normalization should ignore case and surrounding spaces")
+	}
+	if !isTOTPCode(normalizeTOTPCode("123 456")) {
+		t.Fatal("spaced TOTP code should be accepted")
+	}
+}
+
+func TestMFAStateEnrollmentPending(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		state mfaState
+		want  bool
+	}{
+		{mfaState{Enabled: false, Required: false}, false},
+		{mfaState{Enabled: true, Required: false}, false},
+		{mfaState{Enabled: false, Required: true}, true},
+		{mfaState{Enabled: true, Required: true}, false},
+	}
+	for _, c := range cases {
+		if got := c.state.enrollmentPending(); got != c.want {
+			t.Errorf("%+v.enrollmentPending() = %v, want %v", c.state, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"iiot-go-api/config"
+	"iiot-go-api/utils"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Notification is an outbound message to end users (tenant admins, invitees,
+// account owners). Ops alerts keep going to Telegram.
+type Notification struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers user-facing notifications.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NewNotifier returns an SMTP notifier when SMTP_HOST is set, otherwise a
+// notifier that only logs (local/dev).
+func NewNotifier(cfg *config.Config) Notifier {
+	if cfg == nil || cfg.SMTPHost == "" {
+		return logNotifier{}
+	}
+	return smtpNotifier{cfg: utils.SMTPConfig{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.SMTPFrom,
+	}}
+}
+
+type smtpNotifier struct {
+	cfg utils.SMTPConfig
+}
+
+func (n smtpNotifier) Notify(ctx context.Context, msg Notification) error {
+	return utils.SendEmail(ctx, n.cfg, msg.To, msg.Subject, msg.Body)
+}
+
+type logNotifier struct{}
+
+func (logNotifier) Notify(_ context.Context, msg Notification) error {
+	slog.Info("notification_not_delivered",
+		slog.String("reason", "smtp_not_configured"),
+		slog.Int("recipients", len(msg.To)),
+		slog.String("subject", msg.Subject),
+	)
+	return nil
+}
+
+// notifyAsync sends n in the background so request paths never wait on SMTP.
+func notifyAsync(notifier Notifier, n Notification) {
+	if notifier == nil || len(n.To) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		if err := notifier.Notify(ctx, n); err != nil {
+			slog.Warn("notification_failed", slog.String("subject", n.Subject), slog.Any("error", err))
+		}
+	}()
+}
+
+// notifyEachAsync sends n separately to each recipient, for messages to a
+// group (e.g. all admins of a tenant) whose addresses must not be disclosed
+// to one another in a shared To: header.
+func notifyEachAsync(notifier Notifier, n Notification) {
+	for _, to := range n.To {
+		notifyAsync(notifier, Notification{To: []string{to}, Subject: n.Subject, Body: n.Body})
+	}
+}
+
+// tenantAdminEmails lists active tenant admins of a tenant.
+func tenantAdminEmails(ctx context.Context, db *pgxpool.Pool, tenantID string) []string {
+	if db == nil || tenantID == "" {
+		return nil
+	}
+	rows, err := db.Query(ctx, `
+		SELECT email FROM users
+		WHERE tenant_id = $1::uuid AND role = 'tenant_admin' AND status = 'active'
+	`, tenantID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var emails []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err == nil && strings.TrimSpace(email) != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
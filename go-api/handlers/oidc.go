@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iiot-go-api/models"
+	"iiot-go-api/utils"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const oidcStateKeyPrefix = "auth:oidc:state:"
+
+var (
+	errOIDCAccountConflict = errors.New("email belongs to another tenant")
+	errOIDCAccountInactive = errors.New("account is not active")
+	errOIDCEmailUnverified = errors.New("email not verified by the identity provider")
+)
+
+// oidcLoginState travels through the IdP as the opaque "state"; the PKCE
+// verifier and nonce never leave the server.
+type oidcLoginState struct {
+	TenantID string `json:"tenant_id"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+// OIDCLogin starts SSO for the tenant in the path (slug) and redirects the
+// browser to the tenant's IdP (authorization code + PKCE).
+func (h *AuthHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("tenant")
+	if slug == "" {
+		utils.WriteError(w, http.StatusBadRequest, "tenant is required")
+		return
+	}
+	if h.Redis == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "SSO unavailable")
+		return
+	}
+
+	ctx := r.Context()
+	var tenantID string
+	err := h.DB.QueryRow(ctx, `SELECT tenant_id::text FROM tenants WHERE slug = $1 AND status = 'active'`, slug).Scan(&tenantID)
+	if err != nil && err != pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	var p *tenantOIDCProvider
+	if err == nil {
+		p, err = loadTenantOIDCProvider(ctx, h.DB, h.Config, tenantID)
+	}
+	if err == pgx.ErrNoRows || (err == nil && !p.Enabled) {
+		utils.WriteError(w, http.StatusNotFound, "SSO not configured for this tenant")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	provider, err := utils.DiscoverOIDC(ctx, p.Issuer, h.Config.OIDCAllowInsecureIssuers)
+	if err != nil {
+		slog.Warn("oidc_discovery_failed", slog.String("tenant_id", tenantID), slog.Any("error", err))
+		utils.WriteErrorWithCode(w, http.StatusBadGateway, "oidc_unavailable", "Identity provider unavailable")
+		return
+	}
+
+	state, stateHash, err := newOpaqueToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	nonce, _, err := newOpaqueToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	verifier, err := utils.NewPKCEVerifier()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	payload, _ := json.Marshal(oidcLoginState{TenantID: tenantID, Verifier: verifier, Nonce: nonce})
+	ttl := time.Duration(h.Config.OIDCStateTTLMins) * time.Minute
+	if err := h.Redis.Set(ctx, oidcStateKeyPrefix+stateHash, payload, ttl).Err(); err != nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "SSO unavailable")
+		return
+	}
+
+	http.Redirect(w, r, provider.AuthorizationURL(p.ClientID, h.Config.OIDCRedirectURL, state, nonce, verifier), http.StatusFound)
+}
+
+// OIDCCallback completes SSO: redeems the code, verifies the ID token,
+// provisions the user just in time and issues the platform JWTs exactly
+// like a password login (including the TOTP step when enabled).
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if idpErr := q.Get("error"); idpErr != "" {
+		utils.WriteErrorWithCode(w, http.StatusUnauthorized, "oidc_error", "Identity provider returned "+idpErr)
+		return
+	}
+	state, code := q.Get("state"), q.Get("code")
+	if state == "" || code == "" {
+		utils.WriteError(w, http.StatusBadRequest, "state and code are required")
+		return
+	}
+	if h.Redis == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "SSO unavailable")
+		return
+	}
+
+	ctx := r.Context()
+	raw, err := h.Redis.GetDel(ctx, oidcStateKeyPrefix+hashOpaqueToken(state)).Bytes()
+	if err == redis.Nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid or expired SSO state")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "SSO unavailable")
+		return
+	}
+	var ls oidcLoginState
+	if err := json.Unmarshal(raw, &ls); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid or expired SSO state")
+		return
+	}
+
+	p, err := loadTenantOIDCProvider(ctx, h.DB, h.Config, ls.TenantID)
+	if err == pgx.ErrNoRows || (err == nil && !p.Enabled) {
+		utils.WriteError(w, http.StatusNotFound, "SSO not configured for this tenant")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	claims, err := h.exchangeOIDCCode(ctx, p, code, ls)
+	if err != nil {
+		slog.Warn("oidc_login_failed", slog.String("tenant_id", ls.TenantID), slog.Any("error", err))
+		recordAuthEvent(h.DB, ls.TenantID, "", "auth.oidc_failed", "oidc_login", "failure", map[string]interface{}{
+			"issuer": p.Issuer,
+			"reason": "token_exchange_or_verification",
+		})
+		utils.WriteErrorWithCode(w, http.StatusUnauthorized, "oidc_login_failed", "SSO login failed")
+		return
+	}
+
+	sub, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	email = strings.ToLower(strings.TrimSpace(email))
+	emailVerified, verifiedPresent := oidcEmailVerified(claims)
+	if sub == "" || email == "" || (verifiedPresent && !emailVerified) {
+		recordAuthEvent(h.DB, ls.TenantID, "", "auth.oidc_failed", "oidc_login", "failure", map[string]interface{}{
+			"issuer": p.Issuer,
+			"reason": "missing_or_unverified_email",
+		})
+		utils.WriteErrorWithCode(w, http.StatusForbidden, "oidc_email_required", "Identity provider did not return a verified email")
+		return
+	}
+	if !emailDomainAllowed(email, p.AllowedDomains) {
+		recordAuthEvent(h.DB, ls.TenantID, "", "auth.oidc_failed", "oidc_login", "blocked", map[string]interface{}{
+			"issuer": p.Issuer,
+			"email":  email,
+			"reason": "domain_not_allowed",
+		})
+		utils.WriteErrorWithCode(w, http.StatusForbidden, "oidc_domain_not_allowed", "Email domain not allowed for this tenant")
+		return
+	}
+
+	role, roleManaged := mapOIDCRole(p, claims)
+	user, created, err := h.provisionOIDCUser(ctx, p, sub, email, emailVerified, role, roleManaged)
+	switch {
+	case errors.Is(err, errOIDCAccountConflict):
+		utils.WriteErrorWithCode(w, http.StatusConflict, "oidc_account_conflict", "Email already registered in another tenant")
+		return
+	case errors.Is(err, errOIDCEmailUnverified):
+		recordAuthEvent(h.DB, ls.TenantID, "", "auth.oidc_failed", "oidc_login", "blocked", map[string]interface{}{
+			"issuer": p.Issuer,
+			"email":  email,
+			"reason": "link_requires_verified_email",
+		})
+		utils.WriteErrorWithCode(w, http.StatusForbidden, "oidc_email_unverified", "Identity provider must assert a verified email to link an existing account")
+		return
+	case errors.Is(err, errOIDCAccountInactive):
+		utils.WriteError(w, http.StatusForbidden, "Account is not active")
+		return
+	case err != nil:
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if created {
+		notifyUserRegistered(h.DB, h.Config, user.UserID, p.TenantID, user.Email, user.Role)
+	}
+	recordAuthEvent(h.DB, p.TenantID, user.UserID, "auth.oidc_login", "oidc_login", "success", map[string]interface{}{
+		"issuer":      p.Issuer,
+		"subject":     sub,
+		"provisioned": created,
+		"role":        user.Role,
+	})
+
+	permissions, denied, err := h.permissionsFor(ctx, user.UserID, user.Role, p.TenantID, user.EmailVerified)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if denied {
+		utils.WriteErrorWithCode(w, http.StatusForbidden, "email_not_verified", "Email not verified")
+		return
+	}
+	mfa, err := h.loadMFAState(ctx, user.UserID, user.Role, p.TenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if mfa.Enabled {
+		h.writeMFAChallenge(w, r, user.UserID)
+		return
+	}
+	h.writeLoginTokens(w, r, user, p.TenantID, permissions, mfa.enrollmentPending())
+}
+
+func (h *AuthHandler) exchangeOIDCCode(ctx context.Context, p *tenantOIDCProvider, code string, ls oidcLoginState) (jwt.MapClaims, error) {
+	provider, err := utils.DiscoverOIDC(ctx, p.Issuer, h.Config.OIDCAllowInsecureIssuers)
+	if err != nil {
+		return nil, err
+	}
+	idToken, err := provider.ExchangeCode(ctx, p.ClientID, p.ClientSecret, code, h.Config.OIDCRedirectURL, ls.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	return provider.VerifyIDToken(ctx, idToken, p.ClientID, ls.Nonce)
+}
+
+// oidcEmailVerified reads the email_verified claim (bool, or the string
+// some IdPs send) and whether it was present at all.
+func oidcEmailVerified(claims jwt.MapClaims) (verified, present bool) {
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		return v, true
+	case string:
+		return strings.EqualFold(v, "true"), true
+	default:
+		return false, false
+	}
+}
+
+// provisionOIDCUser finds the user by IdP subject (then by e-mail, linking
+// it) or creates it in the tenant. Linking an existing account by e-mail
+// requires emailVerified (email_verified=true): otherwise an IdP that does
+// not vouch for the address could take the account over. A new user is
+// e-mail-verified only with that claim too. With a role claim
+// configured the IdP is authoritative for the role, except that the last
+// active tenant admin is never demoted.
+func (h *AuthHandler) provisionOIDCUser(ctx context.Context, p *tenantOIDCProvider, sub, email string, emailVerified bool, role string, roleManaged bool) (models.User, bool, error) {
+	var user models.User
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		return user, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var linked bool
+	err = tx.QueryRow(ctx, `
+		SELECT user_id, tenant_id, email, role, status, COALESCE(email_verified, false), created_at,
+			COALESCE(tenant_id = $1::uuid AND oidc_subject = $2, false)
+		FROM users
+		WHERE (tenant_id = $1::uuid AND oidc_subject = $2) OR email = $3
+		ORDER BY (oidc_subject IS NOT DISTINCT FROM $2) DESC
+		LIMIT 1
+		FOR UPDATE
+	`, p.TenantID, sub, email).Scan(&user.UserID, &user.TenantID, &user.Email, &user.Role, &user.Status, &user.EmailVerified, &user.CreatedAt, &linked)
+
+	if err == pgx.ErrNoRows {
+		// Unusable password: SSO users authenticate at the IdP only.
+		unusable, err := bcrypt.GenerateFromPassword([]byte(uuid.New().String()+uuid.New().String()), bcryptCost)
+		if err != nil {
+			return user, false, err
+		}
+		tenantID := p.TenantID
+		user = models.User{UserID: uuid.New().String(), TenantID: &tenantID, Email: email, Role: role, Status: "active", EmailVerified: emailVerified}
+		if err := tx.QueryRow(ctx, `
+			INSERT INTO users (user_id, tenant_id, email, password_hash, role, status, email_verified, email_verified_at, auth_provider, oidc_subject)
+			VALUES ($1, $2, $3, $4, $5, 'active', $7, CASE WHEN $7 THEN NOW() END, 'oidc', $6)
+			RETURNING created_at
+		`, user.UserID, tenantID, email, string(unusable), role, sub, emailVerified).Scan(&user.CreatedAt); err != nil {
+			return user, false, err
+		}
+		recordUserEvent(ctx, tx, tenantID, "", user.UserID, "user.provisioned", "oidc_provision", map[string]interface{}{
+			"email":   email,
+			"role":    role,
+			"issuer":  p.Issuer,
+			"subject": sub,
+		})
+		return user, true, tx.Commit(ctx)
+	}
+	if err != nil {
+		return user, false, err
+	}
+
+	if tenantIDStrOrEmpty(user.TenantID) != p.TenantID {
+		return user, false, errOIDCAccountConflict
+	}
+	if !linked && !emailVerified {
+		return user, false, errOIDCEmailUnverified
+	}
+	if user.Status != "active" {
+		return user, false, errOIDCAccountInactive
+	}
+	// Linking needs emailVerified; an already linked account becomes
+	// verified once the IdP asserts it.
+	if _, err := tx.Exec(ctx, `
+		UPDATE users SET oidc_subject = $2, email_verified = true, email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		WHERE user_id = $1::uuid AND $3 AND (oidc_subject IS DISTINCT FROM $2 OR NOT COALESCE(email_verified, false))
+	`, user.UserID, sub, emailVerified); err != nil {
+		return user, false, err
+	}
+	user.EmailVerified = user.EmailVerified || emailVerified
+
+	if roleManaged && role != user.Role {
+		keep := false
+		if user.Role == "tenant_admin" {
+			var others int
+			if err := tx.QueryRow(ctx, `
+				SELECT COUNT(*) FROM users
+				WHERE tenant_id = $1::uuid AND role = 'tenant_admin' AND status = 'active' AND user_id <> $2::uuid
+			`, p.TenantID, user.UserID).Scan(&others); err != nil {
+				return user, false, err
+			}
+			keep = others == 0
+		}
+		if !keep {
+			if _, err := tx.Exec(ctx, `UPDATE users SET role = $2, updated_at = NOW() WHERE user_id = $1::uuid`, user.UserID, role); err != nil {
+				return user, false, err
+			}
+			// Tokens with the previous role stop working now.
+			if _, err := revokeSessions(ctx, tx, h.Redis, h.Config, user.UserID, "", "", "role_synced"); err != nil {
+				return user, false, err
+			}
+			recordUserEvent(ctx, tx, p.TenantID, "", user.UserID, "user.updated", "oidc_role_sync", map[string]interface{}{
+				"role_before": user.Role,
+				"role_after":  role,
+				"issuer":      p.Issuer,
+			})
+			user.Role = role
+		}
+	}
+	return user, false, tx.Commit(ctx)
+}
+
+// mapOIDCRole picks the platform role from the configured claim (string or
+// list; the most privileged mapped value wins). managed is false when no
+// role claim is configured: the role is then only set at provisioning.
+func mapOIDCRole(p *tenantOIDCProvider, claims jwt.MapClaims) (role string, managed bool) {
+	if p.RoleClaim == nil || *p.RoleClaim == "" {
+		return p.DefaultRole, false
+	}
+	var values []string
+	switch v := claims[*p.RoleClaim].(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	role = p.DefaultRole
+	for _, v := range values {
+		switch p.RoleMapping[v] {
+		case "tenant_admin":
+			return "tenant_admin", true
+		case "tenant_user":
+			role = "tenant_user"
+		}
+	}
+	return role, true
+}
+
+func emailDomainAllowed(email string, domains []string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, d := range domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
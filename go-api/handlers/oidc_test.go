@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestOIDCCallbackRejectsIdPError(t *testing.T) {
+	t.Parallel()
+
+	h := testAuthHandler()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/callback?error=access_denied&state=x", nil)
+	w := httptest.NewRecorder()
+	h.OIDCCallback(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body["code"] != "oidc_error" {
+		t.Fatalf("code = %v, want oidc_error", body["code"])
+	}
+}
+
+func TestOIDCCallbackRequiresStateAndCode(t *testing.T) {
+	t.Parallel()
+
+	h := testAuthHandler()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/callback?code=abc", nil)
+	w := httptest.NewRecorder()
+	h.OIDCCallback(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCCallbackRejectsUnknownState(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	h := testAuthHandler()
+	h.Redis = rdb
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/callback?code=abc&state=forged", nil)
+	w := httptest.NewRecorder()
+	h.OIDCCallback(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCLoginWithoutRedisIsUnavailable(t *testing.T) {
+	t.Parallel()
+
+	h := testAuthHandler()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/acme/login", nil)
+	req.SetPathValue("tenant", "acme")
+	w := httptest.NewRecorder()
+	h.OIDCLogin(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestMapOIDCRole(t *testing.T) {
+	t.Parallel()
+
+	claim := "groups"
+	p := &tenantOIDCProvider{TenantOIDCConfig: TenantOIDCConfig{
+		RoleClaim:   &claim,
+		RoleMapping: map[string]string{"iiot-admins": "tenant_admin", "iiot-ops": "tenant_user"},
+		DefaultRole: "tenant_user",
+	}}
+
+	cases := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   string
+	}{
+		{"admin group in list", jwt.MapClaims{"groups": []interface{}{"staff", "iiot-admins"}}, "tenant_admin"},
+		{"string claim", jwt.MapClaims{"groups": "iiot-ops"}, "tenant_user"},
+		{"no match falls back to default", jwt.MapClaims{"groups": []interface{}{"staff"}}, "tenant_user"},
+		{"missing claim", jwt.MapClaims{}, "tenant_user"},
+	}
+	for _, c := range cases {
+		role, managed := mapOIDCRole(p, c.claims)
+		if role != c.want || !managed {
+			t.Errorf("%s: role = %q, managed = %v; want %q, true", c.name, role, managed, c.want)
+		}
+	}
+
+	p.RoleClaim = nil
+	if role, managed := mapOIDCRole(p, jwt.MapClaims{"groups": "iiot-admins"}); role != "tenant_user" || managed {
+		t.Fatalf("without role claim: role = %q, managed = %v; want default, false", role, managed)
+	}
+}
+
+func TestEmailDomainAllowed(t *testing.T) {
+	t.Parallel()
+
+	domains := []string{"empresa.com"}
+	if !emailDomainAllowed("ana@Empresa.com", domains) {
+		t.Fatal("expected case-insensitive domain match")
+	}
+	if emailDomainAllowed("ana@empresa.com.evil.io", domains) || emailDomainAllowed("ana@sub.empresa.com", domains) {
+		t.Fatal("only exact domains are allowed")
+	}
+	if emailDomainAllowed("no-at-sign", domains) {
+		t.Fatal("invalid email must be rejected")
+	}
+}
+
+func TestOIDCEmailVerified(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name              string
+		claims            jwt.MapClaims
+		verified, present bool
+	}{
+		{"true", jwt.MapClaims{"email_verified": true}, true, true},
+		{"false", jwt.MapClaims{"email_verified": false}, false, true},
+		{"string", jwt.MapClaims{"email_verified": "true"}, true, true},
+		// Absent is not verified: linking an existing account needs the claim.
+		{"absent", jwt.MapClaims{}, false, false},
+	}
+	for _, c := range cases {
+		if verified, present := oidcEmailVerified(c.claims); verified != c.verified || present != c.present {
+			t.Errorf("%s: oidcEmailVerified = (%v, %v), want (%v, %v)", c.name, verified, present, c.verified, c.present)
+		}
+	}
+}
@@ -114,6 +114,38 @@ func notifyDeviceCreated(
 	sendTelegramAndAuditAsync(db, cfg, tenantID, userID, "ops.device_created_notified", msg, meta)
 }
 
+func notifyRefreshReuse(
+	db *pgxpool.Pool,
+	cfg *config.Config,
+	userID string,
+	tenantID string,
+	email string,
+	sessionID string,
+	ip string,
+) {
+	emittedAt := formatOpsTimeNow()
+	msg := buildOpsTelegramMessage(
+		"🚨 [SEGURANCA] Reuso de refresh token",
+		map[string]string{
+			"email":      email,
+			"user_id":    userID,
+			"tenant_id":  tenantID,
+			"session_id": sessionID,
+			"ip":         ip,
+			"horario":    emittedAt,
+		},
+	)
+	metaBytes, _ := json.Marshal(map[string]string{
+		"email":      email,
+		"user_id":    userID,
+		"tenant_id":  tenantID,
+		"session_id": sessionID,
+		"ip":         ip,
+		"emitted_at": emittedAt,
+	})
+	sendTelegramAndAuditAsync(db, cfg, tenantID, userID, "ops.refresh_reuse_notified", msg, string(metaBytes))
+}
+
 func formatOpsTimeNow() string {
 	brt := time.FixedZone("BRT", -3*60*60)
 	return time.Now().In(brt).Format("02/01/2006 15:04:05")
@@ -129,6 +161,8 @@ func buildOpsTelegramMessage(title string, fields map[string]string) string {
 		"device_id",
 		"device_label",
 		"source",
+		"session_id",
+		"ip",
 		"status",
 		"quota",
 		"request_id",
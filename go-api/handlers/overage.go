@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"iiot-go-api/config"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// overageMeterLockKey keeps the meter on a single replica (see usageSnapshotLockKey).
+const overageMeterLockKey = 1003
+
+const bytesPerGB = 1024 * 1024 * 1024
+
+// OverageUsage is the metered usage above quota for one billing period.
+type OverageUsage struct {
+	PeriodStart          time.Time  `json:"period_start"`
+	PeriodEnd            time.Time  `json:"period_end"`
+	StorageOverByteHours float64    `json:"storage_over_byte_hours"`
+	StorageOverGBHours   float64    `json:"storage_over_gb_hours"`
+	StorageOverBytesPeak int64      `json:"storage_over_bytes_peak"`
+	MessagesOverQuota    int64      `json:"messages_over_quota"`
+	LastSampledAt        *time.Time `json:"last_sampled_at,omitempty"`
+}
+
+// RunOverageMeter samples overage of every allow_overage tenant each interval.
+func RunOverageMeter(ctx context.Context, db, ts *pgxpool.Pool, rdb *redis.Client, cfg *config.Config, interval time.Duration) {
+	if db == nil || ts == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := withAdvisoryLock(ctx, db, overageMeterLockKey, func() error {
+				return meterOverage(ctx, db, ts, rdb, cfg, time.Now().UTC())
+			})
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("overage_meter_error", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+func meterOverage(ctx context.Context, db, ts *pgxpool.Pool, rdb *redis.Client, cfg *config.Config, now time.Time) error {
+	rows, err := db.Query(ctx, `
+		SELECT t.tenant_id::text
+		FROM tenants t
+		JOIN plans p ON p.plan_id = t.plan_id
+		WHERE t.status = 'active' AND COALESCE(t.allow_overage, p.allow_overage)
+	`)
+	if err != nil {
+		return err
+	}
+	var tenantIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			tenantIDs = append(tenantIDs, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, tenantID := range tenantIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := meterTenantOverage(ctx, db, ts, rdb, cfg, tenantID, now); err != nil {
+			slog.Warn("overage_meter_tenant_failed", slog.String("tenant_id", tenantID), slog.Any("error", err))
+		}
+	}
+	return nil
+}
+
+// meterTenantOverage takes one sample: storage above quota accrues
+// byte-hours since the previous sample, whatever its period (the part
+// before the period boundary goes to the previous sample's period), and
+// messages above quota are read from the period counter.
+func meterTenantOverage(ctx context.Context, db, ts *pgxpool.Pool, rdb *redis.Client, cfg *config.Config, tenantID string, now time.Time) error {
+	q, err := fetchTenantQuota(ctx, db, tenantID)
+	if err != nil {
+		return err
+	}
+	start, end := currentMonthRange(now)
+
+	var overBytes int64
+	if q.QuotaStorageMB > 0 {
+		var storageBytes float64
+		if err := ts.QueryRow(ctx, `SELECT COALESCE(SUM(pg_column_size(value)),0)::float8 FROM telemetry WHERE tenant_id = $1::uuid`, tenantID).Scan(&storageBytes); err != nil {
+			return err
+		}
+		overBytes = int64(math.Max(storageBytes-float64(q.QuotaStorageMB)*1024*1024, 0))
+	}
+
+	var messagesOver int64
+	if q.QuotaMsgsPerMonth > 0 {
+		messagesOver = messagesOverQuota(periodMessageCount(ctx, db, rdb, tenantID), q.QuotaMsgsPerMonth)
+	}
+
+	var lastSampled *time.Time
+	if err := db.QueryRow(ctx, `
+		SELECT MAX(last_sampled_at) FROM tenant_overage_usage WHERE tenant_id = $1::uuid
+	`, tenantID).Scan(&lastSampled); err != nil {
+		return err
+	}
+	previousByteHours, byteHours := storageOverageByteHours(overBytes, lastSampled, start, now)
+	if previousByteHours > 0 {
+		previousStart, _ := currentMonthRange(lastSampled.UTC())
+		if _, err := db.Exec(ctx, `
+			UPDATE tenant_overage_usage
+			SET storage_over_byte_hours = storage_over_byte_hours + $3, updated_at = NOW()
+			WHERE tenant_id = $1::uuid AND period_start = $2
+		`, tenantID, previousStart, previousByteHours); err != nil {
+			return err
+		}
+	}
+
+	var usage OverageUsage
+	var storageAlertedAt, messagesAlertedAt *time.Time
+	err = db.QueryRow(ctx, `
+		INSERT INTO tenant_overage_usage (tenant_id, period_start, period_end, storage_over_byte_hours, storage_over_bytes_peak, messages_over_quota, last_sampled_at, updated_at)
+		VALUES ($1::uuid, $2, $3, $4, $5, $6, $7, NOW())
+		ON CONFLICT (tenant_id, period_start) DO UPDATE SET
+			storage_over_byte_hours = tenant_overage_usage.storage_over_byte_hours + EXCLUDED.storage_over_byte_hours,
+			storage_over_bytes_peak = GREATEST(tenant_overage_usage.storage_over_bytes_peak, EXCLUDED.storage_over_bytes_peak),
+			messages_over_quota = GREATEST(tenant_overage_usage.messages_over_quota, EXCLUDED.messages_over_quota),
+			last_sampled_at = EXCLUDED.last_sampled_at,
+			updated_at = NOW()
+		RETURNING storage_over_byte_hours::float8, messages_over_quota, storage_alerted_at, messages_alerted_at
+	`, tenantID, start, end, byteHours, overBytes, messagesOver, now).Scan(&usage.StorageOverByteHours, &usage.MessagesOverQuota, &storageAlertedAt, &messagesAlertedAt)
+	if err != nil {
+		return err
+	}
+
+	if cfg == nil {
+		return nil
+	}
+	gbHours := usage.StorageOverByteHours / bytesPerGB
+	if storageAlertedAt == nil && cfg.OverageAlertCeilingGBHours > 0 && gbHours >= float64(cfg.OverageAlertCeilingGBHours) {
+		alertOverageCeiling(ctx, db, cfg, tenantID, "storage", start, fmt.Sprintf("%.2f GB-h", gbHours), fmt.Sprintf("%d GB-h", cfg.OverageAlertCeilingGBHours))
+	}
+	if messagesAlertedAt == nil && cfg.OverageAlertCeilingMessages > 0 && usage.MessagesOverQuota >= cfg.OverageAlertCeilingMessages {
+		alertOverageCeiling(ctx, db, cfg, tenantID, "messages", start, fmt.Sprintf("%d", usage.MessagesOverQuota), fmt.Sprintf("%d", cfg.OverageAlertCeilingMessages))
+	}
+	return nil
+}
+
+// storageOverageByteHours returns the byte-hours accrued since the previous
+// sample, split at the period boundary: previous is the part up to the end
+// of the previous sample's period, current the part inside the period
+// starting at periodStart. The first sample of a tenant accrues nothing, and
+// whole months without any sample are not billed.
+func storageOverageByteHours(overBytes int64, lastSampled *time.Time, periodStart, now time.Time) (previous, current float64) {
+	if overBytes <= 0 || lastSampled == nil || !lastSampled.Before(now) {
+		return 0, 0
+	}
+	if !lastSampled.Before(periodStart) {
+		return 0, float64(overBytes) * now.Sub(*lastSampled).Hours()
+	}
+	_, lastEnd := currentMonthRange(lastSampled.UTC())
+	return float64(overBytes) * lastEnd.Sub(*lastSampled).Hours(), float64(overBytes) * now.Sub(periodStart).Hours()
+}
+
+func messagesOverQuota(count, limit int64) int64 {
+	if limit <= 0 || count <= limit {
+		return 0
+	}
+	return count - limit
+}
+
+// alertOverageCeiling raises the ops alert once per tenant/kind/period.
+func alertOverageCeiling(ctx context.Context, db *pgxpool.Pool, cfg *config.Config, tenantID, kind string, periodStart time.Time, value, ceiling string) {
+	column := "storage_alerted_at"
+	if kind == "messages" {
+		column = "messages_alerted_at"
+	}
+	tag, err := db.Exec(ctx, `
+		UPDATE tenant_overage_usage SET `+column+` = NOW()
+		WHERE tenant_id = $1::uuid AND period_start = $2 AND `+column+` IS NULL
+	`, tenantID, periodStart)
+	if err != nil || tag.RowsAffected() == 0 {
+		return
+	}
+
+	_, _ = db.Exec(ctx, `
+		INSERT INTO audit_log (tenant_id, event_type, event_category, severity, actor_type, action, result, metadata, timestamp)
+		VALUES ($1::uuid, 'quota.overage_ceiling_reached', 'billing', 'warning', 'system', 'meter_overage', 'success', $2::jsonb, NOW())
+	`, tenantID, toJSONB(map[string]interface{}{
+		"kind":         kind,
+		"value":        value,
+		"ceiling":      ceiling,
+		"period_start": periodStart,
+	}))
+	SendQuotaTelegramAsync(cfg,
+		fmt.Sprintf("[IIoT Core] Overage %s acima do teto", kind),
+		fmt.Sprintf("tenant=%s", tenantID),
+		fmt.Sprintf("overage=%s teto=%s", value, ceiling),
+	)
+}
+
+// loadOverageUsage returns the metered overage of a period (zero when the
+// tenant never went over quota).
+func loadOverageUsage(ctx context.Context, db *pgxpool.Pool, tenantID string, start, end time.Time) OverageUsage {
+	usage := OverageUsage{PeriodStart: start, PeriodEnd: end}
+	_ = db.QueryRow(ctx, `
+		SELECT storage_over_byte_hours::float8, storage_over_bytes_peak, messages_over_quota, last_sampled_at
+		FROM tenant_overage_usage
+		WHERE tenant_id = $1::uuid AND period_start = $2
+	`, tenantID, start).Scan(&usage.StorageOverByteHours, &usage.StorageOverBytesPeak, &usage.MessagesOverQuota, &usage.LastSampledAt)
+	usage.StorageOverGBHours = math.Round(usage.StorageOverByteHours/bytesPerGB*1000) / 1000
+	return usage
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorageOverageByteHours(t *testing.T) {
+	t.Parallel()
+
+	periodStart := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	now := periodStart.Add(10 * time.Hour)
+
+	if prev, cur := storageOverageByteHours(1000, nil, periodStart, now); prev != 0 || cur != 0 {
+		t.Fatalf("first sample = %v/%v, want 0", prev, cur)
+	}
+
+	last := now.Add(-2 * time.Hour)
+	if prev, cur := storageOverageByteHours(1000, &last, periodStart, now); prev != 0 || cur != 2000 {
+		t.Fatalf("2h at 1000 bytes over = %v/%v, want 0/2000", prev, cur)
+	}
+
+	if prev, cur := storageOverageByteHours(0, &last, periodStart, now); prev != 0 || cur != 0 {
+		t.Fatalf("no overage = %v/%v, want 0", prev, cur)
+	}
+
+	// A sample from the previous period: the hours before the boundary go
+	// to that period, the rest to the current one.
+	prevSample := periodStart.Add(-5 * time.Hour)
+	if prev, cur := storageOverageByteHours(1000, &prevSample, periodStart, now); prev != 5000 || cur != 10000 {
+		t.Fatalf("cross-period sample = %v/%v, want 5000/10000", prev, cur)
+	}
+
+	// A gap of whole months only bills the ends next to a sample.
+	old := time.Date(2026, time.January, 31, 22, 0, 0, 0, time.UTC)
+	if prev, cur := storageOverageByteHours(1000, &old, periodStart, now); prev != 2000 || cur != 10000 {
+		t.Fatalf("gap across February = %v/%v, want 2000/10000", prev, cur)
+	}
+}
+
+// TestStorageOverageSamplingAcrossMonthBoundary replays the meter loop
+// (lookup of the latest sample, per-period accrual) hour by hour over the
+// end of February: every hour above quota is billed exactly once.
+func TestStorageOverageSamplingAcrossMonthBoundary(t *testing.T) {
+	t.Parallel()
+
+	const overBytes = 1000
+	byPeriod := map[time.Time]float64{}
+	var lastSampled *time.Time
+	first := time.Date(2026, time.February, 28, 20, 30, 0, 0, time.UTC)
+	for i := 0; i <= 10; i++ {
+		now := first.Add(time.Duration(i) * time.Hour)
+		start, _ := currentMonthRange(now)
+		prev, cur := storageOverageByteHours(overBytes, lastSampled, start, now)
+		if prev > 0 {
+			previousStart, _ := currentMonthRange(*lastSampled)
+			byPeriod[previousStart] += prev
+		}
+		byPeriod[start] += cur
+		sampled := now
+		lastSampled = &sampled
+	}
+
+	feb := time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)
+	mar := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	if byPeriod[feb] != 3.5*overBytes || byPeriod[mar] != 6.5*overBytes {
+		t.Fatalf("February = %v, March = %v; want %v and %v", byPeriod[feb], byPeriod[mar], 3.5*overBytes, 6.5*overBytes)
+	}
+}
+
+func TestMessagesOverQuota(t *testing.T) {
+	t.Parallel()
+
+	if got := messagesOverQuota(1500, 1000); got != 500 {
+		t.Fatalf("messagesOverQuota(1500, 1000) = %d, want 500", got)
+	}
+	if got := messagesOverQuota(900, 1000); got != 0 {
+		t.Fatalf("messagesOverQuota under limit = %d, want 0", got)
+	}
+	if got := messagesOverQuota(900, 0); got != 0 {
+		t.Fatalf("messagesOverQuota unlimited = %d, want 0", got)
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iiot-go-api/utils"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordResetKeyPrefix     = "auth:pwreset:"
+	passwordResetUserKeyPrefix = "auth:pwreset:user:"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// ForgotPassword issues a single-use reset token for an active account and
+// e-mails the link. The response is the same whether or not the account
+// exists.
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	if h.Redis == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "Password reset unavailable")
+		return
+	}
+
+	accepted := map[string]string{"message": "If the account exists, a reset link has been sent"}
+
+	ctx := context.Background()
+	var userID, status string
+	var tenantID *string
+	err := h.DB.QueryRow(ctx, `SELECT user_id::text, tenant_id::text, status FROM users WHERE email = $1`, req.Email).Scan(&userID, &tenantID, &status)
+	if err == pgx.ErrNoRows || (err == nil && status != "active") {
+		utils.WriteJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	ttl := time.Duration(h.Config.PasswordResetTTLMins) * time.Minute
+	if err := storePasswordResetToken(ctx, h.Redis, userID, tokenHash, ttl); err != nil {
+		slog.Warn("password_reset_store_failed", slog.String("user_id", userID), slog.Any("error", err))
+		utils.WriteError(w, http.StatusServiceUnavailable, "Password reset unavailable")
+		return
+	}
+
+	recordAuthEvent(h.DB, tenantIDStrOrEmpty(tenantID), userID, "auth.password_reset_requested", "forgot_password", "success", map[string]interface{}{
+		"expires_in_minutes": h.Config.PasswordResetTTLMins,
+	})
+	notifyAsync(h.Notifier, Notification{
+		To:      []string{req.Email},
+		Subject: "Redefinição de senha",
+		Body: fmt.Sprintf(
+			"Recebemos um pedido para redefinir sua senha na plataforma IIoT.\n\nPara criar uma nova senha, acesse:\n%s\n\nO link é de uso único e expira em %d minutos. Se você não fez o pedido, ignore este e-mail.",
+			passwordResetURL(h.Config.AppBaseURL, token), h.Config.PasswordResetTTLMins,
+		),
+	})
+
+	utils.WriteJSON(w, http.StatusAccepted, accepted)
+}
+
+// ResetPassword consumes a reset token, sets the new password and revokes
+// every token previously issued to the user.
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	if err := validatePassword(req.Password); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if h.Redis == nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "Password reset unavailable")
+		return
+	}
+
+	ctx := context.Background()
+	// GETDEL makes the token single-use even under concurrent requests.
+	userID, err := h.Redis.GetDel(ctx, passwordResetKeyPrefix+hashOpaqueToken(req.Token)).Result()
+	if err == redis.Nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusServiceUnavailable, "Password reset unavailable")
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptCost)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	var email string
+	var tenantID *string
+	err = h.DB.QueryRow(ctx, `
+		UPDATE users SET password_hash = $2, updated_at = NOW()
+		WHERE user_id = $1::uuid AND status = 'active'
+		RETURNING email, tenant_id::text
+	`, userID, string(passwordHash)).Scan(&email, &tenantID)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	_ = h.Redis.Del(ctx, passwordResetUserKeyPrefix+userID).Err()
+	if _, err := revokeSessions(ctx, h.DB, h.Redis, h.Config, userID, "", "", "password_reset"); err != nil {
+		slog.Warn("password_reset_session_revocation_failed", slog.String("user_id", userID), slog.Any("error", err))
+	}
+	if err := utils.RevokeUserTokens(h.Redis, userID, h.Config.JWTRefreshExpiration); err != nil {
+		slog.Warn("password_reset_revocation_failed", slog.String("user_id", userID), slog.Any("error", err))
+	}
+
+	recordAuthEvent(h.DB, tenantIDStrOrEmpty(tenantID), userID, "auth.password_reset", "reset_password", "success", map[string]interface{}{
+		"sessions_revoked": true,
+	})
+	notifyAsync(h.Notifier, Notification{
+		To:      []string{email},
+		Subject: "Sua senha foi alterada",
+		Body:    "A senha da sua conta na plataforma IIoT foi redefinida e as sessões abertas foram encerradas.\n\nSe não foi você, entre em contato com o administrador do seu tenant imediatamente.",
+	})
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "Password updated"})
+}
+
+// storePasswordResetToken keeps only the latest token of a user valid.
+func storePasswordResetToken(ctx context.Context, rdb *redis.Client, userID, tokenHash string, ttl time.Duration) error {
+	userKey := passwordResetUserKeyPrefix + userID
+	if previous, err := rdb.Get(ctx, userKey).Result(); err == nil && previous != "" {
+		_ = rdb.Del(ctx, passwordResetKeyPrefix+previous).Err()
+	} else if err != nil && err != redis.Nil {
+		return err
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, passwordResetKeyPrefix+tokenHash, userID, ttl)
+	pipe.Set(ctx, userKey, tokenHash, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func passwordResetURL(baseURL, token string) string {
+	return strings.TrimRight(baseURL, "/") + "/reset-password?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestStorePasswordResetTokenKeepsOnlyLatest(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	ctx := context.Background()
+
+	if err := storePasswordResetToken(ctx, rdb, "u1", "hash-1", 30*time.Minute); err != nil {
+		t.Fatalf("store first: %v", err)
+	}
+	if err := storePasswordResetToken(ctx, rdb, "u1", "hash-2", 30*time.Minute); err != nil {
+		t.Fatalf("store second: %v", err)
+	}
+
+	if mr.Exists(passwordResetKeyPrefix + "hash-1") {
+		t.Fatal("previous reset token should be invalidated")
+	}
+	if got, _ := mr.Get(passwordResetKeyPrefix + "hash-2"); got != "u1" {
+		t.Fatalf("latest token maps to %q, want u1", got)
+	}
+	if ttl := mr.TTL(passwordResetKeyPrefix + "hash-2"); ttl != 30*time.Minute {
+		t.Fatalf("ttl = %v, want 30m", ttl)
+	}
+}
+
+func TestResetPasswordRejectsUnknownToken(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	h := testAuthHandler()
+	h.Redis = rdb
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/password/reset", strings.NewReader(`{"token":"nope","password":"Abcdef1!"}`))
+	w := httptest.NewRecorder()
+
+	h.ResetPassword(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestResetPasswordRejectsWeakPassword(t *testing.T) {
+	t.Parallel()
+
+	h := testAuthHandler()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/password/reset", strings.NewReader(`{"token":"abc","password":"weak"}`))
+	w := httptest.NewRecorder()
+
+	h.ResetPassword(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
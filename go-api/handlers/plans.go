@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iiot-go-api/config"
+	"iiot-go-api/utils"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	errPlanNotFound = errors.New("plan not found")
+	errPlanInactive = errors.New("plan inactive")
+)
+
+var planCodePattern = regexp.MustCompile(`^[a-z0-9_-]{1,40}$`)
+
+type PlanHandler struct {
+	DB     *pgxpool.Pool
+	Cache  *IngestCache
+	Config *config.Config
+}
+
+// Plan is a row of the plan catalog: default quotas, overage rule,
+// retention and feature flags shared by every tenant on the plan.
+type Plan struct {
+	PlanID            string                 `json:"plan_id"`
+	Code              string                 `json:"code"`
+	Name              string                 `json:"name"`
+	Description       string                 `json:"description"`
+	QuotaDevices      int                    `json:"quota_devices"`
+	QuotaMsgsPerMin   int                    `json:"quota_msgs_per_min"`
+	QuotaStorageMB    int                    `json:"quota_storage_mb"`
+	QuotaMsgsPerMonth int64                  `json:"quota_msgs_per_month"`
+	AllowOverage      bool                   `json:"allow_overage"`
+	RetentionDays     int                    `json:"retention_days"`
+	Features          map[string]interface{} `json:"features"`
+	IsActive          bool                   `json:"is_active"`
+	IsDefault         bool                   `json:"is_default"`
+	CreatedAt         time.Time              `json:"created_at"`
+	UpdatedAt         time.Time              `json:"updated_at"`
+}
+
+type PlanRequest struct {
+	Code              string                 `json:"code"`
+	Name              string                 `json:"name" validate:"required,max=100"`
+	Description       string                 `json:"description"`
+	QuotaDevices      int                    `json:"quota_devices" validate:"min=0"`
+	QuotaMsgsPerMin   int                    `json:"quota_msgs_per_min" validate:"min=0"`
+	QuotaStorageMB    int                    `json:"quota_storage_mb" validate:"min=0"`
+	QuotaMsgsPerMonth int64                  `json:"quota_msgs_per_month" validate:"min=0"`
+	AllowOverage      bool                   `json:"allow_overage"`
+	RetentionDays     int                    `json:"retention_days" validate:"min=1"`
+	Features          map[string]interface{} `json:"features"`
+	IsActive          *bool                  `json:"is_active,omitempty"`
+	IsDefault         bool                   `json:"is_default"`
+}
+
+// TenantQuotaOverrides are per-tenant values replacing plan defaults; nil
+// means the tenant follows its plan.
+type TenantQuotaOverrides struct {
+	QuotaDevices      *int   `json:"quota_devices,omitempty"`
+	QuotaMsgsPerMin   *int   `json:"quota_msgs_per_min,omitempty"`
+	QuotaStorageMB    *int   `json:"quota_storage_mb,omitempty"`
+	QuotaMsgsPerMonth *int64 `json:"quota_msgs_per_month,omitempty"`
+	AllowOverage      *bool  `json:"allow_overage,omitempty"`
+}
+
+// overridableQuotaFields lists the tenant columns accepted by clear_overrides.
+var overridableQuotaFields = map[string]bool{
+	"quota_devices":        true,
+	"quota_msgs_per_min":   true,
+	"quota_storage_mb":     true,
+	"quota_msgs_per_month": true,
+	"allow_overage":        true,
+}
+
+func NewPlanHandler(db *pgxpool.Pool, cache *IngestCache, cfg *config.Config) *PlanHandler {
+	return &PlanHandler{DB: db, Cache: cache, Config: cfg}
+}
+
+// effectiveQuota merges plan defaults with tenant overrides.
+func effectiveQuota(tenantID, billingCycle string, p Plan, o TenantQuotaOverrides) TenantQuota {
+	q := TenantQuota{
+		TenantID:          tenantID,
+		PlanID:            p.PlanID,
+		PlanType:          p.Code,
+		BillingCycle:      billingCycle,
+		QuotaDevices:      p.QuotaDevices,
+		QuotaMsgsPerMin:   p.QuotaMsgsPerMin,
+		QuotaStorageMB:    p.QuotaStorageMB,
+		AllowOverage:      p.AllowOverage,
+		QuotaMsgsPerMonth: p.QuotaMsgsPerMonth,
+		RetentionDays:     p.RetentionDays,
+	}
+	if o.QuotaDevices != nil {
+		q.QuotaDevices = *o.QuotaDevices
+	}
+	if o.QuotaMsgsPerMin != nil {
+		q.QuotaMsgsPerMin = *o.QuotaMsgsPerMin
+	}
+	if o.QuotaStorageMB != nil {
+		q.QuotaStorageMB = *o.QuotaStorageMB
+	}
+	if o.QuotaMsgsPerMonth != nil {
+		q.QuotaMsgsPerMonth = *o.QuotaMsgsPerMonth
+	}
+	if o.AllowOverage != nil {
+		q.AllowOverage = *o.AllowOverage
+	}
+	return q
+}
+
+// ListPlans returns the plan catalog (super admin).
+func (h *PlanHandler) ListPlans(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(context.Background(), `SELECT `+planColumns+` FROM plans ORDER BY created_at, code`)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer rows.Close()
+
+	plans := []Plan{}
+	for rows.Next() {
+		p, err := scanPlan(rows)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		plans = append(plans, *p)
+	}
+	utils.WriteJSON(w, http.StatusOK, plans)
+}
+
+// GetPlan returns a single plan (super admin).
+func (h *PlanHandler) GetPlan(w http.ResponseWriter, r *http.Request) {
+	p, err := loadPlan(context.Background(), h.DB, r.PathValue("plan_id"))
+	if err != nil {
+		if errors.Is(err, errPlanNotFound) {
+			utils.WriteError(w, http.StatusNotFound, "Plan not found")
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, p)
+}
+
+// CreatePlan adds a plan to the catalog (super admin).
+func (h *PlanHandler) CreatePlan(w http.ResponseWriter, r *http.Request) {
+	var req PlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Code = strings.ToLower(strings.TrimSpace(req.Code))
+	if !planCodePattern.MatchString(req.Code) {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid code")
+		return
+	}
+	if req.RetentionDays == 0 {
+		req.RetentionDays = 365
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+	if req.Features == nil {
+		req.Features = map[string]interface{}{}
+	}
+
+	ctx := context.Background()
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM plans WHERE code = $1)`, req.Code).Scan(&exists); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if exists {
+		utils.WriteError(w, http.StatusConflict, "Plan code already exists")
+		return
+	}
+	if req.IsDefault {
+		if _, err := tx.Exec(ctx, `UPDATE plans SET is_default = false WHERE is_default`); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+	}
+
+	var planID string
+	err = tx.QueryRow(ctx, `
+		INSERT INTO plans (code, name, description, quota_devices, quota_msgs_per_min, quota_storage_mb, quota_msgs_per_month,
+			allow_overage, retention_days, features, is_active, is_default)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10::jsonb, $11, $12)
+		RETURNING plan_id::text
+	`, req.Code, req.Name, req.Description, req.QuotaDevices, req.QuotaMsgsPerMin, req.QuotaStorageMB, req.QuotaMsgsPerMonth,
+		req.AllowOverage, req.RetentionDays, toJSONB(req.Features), isActive, req.IsDefault).Scan(&planID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	recordPlanEvent(h.DB, actorUserID, planID, "plan.created", "create", map[string]interface{}{"plan": req})
+
+	p, err := loadPlan(ctx, h.DB, planID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, p)
+}
+
+// UpdatePlan replaces a plan's defaults (super admin). The code is immutable
+// because pricing_catalog and invoices are keyed by it.
+func (h *PlanHandler) UpdatePlan(w http.ResponseWriter, r *http.Request) {
+	planID := r.PathValue("plan_id")
+	var req PlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	if req.Features == nil {
+		req.Features = map[string]interface{}{}
+	}
+
+	ctx := context.Background()
+	current, err := loadPlan(ctx, h.DB, planID)
+	if err != nil {
+		if errors.Is(err, errPlanNotFound) {
+			utils.WriteError(w, http.StatusNotFound, "Plan not found")
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if req.Code != "" && req.Code != current.Code {
+		utils.WriteError(w, http.StatusBadRequest, "code cannot be changed")
+		return
+	}
+	isActive := current.IsActive
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+	if current.IsDefault && (!req.IsDefault || !isActive) {
+		utils.WriteError(w, http.StatusConflict, "Default plan must stay active; mark another plan as default first")
+		return
+	}
+
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if req.IsDefault && !current.IsDefault {
+		if _, err := tx.Exec(ctx, `UPDATE plans SET is_default = false WHERE is_default`); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE plans
+		SET name = $2, description = $3, quota_devices = $4, quota_msgs_per_min = $5, quota_storage_mb = $6,
+			quota_msgs_per_month = $7, allow_overage = $8, retention_days = $9, features = $10::jsonb,
+			is_active = $11, is_default = $12
+		WHERE plan_id = $1::uuid
+	`, planID, req.Name, req.Description, req.QuotaDevices, req.QuotaMsgsPerMin, req.QuotaStorageMB,
+		req.QuotaMsgsPerMonth, req.AllowOverage, req.RetentionDays, toJSONB(req.Features), isActive, req.IsDefault)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	h.invalidatePlanTenants(ctx, planID)
+
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	recordPlanEvent(h.DB, actorUserID, planID, "plan.updated", "update", map[string]interface{}{
+		"before": current,
+		"after":  req,
+	})
+
+	p, err := loadPlan(ctx, h.DB, planID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, p)
+}
+
+// DeletePlan removes an unused, non-default plan (super admin). Plans still
+// referenced by tenants should be deactivated instead.
+func (h *PlanHandler) DeletePlan(w http.ResponseWriter, r *http.Request) {
+	planID := r.PathValue("plan_id")
+	ctx := context.Background()
+
+	current, err := loadPlan(ctx, h.DB, planID)
+	if err != nil {
+		if errors.Is(err, errPlanNotFound) {
+			utils.WriteError(w, http.StatusNotFound, "Plan not found")
+			return
+		}
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if current.IsDefault {
+		utils.WriteError(w, http.StatusConflict, "Default plan cannot be deleted")
+		return
+	}
+
+	var inUse bool
+	if err := h.DB.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM tenants WHERE plan_id = $1::uuid)`, planID).Scan(&inUse); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if inUse {
+		utils.WriteError(w, http.StatusConflict, "Plan is assigned to tenants; deactivate it instead")
+		return
+	}
+
+	if _, err := h.DB.Exec(ctx, `DELETE FROM plans WHERE plan_id = $1::uuid`, planID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	recordPlanEvent(h.DB, actorUserID, planID, "plan.deleted", "delete", map[string]interface{}{"plan": current})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// invalidatePlanTenants drops cached quotas of every tenant on the plan.
+func (h *PlanHandler) invalidatePlanTenants(ctx context.Context, planID string) {
+	if h.Cache == nil {
+		return
+	}
+	rows, err := h.DB.Query(ctx, `SELECT tenant_id::text FROM tenants WHERE plan_id = $1::uuid`, planID)
+	if err != nil {
+		slog.Warn("plan_cache_invalidation_failed", slog.String("plan_id", planID), slog.Any("error", err))
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tenantID string
+		if err := rows.Scan(&tenantID); err == nil {
+			h.Cache.InvalidateTenant(tenantID)
+		}
+	}
+}
+
+const planColumns = `plan_id::text, code, name, description, quota_devices, quota_msgs_per_min, quota_storage_mb,
+	quota_msgs_per_month, allow_overage, retention_days, features, is_active, is_default, created_at, updated_at`
+
+func scanPlan(row pgx.Row) (*Plan, error) {
+	var p Plan
+	var features []byte
+	if err := row.Scan(&p.PlanID, &p.Code, &p.Name, &p.Description, &p.QuotaDevices, &p.QuotaMsgsPerMin, &p.QuotaStorageMB,
+		&p.QuotaMsgsPerMonth, &p.AllowOverage, &p.RetentionDays, &features, &p.IsActive, &p.IsDefault, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	p.Features = map[string]interface{}{}
+	_ = json.Unmarshal(features, &p.Features)
+	return &p, nil
+}
+
+func loadPlan(ctx context.Context, db *pgxpool.Pool, planID string) (*Plan, error) {
+	p, err := scanPlan(db.QueryRow(ctx, `SELECT `+planColumns+` FROM plans WHERE plan_id::text = $1`, planID))
+	if err == pgx.ErrNoRows {
+		return nil, errPlanNotFound
+	}
+	return p, err
+}
+
+// resolvePlanID looks up an assignable plan by ID or code.
+func resolvePlanID(ctx context.Context, db *pgxpool.Pool, planID, code string) (string, error) {
+	var id string
+	var active bool
+	err := db.QueryRow(ctx, `
+		SELECT plan_id::text, is_active FROM plans
+		WHERE ($1 <> '' AND plan_id::text = $1) OR ($1 = '' AND code = $2)
+	`, planID, code).Scan(&id, &active)
+	if err == pgx.ErrNoRows {
+		return "", errPlanNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if !active {
+		return "", errPlanInactive
+	}
+	return id, nil
+}
+
+func recordPlanEvent(db *pgxpool.Pool, userID, planID, eventType, action string, metadata map[string]interface{}) {
+	if db == nil {
+		return
+	}
+	_, _ = db.Exec(context.Background(), `
+		INSERT INTO audit_log (user_id, event_type, event_category, severity, actor_type, actor_id, action, result, resource_type, resource_id, metadata, timestamp)
+		VALUES (NULLIF($1,'')::uuid, $2, 'billing', 'info', 'user', NULLIF($1,'')::uuid, $3, 'success', 'plan', $4::uuid, $5::jsonb, NOW())
+	`, userID, eventType, action, planID, toJSONB(metadata))
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import "testing"
+
+func TestEffectiveQuotaUsesPlanDefaults(t *testing.T) {
+	t.Parallel()
+
+	p := Plan{PlanID: "p1", Code: "pro", QuotaDevices: 100, QuotaMsgsPerMin: 1200, QuotaStorageMB: 10000,
+		QuotaMsgsPerMonth: 5000000, AllowOverage: false, RetentionDays: 180}
+
+	q := effectiveQuota("t1", "monthly", p, TenantQuotaOverrides{})
+	if q.PlanType != "pro" || q.PlanID != "p1" || q.QuotaDevices != 100 || q.QuotaMsgsPerMin != 1200 ||
+		q.QuotaStorageMB != 10000 || q.QuotaMsgsPerMonth != 5000000 || q.AllowOverage || q.RetentionDays != 180 {
+		t.Fatalf("effectiveQuota without overrides = %+v", q)
+	}
+}
+
+func TestEffectiveQuotaAppliesOverrides(t *testing.T) {
+	t.Parallel()
+
+	p := Plan{Code: "starter", QuotaDevices: 10, QuotaMsgsPerMin: 360, QuotaStorageMB: 1000, QuotaMsgsPerMonth: 1000000}
+	devices, overage := 0, true
+	var monthly int64 = 2000000
+
+	q := effectiveQuota("t1", "annual", p, TenantQuotaOverrides{
+		QuotaDevices:      &devices,
+		QuotaMsgsPerMonth: &monthly,
+		AllowOverage:      &overage,
+	})
+	if q.QuotaDevices != 0 {
+		t.Fatalf("QuotaDevices = %d, want override 0 (unlimited)", q.QuotaDevices)
+	}
+	if q.QuotaMsgsPerMonth != 2000000 || !q.AllowOverage {
+		t.Fatalf("overrides not applied: %+v", q)
+	}
+	if q.QuotaMsgsPerMin != 360 || q.QuotaStorageMB != 1000 || q.BillingCycle != "annual" {
+		t.Fatalf("non-overridden fields should follow plan/tenant: %+v", q)
+	}
+}
+
+func TestPlanCodePattern(t *testing.T) {
+	t.Parallel()
+
+	for _, code := range []string{"starter", "pro-2026", "enterprise_plus"} {
+		if !planCodePattern.MatchString(code) {
+			t.Fatalf("code %q should be valid", code)
+		}
+	}
+	for _, code := range []string{"", "Pro", "pro plan", "pro;drop"} {
+		if planCodePattern.MatchString(code) {
+			t.Fatalf("code %q should be rejected", code)
+		}
+	}
+}
@@ -0,0 +1,810 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iiot-go-api/config"
+	"iiot-go-api/utils"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// privacyExportFormat versions the export bundle layout.
+const privacyExportFormat = "iiot-privacy-export/1"
+
+// privacyPIIKeys are the metadata fields removed from the audit rows of an
+// erased user (top level and inside metadata.request). "subject" is the IdP
+// subject recorded by OIDC logins and provisioning.
+var privacyPIIKeys = []string{"email", "new_email", "old_email", "name", "full_name", "phone", "ip", "ip_address", "user_agent", "subject"}
+
+// privacyAuditMatch selects the audit_log rows about a user ($1 user_id, $2
+// tenant_id or empty, $3 e-mail), for both export and erasure. Rows without a
+// user, such as anonymous login, forgot-password and resend-verification
+// requests, are matched by the e-mail they carry, at the top level of
+// metadata or inside metadata.request.
+const privacyAuditMatch = `(user_id = $1::uuid OR actor_id = $1::uuid OR resource_id = $1::uuid
+		   OR ((tenant_id IS NULL OR tenant_id = NULLIF($2,'')::uuid)
+		       AND lower($3) IN (lower(metadata->>'email'), lower(metadata->'request'->>'email'))))`
+
+// PrivacyHandler implements LGPD data subject requests: personal data
+// export and the reviewed erasure workflow. Users act on themselves; acting
+// on another user of the tenant requires privacy:manage (super admins reach
+// every tenant).
+type PrivacyHandler struct {
+	DB     *pgxpool.Pool
+	Redis  *redis.Client
+	Config *config.Config
+}
+
+func NewPrivacyHandler(db *pgxpool.Pool, redisClient *redis.Client, cfg *config.Config) *PrivacyHandler {
+	return &PrivacyHandler{
+		DB:     db,
+		Redis:  redisClient,
+		Config: cfg,
+	}
+}
+
+// PrivacySubjectRequest names the data subject; empty means the caller.
+type PrivacySubjectRequest struct {
+	UserID string `json:"user_id,omitempty" validate:"omitempty,uuid"`
+}
+
+// CreateErasureRequest files an erasure request for the caller or, with
+// privacy:manage, for user_id.
+type CreateErasureRequest struct {
+	UserID string `json:"user_id,omitempty" validate:"omitempty,uuid"`
+	Reason string `json:"reason,omitempty" validate:"max=1000"`
+}
+
+// ReviewErasureRequest carries the reviewer's note (required to reject).
+type ReviewErasureRequest struct {
+	Note string `json:"note,omitempty" validate:"max=1000"`
+}
+
+// PrivacyProfile is the users row of the subject.
+type PrivacyProfile struct {
+	UserID          string          `json:"user_id"`
+	TenantID        *string         `json:"tenant_id,omitempty"`
+	Email           string          `json:"email"`
+	Role            string          `json:"role"`
+	CustomRoleID    *string         `json:"custom_role_id,omitempty"`
+	Status          string          `json:"status"`
+	EmailVerified   bool            `json:"email_verified"`
+	EmailVerifiedAt *time.Time      `json:"email_verified_at,omitempty"`
+	AuthProvider    string          `json:"auth_provider"`
+	OIDCSubject     *string         `json:"oidc_subject,omitempty"`
+	MFAEnabled      bool            `json:"mfa_enabled"`
+	Metadata        json.RawMessage `json:"metadata,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	LastLoginAt     *time.Time      `json:"last_login_at,omitempty"`
+}
+
+// PrivacySession is a login session of the subject, revoked ones included.
+type PrivacySession struct {
+	SessionID     string     `json:"session_id"`
+	UserAgent     *string    `json:"user_agent,omitempty"`
+	IPAddress     *string    `json:"ip_address,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastUsedAt    time.Time  `json:"last_used_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason *string    `json:"revoked_reason,omitempty"`
+}
+
+// PrivacyDevice is a device owned by the subject.
+type PrivacyDevice struct {
+	DeviceID        string          `json:"device_id"`
+	DeviceLabel     string          `json:"device_label"`
+	Status          string          `json:"status"`
+	FirmwareVersion *string         `json:"firmware_version,omitempty"`
+	ClaimedAt       *time.Time      `json:"claimed_at,omitempty"`
+	LastSeenAt      *time.Time      `json:"last_seen_at,omitempty"`
+	Metadata        json.RawMessage `json:"metadata,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+}
+
+// PrivacyExport is the machine-readable bundle returned by ExportPersonalData.
+type PrivacyExport struct {
+	Format          string           `json:"format"`
+	GeneratedAt     time.Time        `json:"generated_at"`
+	Profile         PrivacyProfile   `json:"profile"`
+	Sessions        []PrivacySession `json:"sessions"`
+	Devices         []PrivacyDevice  `json:"devices"`
+	AuditEntries    []AuditEntry     `json:"audit_entries"`
+	AuditTruncated  bool             `json:"audit_truncated"`
+	ErasureRequests []ErasureRequest `json:"erasure_requests"`
+}
+
+// ErasureRequest is a privacy_erasure_requests row.
+type ErasureRequest struct {
+	RequestID   string          `json:"request_id"`
+	TenantID    *string         `json:"tenant_id,omitempty"`
+	UserID      *string         `json:"user_id"`
+	Status      string          `json:"status"`
+	Reason      *string         `json:"reason,omitempty"`
+	RequestedBy *string         `json:"requested_by,omitempty"`
+	RequestedAt time.Time       `json:"requested_at"`
+	ReviewedBy  *string         `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time      `json:"reviewed_at,omitempty"`
+	ReviewNote  *string         `json:"review_note,omitempty"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+}
+
+const erasureRequestColumns = `request_id::text, tenant_id::text, user_id::text, status, reason, requested_by::text, requested_at,
+	reviewed_by::text, reviewed_at, review_note, completed_at, result`
+
+func scanErasureRequest(row pgx.Row, e *ErasureRequest) error {
+	var result []byte
+	err := row.Scan(&e.RequestID, &e.TenantID, &e.UserID, &e.Status, &e.Reason, &e.RequestedBy, &e.RequestedAt,
+		&e.ReviewedBy, &e.ReviewedAt, &e.ReviewNote, &e.CompletedAt, &result)
+	e.Result = result
+	return err
+}
+
+// privacySubject resolves the data subject: the caller when userID is empty
+// or their own id, otherwise a user of the caller's tenant (any tenant for
+// a super admin) provided the caller holds privacy:manage. It returns the
+// subject's tenant ("" for super admins).
+func privacySubject(w http.ResponseWriter, r *http.Request, q dbQueryRower, userID string) (string, string, bool) {
+	callerID, _ := r.Context().Value("user_id").(string)
+	if callerID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Unauthorized")
+		return "", "", false
+	}
+	if userID != "" && userID != callerID {
+		if missing := missingPermission(actorPermissions(r), []string{"privacy:manage"}); missing != "" {
+			utils.WriteError(w, http.StatusForbidden, "Insufficient permissions")
+			return "", "", false
+		}
+	} else {
+		userID = callerID
+	}
+
+	callerTenant, _ := r.Context().Value("tenant_id").(string)
+	role, _ := r.Context().Value("role").(string)
+	var tenantID *string
+	err := q.QueryRow(r.Context(), `SELECT tenant_id::text FROM users WHERE user_id = $1::uuid`, userID).Scan(&tenantID)
+	if err == nil && userID != callerID && role != "super_admin" && (tenantID == nil || *tenantID != callerTenant) {
+		err = pgx.ErrNoRows
+	}
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, "User not found")
+		return "", "", false
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return "", "", false
+	}
+	return userID, stringOrEmpty(tenantID), true
+}
+
+// ExportPersonalData returns the subject's personal data as a JSON
+// attachment: profile, sessions, owned devices, audit entries about them
+// (newest first, up to maxAuditExportRows) and erasure requests. The export
+// is audited.
+func (h *PrivacyHandler) ExportPersonalData(w http.ResponseWriter, r *http.Request) {
+	var req PrivacySubjectRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	ctx := r.Context()
+	userID, tenantID, ok := privacySubject(w, r, h.DB, req.UserID)
+	if !ok {
+		return
+	}
+
+	export, err := h.collectPersonalData(ctx, userID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	actorUserID, _ := ctx.Value("user_id").(string)
+	recordPrivacyEvent(ctx, h.DB, tenantID, actorUserID, userID, "privacy.exported", "export_personal_data", map[string]interface{}{
+		"audit_entries": len(export.AuditEntries),
+		"sessions":      len(export.Sessions),
+		"devices":       len(export.Devices),
+	})
+
+	filename := fmt.Sprintf("privacy-export-%s-%s.json", userID, export.GeneratedAt.Format("20060102T150405Z"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	utils.WriteJSON(w, http.StatusOK, export)
+}
+
+func (h *PrivacyHandler) collectPersonalData(ctx context.Context, userID string) (PrivacyExport, error) {
+	export := PrivacyExport{
+		Format:          privacyExportFormat,
+		GeneratedAt:     time.Now().UTC(),
+		Sessions:        []PrivacySession{},
+		Devices:         []PrivacyDevice{},
+		AuditEntries:    []AuditEntry{},
+		ErasureRequests: []ErasureRequest{},
+	}
+
+	p := &export.Profile
+	var metadata []byte
+	if err := h.DB.QueryRow(ctx, `
+		SELECT u.user_id::text, u.tenant_id::text, u.email, u.role::text, u.custom_role_id::text, u.status,
+		       COALESCE(u.email_verified, false), u.email_verified_at, u.auth_provider, u.oidc_subject,
+		       EXISTS (SELECT 1 FROM user_mfa m WHERE m.user_id = u.user_id AND m.confirmed_at IS NOT NULL),
+		       COALESCE(u.metadata, '{}'::jsonb), u.created_at, u.updated_at, u.last_login_at
+		FROM users u WHERE u.user_id = $1::uuid
+	`, userID).Scan(&p.UserID, &p.TenantID, &p.Email, &p.Role, &p.CustomRoleID, &p.Status,
+		&p.EmailVerified, &p.EmailVerifiedAt, &p.AuthProvider, &p.OIDCSubject,
+		&p.MFAEnabled, &metadata, &p.CreatedAt, &p.UpdatedAt, &p.LastLoginAt); err != nil {
+		return export, err
+	}
+	p.Metadata = metadata
+
+	rows, err := h.DB.Query(ctx, `
+		SELECT session_id::text, user_agent, ip_address, created_at, last_used_at, expires_at, revoked_at, revoked_reason
+		FROM user_sessions WHERE user_id = $1::uuid
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return export, err
+	}
+	for rows.Next() {
+		var s PrivacySession
+		if err := rows.Scan(&s.SessionID, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &s.RevokedAt, &s.RevokedReason); err != nil {
+			rows.Close()
+			return export, err
+		}
+		export.Sessions = append(export.Sessions, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return export, err
+	}
+
+	rows, err = h.DB.Query(ctx, `
+		SELECT device_id::text, device_label, status::text, firmware_version, claimed_at, last_seen_at, COALESCE(metadata, '{}'::jsonb), created_at
+		FROM devices WHERE owner_user_id = $1::uuid
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return export, err
+	}
+	for rows.Next() {
+		var d PrivacyDevice
+		var deviceMetadata []byte
+		if err := rows.Scan(&d.DeviceID, &d.DeviceLabel, &d.Status, &d.FirmwareVersion, &d.ClaimedAt, &d.LastSeenAt, &deviceMetadata, &d.CreatedAt); err != nil {
+			rows.Close()
+			return export, err
+		}
+		d.Metadata = deviceMetadata
+		export.Devices = append(export.Devices, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return export, err
+	}
+
+	rows, err = h.DB.Query(ctx, `
+		SELECT `+auditColumns+`
+		FROM audit_log
+		WHERE `+privacyAuditMatch+`
+		ORDER BY timestamp DESC, audit_id DESC
+		LIMIT $4
+	`, userID, stringOrEmpty(p.TenantID), p.Email, maxAuditExportRows+1)
+	if err != nil {
+		return export, err
+	}
+	for rows.Next() {
+		var e AuditEntry
+		if err := scanAuditEntry(rows, &e); err != nil {
+			rows.Close()
+			return export, err
+		}
+		export.AuditEntries = append(export.AuditEntries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return export, err
+	}
+	if len(export.AuditEntries) > maxAuditExportRows {
+		export.AuditEntries = export.AuditEntries[:maxAuditExportRows]
+		export.AuditTruncated = true
+	}
+
+	rows, err = h.DB.Query(ctx, `
+		SELECT `+erasureRequestColumns+`
+		FROM privacy_erasure_requests WHERE user_id = $1::uuid
+		ORDER BY requested_at DESC
+	`, userID)
+	if err != nil {
+		return export, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e ErasureRequest
+		if err := scanErasureRequest(rows, &e); err != nil {
+			return export, err
+		}
+		export.ErasureRequests = append(export.ErasureRequests, e)
+	}
+	return export, rows.Err()
+}
+
+// CreateErasureRequest files a pending erasure request; nothing is erased
+// until an admin approves it.
+func (h *PrivacyHandler) CreateErasureRequest(w http.ResponseWriter, r *http.Request) {
+	var req CreateErasureRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	ctx := r.Context()
+	userID, tenantID, ok := privacySubject(w, r, h.DB, req.UserID)
+	if !ok {
+		return
+	}
+	actorUserID, _ := ctx.Value("user_id").(string)
+
+	var erased bool
+	if err := h.DB.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM privacy_erasure_requests WHERE user_id = $1::uuid AND status = 'completed')
+	`, userID).Scan(&erased); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if erased {
+		utils.WriteErrorWithCode(w, http.StatusConflict, "already_erased", "User data was already erased")
+		return
+	}
+
+	var created ErasureRequest
+	err := scanErasureRequest(h.DB.QueryRow(ctx, `
+		INSERT INTO privacy_erasure_requests (tenant_id, user_id, reason, requested_by)
+		VALUES (NULLIF($1,'')::uuid, $2::uuid, NULLIF($3,''), $4::uuid)
+		ON CONFLICT (user_id) WHERE status = 'pending' DO NOTHING
+		RETURNING `+erasureRequestColumns,
+		tenantID, userID, req.Reason, actorUserID), &created)
+	if err == pgx.ErrNoRows {
+		utils.WriteErrorWithCode(w, http.StatusConflict, "erasure_pending", "An erasure request for this user is already pending")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	recordPrivacyEvent(ctx, h.DB, tenantID, actorUserID, userID, "privacy.erasure_requested", "request_erasure", map[string]interface{}{
+		"request_id": created.RequestID,
+	})
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+// ListErasureRequests lists the erasure requests of the caller's tenant
+// (super admins: every tenant, or ?tenant_id), ?status=pending (default),
+// rejected, completed or all.
+func (h *PrivacyHandler) ListErasureRequests(w http.ResponseWriter, r *http.Request) {
+	tenantID, status, msg := auditTenantScope(r)
+	if status != 0 {
+		utils.WriteError(w, status, msg)
+		return
+	}
+	if tenantID != "" {
+		if _, err := uuid.Parse(tenantID); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "Invalid tenant_id")
+			return
+		}
+	}
+	state := strings.TrimSpace(r.URL.Query().Get("status"))
+	switch state {
+	case "":
+		state = "pending"
+	case "pending", "rejected", "completed", "all":
+	default:
+		utils.WriteError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(), `
+		SELECT `+erasureRequestColumns+`
+		FROM privacy_erasure_requests
+		WHERE ($1 = '' OR tenant_id = NULLIF($1,'')::uuid) AND ($2 = 'all' OR status = $2)
+		ORDER BY requested_at DESC
+	`, tenantID, state)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer rows.Close()
+
+	requests := []ErasureRequest{}
+	for rows.Next() {
+		var e ErasureRequest
+		if err := scanErasureRequest(rows, &e); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		requests = append(requests, e)
+	}
+	if err := rows.Err(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"erasure_requests": requests})
+}
+
+// ApproveErasureRequest erases the subject's personal data in one
+// transaction and records the completion on the request. Reviewers cannot
+// approve their own request, and a tenant keeps at least one active admin.
+func (h *PrivacyHandler) ApproveErasureRequest(w http.ResponseWriter, r *http.Request) {
+	requestID, ok := erasureRequestIDParam(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeReviewErasureRequest(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	actorUserID, _ := ctx.Value("user_id").(string)
+
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	pending, ok := lockPendingErasureRequest(w, r, tx, requestID)
+	if !ok {
+		return
+	}
+	userID, tenantID := stringOrEmpty(pending.UserID), stringOrEmpty(pending.TenantID)
+	if userID == actorUserID {
+		utils.WriteErrorWithCode(w, http.StatusForbidden, "self_review", "Erasure requests must be approved by another admin")
+		return
+	}
+
+	var role, status, email string
+	err = tx.QueryRow(ctx, `
+		SELECT role::text, status, email FROM users WHERE user_id = $1::uuid FOR UPDATE
+	`, userID).Scan(&role, &status, &email)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if role == "tenant_admin" && status == "active" {
+		if !otherActiveAdminExists(w, ctx, tx, tenantID, userID) {
+			return
+		}
+	}
+
+	if _, err := revokeSessions(ctx, tx, h.Redis, h.Config, userID, "", actorUserID, "erased"); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	result, redactions, err := eraseUserData(ctx, tx, userID, tenantID, email)
+	if err != nil {
+		slog.Error("privacy_erasure_failed", slog.String("request_id", pending.RequestID), slog.Any("error", err))
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	var completed ErasureRequest
+	if err := scanErasureRequest(tx.QueryRow(ctx, `
+		UPDATE privacy_erasure_requests
+		SET status = 'completed', reviewed_by = $2::uuid, reviewed_at = NOW(), review_note = NULLIF($3,''),
+		    completed_at = NOW(), result = $4::jsonb
+		WHERE request_id = $1::uuid
+		RETURNING `+erasureRequestColumns,
+		pending.RequestID, actorUserID, req.Note, toJSONB(result)), &completed); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if err := recordErasureCompleted(ctx, tx, tenantID, actorUserID, userID, pending.RequestID, result, redactions); err != nil {
+		slog.Error("privacy_erasure_record_failed", slog.String("request_id", pending.RequestID), slog.Any("error", err))
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	if h.Redis != nil {
+		if err := utils.RevokeUserTokens(h.Redis, userID, h.Config.JWTRefreshExpiration); err != nil {
+			slog.Warn("user_token_revocation_failed", slog.String("user_id", userID), slog.Any("error", err))
+		}
+	}
+	utils.WriteJSON(w, http.StatusOK, completed)
+}
+
+// RejectErasureRequest closes a pending request without erasing; the note
+// (the legal ground for keeping the data) is required.
+func (h *PrivacyHandler) RejectErasureRequest(w http.ResponseWriter, r *http.Request) {
+	requestID, ok := erasureRequestIDParam(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeReviewErasureRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Note == "" {
+		utils.WriteError(w, http.StatusBadRequest, "note is required to reject a request")
+		return
+	}
+	ctx := r.Context()
+	actorUserID, _ := ctx.Value("user_id").(string)
+
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	pending, ok := lockPendingErasureRequest(w, r, tx, requestID)
+	if !ok {
+		return
+	}
+	var rejected ErasureRequest
+	if err := scanErasureRequest(tx.QueryRow(ctx, `
+		UPDATE privacy_erasure_requests
+		SET status = 'rejected', reviewed_by = $2::uuid, reviewed_at = NOW(), review_note = $3
+		WHERE request_id = $1::uuid
+		RETURNING `+erasureRequestColumns,
+		pending.RequestID, actorUserID, req.Note), &rejected); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	recordPrivacyEvent(ctx, tx, stringOrEmpty(pending.TenantID), actorUserID, stringOrEmpty(pending.UserID), "privacy.erasure_rejected", "reject_erasure", map[string]interface{}{
+		"request_id": pending.RequestID,
+	})
+	if err := tx.Commit(ctx); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, rejected)
+}
+
+func erasureRequestIDParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	requestID := r.PathValue("request_id")
+	if _, err := uuid.Parse(requestID); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request_id")
+		return "", false
+	}
+	return requestID, true
+}
+
+func decodeReviewErasureRequest(w http.ResponseWriter, r *http.Request) (ReviewErasureRequest, bool) {
+	var req ReviewErasureRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return req, false
+		}
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return req, false
+	}
+	return req, true
+}
+
+// lockPendingErasureRequest loads {request_id} FOR UPDATE within the
+// caller's tenant (any tenant for a super admin); 404 when it is not
+// visible, 409 when it was already reviewed.
+func lockPendingErasureRequest(w http.ResponseWriter, r *http.Request, tx pgx.Tx, requestID string) (ErasureRequest, bool) {
+	var e ErasureRequest
+	tenantID, _ := r.Context().Value("tenant_id").(string)
+	role, _ := r.Context().Value("role").(string)
+	if role == "super_admin" {
+		tenantID = ""
+	} else if tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return e, false
+	}
+
+	err := scanErasureRequest(tx.QueryRow(r.Context(), `
+		SELECT `+erasureRequestColumns+`
+		FROM privacy_erasure_requests
+		WHERE request_id = $1::uuid AND ($2 = '' OR tenant_id = NULLIF($2,'')::uuid)
+		FOR UPDATE
+	`, requestID, tenantID), &e)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, "Erasure request not found")
+		return e, false
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return e, false
+	}
+	if e.Status != "pending" || e.UserID == nil {
+		utils.WriteErrorWithCode(w, http.StatusConflict, "request_not_pending", "Erasure request was already reviewed")
+		return e, false
+	}
+	return e, true
+}
+
+// eraseUserData anonymizes the users row in place (the id stays as a
+// pseudonym), drops sessions and MFA secrets, anonymizes invitations sent to
+// the e-mail and redacts personal data from audit_log. Audit rows keep their
+// chain hashes and get redacted_at and redacted_hash; billing rows keep
+// their metadata. The redacted hashes are returned per chain (tenant_id, ""
+// for the platform chain) for recordErasureCompleted.
+func eraseUserData(ctx context.Context, tx pgx.Tx, userID, tenantID, email string) (map[string]interface{}, map[string]map[string]string, error) {
+	erasedEmail := erasedUserEmail(userID)
+	if _, err := tx.Exec(ctx, `
+		UPDATE users
+		SET email = $2, password_hash = '!erased', status = 'deleted', email_verified = false, email_verified_at = NULL,
+		    oidc_subject = NULL, custom_role_id = NULL, last_login_at = NULL, metadata = '{}'::jsonb, updated_at = NOW()
+		WHERE user_id = $1::uuid
+	`, userID, erasedEmail); err != nil {
+		return nil, nil, err
+	}
+
+	sessions, err := tx.Exec(ctx, `DELETE FROM user_sessions WHERE user_id = $1::uuid`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_mfa_recovery_codes WHERE user_id = $1::uuid`, userID); err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_mfa WHERE user_id = $1::uuid`, userID); err != nil {
+		return nil, nil, err
+	}
+	invitations, err := tx.Exec(ctx, `
+		UPDATE user_invitations SET email = $3
+		WHERE tenant_id IS NOT DISTINCT FROM NULLIF($1,'')::uuid AND lower(email) = lower($2)
+	`, tenantID, email, erasedEmail)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		UPDATE audit_log
+		SET ip_address = NULL,
+		    user_agent = NULL,
+		    metadata = CASE WHEN event_category = 'billing' THEN metadata ELSE
+		      (COALESCE(metadata, '{}'::jsonb) - $4::text[])
+		      || CASE WHEN jsonb_typeof(metadata->'request') = 'object'
+		              THEN jsonb_build_object('request', (metadata->'request') - $4::text[])
+		              ELSE '{}'::jsonb END
+		    END,
+		    redacted_at = NOW()
+		WHERE `+privacyAuditMatch+`
+		RETURNING audit_id
+	`, userID, tenantID, email, privacyPIIKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+	auditIDs := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		auditIDs = append(auditIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// Hashed in a second statement so audit_row_hash sees the redacted content.
+	rows, err = tx.Query(ctx, `
+		UPDATE audit_log a
+		SET redacted_hash = audit_row_hash(a)
+		WHERE a.audit_id = ANY($1) AND a.chain_seq IS NOT NULL
+		RETURNING COALESCE(a.tenant_id::text, ''), a.audit_id, a.redacted_hash
+	`, auditIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	redactions := map[string]map[string]string{}
+	for rows.Next() {
+		var chainTenant, hash string
+		var id int64
+		if err := rows.Scan(&chainTenant, &id, &hash); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if redactions[chainTenant] == nil {
+			redactions[chainTenant] = map[string]string{}
+		}
+		redactions[chainTenant][strconv.FormatInt(id, 10)] = hash
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return map[string]interface{}{
+		"sessions_deleted":       sessions.RowsAffected(),
+		"invitations_anonymized": invitations.RowsAffected(),
+		"audit_rows_redacted":    len(auditIDs),
+	}, redactions, nil
+}
+
+// recordErasureCompleted writes the chained privacy.erasure_completed row
+// that lists the redacted rows of each chain with their redacted hash
+// (metadata.redacted, audit_id -> hash); audit verification checks redacted
+// rows against it. The request's tenant chain gets the full record, other
+// chains touched by the erasure (the platform chain for anonymous requests)
+// one listing their own rows.
+func recordErasureCompleted(ctx context.Context, tx pgx.Tx, tenantID, actorUserID, userID, requestID string, result map[string]interface{}, redactions map[string]map[string]string) error {
+	metadata := map[string]interface{}{"request_id": requestID}
+	for k, v := range result {
+		metadata[k] = v
+	}
+	redacted := redactions[tenantID]
+	if redacted == nil {
+		redacted = map[string]string{}
+	}
+	metadata["redacted"] = redacted
+	if err := writePrivacyEvent(ctx, tx, tenantID, actorUserID, userID, "privacy.erasure_completed", "erase_personal_data", metadata); err != nil {
+		return err
+	}
+
+	chains := make([]string, 0, len(redactions))
+	for chain := range redactions {
+		if chain != tenantID {
+			chains = append(chains, chain)
+		}
+	}
+	sort.Strings(chains)
+	for _, chain := range chains {
+		if err := writePrivacyEvent(ctx, tx, chain, actorUserID, userID, "privacy.erasure_completed", "erase_personal_data", map[string]interface{}{
+			"request_id": requestID,
+			"redacted":   redactions[chain],
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// erasedUserEmail is the placeholder e-mail of an erased user (unique, and
+// .invalid can never receive mail).
+func erasedUserEmail(userID string) string {
+	return "erased+" + userID + "@erased.invalid"
+}
+
+// recordPrivacyEvent writes a privacy audit row about userID. Erasure passes
+// its transaction (the users row is locked there).
+func recordPrivacyEvent(ctx context.Context, q dbExecer, tenantID, actorUserID, userID, eventType, action string, metadata map[string]interface{}) {
+	_ = writePrivacyEvent(ctx, q, tenantID, actorUserID, userID, eventType, action, metadata)
+}
+
+// writePrivacyEvent is recordPrivacyEvent for rows the caller cannot lose.
+func writePrivacyEvent(ctx context.Context, q dbExecer, tenantID, actorUserID, userID, eventType, action string, metadata map[string]interface{}) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, resource_type, resource_id, metadata, timestamp)
+		VALUES (NULLIF($1,'')::uuid, NULLIF($2,'')::uuid, $3, 'privacy', 'info', 'user', NULLIF($2,'')::uuid, $4, 'success', 'user', $5::uuid, $6::jsonb, NOW())
+	`, tenantID, actorUserID, eventType, action, userID, toJSONB(metadata))
+	return err
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func privacyRequest(method, path, body, userID string, permissions []string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	ctx := context.WithValue(req.Context(), "user_id", userID)
+	ctx = context.WithValue(ctx, "tenant_id", "7f1f8f3e-9d7c-4c1a-8a43-2a4d8c0c1e11")
+	ctx = context.WithValue(ctx, "role", "tenant_user")
+	ctx = context.WithValue(ctx, "permissions", permissions)
+	return req.WithContext(ctx)
+}
+
+func TestPrivacyRequestsForOtherUsersNeedPermission(t *testing.T) {
+	t.Parallel()
+
+	h := &PrivacyHandler{}
+	self := "0b7d2a9c-3f4e-4d5a-9b6c-7d8e9f0a1b2c"
+	other := `{"user_id":"5d1c9e4a-8b7f-4e2d-a1c3-9f8e7d6c5b4a"}`
+	cases := []struct {
+		name   string
+		userID string
+		body   string
+		handle func(http.ResponseWriter, *http.Request)
+		status int
+	}{
+		{"export without caller", "", "", h.ExportPersonalData, http.StatusUnauthorized},
+		{"export other user", self, other, h.ExportPersonalData, http.StatusForbidden},
+		{"export invalid user_id", self, `{"user_id":"nope"}`, h.ExportPersonalData, http.StatusBadRequest},
+		{"erasure other user", self, other, h.CreateErasureRequest, http.StatusForbidden},
+		{"erasure reason too long", self, `{"reason":"` + strings.Repeat("x", 1001) + `"}`, h.CreateErasureRequest, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		c.handle(w, privacyRequest(http.MethodPost, "/api/v1/privacy/export", c.body, c.userID, []string{"devices:read"}))
+		if w.Code != c.status {
+			t.Fatalf("%s: status = %d, want %d", c.name, w.Code, c.status)
+		}
+	}
+}
+
+func TestReviewErasureRequestValidation(t *testing.T) {
+	t.Parallel()
+
+	h := &PrivacyHandler{}
+	id := "5d1c9e4a-8b7f-4e2d-a1c3-9f8e7d6c5b4a"
+	cases := []struct {
+		name      string
+		requestID string
+		body      string
+		handle    func(http.ResponseWriter, *http.Request)
+	}{
+		{"approve invalid id", "nope", "", h.ApproveErasureRequest},
+		{"approve note too long", id, `{"note":"` + strings.Repeat("x", 1001) + `"}`, h.ApproveErasureRequest},
+		{"reject without note", id, `{"note":"  "}`, h.RejectErasureRequest},
+		{"reject invalid body", id, `{`, h.RejectErasureRequest},
+	}
+	for _, c := range cases {
+		req := privacyRequest(http.MethodPost, "/api/v1/privacy/erasure-requests/"+c.requestID+"/approve", c.body, id, []string{"privacy:manage"})
+		req.SetPathValue("request_id", c.requestID)
+		w := httptest.NewRecorder()
+		c.handle(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", c.name, w.Code)
+		}
+	}
+}
+
+func TestErasedUserEmail(t *testing.T) {
+	t.Parallel()
+
+	id := "5d1c9e4a-8b7f-4e2d-a1c3-9f8e7d6c5b4a"
+	email := erasedUserEmail(id)
+	if !strings.HasSuffix(email, "@erased.invalid") || !strings.Contains(email, id) || len(email) > 255 {
+		t.Fatalf("erasedUserEmail = %q", email)
+	}
+}
+
+// redactAuditMetadata mirrors the jsonb key removal of eraseUserData.
+func redactAuditMetadata(metadata map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range metadata {
+		out[k] = v
+	}
+	for _, key := range privacyPIIKeys {
+		delete(out, key)
+	}
+	if request, ok := out["request"].(map[string]interface{}); ok {
+		out["request"] = redactAuditMetadata(request)
+	}
+	return out
+}
+
+func TestErasureRedactsOIDCSubject(t *testing.T) {
+	t.Parallel()
+
+	// Metadata of a user.provisioned row written by OIDC provisioning.
+	got := redactAuditMetadata(map[string]interface{}{
+		"email":   "ana@example.com",
+		"role":    "tenant_user",
+		"issuer":  "https://idp.example.com",
+		"subject": "248289761001",
+		"request": map[string]interface{}{"subject": "248289761001"},
+	})
+	if _, ok := got["subject"]; ok {
+		t.Fatalf("subject kept after redaction: %v", got)
+	}
+	if _, ok := got["request"].(map[string]interface{})["subject"]; ok {
+		t.Fatalf("request.subject kept after redaction: %v", got)
+	}
+	if _, ok := got["email"]; ok || got["role"] != "tenant_user" || got["issuer"] != "https://idp.example.com" {
+		t.Fatalf("redacted metadata = %v, want only personal fields removed", got)
+	}
+}
@@ -4,36 +4,60 @@ import (
 	"context"
 	"fmt"
 	"iiot-go-api/config"
+	"math"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 )
 
+// TenantQuota holds the effective limits of a tenant (plan defaults merged
+// with tenant overrides).
 type TenantQuota struct {
-	TenantID        string
-	PlanType        string
-	BillingCycle    string
-	QuotaDevices    int
-	QuotaMsgsPerMin int
-	QuotaStorageMB  int
-	AllowOverage    bool
+	TenantID          string
+	PlanID            string
+	PlanType          string
+	BillingCycle      string
+	QuotaDevices      int
+	QuotaMsgsPerMin   int
+	QuotaStorageMB    int
+	AllowOverage      bool
+	QuotaMsgsPerMonth int64
+	RetentionDays     int
 }
 
 func fetchTenantQuota(ctx context.Context, db *pgxpool.Pool, tenantID string) (*TenantQuota, error) {
-	var q TenantQuota
-	err := db.QueryRow(ctx, `
-		SELECT tenant_id::text, plan_type, billing_cycle, quota_devices, quota_msgs_per_min, quota_storage_mb, allow_overage
-		FROM tenants
-		WHERE tenant_id = $1::uuid
-	`, tenantID).Scan(&q.TenantID, &q.PlanType, &q.BillingCycle, &q.QuotaDevices, &q.QuotaMsgsPerMin, &q.QuotaStorageMB, &q.AllowOverage)
+	plan, overrides, billingCycle, err := fetchTenantPlan(ctx, db, tenantID)
 	if err != nil {
 		return nil, err
 	}
+	q := effectiveQuota(tenantID, billingCycle, *plan, overrides)
 	return &q, nil
 }
 
-func enforceDeviceQuota(ctx context.Context, db *pgxpool.Pool, cfg *config.Config, tenantID, userID, userEmail string) (bool, error) {
+// fetchTenantPlan loads the tenant's plan and its quota overrides.
+func fetchTenantPlan(ctx context.Context, db *pgxpool.Pool, tenantID string) (*Plan, TenantQuotaOverrides, string, error) {
+	var p Plan
+	var o TenantQuotaOverrides
+	var billingCycle string
+	err := db.QueryRow(ctx, `
+		SELECT p.plan_id::text, p.code, p.name, p.quota_devices, p.quota_msgs_per_min, p.quota_storage_mb,
+			p.quota_msgs_per_month, p.allow_overage, p.retention_days,
+			t.billing_cycle, t.quota_devices, t.quota_msgs_per_min, t.quota_storage_mb, t.quota_msgs_per_month, t.allow_overage
+		FROM tenants t
+		JOIN plans p ON p.plan_id = t.plan_id
+		WHERE t.tenant_id = $1::uuid
+	`, tenantID).Scan(&p.PlanID, &p.Code, &p.Name, &p.QuotaDevices, &p.QuotaMsgsPerMin, &p.QuotaStorageMB,
+		&p.QuotaMsgsPerMonth, &p.AllowOverage, &p.RetentionDays,
+		&billingCycle, &o.QuotaDevices, &o.QuotaMsgsPerMin, &o.QuotaStorageMB, &o.QuotaMsgsPerMonth, &o.AllowOverage)
+	if err != nil {
+		return nil, o, "", err
+	}
+	return &p, o, billingCycle, nil
+}
+
+func enforceDeviceQuota(ctx context.Context, db *pgxpool.Pool, rdb *redis.Client, cfg *config.Config, tenantID, userID, userEmail string) (bool, error) {
 	quota, err := fetchTenantQuota(ctx, db, tenantID)
 	if err != nil {
 		return false, err
@@ -47,8 +71,11 @@ func enforceDeviceQuota(ctx context.Context, db *pgxpool.Pool, cfg *config.Confi
 	if err != nil {
 		return false, err
 	}
-	if total >= quota.QuotaDevices {
-		recordQuotaExceeded(db, tenantID, userID, "quota.devices_exceeded", "quota_devices", map[string]interface{}{
+	periodStart, periodEnd := currentMonthRange(time.Now().UTC())
+	// Measure as if the new device were already added.
+	check := quotaCheck{TenantID: tenantID, Quota: "quota_devices", Used: float64(total + 1), Limit: int64(quota.QuotaDevices), PeriodStart: periodStart, PeriodEnd: periodEnd}
+	if total >= quota.QuotaDevices && !withinQuotaGrace(ctx, db, rdb, cfg, check) {
+		recordQuotaExceeded(ctx, db, tenantID, userID, "quota.devices_exceeded", "quota_devices", map[string]interface{}{
 			"quota_devices": quota.QuotaDevices,
 			"devices_total": total,
 			"user_email":    userEmail,
@@ -61,12 +88,13 @@ func enforceDeviceQuota(ctx context.Context, db *pgxpool.Pool, cfg *config.Confi
 		)
 		return false, nil
 	}
+	checkQuotaThresholds(ctx, db, rdb, cfg, check)
 
 	return true, nil
 }
 
-func enforceTelemetryQuota(ctx context.Context, db *pgxpool.Pool, ts *pgxpool.Pool, rdb *redis.Client, cfg *config.Config, tenantID, deviceID string) (bool, int, error) {
-	quota, err := fetchTenantQuota(ctx, db, tenantID)
+func enforceTelemetryQuota(ctx context.Context, db *pgxpool.Pool, ts *pgxpool.Pool, rdb *redis.Client, cache *IngestCache, cfg *config.Config, tenantID, deviceID string) (bool, int, error) {
+	quota, err := cache.TenantQuota(ctx, db, tenantID)
 	if err != nil {
 		return false, 0, err
 	}
@@ -79,7 +107,7 @@ func enforceTelemetryQuota(ctx context.Context, db *pgxpool.Pool, ts *pgxpool.Po
 				_ = rdb.Expire(ctx, key, 60*time.Second).Err()
 			}
 			if int(count) > quota.QuotaMsgsPerMin {
-				recordQuotaExceeded(db, tenantID, "", "quota.messages_exceeded", "quota_msgs_per_min", map[string]interface{}{
+				recordQuotaExceeded(ctx, db, tenantID, "", "quota.messages_exceeded", "quota_msgs_per_min", map[string]interface{}{
 					"quota_msgs_per_min": quota.QuotaMsgsPerMin,
 					"device_id":          deviceID,
 					"count":              count,
@@ -95,16 +123,44 @@ func enforceTelemetryQuota(ctx context.Context, db *pgxpool.Pool, ts *pgxpool.Po
 		}
 	}
 
+	if rdb != nil && quota.QuotaMsgsPerMonth > 0 {
+		count, err := monthlyMessageCount(ctx, db, rdb, tenantID)
+		if err == nil {
+			start, end := currentMonthRange(time.Now().UTC())
+			check := quotaCheck{TenantID: tenantID, Quota: "quota_msgs_per_month", Used: float64(count + 1), Limit: quota.QuotaMsgsPerMonth, PeriodStart: start, PeriodEnd: end}
+			if count >= quota.QuotaMsgsPerMonth && !quota.AllowOverage && !withinQuotaGrace(ctx, db, rdb, cfg, check) {
+				// Notify once per period; every further message would otherwise flood audit/Telegram.
+				notifyKey := messageCounterKey(tenantID, start) + ":blocked"
+				if first, err := rdb.SetNX(ctx, notifyKey, 1, time.Until(end)).Result(); err == nil && first {
+					recordQuotaExceeded(ctx, db, tenantID, "", "quota.monthly_messages_exceeded", "quota_msgs_per_month", map[string]interface{}{
+						"quota_msgs_per_month": quota.QuotaMsgsPerMonth,
+						"device_id":            deviceID,
+						"count":                count,
+						"period_start":         start,
+						"period_end":           end,
+					})
+					SendQuotaTelegramAsync(cfg,
+						"[IIoT Core] Quota msg/mes excedida",
+						fmt.Sprintf("tenant=%s", tenantID),
+						fmt.Sprintf("plan=%s", quota.PlanType),
+						fmt.Sprintf("count=%d quota=%d", count, quota.QuotaMsgsPerMonth),
+					)
+				}
+				return false, quota.QuotaMsgsPerMin, nil
+			}
+			checkQuotaThresholds(ctx, db, rdb, cfg, check)
+		}
+	}
+
 	if quota.QuotaStorageMB > 0 {
 		var storageBytes float64
 		err := ts.QueryRow(ctx, `SELECT COALESCE(SUM(pg_column_size(value)),0)::float8 FROM telemetry WHERE tenant_id = $1::uuid`, tenantID).Scan(&storageBytes)
 		if err == nil {
 			storageMB := storageBytes / 1024.0 / 1024.0
-			if storageMB >= float64(quota.QuotaStorageMB) {
-				if quota.PlanType == "enterprise" && quota.AllowOverage {
-					return true, quota.QuotaMsgsPerMin, nil
-				}
-				recordQuotaExceeded(db, tenantID, "", "quota.storage_exceeded", "quota_storage_mb", map[string]interface{}{
+			start, end := currentMonthRange(time.Now().UTC())
+			check := quotaCheck{TenantID: tenantID, Quota: "quota_storage_mb", Used: math.Round(storageMB*100) / 100, Limit: int64(quota.QuotaStorageMB), PeriodStart: start, PeriodEnd: end}
+			if storageMB >= float64(quota.QuotaStorageMB) && !quota.AllowOverage && !withinQuotaGrace(ctx, db, rdb, cfg, check) {
+				recordQuotaExceeded(ctx, db, tenantID, "", "quota.storage_exceeded", "quota_storage_mb", map[string]interface{}{
 					"quota_storage_mb": quota.QuotaStorageMB,
 					"storage_mb":       storageMB,
 					"plan_type":        quota.PlanType,
@@ -118,6 +174,7 @@ func enforceTelemetryQuota(ctx context.Context, db *pgxpool.Pool, ts *pgxpool.Po
 				)
 				return false, quota.QuotaMsgsPerMin, nil
 			}
+			checkQuotaThresholds(ctx, db, rdb, cfg, check)
 		}
 	}
 
@@ -126,47 +183,86 @@ func enforceTelemetryQuota(ctx context.Context, db *pgxpool.Pool, ts *pgxpool.Po
 
 func createUsageSnapshot(ctx context.Context, db, ts *pgxpool.Pool, tenantID string) error {
 	start, end := currentMonthRange(time.Now().UTC())
+	_, err := createUsageSnapshotForPeriod(ctx, db, ts, tenantID, start, end)
+	return err
+}
 
+// createUsageSnapshotForPeriod upserts the usage snapshot of a period and
+// returns its snapshot_id. Closed snapshots (final) and frozen ones (already
+// invoiced) are left as-is. storage_mb is the footprint of the telemetry
+// stored up to period_end, as measured when the snapshot is written; once the
+// period is closed it no longer changes.
+func createUsageSnapshotForPeriod(ctx context.Context, db, ts *pgxpool.Pool, tenantID string, start, end time.Time) (int64, error) {
+	// Telemetry failures abort the snapshot: zeros would end up on an invoice.
 	var messages int64
-	_ = ts.QueryRow(ctx, `
+	err := ts.QueryRow(ctx, `
 		SELECT COALESCE(COUNT(*),0)
 		FROM telemetry
 		WHERE tenant_id = $1::uuid
 		  AND timestamp >= $2
 		  AND timestamp < $3
 	`, tenantID, start, end).Scan(&messages)
+	if err != nil {
+		return 0, err
+	}
 
 	var devices int
-	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM devices WHERE tenant_id = $1::uuid`, tenantID).Scan(&devices)
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM devices WHERE tenant_id = $1::uuid`, tenantID).Scan(&devices); err != nil {
+		return 0, err
+	}
+
+	// Effective limit: tenant override, or the plan default.
+	quota, err := fetchTenantQuota(ctx, db, tenantID)
+	if err != nil {
+		return 0, err
+	}
+	quotaMsgsPerMonth := quota.QuotaMsgsPerMonth
 
 	var storageBytes float64
-	_ = ts.QueryRow(ctx, `SELECT COALESCE(SUM(pg_column_size(value)),0)::float8 FROM telemetry WHERE tenant_id = $1::uuid`, tenantID).Scan(&storageBytes)
+	err = ts.QueryRow(ctx, `SELECT COALESCE(SUM(pg_column_size(value)),0)::float8 FROM telemetry WHERE tenant_id = $1::uuid AND timestamp < $2`, tenantID, end).Scan(&storageBytes)
+	if err != nil {
+		return 0, err
+	}
 	storageMB := storageBytes / 1024.0 / 1024.0
 
-	_, err := db.Exec(ctx, `
-		INSERT INTO tenant_usage_snapshots (tenant_id, period_start, period_end, messages_ingested, storage_mb, devices_total)
-		VALUES ($1::uuid, $2, $3, $4, $5, $6)
+	var snapshotID int64
+	err = db.QueryRow(ctx, `
+		INSERT INTO tenant_usage_snapshots (tenant_id, period_start, period_end, messages_ingested, storage_mb, devices_total, quota_msgs_per_month)
+		VALUES ($1::uuid, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (tenant_id, period_start, period_end)
 		DO UPDATE SET
 			messages_ingested = EXCLUDED.messages_ingested,
 			storage_mb = EXCLUDED.storage_mb,
 			devices_total = EXCLUDED.devices_total,
+			quota_msgs_per_month = EXCLUDED.quota_msgs_per_month,
 			created_at = NOW()
-	`, tenantID, start, end, messages, storageMB, devices)
+		WHERE tenant_usage_snapshots.frozen_at IS NULL
+		  AND tenant_usage_snapshots.closed_at IS NULL
+		RETURNING snapshot_id
+	`, tenantID, start, end, messages, storageMB, devices, quotaMsgsPerMonth).Scan(&snapshotID)
+	if err == pgx.ErrNoRows {
+		// Closed or frozen: keep the final (invoiced) numbers.
+		err = db.QueryRow(ctx, `
+			SELECT snapshot_id FROM tenant_usage_snapshots
+			WHERE tenant_id = $1::uuid AND period_start = $2 AND period_end = $3
+		`, tenantID, start, end).Scan(&snapshotID)
+		return snapshotID, err
+	}
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	_, _ = db.Exec(ctx, `
 		INSERT INTO audit_log (tenant_id, event_type, event_category, severity, actor_type, action, result, metadata)
 		VALUES ($1::uuid, 'billing.snapshot_generated', 'billing', 'info', 'system', 'snapshot', 'success', $2::jsonb)
 	`, tenantID, toJSONB(map[string]interface{}{
-		"period_start": start,
-		"period_end":   end,
-		"messages":     messages,
-		"devices":      devices,
-		"storage_mb":   storageMB,
+		"period_start":         start,
+		"period_end":           end,
+		"messages":             messages,
+		"devices":              devices,
+		"storage_mb":           storageMB,
+		"quota_msgs_per_month": quotaMsgsPerMonth,
 	}))
 
-	return nil
+	return snapshotID, nil
 }
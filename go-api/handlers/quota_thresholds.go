@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"iiot-go-api/config"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// quotaCheck describes one tenant-level quota measurement inside a period.
+type quotaCheck struct {
+	TenantID    string
+	Quota       string // quota_devices | quota_storage_mb | quota_msgs_per_month
+	Used        float64
+	Limit       int64
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+}
+
+// crossedThreshold returns the highest configured threshold reached by
+// used/limit, or 0 when none is reached (or the quota is unlimited).
+func crossedThreshold(thresholds []int, used float64, limit int64) int {
+	if limit <= 0 {
+		return 0
+	}
+	sorted := append([]int(nil), thresholds...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	percent := used / float64(limit) * 100
+	for _, t := range sorted {
+		if t > 0 && percent >= float64(t) {
+			return t
+		}
+	}
+	return 0
+}
+
+// checkQuotaThresholds emits quota.threshold_reached plus notifications to
+// tenant admins and ops the first time a threshold is crossed in the period.
+// Redis (when available) gates the hot path; Postgres is the source of truth
+// for deduplication across replicas and restarts.
+func checkQuotaThresholds(ctx context.Context, db *pgxpool.Pool, rdb *redis.Client, cfg *config.Config, c quotaCheck) {
+	if db == nil || cfg == nil {
+		return
+	}
+	threshold := crossedThreshold(cfg.QuotaWarningThresholds, c.Used, c.Limit)
+	if threshold == 0 {
+		return
+	}
+
+	if rdb != nil {
+		gate := fmt.Sprintf("%s%s:threshold:%s:%d:%s", messageCounterKeyPrefix, c.TenantID, c.Quota, threshold, c.PeriodStart.UTC().Format("200601"))
+		first, err := rdb.SetNX(ctx, gate, 1, time.Until(c.PeriodEnd)+time.Hour).Result()
+		if err == nil && !first {
+			return
+		}
+	}
+
+	var inserted bool
+	err := db.QueryRow(ctx, `
+		INSERT INTO quota_threshold_notifications (tenant_id, quota, threshold_percent, period_start, used, quota_limit)
+		VALUES ($1::uuid, $2, $3, $4, $5, $6)
+		ON CONFLICT DO NOTHING
+		RETURNING true
+	`, c.TenantID, c.Quota, threshold, c.PeriodStart, c.Used, c.Limit).Scan(&inserted)
+	if err == pgx.ErrNoRows {
+		// Already notified for this period (another replica or before a restart).
+		return
+	}
+	if err != nil || !inserted {
+		slog.Warn("quota_threshold_record_failed", slog.String("tenant_id", c.TenantID), slog.Any("error", err))
+		return
+	}
+
+	percent := c.Used / float64(c.Limit) * 100
+	_, _ = db.Exec(ctx, `
+		INSERT INTO audit_log (tenant_id, event_type, event_category, severity, actor_type, action, result, metadata, timestamp)
+		VALUES ($1::uuid, 'quota.threshold_reached', 'billing', 'warning', 'system', 'quota_warning', 'success', $2::jsonb, NOW())
+	`, c.TenantID, toJSONB(map[string]interface{}{
+		"quota":             c.Quota,
+		"threshold_percent": threshold,
+		"used":              c.Used,
+		"limit":             c.Limit,
+		"percent_used":      percent,
+		"period_start":      c.PeriodStart,
+		"period_end":        c.PeriodEnd,
+	}))
+
+	SendQuotaTelegramAsync(cfg,
+		fmt.Sprintf("[IIoT Core] Quota %s em %d%%", c.Quota, threshold),
+		fmt.Sprintf("tenant=%s", c.TenantID),
+		fmt.Sprintf("used=%s limit=%d", formatQuotaValue(c.Used), c.Limit),
+	)
+	notifyEachAsync(NewNotifier(cfg), Notification{
+		To:      tenantAdminEmails(ctx, db, c.TenantID),
+		Subject: fmt.Sprintf("Aviso de quota: %s atingiu %d%%", c.Quota, threshold),
+		Body: fmt.Sprintf(
+			"Seu tenant atingiu %d%% da quota %s.\n\nUso atual: %s\nLimite: %d\nPeríodo: %s a %s\n\nAo atingir 100%% novas operações podem ser bloqueadas.",
+			threshold, c.Quota, formatQuotaValue(c.Used), c.Limit,
+			c.PeriodStart.Format("02/01/2006"), c.PeriodEnd.Add(-time.Second).Format("02/01/2006"),
+		),
+	})
+}
+
+// withinQuotaGrace reports whether usage above the hard limit is still
+// tolerated: at most QUOTA_GRACE_PERCENT over the limit, for at most
+// QUOTA_GRACE_HOURS counted from the first time the limit was exceeded in
+// the period.
+func withinQuotaGrace(ctx context.Context, db *pgxpool.Pool, rdb *redis.Client, cfg *config.Config, c quotaCheck) bool {
+	if db == nil || cfg == nil || cfg.QuotaGracePercent <= 0 || cfg.QuotaGraceHours <= 0 || c.Limit <= 0 {
+		return false
+	}
+	if !withinGraceBuffer(c.Used, c.Limit, cfg.QuotaGracePercent) {
+		return false
+	}
+
+	startedAt, err := quotaGraceStart(ctx, db, rdb, c)
+	if err != nil {
+		slog.Warn("quota_grace_lookup_failed", slog.String("tenant_id", c.TenantID), slog.Any("error", err))
+		return false
+	}
+	return time.Since(startedAt) < time.Duration(cfg.QuotaGraceHours)*time.Hour
+}
+
+func withinGraceBuffer(used float64, limit, gracePercent int64) bool {
+	return used <= float64(limit)*(1+float64(gracePercent)/100)
+}
+
+// quotaGraceStart returns when the grace window of the period began, opening
+// it (and auditing quota.grace_started) on first use.
+func quotaGraceStart(ctx context.Context, db *pgxpool.Pool, rdb *redis.Client, c quotaCheck) (time.Time, error) {
+	key := fmt.Sprintf("%s%s:grace:%s:%s", messageCounterKeyPrefix, c.TenantID, c.Quota, c.PeriodStart.UTC().Format("200601"))
+	if rdb != nil {
+		if v, err := rdb.Get(ctx, key).Result(); err == nil {
+			if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return time.Unix(unix, 0), nil
+			}
+		}
+	}
+
+	var startedAt time.Time
+	var opened bool
+	err := db.QueryRow(ctx, `
+		INSERT INTO quota_grace_periods (tenant_id, quota, period_start)
+		VALUES ($1::uuid, $2, $3)
+		ON CONFLICT (tenant_id, quota, period_start) DO UPDATE SET started_at = quota_grace_periods.started_at
+		RETURNING started_at, (xmax = 0)
+	`, c.TenantID, c.Quota, c.PeriodStart).Scan(&startedAt, &opened)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if opened {
+		_, _ = db.Exec(ctx, `
+			INSERT INTO audit_log (tenant_id, event_type, event_category, severity, actor_type, action, result, metadata, timestamp)
+			VALUES ($1::uuid, 'quota.grace_started', 'billing', 'warning', 'system', 'enforce_quota', 'allowed', $2::jsonb, NOW())
+		`, c.TenantID, toJSONB(map[string]interface{}{
+			"quota":        c.Quota,
+			"used":         c.Used,
+			"limit":        c.Limit,
+			"period_start": c.PeriodStart,
+		}))
+	}
+	if rdb != nil {
+		_ = rdb.Set(ctx, key, startedAt.Unix(), time.Until(c.PeriodEnd)+time.Hour).Err()
+	}
+	return startedAt, nil
+}
+
+func formatQuotaValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"iiot-go-api/config"
+	"testing"
+	"time"
+)
+
+func TestCrossedThreshold(t *testing.T) {
+	t.Parallel()
+
+	thresholds := []int{95, 80}
+	tests := []struct {
+		used  float64
+		limit int64
+		want  int
+	}{
+		{used: 79, limit: 100, want: 0},
+		{used: 80, limit: 100, want: 80},
+		{used: 94.9, limit: 100, want: 80},
+		{used: 96, limit: 100, want: 95},
+		{used: 150, limit: 100, want: 95},
+		{used: 1000, limit: 0, want: 0}, // unlimited
+	}
+	for _, tt := range tests {
+		if got := crossedThreshold(thresholds, tt.used, tt.limit); got != tt.want {
+			t.Fatalf("crossedThreshold(%v/%d) = %d, want %d", tt.used, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestWithinGraceBuffer(t *testing.T) {
+	t.Parallel()
+
+	if !withinGraceBuffer(110, 100, 10) {
+		t.Fatalf("110/100 with 10%% grace should be within buffer")
+	}
+	if withinGraceBuffer(111, 100, 10) {
+		t.Fatalf("111/100 with 10%% grace should exceed buffer")
+	}
+}
+
+func TestWithinQuotaGraceDisabledByDefault(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{QuotaGracePercent: 0, QuotaGraceHours: 24}
+	if withinQuotaGrace(context.Background(), nil, nil, cfg, quotaCheck{Used: 101, Limit: 100}) {
+		t.Fatalf("grace should be disabled when QUOTA_GRACE_PERCENT=0")
+	}
+}
+
+type recordingNotifier struct {
+	sent chan Notification
+}
+
+func (n recordingNotifier) Notify(_ context.Context, msg Notification) error {
+	n.sent <- msg
+	return nil
+}
+
+func TestNotifyEachAsyncSendsOneMessagePerRecipient(t *testing.T) {
+	t.Parallel()
+
+	notifier := recordingNotifier{sent: make(chan Notification, 2)}
+	notifyEachAsync(notifier, Notification{To: []string{"a@example.com", "b@example.com"}, Subject: "quota", Body: "80%"})
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-notifier.sent:
+			if len(msg.To) != 1 || msg.Subject != "quota" {
+				t.Fatalf("notification = %+v, want a single recipient", msg)
+			}
+			got[msg.To[0]] = true
+		case <-time.After(time.Second):
+			t.Fatalf("only %d notifications sent, want 2", i)
+		}
+	}
+	if !got["a@example.com"] || !got["b@example.com"] {
+		t.Fatalf("recipients = %v", got)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"iiot-go-api/models"
+	"iiot-go-api/utils"
+	"log/slog"
+	"net/http"
+)
+
+// handleRefreshReuse reacts to a rotated-out refresh token presented again:
+// someone else holds a copy of the chain, so the whole token family (the
+// session, "sid" claim) is revoked, for the attacker and the victim alike.
+// Tokens issued before sessions existed have no family, so every token of
+// the user is revoked instead.
+func (h *AuthHandler) handleRefreshReuse(r *http.Request, claims *models.JWTClaims) {
+	ctx := context.Background()
+	revoked := 0
+	if claims.SessionID != "" {
+		// Cut access tokens first; the DB update below may fail.
+		if err := utils.RevokeSession(h.Redis, claims.SessionID, h.Config.JWTAccessExpiration); err != nil {
+			slog.Warn("refresh_reuse_session_revocation_failed", slog.String("session_id", claims.SessionID), slog.Any("error", err))
+		}
+		if h.DB != nil {
+			n, err := revokeSessions(ctx, h.DB, h.Redis, h.Config, claims.UserID, claims.SessionID, "", "refresh_reuse")
+			if err != nil {
+				slog.Warn("refresh_reuse_session_revocation_failed", slog.String("session_id", claims.SessionID), slog.Any("error", err))
+			}
+			revoked = n
+		}
+	} else if err := utils.RevokeUserTokens(h.Redis, claims.UserID, h.Config.JWTRefreshExpiration); err != nil {
+		slog.Warn("refresh_reuse_user_revocation_failed", slog.String("user_id", claims.UserID), slog.Any("error", err))
+	}
+
+	ip := utils.ClientIP(r)
+	slog.Warn("refresh_token_reuse_detected",
+		slog.String("user_id", claims.UserID),
+		slog.String("session_id", claims.SessionID),
+		slog.String("ip", ip),
+	)
+	recordRefreshReuse(h, claims, ip, r.UserAgent(), revoked)
+	notifyRefreshReuse(h.DB, h.Config, claims.UserID, claims.TenantID, claims.Email, claims.SessionID, ip)
+	notifyAsync(h.Notifier, Notification{
+		To:      []string{claims.Email},
+		Subject: "Atividade suspeita na sua conta",
+		Body:    "Detectamos o reuso de um token de sessão já substituído na sua conta da plataforma IIoT. Por segurança, a sessão afetada foi encerrada e será preciso entrar novamente.\n\nSe você não reconhece esta atividade, altere sua senha e avise o administrador do seu tenant.",
+	})
+}
+
+func recordRefreshReuse(h *AuthHandler, claims *models.JWTClaims, ip, userAgent string, sessionsRevoked int) {
+	if h.DB == nil {
+		return
+	}
+	_, _ = h.DB.Exec(context.Background(), `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, resource_type, resource_id, user_agent, metadata, timestamp)
+		VALUES (NULLIF($1,'')::uuid, $2::uuid, 'security.refresh_reuse_detected', 'security', 'critical', 'user', $2::uuid, 'refresh_token', 'blocked', 'session', NULLIF($3,'')::uuid, NULLIF($4,''), $5::jsonb, NOW())
+	`, claims.TenantID, claims.UserID, claims.SessionID, userAgent, toJSONB(map[string]interface{}{
+		"jti":              claims.JTI,
+		"family_id":        claims.SessionID,
+		"ip":               ip,
+		"sessions_revoked": sessionsRevoked,
+		"all_tokens":       claims.SessionID == "",
+	}))
+}
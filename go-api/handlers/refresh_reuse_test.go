@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"iiot-go-api/utils"
+)
+
+func TestRefreshReuseRevokesTokenFamily(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	h := testAuthHandler()
+	h.Redis = rdb
+
+	refresh, err := utils.GenerateSessionJWT(h.Config.JWTSecret, "refresh", "0b8c7f4e-5d6a-4f3b-9e2d-1a2b3c4d5e6f", "u1", "t1", "user@example.com", "tenant_admin", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateSessionJWT error: %v", err)
+	}
+	claims, err := utils.ValidateJWT(h.Config.JWTSecret, refresh)
+	if err != nil {
+		t.Fatalf("ValidateJWT error: %v", err)
+	}
+	// Simulate a token already rotated out by a previous refresh.
+	if claimed, err := utils.ClaimToken(rdb, claims.JTI, time.Hour); err != nil || !claimed {
+		t.Fatalf("ClaimToken = %v, %v", claimed, err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", strings.NewReader(`{"refresh_token":"`+refresh+`"}`))
+	w := httptest.NewRecorder()
+	h.Refresh(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body["code"] != "refresh_token_reused" {
+		t.Fatalf("code = %v, want refresh_token_reused", body["code"])
+	}
+
+	// Access tokens of the same family are now rejected.
+	revoked, err := utils.IsAccessTokenRevoked(rdb, "", claims.SessionID)
+	if err != nil || !revoked {
+		t.Fatalf("IsAccessTokenRevoked = %v, %v; want true", revoked, err)
+	}
+}
+
+func TestRefreshReuseWithoutFamilyRevokesAllUserTokens(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	h := testAuthHandler()
+	h.Redis = rdb
+
+	refresh, err := utils.GenerateJWT(h.Config.JWTSecret, "refresh", "u1", "t1", "user@example.com", "tenant_admin", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateJWT error: %v", err)
+	}
+	claims, err := utils.ValidateJWT(h.Config.JWTSecret, refresh)
+	if err != nil {
+		t.Fatalf("ValidateJWT error: %v", err)
+	}
+	if claimed, err := utils.ClaimToken(rdb, claims.JTI, time.Hour); err != nil || !claimed {
+		t.Fatalf("ClaimToken = %v, %v", claimed, err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", strings.NewReader(`{"refresh_token":"`+refresh+`"}`))
+	w := httptest.NewRecorder()
+	h.Refresh(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	revoked, err := utils.IsUserTokenRevoked(rdb, "u1", claims.IssuedAt)
+	if err != nil || !revoked {
+		t.Fatalf("IsUserTokenRevoked = %v, %v; want true", revoked, err)
+	}
+}
+
+func TestConcurrentRefreshOnlyOneWins(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	h := testAuthHandler()
+	h.Redis = rdb
+
+	refresh, err := utils.GenerateSessionJWT(h.Config.JWTSecret, "refresh", "0b8c7f4e-5d6a-4f3b-9e2d-1a2b3c4d5e6f", "u1", "t1", "user@example.com", "tenant_admin", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateSessionJWT error: %v", err)
+	}
+	// The winner stops at the user revocation check (no DB here); what
+	// matters is that it got past the claim and nobody else did.
+	if err := utils.RevokeUserTokens(rdb, "u1", time.Hour); err != nil {
+		t.Fatalf("RevokeUserTokens error: %v", err)
+	}
+
+	const replays = 8
+	codes := make(chan string, replays)
+	var wg sync.WaitGroup
+	for i := 0; i < replays; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", strings.NewReader(`{"refresh_token":"`+refresh+`"}`))
+			w := httptest.NewRecorder()
+			h.Refresh(w, req)
+			var body map[string]interface{}
+			_ = json.Unmarshal(w.Body.Bytes(), &body)
+			code, _ := body["code"].(string)
+			codes <- code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	reused := 0
+	for code := range codes {
+		if code == "refresh_token_reused" {
+			reused++
+		}
+	}
+	if reused != replays-1 {
+		t.Fatalf("%d of %d concurrent refreshes reported reuse, want %d", reused, replays, replays-1)
+	}
+}
+
+func TestRefreshAfterLogoutIsNotReuse(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	h := testAuthHandler()
+	h.Redis = rdb
+
+	access, err := utils.GenerateJWT(h.Config.JWTSecret, "access", "u1", "t1", "user@example.com", "tenant_admin", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateJWT error: %v", err)
+	}
+	refresh, err := utils.GenerateJWT(h.Config.JWTSecret, "refresh", "u1", "t1", "user@example.com", "tenant_admin", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateJWT error: %v", err)
+	}
+	accessClaims, err := utils.ValidateJWT(h.Config.JWTSecret, access)
+	if err != nil {
+		t.Fatalf("ValidateJWT error: %v", err)
+	}
+
+	logout := httptest.NewRequest(http.MethodPost, "/api/v1/auth/logout", strings.NewReader(`{"refresh_token":"`+refresh+`"}`))
+	logout = logout.WithContext(context.WithValue(logout.Context(), "jwt_claims", accessClaims))
+	w := httptest.NewRecorder()
+	h.Logout(w, logout)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("logout status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", strings.NewReader(`{"refresh_token":"`+refresh+`"}`))
+	w = httptest.NewRecorder()
+	h.Refresh(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if strings.Contains(w.Body.String(), "refresh_token_reused") {
+		t.Fatalf("logged-out token reported as reuse: %s", w.Body.String())
+	}
+	// No reuse response: the user's other tokens were not revoked.
+	revoked, err := utils.IsUserTokenRevoked(rdb, "u1", time.Now().Unix()-1)
+	if err != nil || revoked {
+		t.Fatalf("IsUserTokenRevoked = %v, %v; want false", revoked, err)
+	}
+}
@@ -0,0 +1,514 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"iiot-go-api/utils"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// GrantablePermission is an entry of the permissions catalog that tenants
+// may put in custom roles.
+type GrantablePermission struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description,omitempty"`
+}
+
+// TenantRole is a custom role of the tenant. It applies to tenant_user
+// accounts, replacing the built-in tenant_user permissions.
+type TenantRole struct {
+	RoleID      string    `json:"role_id"`
+	TenantID    string    `json:"tenant_id"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description,omitempty"`
+	Permissions []string  `json:"permissions"`
+	UserCount   int       `json:"user_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TenantRoleRequest creates or replaces a custom role.
+type TenantRoleRequest struct {
+	Name        string   `json:"name" validate:"required,min=2,max=50"`
+	Description *string  `json:"description" validate:"omitempty,max=500"`
+	Permissions []string `json:"permissions" validate:"required,min=1,dive,required"`
+}
+
+const tenantRoleColumns = `r.role_id::text, r.tenant_id::text, r.name, r.description, r.created_at, r.updated_at,
+	COALESCE((SELECT array_agg(p.name ORDER BY p.name) FROM tenant_role_permissions trp JOIN permissions p ON p.permission_id = trp.permission_id WHERE trp.role_id = r.role_id), '{}'),
+	(SELECT COUNT(*) FROM users u WHERE u.custom_role_id = r.role_id AND u.status <> 'deleted')`
+
+func scanTenantRole(row pgx.Row, tr *TenantRole) error {
+	return row.Scan(&tr.RoleID, &tr.TenantID, &tr.Name, &tr.Description, &tr.CreatedAt, &tr.UpdatedAt, &tr.Permissions, &tr.UserCount)
+}
+
+// ListGrantablePermissions lists the permissions a tenant may grant in
+// custom roles (platform-only ones such as system:admin are excluded).
+func (h *UserHandler) ListGrantablePermissions(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(r.Context(), `
+		SELECT name, description FROM permissions WHERE tenant_grantable ORDER BY name
+	`)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer rows.Close()
+
+	perms := []GrantablePermission{}
+	for rows.Next() {
+		var p GrantablePermission
+		if err := rows.Scan(&p.Name, &p.Description); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		perms = append(perms, p)
+	}
+	if err := rows.Err(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"permissions": perms})
+}
+
+func (h *UserHandler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	tenantID, tx, ok := tenantTx(w, r)
+	if !ok {
+		return
+	}
+	rows, err := tx.Query(r.Context(), `
+		SELECT `+tenantRoleColumns+` FROM tenant_roles r WHERE r.tenant_id = $1::uuid ORDER BY r.name
+	`, tenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer rows.Close()
+
+	roles := []TenantRole{}
+	for rows.Next() {
+		var tr TenantRole
+		if err := scanTenantRole(rows, &tr); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		roles = append(roles, tr)
+	}
+	if err := rows.Err(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"roles": roles})
+}
+
+func (h *UserHandler) GetRole(w http.ResponseWriter, r *http.Request) {
+	tenantID, tx, ok := tenantTx(w, r)
+	if !ok {
+		return
+	}
+	roleID, ok := roleIDParam(w, r)
+	if !ok {
+		return
+	}
+	var tr TenantRole
+	err := scanTenantRole(tx.QueryRow(r.Context(), `
+		SELECT `+tenantRoleColumns+` FROM tenant_roles r WHERE r.role_id = $1::uuid AND r.tenant_id = $2::uuid
+	`, roleID, tenantID), &tr)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, "Role not found")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, tr)
+}
+
+// CreateRole creates a custom role in the JWT tenant. Only grantable
+// permissions the caller holds can be put in it.
+func (h *UserHandler) CreateRole(w http.ResponseWriter, r *http.Request) {
+	tenantID, tx, ok := tenantTx(w, r)
+	if !ok {
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	req, ok := decodeTenantRoleRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	permIDs, ok := grantablePermissionIDs(w, ctx, tx, req.Permissions)
+	if !ok {
+		return
+	}
+
+	var roleID string
+	err := tx.QueryRow(ctx, `
+		INSERT INTO tenant_roles (tenant_id, name, description, created_by)
+		VALUES ($1::uuid, $2, NULLIF($3,''), NULLIF($4,'')::uuid)
+		ON CONFLICT (tenant_id, name) DO NOTHING
+		RETURNING role_id::text
+	`, tenantID, req.Name, stringOrEmpty(req.Description), actorUserID).Scan(&roleID)
+	if err == pgx.ErrNoRows {
+		utils.WriteErrorWithCode(w, http.StatusConflict, "role_name_taken", "A role with this name already exists")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO tenant_role_permissions (role_id, permission_id) SELECT $1::uuid, unnest($2::int[])
+	`, roleID, permIDs); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	var tr TenantRole
+	if err := scanTenantRole(tx.QueryRow(ctx, `
+		SELECT `+tenantRoleColumns+` FROM tenant_roles r WHERE r.role_id = $1::uuid
+	`, roleID), &tr); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	recordRoleEvent(ctx, tx, tenantID, actorUserID, roleID, "role.created", "create_role", map[string]interface{}{
+		"name":        tr.Name,
+		"permissions": tr.Permissions,
+	})
+	utils.WriteJSON(w, http.StatusCreated, tr)
+}
+
+// UpdateRole replaces name, description and permissions of a custom role.
+// When the permission set changes, holders' sessions are revoked so their
+// next login carries the new set.
+func (h *UserHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	tenantID, tx, ok := tenantTx(w, r)
+	if !ok {
+		return
+	}
+	roleID, ok := roleIDParam(w, r)
+	if !ok {
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+	req, ok := decodeTenantRoleRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	current, ok := lockTenantRole(w, ctx, tx, tenantID, roleID)
+	if !ok {
+		return
+	}
+	permIDs, ok := grantablePermissionIDs(w, ctx, tx, req.Permissions)
+	if !ok {
+		return
+	}
+	if req.Name != current.Name {
+		var taken bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM tenant_roles WHERE tenant_id = $1::uuid AND name = $2 AND role_id <> $3::uuid)
+		`, tenantID, req.Name, roleID).Scan(&taken); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		if taken {
+			utils.WriteErrorWithCode(w, http.StatusConflict, "role_name_taken", "A role with this name already exists")
+			return
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE tenant_roles SET name = $2, description = NULLIF($3,''), updated_at = NOW() WHERE role_id = $1::uuid
+	`, roleID, req.Name, stringOrEmpty(req.Description)); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM tenant_role_permissions WHERE role_id = $1::uuid`, roleID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO tenant_role_permissions (role_id, permission_id) SELECT $1::uuid, unnest($2::int[])
+	`, roleID, permIDs); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	var updated TenantRole
+	if err := scanTenantRole(tx.QueryRow(ctx, `
+		SELECT `+tenantRoleColumns+` FROM tenant_roles r WHERE r.role_id = $1::uuid
+	`, roleID), &updated); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	permissionsChanged := !equalStringSets(current.Permissions, updated.Permissions)
+	if permissionsChanged {
+		if !h.revokeRoleHolders(w, ctx, tx, roleID, actorUserID) {
+			return
+		}
+	}
+	recordRoleEvent(ctx, tx, tenantID, actorUserID, roleID, "role.updated", "update_role", map[string]interface{}{
+		"name_from":        current.Name,
+		"name_to":          updated.Name,
+		"permissions_from": current.Permissions,
+		"permissions_to":   updated.Permissions,
+		"sessions_revoked": permissionsChanged,
+	})
+	utils.WriteJSON(w, http.StatusOK, updated)
+}
+
+// DeleteRole removes a custom role that is not assigned to any user.
+func (h *UserHandler) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	tenantID, tx, ok := tenantTx(w, r)
+	if !ok {
+		return
+	}
+	roleID, ok := roleIDParam(w, r)
+	if !ok {
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+
+	ctx := r.Context()
+	current, ok := lockTenantRole(w, ctx, tx, tenantID, roleID)
+	if !ok {
+		return
+	}
+	var assigned int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM users WHERE custom_role_id = $1::uuid`, roleID).Scan(&assigned); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if assigned > 0 {
+		utils.WriteErrorWithCode(w, http.StatusConflict, "role_in_use", "Role is assigned to users; reassign them first")
+		return
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM tenant_roles WHERE role_id = $1::uuid`, roleID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	recordRoleEvent(ctx, tx, tenantID, actorUserID, "", "role.deleted", "delete_role", map[string]interface{}{
+		"role_id":     roleID,
+		"name":        current.Name,
+		"permissions": current.Permissions,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeRoleHolders revokes sessions and tokens of every user holding
+// roleID.
+func (h *UserHandler) revokeRoleHolders(w http.ResponseWriter, ctx context.Context, tx pgx.Tx, roleID, actorUserID string) bool {
+	rows, err := tx.Query(ctx, `SELECT user_id::text FROM users WHERE custom_role_id = $1::uuid AND status <> 'deleted'`, roleID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return false
+	}
+	var holders []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			holders = append(holders, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return false
+	}
+	for _, userID := range holders {
+		if _, err := revokeSessions(ctx, tx, h.Redis, h.Config, userID, "", actorUserID, "role_updated"); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return false
+		}
+		h.revokeTokens(userID)
+	}
+	return true
+}
+
+// lockTenantRole loads a custom role FOR UPDATE, writing 404 when it is not
+// in the tenant.
+func lockTenantRole(w http.ResponseWriter, ctx context.Context, tx pgx.Tx, tenantID, roleID string) (TenantRole, bool) {
+	var tr TenantRole
+	err := scanTenantRole(tx.QueryRow(ctx, `
+		SELECT `+tenantRoleColumns+` FROM tenant_roles r WHERE r.role_id = $1::uuid AND r.tenant_id = $2::uuid FOR UPDATE OF r
+	`, roleID, tenantID), &tr)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, "Role not found")
+		return tr, false
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return tr, false
+	}
+	return tr, true
+}
+
+func decodeTenantRoleRequest(w http.ResponseWriter, r *http.Request) (TenantRoleRequest, bool) {
+	var req TenantRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return req, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return req, false
+	}
+	if isBuiltinRole(req.Name) {
+		utils.WriteError(w, http.StatusBadRequest, "Role name is reserved")
+		return req, false
+	}
+	req.Permissions = dedupeStrings(req.Permissions)
+	if missing := missingPermission(actorPermissions(r), req.Permissions); missing != "" {
+		utils.WriteError(w, http.StatusForbidden, "Cannot grant a permission you do not hold: "+missing)
+		return req, false
+	}
+	return req, true
+}
+
+// grantablePermissionIDs maps permission names to ids, writing 400 for
+// unknown or platform-only permissions.
+func grantablePermissionIDs(w http.ResponseWriter, ctx context.Context, q dbQuerier, names []string) ([]int32, bool) {
+	rows, err := q.Query(ctx, `SELECT name, permission_id, tenant_grantable FROM permissions WHERE name = ANY($1)`, names)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return nil, false
+	}
+	found := map[string]int32{}
+	notGrantable := ""
+	for rows.Next() {
+		var name string
+		var id int32
+		var grantable bool
+		if err := rows.Scan(&name, &id, &grantable); err != nil {
+			rows.Close()
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return nil, false
+		}
+		if !grantable && notGrantable == "" {
+			notGrantable = name
+		}
+		found[name] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return nil, false
+	}
+	if notGrantable != "" {
+		utils.WriteErrorWithCode(w, http.StatusBadRequest, "permission_not_grantable", "Permission cannot be granted to tenant roles: "+notGrantable)
+		return nil, false
+	}
+	ids := make([]int32, 0, len(names))
+	for _, name := range names {
+		id, ok := found[name]
+		if !ok {
+			utils.WriteError(w, http.StatusBadRequest, "Unknown permission: "+name)
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+// recordRoleEvent writes the role audit row in the request transaction
+// (roleID is empty once the role is deleted).
+func recordRoleEvent(ctx context.Context, tx pgx.Tx, tenantID, actorUserID, roleID, eventType, action string, metadata map[string]interface{}) {
+	_, _ = tx.Exec(ctx, `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, resource_type, resource_id, metadata, timestamp)
+		VALUES ($1::uuid, NULLIF($2,'')::uuid, $3, 'auth', 'info', 'user', NULLIF($2,'')::uuid, $4, 'success', 'role', NULLIF($5,'')::uuid, $6::jsonb, NOW())
+	`, tenantID, actorUserID, eventType, action, roleID, toJSONB(metadata))
+}
+
+func roleIDParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	roleID := r.PathValue("role_id")
+	if _, err := uuid.Parse(roleID); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid role_id")
+		return "", false
+	}
+	return roleID, true
+}
+
+// requireTenantAdmin limits an action to the built-in tenant_admin (or a
+// super admin). Changes that hand out admin power (roles, admin invites,
+// SSO and auth policy) must not be reachable through a custom role, even
+// one holding the route's permission.
+func requireTenantAdmin(w http.ResponseWriter, r *http.Request) bool {
+	switch role, _ := r.Context().Value("role").(string); role {
+	case "tenant_admin", "super_admin":
+		return true
+	}
+	utils.WriteErrorWithCode(w, http.StatusForbidden, "tenant_admin_required", "Only a tenant admin can do this")
+	return false
+}
+
+func actorPermissions(r *http.Request) []string {
+	perms, _ := r.Context().Value("permissions").([]string)
+	return perms
+}
+
+// missingPermission returns the first of wanted not held by the caller
+// (system:admin holds everything), or "".
+func missingPermission(held, wanted []string) string {
+	have := make(map[string]bool, len(held))
+	for _, p := range held {
+		if p == "system:admin" {
+			return ""
+		}
+		have[p] = true
+	}
+	for _, p := range wanted {
+		if !have[p] {
+			return p
+		}
+	}
+	return ""
+}
+
+func isBuiltinRole(name string) bool {
+	switch strings.ToLower(name) {
+	case "super_admin", "tenant_admin", "tenant_user":
+		return true
+	}
+	return false
+}
+
+func dedupeStrings(in []string) []string {
+	seen := make(map[string]bool, len(in))
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		s = strings.TrimSpace(s)
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		out = append(out, s)
+	}
+	return out
+}
+
+func equalStringSets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x := append([]string(nil), a...)
+	y := append([]string(nil), b...)
+	sort.Strings(x)
+	sort.Strings(y)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMissingPermission(t *testing.T) {
+	t.Parallel()
+
+	held := []string{"devices:read", "devices:write", "users:write"}
+	if got := missingPermission(held, []string{"devices:read", "users:write"}); got != "" {
+		t.Fatalf("missingPermission = %q, want none", got)
+	}
+	if got := missingPermission(held, []string{"devices:read", "audit:read"}); got != "audit:read" {
+		t.Fatalf("missingPermission = %q, want audit:read", got)
+	}
+	if got := missingPermission([]string{"system:admin"}, []string{"audit:read"}); got != "" {
+		t.Fatalf("system:admin holds everything, got %q", got)
+	}
+	if got := missingPermission(nil, []string{"devices:read"}); got != "devices:read" {
+		t.Fatalf("no permissions: got %q", got)
+	}
+}
+
+func TestDecodeTenantRoleRequest(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"reserved name", `{"name":"Tenant_Admin","permissions":["devices:read"]}`, http.StatusBadRequest},
+		{"no permissions", `{"name":"installer","permissions":[]}`, http.StatusBadRequest},
+		{"permission not held", `{"name":"auditor","permissions":["audit:read"]}`, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/roles", strings.NewReader(c.body))
+		req = req.WithContext(context.WithValue(req.Context(), "permissions", []string{"devices:read", "users:write"}))
+		w := httptest.NewRecorder()
+		if _, ok := decodeTenantRoleRequest(w, req); ok {
+			t.Fatalf("%s: expected rejection", c.name)
+		}
+		if w.Code != c.status {
+			t.Fatalf("%s: status = %d, want %d", c.name, w.Code, c.status)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/roles", strings.NewReader(`{"name":" installer ","permissions":["devices:read","devices:read"," users:write"]}`))
+	req = req.WithContext(context.WithValue(req.Context(), "permissions", []string{"devices:read", "users:write"}))
+	got, ok := decodeTenantRoleRequest(httptest.NewRecorder(), req)
+	if !ok {
+		t.Fatal("expected valid request")
+	}
+	if got.Name != "installer" || len(got.Permissions) != 2 {
+		t.Fatalf("got %+v, want trimmed name and deduplicated permissions", got)
+	}
+}
+
+func TestEqualStringSets(t *testing.T) {
+	t.Parallel()
+
+	if !equalStringSets([]string{"a", "b"}, []string{"b", "a"}) {
+		t.Fatal("order must not matter")
+	}
+	if equalStringSets([]string{"a", "b"}, []string{"a", "c"}) || equalStringSets([]string{"a"}, []string{"a", "b"}) {
+		t.Fatal("different sets reported equal")
+	}
+}
+
+func TestCreateRoleRequiresTenant(t *testing.T) {
+	t.Parallel()
+
+	h := &UserHandler{}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/roles", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	h.CreateRole(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+// TestAdminPowerNeedsBuiltInTenantAdmin covers the paths a custom role
+// holding users:write could otherwise use to escalate to tenant admin.
+func TestAdminPowerNeedsBuiltInTenantAdmin(t *testing.T) {
+	t.Parallel()
+
+	perms := []string{"users:read", "users:write"}
+	target := "5d1c9e4a-8b7f-4e2d-a1c3-9f8e7d6c5b4a"
+	cases := []struct {
+		name   string
+		handle func(http.ResponseWriter, *http.Request)
+		method string
+		body   string
+	}{
+		{"promote user", (&UserHandler{}).UpdateUser, http.MethodPatch, `{"role":"tenant_admin"}`},
+		{"assign custom role", (&UserHandler{}).UpdateUser, http.MethodPatch, `{"custom_role_id":"9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"}`},
+		{"invite tenant admin", (&InvitationHandler{}).CreateInvitation, http.MethodPost, `{"email":"eve@empresa.com","role":"tenant_admin"}`},
+		{"put oidc config", (&TenantAdminHandler{}).PutOwnOIDCConfig, http.MethodPut, `{"issuer":"https://idp.example.com","client_id":"c","allowed_domains":["empresa.com"]}`},
+		{"delete oidc config", (&TenantAdminHandler{}).DeleteOwnOIDCConfig, http.MethodDelete, ``},
+		{"put auth policy", (&TenantAdminHandler{}).PutOwnAuthPolicy, http.MethodPut, `{"mfa_required":false}`},
+	}
+	for _, c := range cases {
+		req := privacyRequest(c.method, "/api/v1/tenant", c.body, "0b7d2a9c-3f4e-4d5a-9b6c-7d8e9f0a1b2c", perms)
+		req.SetPathValue("user_id", target)
+		w := httptest.NewRecorder()
+		c.handle(w, req)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "tenant_admin_required") {
+			t.Fatalf("%s: status = %d body = %s, want 403 tenant_admin_required", c.name, w.Code, w.Body.String())
+		}
+	}
+}
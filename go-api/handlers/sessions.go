@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"iiot-go-api/config"
+	"iiot-go-api/models"
+	"iiot-go-api/utils"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+const maxSessionUserAgentLen = 512
+
+// Session is a server-side login session; it owns one refresh token chain.
+type Session struct {
+	SessionID  string    `json:"session_id"`
+	UserAgent  *string   `json:"user_agent"`
+	IPAddress  *string   `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// LogoutRequest optionally carries the refresh token, needed only for tokens
+// issued before sessions existed (no sid claim).
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// dbQuerier is satisfied by *pgxpool.Pool and pgx.Tx.
+type dbQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// dbQueryRower is satisfied by *pgxpool.Pool and pgx.Tx.
+type dbQueryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// dbExecer is satisfied by *pgxpool.Pool and pgx.Tx.
+type dbExecer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// Logout ends the session of the calling access token.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("jwt_claims").(*models.JWTClaims)
+	if !ok || claims == nil {
+		utils.WriteError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	var req LogoutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	ctx := r.Context()
+	if claims.SessionID != "" {
+		if _, err := revokeSessions(ctx, h.DB, h.Redis, h.Config, claims.UserID, claims.SessionID, claims.UserID, "logout"); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+	}
+	if h.Redis != nil {
+		h.blacklistUntilExpiry(claims)
+		if req.RefreshToken != "" {
+			if refresh, err := utils.ValidateJWT(h.Config.JWTSecret, req.RefreshToken); err == nil && refresh.TokenType == "refresh" && refresh.UserID == claims.UserID {
+				h.blacklistUntilExpiry(refresh)
+			}
+		}
+	}
+
+	recordAuthEvent(h.DB, claims.TenantID, claims.UserID, "auth.logout", "logout", "success", map[string]interface{}{
+		"session_id": claims.SessionID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll ends every session of the caller, including tokens issued
+// before sessions existed.
+func (h *AuthHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("jwt_claims").(*models.JWTClaims)
+	if !ok || claims == nil {
+		utils.WriteError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	revoked, err := revokeSessions(r.Context(), h.DB, h.Redis, h.Config, claims.UserID, "", claims.UserID, "logout_all")
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if h.Redis != nil {
+		if err := utils.RevokeUserTokens(h.Redis, claims.UserID, h.Config.JWTRefreshExpiration); err != nil {
+			slog.Warn("logout_all_revocation_failed", slog.String("user_id", claims.UserID), slog.Any("error", err))
+		}
+	}
+
+	recordAuthEvent(h.DB, claims.TenantID, claims.UserID, "auth.logout_all", "logout_all", "success", map[string]interface{}{
+		"sessions_revoked": revoked,
+	})
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"sessions_revoked": revoked})
+}
+
+// ListSessions lists the caller's active sessions; the one of the calling
+// token is flagged as current.
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("jwt_claims").(*models.JWTClaims)
+	if !ok || claims == nil {
+		utils.WriteError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	sessions, err := listActiveSessions(r.Context(), h.DB, claims.UserID, claims.SessionID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"sessions": sessions})
+}
+
+// RevokeSession ends one of the caller's own sessions (e.g. a lost device).
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("jwt_claims").(*models.JWTClaims)
+	if !ok || claims == nil {
+		utils.WriteError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	sessionID := r.PathValue("session_id")
+	if _, err := uuid.Parse(sessionID); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid session_id")
+		return
+	}
+
+	revoked, err := revokeSessions(r.Context(), h.DB, h.Redis, h.Config, claims.UserID, sessionID, claims.UserID, "logout")
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if revoked == 0 {
+		utils.WriteError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	recordAuthEvent(h.DB, claims.TenantID, claims.UserID, "auth.session_revoked", "revoke_session", "success", map[string]interface{}{
+		"session_id": sessionID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListUserSessions lists the active sessions of a user of the JWT tenant.
+func (h *UserHandler) ListUserSessions(w http.ResponseWriter, r *http.Request) {
+	tenantID, tx, ok := tenantTx(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	var exists bool
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM users WHERE user_id = $1::uuid AND tenant_id = $2::uuid)
+	`, userID, tenantID).Scan(&exists); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if !exists {
+		utils.WriteError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	sessions, err := listActiveSessions(ctx, tx, userID, "")
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"sessions": sessions})
+}
+
+// RevokeUserSessions ends all sessions of a user of the JWT tenant, or only
+// {session_id} when present in the route.
+func (h *UserHandler) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	tenantID, tx, ok := tenantTx(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	sessionID := r.PathValue("session_id")
+	if sessionID != "" {
+		if _, err := uuid.Parse(sessionID); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "Invalid session_id")
+			return
+		}
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+
+	ctx := r.Context()
+	target, ok := h.lockTenantUser(w, ctx, tx, tenantID, userID)
+	if !ok {
+		return
+	}
+	revoked, err := revokeSessions(ctx, tx, h.Redis, h.Config, userID, sessionID, actorUserID, "admin_revoked")
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if sessionID != "" && revoked == 0 {
+		utils.WriteError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if sessionID == "" {
+		// Also cut tokens issued before sessions existed.
+		h.revokeTokens(userID)
+	}
+
+	recordUserEvent(ctx, tx, tenantID, actorUserID, userID, "user.sessions_revoked", "revoke_sessions", map[string]interface{}{
+		"email":            target.Email,
+		"session_id":       sessionID,
+		"sessions_revoked": revoked,
+	})
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"sessions_revoked": revoked})
+}
+
+// issueTokens returns an access/refresh pair bound to sessionID, opening a
+// new session when sessionID is empty (login, register, accepted invite).
+func (h *AuthHandler) issueTokens(ctx context.Context, r *http.Request, sessionID, userID, tenantID, email, role string, permissions []string) (accessToken, refreshToken string, err error) {
+	if sessionID == "" {
+		sessionID, err = createSession(ctx, h.DB, r, userID, tenantID, h.Config.JWTRefreshExpiration)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	accessToken, err = utils.GenerateSessionJWT(h.Config.JWTSecret, "access", sessionID, userID, tenantID, email, role, permissions, h.Config.JWTAccessExpiration)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err = utils.GenerateSessionJWT(h.Config.JWTSecret, "refresh", sessionID, userID, tenantID, email, role, permissions, h.Config.JWTRefreshExpiration)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+func (h *AuthHandler) blacklistUntilExpiry(claims *models.JWTClaims) {
+	ttl := time.Until(time.Unix(claims.ExpiresAt, 0))
+	if ttl <= 0 {
+		return
+	}
+	if err := utils.BlacklistToken(h.Redis, claims.JTI, ttl); err != nil {
+		slog.Warn("logout_blacklist_failed", slog.String("user_id", claims.UserID), slog.Any("error", err))
+	}
+}
+
+func createSession(ctx context.Context, db *pgxpool.Pool, r *http.Request, userID, tenantID string, ttl time.Duration) (string, error) {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxSessionUserAgentLen {
+		userAgent = userAgent[:maxSessionUserAgentLen]
+	}
+	var sessionID string
+	err := db.QueryRow(ctx, `
+		INSERT INTO user_sessions (user_id, tenant_id, user_agent, ip_address, expires_at)
+		VALUES ($1::uuid, NULLIF($2,'')::uuid, NULLIF($3,''), NULLIF($4,''), NOW() + make_interval(secs => $5))
+		RETURNING session_id::text
+	`, userID, tenantID, userAgent, utils.ClientIP(r), ttl.Seconds()).Scan(&sessionID)
+	return sessionID, err
+}
+
+// extendSession slides the expiry of an active session on refresh; false
+// when it was revoked, has expired or belongs to another user.
+func extendSession(ctx context.Context, db *pgxpool.Pool, sessionID, userID string, ttl time.Duration) (bool, error) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return false, nil
+	}
+	tag, err := db.Exec(ctx, `
+		UPDATE user_sessions SET last_used_at = NOW(), expires_at = NOW() + make_interval(secs => $3)
+		WHERE session_id = $1::uuid AND user_id = $2::uuid AND revoked_at IS NULL AND expires_at > NOW()
+	`, sessionID, userID, ttl.Seconds())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// revokeSessions ends the user's active sessions (only sessionID when set)
+// and marks them in Redis so their access tokens stop working at once; the
+// marker only has to outlive access tokens since refresh checks the table.
+func revokeSessions(ctx context.Context, q dbQuerier, rdb *redis.Client, cfg *config.Config, userID, sessionID, revokedBy, reason string) (int, error) {
+	rows, err := q.Query(ctx, `
+		UPDATE user_sessions
+		SET revoked_at = NOW(), revoked_by = NULLIF($3,'')::uuid, revoked_reason = $4
+		WHERE user_id = $1::uuid AND revoked_at IS NULL AND ($2 = '' OR session_id = NULLIF($2,'')::uuid)
+		RETURNING session_id::text
+	`, userID, sessionID, revokedBy, reason)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if rdb != nil {
+		for _, id := range ids {
+			if err := utils.RevokeSession(rdb, id, cfg.JWTAccessExpiration); err != nil {
+				slog.Warn("session_revocation_failed", slog.String("session_id", id), slog.Any("error", err))
+			}
+		}
+	}
+	return len(ids), nil
+}
+
+func listActiveSessions(ctx context.Context, q dbQuerier, userID, currentSessionID string) ([]Session, error) {
+	rows, err := q.Query(ctx, `
+		SELECT session_id::text, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM user_sessions
+		WHERE user_id = $1::uuid AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.SessionID, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		s.Current = currentSessionID != "" && s.SessionID == currentSessionID
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
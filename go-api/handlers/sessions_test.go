@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"iiot-go-api/models"
+)
+
+func TestLogoutRequiresClaims(t *testing.T) {
+	t.Parallel()
+
+	h := testAuthHandler()
+	for name, handler := range map[string]http.HandlerFunc{
+		"logout":     h.Logout,
+		"logout-all": h.LogoutAll,
+		"sessions":   h.ListSessions,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/"+name, nil)
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: status = %d, want %d", name, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestRevokeSessionRejectsInvalidID(t *testing.T) {
+	t.Parallel()
+
+	h := testAuthHandler()
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/auth/sessions/nope", nil)
+	req.SetPathValue("session_id", "nope")
+	req = req.WithContext(context.WithValue(req.Context(), "jwt_claims", &models.JWTClaims{UserID: "50f4ab20-198a-4d31-822b-36677d22a018"}))
+	w := httptest.NewRecorder()
+
+	h.RevokeSession(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRevokeUserSessionsRequiresTenant(t *testing.T) {
+	t.Parallel()
+
+	h := &UserHandler{}
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/x/sessions", nil)
+	w := httptest.NewRecorder()
+
+	h.RevokeUserSessions(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestExtendSessionRejectsMalformedID(t *testing.T) {
+	t.Parallel()
+
+	// A malformed sid never reaches the database.
+	active, err := extendSession(context.Background(), nil, "not-a-uuid", "u1", time.Hour)
+	if err != nil || active {
+		t.Fatalf("extendSession = %v, %v; want false, nil", active, err)
+	}
+}
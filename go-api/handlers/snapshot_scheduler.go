@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"iiot-go-api/metrics"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// usageSnapshotLockKey is the pg advisory lock held by the replica running
+// the scheduler (1001 serializes first-user bootstrap in auth.go).
+const usageSnapshotLockKey = 1002
+
+const usageSnapshotAttempts = 3
+
+// usageSnapshotRetryDelay is the base backoff between attempts (doubled each retry).
+var usageSnapshotRetryDelay = 2 * time.Second
+
+// RunUsageSnapshotScheduler snapshots every active tenant each interval and
+// closes (and invoices) the previous period once the billing boundary has
+// passed. Only the
+// replica holding the advisory lock does work; the others skip the tick.
+func RunUsageSnapshotScheduler(ctx context.Context, db, ts *pgxpool.Pool, interval time.Duration) {
+	if db == nil || ts == nil || interval <= 0 {
+		return
+	}
+	run := func() {
+		if err := runUsageSnapshots(ctx, db, ts, time.Now().UTC()); err != nil && ctx.Err() == nil {
+			slog.Warn("usage_snapshot_run_error", slog.Any("error", err))
+		}
+	}
+
+	run()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run()
+		}
+	}
+}
+
+// runUsageSnapshots performs one scheduler pass under the advisory lock.
+func runUsageSnapshots(ctx context.Context, db, ts *pgxpool.Pool, now time.Time) error {
+	locked, err := withAdvisoryLock(ctx, db, usageSnapshotLockKey, func() error {
+		return snapshotActiveTenants(ctx, db, ts, now)
+	})
+	if err == nil && !locked {
+		metrics.UsageSnapshotRun("skipped")
+	}
+	return err
+}
+
+// withAdvisoryLock runs fn only if the session advisory lock key is free,
+// so background jobs execute on a single replica. It reports whether the
+// lock was acquired.
+func withAdvisoryLock(ctx context.Context, db *pgxpool.Pool, key int64, fn func() error) (bool, error) {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, nil
+	}
+	defer func() {
+		// Session lock: release on the same connection, even if ctx was cancelled.
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, _ = conn.Exec(unlockCtx, "SELECT pg_advisory_unlock($1)", key)
+	}()
+
+	return true, fn()
+}
+
+// snapshotActiveTenants closes and invoices the previous period and
+// refreshes the current one for every active tenant.
+func snapshotActiveTenants(ctx context.Context, db, ts *pgxpool.Pool, now time.Time) error {
+	start, end := currentMonthRange(now)
+	prevStart := start.AddDate(0, -1, 0)
+
+	rows, err := db.Query(ctx, `SELECT tenant_id::text, created_at FROM tenants WHERE status = 'active' ORDER BY tenant_id`)
+	if err != nil {
+		return err
+	}
+	var tenantIDs []string
+	existedBefore := map[string]bool{}
+	for rows.Next() {
+		var id string
+		var createdAt time.Time
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		tenantIDs = append(tenantIDs, id)
+		existedBefore[id] = createdAt.Before(start)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, tenantID := range tenantIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Tenants created this period have nothing to close.
+		if existedBefore[tenantID] {
+			if err := closeAndInvoicePeriod(ctx, tenantID, func() error {
+				return closeUsagePeriod(ctx, db, ts, tenantID, prevStart, start)
+			}, func() error {
+				return invoiceClosedPeriod(ctx, db, ts, tenantID, prevStart, start)
+			}); err != nil {
+				failed++
+			}
+		}
+		if err := withSnapshotRetry(ctx, func() error {
+			_, err := createUsageSnapshotForPeriod(ctx, db, ts, tenantID, start, end)
+			return err
+		}); err != nil {
+			failed++
+			slog.Warn("usage_snapshot_failed", slog.String("tenant_id", tenantID), slog.Any("error", err))
+		}
+	}
+
+	result := "success"
+	if failed > 0 {
+		result = "partial"
+	}
+	metrics.UsageSnapshotRun(result)
+	slog.Info("usage_snapshot_run",
+		slog.Int("tenants", len(tenantIDs)),
+		slog.Int("failed", failed),
+		slog.Time("period_start", start),
+	)
+	return nil
+}
+
+// closeUsagePeriod writes the final snapshot of a finished period exactly
+// once. Re-runs are no-ops thanks to closed_at; the upsert itself relies on
+// the (tenant_id, period_start, period_end) conflict target.
+func closeUsagePeriod(ctx context.Context, db, ts *pgxpool.Pool, tenantID string, start, end time.Time) error {
+	var closed bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM tenant_usage_snapshots
+			WHERE tenant_id = $1::uuid AND period_start = $2 AND period_end = $3 AND closed_at IS NOT NULL
+		)
+	`, tenantID, start, end).Scan(&closed)
+	if err != nil || closed {
+		return err
+	}
+
+	snapshotID, err := createUsageSnapshotForPeriod(ctx, db, ts, tenantID, start, end)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, `
+		UPDATE tenant_usage_snapshots SET closed_at = NOW()
+		WHERE snapshot_id = $1 AND closed_at IS NULL
+	`, snapshotID)
+	return err
+}
+
+// closeAndInvoicePeriod closes a finished period, then drafts its invoice.
+// A period that failed to close is not invoiced; the next tick retries both.
+func closeAndInvoicePeriod(ctx context.Context, tenantID string, closePeriod, invoice func() error) error {
+	if err := withSnapshotRetry(ctx, closePeriod); err != nil {
+		slog.Warn("usage_snapshot_close_failed", slog.String("tenant_id", tenantID), slog.Any("error", err))
+		return err
+	}
+	if err := withSnapshotRetry(ctx, func() error {
+		if err := invoice(); !errors.Is(err, errInvoiceExists) {
+			return err
+		}
+		return nil
+	}); err != nil {
+		slog.Warn("usage_invoice_failed", slog.String("tenant_id", tenantID), slog.Any("error", err))
+		return err
+	}
+	return nil
+}
+
+// invoiceClosedPeriod drafts the monthly invoice of a closed period once.
+// Any invoice for the period, even a void one, means it was already handled:
+// regenerations after a void stay manual.
+func invoiceClosedPeriod(ctx context.Context, db, ts *pgxpool.Pool, tenantID string, start, end time.Time) error {
+	var invoiced bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM invoices
+			WHERE tenant_id = $1::uuid AND period_start = $2 AND period_end = $3
+		)
+	`, tenantID, start, end).Scan(&invoiced)
+	if err != nil || invoiced {
+		return err
+	}
+	_, err = generateInvoice(ctx, db, ts, tenantID, start, end, "")
+	return err
+}
+
+func withSnapshotRetry(ctx context.Context, fn func() error) error {
+	delay := usageSnapshotRetryDelay
+	var err error
+	for attempt := 1; attempt <= usageSnapshotAttempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == usageSnapshotAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithSnapshotRetry(t *testing.T) {
+	usageSnapshotRetryDelay = time.Millisecond
+
+	calls := 0
+	err := withSnapshotRetry(context.Background(), func() error {
+		calls++
+		if calls < 2 {
+			return errors.New("timescale unavailable")
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("withSnapshotRetry = (%v, calls=%d), want (nil, 2)", err, calls)
+	}
+
+	calls = 0
+	err = withSnapshotRetry(context.Background(), func() error {
+		calls++
+		return errors.New("boom")
+	})
+	if err == nil || calls != usageSnapshotAttempts {
+		t.Fatalf("withSnapshotRetry = (%v, calls=%d), want error after %d attempts", err, calls, usageSnapshotAttempts)
+	}
+}
+
+func TestWithSnapshotRetryStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := withSnapshotRetry(ctx, func() error {
+		calls++
+		return errors.New("boom")
+	})
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Fatalf("withSnapshotRetry = (%v, calls=%d), want context.Canceled after 1 call", err, calls)
+	}
+}
+
+func TestCloseAndInvoicePeriod(t *testing.T) {
+	usageSnapshotRetryDelay = time.Millisecond
+	ctx := context.Background()
+
+	var steps []string
+	closePeriod := func() error { steps = append(steps, "close"); return nil }
+	invoice := func() error { steps = append(steps, "invoice"); return nil }
+	if err := closeAndInvoicePeriod(ctx, "t1", closePeriod, invoice); err != nil || len(steps) != 2 || steps[0] != "close" || steps[1] != "invoice" {
+		t.Fatalf("closeAndInvoicePeriod = (%v, %v), want close then invoice", err, steps)
+	}
+
+	// Re-runs after the invoice exists are no-ops, not failures.
+	steps = nil
+	if err := closeAndInvoicePeriod(ctx, "t1", closePeriod, func() error { steps = append(steps, "invoice"); return errInvoiceExists }); err != nil || len(steps) != 2 {
+		t.Fatalf("existing invoice: (%v, %v), want nil after one attempt", err, steps)
+	}
+
+	// A period that failed to close is never invoiced.
+	steps = nil
+	err := closeAndInvoicePeriod(ctx, "t1", func() error { steps = append(steps, "close"); return errors.New("timescale unavailable") }, invoice)
+	if err == nil || len(steps) != usageSnapshotAttempts {
+		t.Fatalf("failed close: (%v, %v), want error without invoice", err, steps)
+	}
+
+	steps = nil
+	err = closeAndInvoicePeriod(ctx, "t1", closePeriod, func() error { steps = append(steps, "invoice"); return errPricingNotFound })
+	if !errors.Is(err, errPricingNotFound) || len(steps) != 1+usageSnapshotAttempts {
+		t.Fatalf("failed invoice: (%v, %v), want errPricingNotFound after retries", err, steps)
+	}
+}
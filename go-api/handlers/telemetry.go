@@ -27,9 +27,10 @@ type TelemetryHandler struct {
 	Redis     *redis.Client
 	Config    *config.Config
 	Limiter   *RateLimiter
+	Cache     *IngestCache
 }
 
-func NewTelemetryHandler(pg, ts *pgxpool.Pool, rdb *redis.Client, cfg *config.Config) *TelemetryHandler {
+func NewTelemetryHandler(pg, ts *pgxpool.Pool, rdb *redis.Client, cache *IngestCache, cfg *config.Config) *TelemetryHandler {
 	var limiter *RateLimiter
 	if rdb != nil {
 		limiter = NewRateLimiter(rdb, cfg)
@@ -41,6 +42,7 @@ func NewTelemetryHandler(pg, ts *pgxpool.Pool, rdb *redis.Client, cfg *config.Co
 		Redis:     rdb,
 		Config:    cfg,
 		Limiter:   limiter,
+		Cache:     cache,
 	}
 }
 
@@ -91,20 +93,15 @@ func (h *TelemetryHandler) Webhook(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Find device + tenant
-	var deviceID string
-	var tenantID string
-	err = h.Postgres.QueryRow(context.Background(), `
-		SELECT device_id, tenant_id
-		FROM devices
-		WHERE device_id = $1::uuid AND status IN ('active', 'claimed')
-	`, deviceToken).Scan(&deviceID, &tenantID)
-
-	if err != nil {
+	// Find device + tenant (cached, invalidated on reset/claim/provision)
+	device, err := h.Cache.Device(context.Background(), h.Postgres, deviceToken)
+	if err != nil || (device.Status != "active" && device.Status != "claimed") {
 		metrics.TelemetryRejected("device_not_found")
 		utils.WriteError(w, http.StatusNotFound, "Device not found or inactive")
 		return
 	}
+	deviceID := device.DeviceID
+	tenantID := device.TenantID
 	if tenantID == "" {
 		metrics.TelemetryRejected("tenant_missing")
 		utils.WriteError(w, http.StatusNotFound, "Device missing tenant")
@@ -116,7 +113,7 @@ func (h *TelemetryHandler) Webhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	allowed, _, err := enforceTelemetryQuota(context.Background(), h.Postgres, h.Timescale, h.Redis, h.Config, tenantID, deviceID)
+	allowed, _, err := enforceTelemetryQuota(context.WithoutCancel(r.Context()), h.Postgres, h.Timescale, h.Redis, h.Cache, h.Config, tenantID, deviceID)
 	if err != nil {
 		metrics.TelemetryRejected("quota_check_error")
 		utils.WriteError(w, http.StatusInternalServerError, "Internal server error")
@@ -182,6 +179,9 @@ func (h *TelemetryHandler) Webhook(w http.ResponseWriter, r *http.Request) {
 
 	metrics.TelemetryIngested(strconv.Itoa(slot))
 
+	// Count towards quota_msgs_per_month (only accepted messages are billed)
+	recordMonthlyMessage(context.Background(), h.Redis, tenantID)
+
 	// Update cache
 	if h.Redis != nil {
 		cacheLatest(context.Background(), h.Redis, deviceID, slot, req.Payload, ts, h.Config.CacheTTLSeconds)
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// telemetryRetentionLockKey keeps the pruning job on a single replica (see
+// usageSnapshotLockKey).
+const telemetryRetentionLockKey = 1006
+
+// telemetryPruneBatch bounds each prune_telemetry_for_tenant call.
+const telemetryPruneBatch = 50000
+
+// telemetryRetentionOverride is an enabled row of the ops-managed
+// tenant_telemetry_retention_policy table (Timescale migration 003).
+type telemetryRetentionOverride struct {
+	RetentionDays       int
+	ArchiveBeforeDelete bool
+}
+
+// RunTelemetryRetention deletes telemetry older than each tenant's plan
+// retention_days, each interval. Only the replica holding the advisory lock
+// does work. A zero interval (the default) disables it.
+func RunTelemetryRetention(ctx context.Context, db, ts *pgxpool.Pool, interval time.Duration) {
+	if db == nil || ts == nil || interval <= 0 {
+		return
+	}
+	run := func() {
+		_, err := withAdvisoryLock(ctx, db, telemetryRetentionLockKey, func() error {
+			return pruneTelemetryByPlan(ctx, db, ts)
+		})
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("telemetry_retention_error", slog.Any("error", err))
+		}
+	}
+
+	run()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run()
+		}
+	}
+}
+
+// telemetryRetentionDays resolves the retention applied by the job. An
+// enabled ops policy takes precedence over the plan; when it archives before
+// deleting, pruning is left to the archive script (skip).
+func telemetryRetentionDays(planDays int, override *telemetryRetentionOverride) (int, bool) {
+	if override != nil {
+		if override.ArchiveBeforeDelete {
+			return 0, false
+		}
+		return override.RetentionDays, override.RetentionDays > 0
+	}
+	return planDays, planDays > 0
+}
+
+func pruneTelemetryByPlan(ctx context.Context, db, ts *pgxpool.Pool) error {
+	type tenantRetention struct {
+		tenantID string
+		days     int
+	}
+	rows, err := db.Query(ctx, `
+		SELECT t.tenant_id::text, p.retention_days
+		FROM tenants t
+		JOIN plans p ON p.plan_id = t.plan_id
+		ORDER BY t.tenant_id
+	`)
+	if err != nil {
+		return err
+	}
+	tenants := []tenantRetention{}
+	for rows.Next() {
+		var tr tenantRetention
+		if err := rows.Scan(&tr.tenantID, &tr.days); err != nil {
+			rows.Close()
+			return err
+		}
+		tenants = append(tenants, tr)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	overrides := map[string]*telemetryRetentionOverride{}
+	rows, err = ts.Query(ctx, `
+		SELECT tenant_id::text, retention_days, archive_before_delete
+		FROM tenant_telemetry_retention_policy
+		WHERE enabled = true
+	`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var tenantID string
+		o := &telemetryRetentionOverride{}
+		if err := rows.Scan(&tenantID, &o.RetentionDays, &o.ArchiveBeforeDelete); err != nil {
+			rows.Close()
+			return err
+		}
+		overrides[tenantID] = o
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var deleted int64
+	for _, tr := range tenants {
+		days, ok := telemetryRetentionDays(tr.days, overrides[tr.tenantID])
+		if !ok {
+			continue
+		}
+		for {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			var n int
+			if err := ts.QueryRow(ctx, `SELECT prune_telemetry_for_tenant($1::uuid, $2, $3)`, tr.tenantID, days, telemetryPruneBatch).Scan(&n); err != nil {
+				slog.Warn("telemetry_retention_tenant_failed", slog.String("tenant_id", tr.tenantID), slog.Any("error", err))
+				break
+			}
+			deleted += int64(n)
+			if n < telemetryPruneBatch {
+				break
+			}
+		}
+	}
+	if deleted > 0 {
+		slog.Info("telemetry_retention_run", slog.Int("tenants", len(tenants)), slog.Int64("deleted_rows", deleted))
+	}
+	return nil
+}
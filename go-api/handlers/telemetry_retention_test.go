@@ -0,0 +1,25 @@
+package handlers
+
+import "testing"
+
+func TestTelemetryRetentionDays(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		planDays int
+		override *telemetryRetentionOverride
+		days     int
+		ok       bool
+	}{
+		{"plan", 90, nil, 90, true},
+		{"ops policy wins", 90, &telemetryRetentionOverride{RetentionDays: 30}, 30, true},
+		{"archived by script", 90, &telemetryRetentionOverride{RetentionDays: 30, ArchiveBeforeDelete: true}, 0, false},
+		{"no retention", 0, nil, 0, false},
+	}
+	for _, c := range cases {
+		if days, ok := telemetryRetentionDays(c.planDays, c.override); days != c.days || ok != c.ok {
+			t.Fatalf("%s: telemetryRetentionDays = (%d, %v), want (%d, %v)", c.name, days, ok, c.days, c.ok)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"iiot-go-api/config"
 	"iiot-go-api/utils"
@@ -12,44 +13,63 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
 )
 
 type TenantAdminHandler struct {
 	DB        *pgxpool.Pool
 	Timescale *pgxpool.Pool
+	Redis     *redis.Client
+	Cache     *IngestCache
 	Config    *config.Config
 }
 
+// TenantQuotaResponse reports effective limits; overrides lists the values
+// that differ from the plan defaults.
 type TenantQuotaResponse struct {
-	TenantID        string `json:"tenant_id"`
-	PlanType        string `json:"plan_type"`
-	BillingCycle    string `json:"billing_cycle"`
-	QuotaDevices    int    `json:"quota_devices"`
-	QuotaMsgsPerMin int    `json:"quota_msgs_per_min"`
-	QuotaStorageMB  int    `json:"quota_storage_mb"`
-	AllowOverage    bool   `json:"allow_overage"`
+	TenantID          string               `json:"tenant_id"`
+	PlanID            string               `json:"plan_id"`
+	PlanType          string               `json:"plan_type"`
+	BillingCycle      string               `json:"billing_cycle"`
+	QuotaDevices      int                  `json:"quota_devices"`
+	QuotaMsgsPerMin   int                  `json:"quota_msgs_per_min"`
+	QuotaStorageMB    int                  `json:"quota_storage_mb"`
+	AllowOverage      bool                 `json:"allow_overage"`
+	QuotaMsgsPerMonth int64                `json:"quota_msgs_per_month"`
+	RetentionDays     int                  `json:"retention_days"`
+	Overrides         TenantQuotaOverrides `json:"overrides"`
 }
 
 type TenantQuotaPatchRequest struct {
-	PlanType        *string `json:"plan_type,omitempty"`
-	BillingCycle    *string `json:"billing_cycle,omitempty"`
-	QuotaDevices    *int    `json:"quota_devices,omitempty"`
-	QuotaMsgsPerMin *int    `json:"quota_msgs_per_min,omitempty"`
-	QuotaStorageMB  *int    `json:"quota_storage_mb,omitempty"`
-	AllowOverage    *bool   `json:"allow_overage,omitempty"`
+	PlanID            *string `json:"plan_id,omitempty"`
+	PlanType          *string `json:"plan_type,omitempty"`
+	BillingCycle      *string `json:"billing_cycle,omitempty"`
+	QuotaDevices      *int    `json:"quota_devices,omitempty"`
+	QuotaMsgsPerMin   *int    `json:"quota_msgs_per_min,omitempty"`
+	QuotaStorageMB    *int    `json:"quota_storage_mb,omitempty"`
+	AllowOverage      *bool   `json:"allow_overage,omitempty"`
+	QuotaMsgsPerMonth *int64  `json:"quota_msgs_per_month,omitempty"`
+	// ClearOverrides resets the listed quota fields to the plan default.
+	ClearOverrides []string `json:"clear_overrides,omitempty"`
 }
 
 type TenantUsageResponse struct {
-	TenantID           string  `json:"tenant_id"`
-	MessagesLast60Min  int64   `json:"messages_last_60min"`
-	DevicesTotal       int64   `json:"devices_total"`
-	StorageMBEstimated float64 `json:"storage_mb_estimated"`
-	PlanType           string  `json:"plan_type"`
-	BillingCycle       string  `json:"billing_cycle"`
+	TenantID           string    `json:"tenant_id"`
+	MessagesLast60Min  int64     `json:"messages_last_60min"`
+	DevicesTotal       int64     `json:"devices_total"`
+	StorageMBEstimated float64   `json:"storage_mb_estimated"`
+	PlanType           string    `json:"plan_type"`
+	BillingCycle       string    `json:"billing_cycle"`
+	MessagesThisPeriod int64     `json:"messages_this_period"`
+	QuotaMsgsPerMonth  int64     `json:"quota_msgs_per_month"`
+	PeriodStart        time.Time `json:"period_start"`
+	PeriodEnd          time.Time `json:"period_end"`
+	// Overage is present only for tenants with allow_overage.
+	Overage *OverageUsage `json:"overage,omitempty"`
 }
 
-func NewTenantAdminHandler(db, ts *pgxpool.Pool, cfg *config.Config) *TenantAdminHandler {
-	return &TenantAdminHandler{DB: db, Timescale: ts, Config: cfg}
+func NewTenantAdminHandler(db, ts *pgxpool.Pool, rdb *redis.Client, cache *IngestCache, cfg *config.Config) *TenantAdminHandler {
+	return &TenantAdminHandler{DB: db, Timescale: ts, Redis: rdb, Cache: cache, Config: cfg}
 }
 
 func (h *TenantAdminHandler) GetTenantQuotas(w http.ResponseWriter, r *http.Request) {
@@ -59,18 +79,26 @@ func (h *TenantAdminHandler) GetTenantQuotas(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var resp TenantQuotaResponse
-	err := h.DB.QueryRow(context.Background(), `
-		SELECT tenant_id::text, plan_type, billing_cycle, quota_devices, quota_msgs_per_min, quota_storage_mb, allow_overage
-		FROM tenants
-		WHERE tenant_id = $1::uuid
-	`, tenantID).Scan(&resp.TenantID, &resp.PlanType, &resp.BillingCycle, &resp.QuotaDevices, &resp.QuotaMsgsPerMin, &resp.QuotaStorageMB, &resp.AllowOverage)
+	plan, overrides, billingCycle, err := fetchTenantPlan(context.Background(), h.DB, tenantID)
 	if err != nil {
 		utils.WriteError(w, http.StatusNotFound, "Tenant not found")
 		return
 	}
+	q := effectiveQuota(tenantID, billingCycle, *plan, overrides)
 
-	utils.WriteJSON(w, http.StatusOK, resp)
+	utils.WriteJSON(w, http.StatusOK, TenantQuotaResponse{
+		TenantID:          q.TenantID,
+		PlanID:            q.PlanID,
+		PlanType:          q.PlanType,
+		BillingCycle:      q.BillingCycle,
+		QuotaDevices:      q.QuotaDevices,
+		QuotaMsgsPerMin:   q.QuotaMsgsPerMin,
+		QuotaStorageMB:    q.QuotaStorageMB,
+		AllowOverage:      q.AllowOverage,
+		QuotaMsgsPerMonth: q.QuotaMsgsPerMonth,
+		RetentionDays:     q.RetentionDays,
+		Overrides:         overrides,
+	})
 }
 
 func (h *TenantAdminHandler) PatchTenantQuotas(w http.ResponseWriter, r *http.Request) {
@@ -86,11 +114,37 @@ func (h *TenantAdminHandler) PatchTenantQuotas(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	if req.PlanType != nil {
-		if *req.PlanType != "starter" && *req.PlanType != "pro" && *req.PlanType != "enterprise" {
-			utils.WriteError(w, http.StatusBadRequest, "Invalid plan_type")
+	ctx := context.Background()
+
+	var planID *string
+	if req.PlanID != nil || req.PlanType != nil {
+		var id, code string
+		if req.PlanID != nil {
+			id = *req.PlanID
+		} else {
+			code = *req.PlanType
+		}
+		resolved, err := resolvePlanID(ctx, h.DB, id, code)
+		if err != nil {
+			switch {
+			case errors.Is(err, errPlanNotFound):
+				utils.WriteError(w, http.StatusBadRequest, "Invalid plan")
+			case errors.Is(err, errPlanInactive):
+				utils.WriteError(w, http.StatusBadRequest, "Plan is not active")
+			default:
+				utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			}
+			return
+		}
+		planID = &resolved
+	}
+	clearFields := map[string]bool{}
+	for _, field := range req.ClearOverrides {
+		if !overridableQuotaFields[field] {
+			utils.WriteError(w, http.StatusBadRequest, "Invalid clear_overrides field: "+field)
 			return
 		}
+		clearFields[field] = true
 	}
 	if req.BillingCycle != nil {
 		if *req.BillingCycle != "monthly" && *req.BillingCycle != "annual" {
@@ -110,34 +164,49 @@ func (h *TenantAdminHandler) PatchTenantQuotas(w http.ResponseWriter, r *http.Re
 		utils.WriteError(w, http.StatusBadRequest, "quota_storage_mb must be >= 0")
 		return
 	}
+	if req.QuotaMsgsPerMonth != nil && *req.QuotaMsgsPerMonth < 0 {
+		utils.WriteError(w, http.StatusBadRequest, "quota_msgs_per_month must be >= 0")
+		return
+	}
 
-	_, err := h.DB.Exec(context.Background(), `
+	// Quota columns hold overrides only; plan_type follows plan_id via trigger.
+	tag, err := h.DB.Exec(ctx, `
 		UPDATE tenants
-		SET plan_type = COALESCE($2, plan_type),
+		SET plan_id = COALESCE($2::uuid, plan_id),
 			billing_cycle = COALESCE($3, billing_cycle),
-			quota_devices = COALESCE($4, quota_devices),
-			quota_msgs_per_min = COALESCE($5, quota_msgs_per_min),
-			quota_storage_mb = COALESCE($6, quota_storage_mb),
-			allow_overage = COALESCE($7, allow_overage),
+			quota_devices = CASE WHEN $9 THEN NULL ELSE COALESCE($4, quota_devices) END,
+			quota_msgs_per_min = CASE WHEN $10 THEN NULL ELSE COALESCE($5, quota_msgs_per_min) END,
+			quota_storage_mb = CASE WHEN $11 THEN NULL ELSE COALESCE($6, quota_storage_mb) END,
+			allow_overage = CASE WHEN $12 THEN NULL ELSE COALESCE($7, allow_overage) END,
+			quota_msgs_per_month = CASE WHEN $13 THEN NULL ELSE COALESCE($8, quota_msgs_per_month) END,
 			updated_at = NOW()
 		WHERE tenant_id = $1::uuid
-	`, tenantID, req.PlanType, req.BillingCycle, req.QuotaDevices, req.QuotaMsgsPerMin, req.QuotaStorageMB, req.AllowOverage)
+	`, tenantID, planID, req.BillingCycle, req.QuotaDevices, req.QuotaMsgsPerMin, req.QuotaStorageMB, req.AllowOverage, req.QuotaMsgsPerMonth,
+		clearFields["quota_devices"], clearFields["quota_msgs_per_min"], clearFields["quota_storage_mb"], clearFields["allow_overage"], clearFields["quota_msgs_per_month"])
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
 		return
 	}
+	if tag.RowsAffected() == 0 {
+		utils.WriteError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+	h.Cache.InvalidateTenant(tenantID)
 
 	actorUserID, _ := r.Context().Value("user_id").(string)
-	_, _ = h.DB.Exec(context.Background(), `
+	_, _ = h.DB.Exec(ctx, `
 		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, metadata)
 		VALUES ($1::uuid, NULLIF($2,'')::uuid, 'quota.updated', 'billing', 'info', 'user', NULLIF($2,'')::uuid, 'update', 'success', $3::jsonb)
 	`, tenantID, actorUserID, toJSONB(map[string]interface{}{
-		"plan_type":          req.PlanType,
-		"billing_cycle":      req.BillingCycle,
-		"quota_devices":      req.QuotaDevices,
-		"quota_msgs_per_min": req.QuotaMsgsPerMin,
-		"quota_storage_mb":   req.QuotaStorageMB,
-		"allow_overage":      req.AllowOverage,
+		"plan_id":              planID,
+		"plan_type":            req.PlanType,
+		"billing_cycle":        req.BillingCycle,
+		"quota_devices":        req.QuotaDevices,
+		"quota_msgs_per_min":   req.QuotaMsgsPerMin,
+		"quota_storage_mb":     req.QuotaStorageMB,
+		"allow_overage":        req.AllowOverage,
+		"quota_msgs_per_month": req.QuotaMsgsPerMonth,
+		"clear_overrides":      req.ClearOverrides,
 	}))
 
 	h.GetTenantQuotas(w, r)
@@ -152,12 +221,12 @@ func (h *TenantAdminHandler) GetTenantUsage(w http.ResponseWriter, r *http.Reque
 
 	ctx := context.Background()
 
-	var planType, billingCycle string
-	err := h.DB.QueryRow(ctx, `SELECT plan_type, billing_cycle FROM tenants WHERE tenant_id = $1::uuid`, tenantID).Scan(&planType, &billingCycle)
+	quota, err := fetchTenantQuota(ctx, h.DB, tenantID)
 	if err != nil {
 		utils.WriteError(w, http.StatusNotFound, "Tenant not found")
 		return
 	}
+	planType, billingCycle, quotaMsgsPerMonth := quota.PlanType, quota.BillingCycle, quota.QuotaMsgsPerMonth
 
 	var devicesTotal int64
 	_ = h.DB.QueryRow(ctx, `SELECT COUNT(*) FROM devices WHERE tenant_id = $1::uuid`, tenantID).Scan(&devicesTotal)
@@ -168,6 +237,9 @@ func (h *TenantAdminHandler) GetTenantUsage(w http.ResponseWriter, r *http.Reque
 	var storageBytes float64
 	_ = h.Timescale.QueryRow(ctx, `SELECT COALESCE(SUM(pg_column_size(value)),0)::float8 FROM telemetry WHERE tenant_id = $1::uuid`, tenantID).Scan(&storageBytes)
 
+	periodStart, periodEnd := currentMonthRange(time.Now().UTC())
+	messagesThisPeriod := periodMessageCount(ctx, h.DB, h.Redis, tenantID)
+
 	resp := TenantUsageResponse{
 		TenantID:           tenantID,
 		MessagesLast60Min:  messagesLast60m,
@@ -175,6 +247,14 @@ func (h *TenantAdminHandler) GetTenantUsage(w http.ResponseWriter, r *http.Reque
 		StorageMBEstimated: math.Round((storageBytes/1024.0/1024.0)*100) / 100,
 		PlanType:           planType,
 		BillingCycle:       billingCycle,
+		MessagesThisPeriod: messagesThisPeriod,
+		QuotaMsgsPerMonth:  quotaMsgsPerMonth,
+		PeriodStart:        periodStart,
+		PeriodEnd:          periodEnd,
+	}
+	if quota.AllowOverage {
+		overage := loadOverageUsage(ctx, h.DB, tenantID, periodStart, periodEnd)
+		resp.Overage = &overage
 	}
 	_ = createUsageSnapshot(ctx, h.DB, h.Timescale, tenantID)
 	utils.WriteJSON(w, http.StatusOK, resp)
@@ -210,7 +290,7 @@ func SendQuotaTelegramAsync(cfg *config.Config, title string, lines ...string) {
 	}()
 }
 
-func recordQuotaExceeded(db *pgxpool.Pool, tenantID, userID, eventType, reason string, metadata map[string]interface{}) {
+func recordQuotaExceeded(ctx context.Context, db *pgxpool.Pool, tenantID, userID, eventType, reason string, metadata map[string]interface{}) {
 	if db == nil || tenantID == "" {
 		return
 	}
@@ -219,11 +299,16 @@ func recordQuotaExceeded(db *pgxpool.Pool, tenantID, userID, eventType, reason s
 	}
 	metadata["reason"] = reason
 	metadata["event_type"] = eventType
+	actorType := "system"
+	apiKeyID := auditAPIKeyID(ctx)
+	if apiKeyID != "" {
+		actorType = "api_key"
+	}
 
 	_, _ = db.Exec(context.Background(), `
-		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, metadata, timestamp)
-		VALUES ($1::uuid, NULLIF($2,'')::uuid, $3, 'billing', 'warning', 'system', NULLIF($2,'')::uuid, 'enforce_quota', 'blocked', $4::jsonb, NOW())
-	`, tenantID, userID, eventType, toJSONB(metadata))
+		INSERT INTO audit_log (tenant_id, user_id, api_key_id, event_type, event_category, severity, actor_type, actor_id, action, result, metadata, timestamp)
+		VALUES ($1::uuid, NULLIF($2,'')::uuid, NULLIF($3,'')::uuid, $4, 'billing', 'warning', $5, COALESCE(NULLIF($2,''), NULLIF($3,''))::uuid, 'enforce_quota', 'blocked', $6::jsonb, NOW())
+	`, tenantID, userID, apiKeyID, eventType, actorType, toJSONB(metadata))
 }
 
 func currentMonthRange(now time.Time) (time.Time, time.Time) {
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"iiot-go-api/utils"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TenantAuthPolicy is the per-tenant authentication policy; nil fields
+// follow the global configuration.
+type TenantAuthPolicy struct {
+	TenantID                       string  `json:"tenant_id"`
+	UnverifiedLoginPolicy          *string `json:"unverified_login_policy"`
+	EffectiveUnverifiedLoginPolicy string  `json:"effective_unverified_login_policy"`
+	MFARequired                    bool    `json:"mfa_required"`
+}
+
+// TenantAuthPolicyRequest replaces the tenant overrides (null = global).
+// mfa_required is left unchanged when omitted.
+type TenantAuthPolicyRequest struct {
+	UnverifiedLoginPolicy *string `json:"unverified_login_policy" validate:"omitempty,oneof=allow restricted deny"`
+	MFARequired           *bool   `json:"mfa_required"`
+}
+
+// TenantMFAPolicyRequest is the tenant admin's own toggle.
+type TenantMFAPolicyRequest struct {
+	MFARequired *bool `json:"mfa_required" validate:"required"`
+}
+
+func (h *TenantAdminHandler) GetTenantAuthPolicy(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.PathValue("tenant_id")
+	if tenantID == "" {
+		utils.WriteError(w, http.StatusBadRequest, "tenant_id is required")
+		return
+	}
+	policy, err := h.loadTenantAuthPolicy(context.Background(), tenantID)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, policy)
+}
+
+func (h *TenantAdminHandler) PutTenantAuthPolicy(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.PathValue("tenant_id")
+	if tenantID == "" {
+		utils.WriteError(w, http.StatusBadRequest, "tenant_id is required")
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+
+	var req TenantAuthPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+
+	ctx := context.Background()
+	tag, err := h.DB.Exec(ctx, `
+		UPDATE tenants
+		SET unverified_login_policy = $2, mfa_required = COALESCE($3, mfa_required), updated_at = NOW()
+		WHERE tenant_id = $1::uuid
+	`, tenantID, req.UnverifiedLoginPolicy, req.MFARequired)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		utils.WriteError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+
+	_, _ = h.DB.Exec(ctx, `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, metadata, timestamp)
+		VALUES ($1::uuid, NULLIF($2,'')::uuid, 'tenant.auth_policy_updated', 'auth', 'info', 'user', NULLIF($2,'')::uuid, 'update', 'success', $3::jsonb, NOW())
+	`, tenantID, actorUserID, toJSONB(map[string]interface{}{
+		"unverified_login_policy": req.UnverifiedLoginPolicy,
+		"mfa_required":            req.MFARequired,
+	}))
+
+	policy, err := h.loadTenantAuthPolicy(ctx, tenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, policy)
+}
+
+// GetOwnAuthPolicy returns the JWT tenant's authentication policy.
+func (h *TenantAdminHandler) GetOwnAuthPolicy(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	policy, err := h.loadTenantAuthPolicy(r.Context(), tenantID)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, policy)
+}
+
+// PutOwnAuthPolicy lets a tenant admin make MFA mandatory for every user of
+// the JWT tenant. Users without TOTP get tokens without permissions until
+// they enrol.
+func (h *TenantAdminHandler) PutOwnAuthPolicy(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	if !requireTenantAdmin(w, r) {
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+
+	var req TenantMFAPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+
+	ctx := r.Context()
+	tag, err := h.DB.Exec(ctx, `
+		UPDATE tenants SET mfa_required = $2, updated_at = NOW() WHERE tenant_id = $1::uuid
+	`, tenantID, *req.MFARequired)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		utils.WriteError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+
+	_, _ = h.DB.Exec(ctx, `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, metadata, timestamp)
+		VALUES ($1::uuid, NULLIF($2,'')::uuid, 'tenant.auth_policy_updated', 'auth', 'info', 'user', NULLIF($2,'')::uuid, 'update', 'success', $3::jsonb, NOW())
+	`, tenantID, actorUserID, toJSONB(map[string]interface{}{
+		"mfa_required": *req.MFARequired,
+	}))
+
+	policy, err := h.loadTenantAuthPolicy(ctx, tenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, policy)
+}
+
+func (h *TenantAdminHandler) loadTenantAuthPolicy(ctx context.Context, tenantID string) (TenantAuthPolicy, error) {
+	policy := TenantAuthPolicy{TenantID: tenantID}
+	if err := h.DB.QueryRow(ctx, `
+		SELECT unverified_login_policy, mfa_required FROM tenants WHERE tenant_id = $1::uuid
+	`, tenantID).Scan(&policy.UnverifiedLoginPolicy, &policy.MFARequired); err != nil {
+		return policy, err
+	}
+	policy.EffectiveUnverifiedLoginPolicy = unverifiedLoginPolicy(ctx, h.DB, h.Config, tenantID)
+	return policy, nil
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iiot-go-api/config"
+	"iiot-go-api/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TenantOIDCConfig is the tenant's SSO provider; the client secret is never
+// returned.
+type TenantOIDCConfig struct {
+	TenantID        string            `json:"tenant_id"`
+	Issuer          string            `json:"issuer"`
+	ClientID        string            `json:"client_id"`
+	HasClientSecret bool              `json:"has_client_secret"`
+	AllowedDomains  []string          `json:"allowed_domains"`
+	RoleClaim       *string           `json:"role_claim"`
+	RoleMapping     map[string]string `json:"role_mapping"`
+	DefaultRole     string            `json:"default_role"`
+	Enabled         bool              `json:"enabled"`
+	LoginURL        string            `json:"login_url"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+}
+
+// TenantOIDCConfigRequest replaces the tenant's SSO provider. client_secret:
+// omitted keeps the stored one, "" clears it (public client, PKCE only).
+type TenantOIDCConfigRequest struct {
+	Issuer         string            `json:"issuer" validate:"required,url"`
+	ClientID       string            `json:"client_id" validate:"required,max=255"`
+	ClientSecret   *string           `json:"client_secret"`
+	AllowedDomains []string          `json:"allowed_domains" validate:"required,min=1,dive,fqdn"`
+	RoleClaim      *string           `json:"role_claim" validate:"omitempty,max=100"`
+	RoleMapping    map[string]string `json:"role_mapping" validate:"omitempty,dive,oneof=tenant_admin tenant_user"`
+	DefaultRole    string            `json:"default_role" validate:"omitempty,oneof=tenant_admin tenant_user"`
+	Enabled        *bool             `json:"enabled"`
+}
+
+// tenantOIDCProvider is the stored configuration with the secret opened.
+type tenantOIDCProvider struct {
+	TenantOIDCConfig
+	ClientSecret string
+}
+
+func (h *TenantAdminHandler) GetOwnOIDCConfig(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	p, err := loadTenantOIDCProvider(r.Context(), h.DB, h.Config, tenantID)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, "OIDC not configured")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, p.TenantOIDCConfig)
+}
+
+// PutOwnOIDCConfig creates or replaces the JWT tenant's OIDC provider. The
+// issuer must answer discovery before it is saved.
+func (h *TenantAdminHandler) PutOwnOIDCConfig(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	if !requireTenantAdmin(w, r) {
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+
+	var req TenantOIDCConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	req.Issuer = strings.TrimRight(strings.TrimSpace(req.Issuer), "/")
+	for i, d := range req.AllowedDomains {
+		req.AllowedDomains[i] = strings.ToLower(strings.TrimSpace(d))
+	}
+	if req.RoleMapping == nil {
+		req.RoleMapping = map[string]string{}
+	}
+	if req.DefaultRole == "" {
+		req.DefaultRole = "tenant_user"
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+
+	ctx := r.Context()
+	if _, err := utils.DiscoverOIDC(ctx, req.Issuer, h.Config.OIDCAllowInsecureIssuers); err != nil {
+		if errors.Is(err, utils.ErrOIDCURLNotAllowed) {
+			utils.WriteErrorWithCode(w, http.StatusBadRequest, "oidc_issuer_not_allowed", "Issuer and its endpoints must be https URLs on public hosts")
+			return
+		}
+		utils.WriteErrorWithCode(w, http.StatusBadRequest, "oidc_discovery_failed", "Issuer discovery failed")
+		return
+	}
+
+	// nil keeps the stored secret (CASE below); "" clears it
+	var sealed *string
+	if req.ClientSecret != nil {
+		v := ""
+		if *req.ClientSecret != "" {
+			s, err := utils.EncryptSecret(oidcSecretKey(h.Config), *req.ClientSecret)
+			if err != nil {
+				utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+				return
+			}
+			v = s
+		}
+		sealed = &v
+	}
+
+	if _, err := h.DB.Exec(ctx, `
+		INSERT INTO tenant_oidc_providers (tenant_id, issuer, client_id, client_secret_encrypted, allowed_domains, role_claim, role_mapping, default_role, enabled)
+		VALUES ($1::uuid, $2, $3, NULLIF($4,''), $5, NULLIF($6,''), $7::jsonb, $8, $9)
+		ON CONFLICT (tenant_id) DO UPDATE SET
+			issuer = EXCLUDED.issuer,
+			client_id = EXCLUDED.client_id,
+			client_secret_encrypted = CASE WHEN $10 THEN EXCLUDED.client_secret_encrypted ELSE tenant_oidc_providers.client_secret_encrypted END,
+			allowed_domains = EXCLUDED.allowed_domains,
+			role_claim = EXCLUDED.role_claim,
+			role_mapping = EXCLUDED.role_mapping,
+			default_role = EXCLUDED.default_role,
+			enabled = EXCLUDED.enabled,
+			updated_at = NOW()
+	`, tenantID, req.Issuer, req.ClientID, stringOrEmpty(sealed), req.AllowedDomains, stringOrEmpty(req.RoleClaim), toJSONB(req.RoleMapping), req.DefaultRole, enabled, sealed != nil); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	_, _ = h.DB.Exec(ctx, `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, resource_type, metadata, timestamp)
+		VALUES ($1::uuid, NULLIF($2,'')::uuid, 'tenant.oidc_updated', 'auth', 'info', 'user', NULLIF($2,'')::uuid, 'update', 'success', 'oidc_provider', $3::jsonb, NOW())
+	`, tenantID, actorUserID, toJSONB(map[string]interface{}{
+		"issuer":          req.Issuer,
+		"client_id":       req.ClientID,
+		"allowed_domains": req.AllowedDomains,
+		"role_claim":      req.RoleClaim,
+		"default_role":    req.DefaultRole,
+		"enabled":         enabled,
+		"secret_changed":  req.ClientSecret != nil,
+	}))
+
+	p, err := loadTenantOIDCProvider(ctx, h.DB, h.Config, tenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, p.TenantOIDCConfig)
+}
+
+// DeleteOwnOIDCConfig removes SSO for the JWT tenant. Users provisioned by
+// SSO stay but cannot log in until it is configured again.
+func (h *TenantAdminHandler) DeleteOwnOIDCConfig(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	if !requireTenantAdmin(w, r) {
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+
+	ctx := r.Context()
+	tag, err := h.DB.Exec(ctx, `DELETE FROM tenant_oidc_providers WHERE tenant_id = $1::uuid`, tenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		utils.WriteError(w, http.StatusNotFound, "OIDC not configured")
+		return
+	}
+
+	_, _ = h.DB.Exec(ctx, `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, resource_type, timestamp)
+		VALUES ($1::uuid, NULLIF($2,'')::uuid, 'tenant.oidc_deleted', 'auth', 'warning', 'user', NULLIF($2,'')::uuid, 'delete', 'success', 'oidc_provider', NOW())
+	`, tenantID, actorUserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadTenantOIDCProvider reads the tenant's provider and opens the client
+// secret. pgx.ErrNoRows when SSO is not configured.
+func loadTenantOIDCProvider(ctx context.Context, db *pgxpool.Pool, cfg *config.Config, tenantID string) (*tenantOIDCProvider, error) {
+	var p tenantOIDCProvider
+	var sealed *string
+	var mapping []byte
+	var slug string
+	err := db.QueryRow(ctx, `
+		SELECT o.tenant_id::text, o.issuer, o.client_id, o.client_secret_encrypted, o.allowed_domains,
+		       o.role_claim, o.role_mapping, o.default_role::text, o.enabled, o.updated_at, t.slug
+		FROM tenant_oidc_providers o
+		JOIN tenants t ON t.tenant_id = o.tenant_id
+		WHERE o.tenant_id = $1::uuid
+	`, tenantID).Scan(&p.TenantID, &p.Issuer, &p.ClientID, &sealed, &p.AllowedDomains,
+		&p.RoleClaim, &mapping, &p.DefaultRole, &p.Enabled, &p.UpdatedAt, &slug)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(mapping, &p.RoleMapping); err != nil {
+		return nil, err
+	}
+	if sealed != nil && *sealed != "" {
+		p.HasClientSecret = true
+		secret, err := utils.DecryptSecret(oidcSecretKey(cfg), *sealed)
+		if err != nil {
+			return nil, err
+		}
+		p.ClientSecret = secret
+	}
+	p.LoginURL = fmt.Sprintf("/api/v1/auth/oidc/%s/login", slug)
+	return &p, nil
+}
+
+func oidcSecretKey(cfg *config.Config) string {
+	if cfg.OIDCEncryptionKey != "" {
+		return cfg.OIDCEncryptionKey
+	}
+	return "oidc:" + cfg.JWTSecret
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"iiot-go-api/utils"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	selfUsageDeviceLimit   = 100
+	selfUsageSnapshotLimit = 12
+	selfUsageEventLimit    = 50
+	selfUsageEventWindow   = 30 * 24 * time.Hour
+)
+
+// QuotaLimitUsage is the consumption of one quota against its effective limit.
+type QuotaLimitUsage struct {
+	Quota       string   `json:"quota"`
+	Scope       string   `json:"scope"`
+	Limit       int64    `json:"limit"`
+	Used        float64  `json:"used"`
+	Unlimited   bool     `json:"unlimited"`
+	Remaining   *float64 `json:"remaining,omitempty"`
+	PercentUsed *float64 `json:"percent_used,omitempty"`
+	Exceeded    bool     `json:"exceeded"`
+}
+
+type DeviceMessageUsage struct {
+	DeviceID      string     `json:"device_id"`
+	DeviceLabel   string     `json:"device_label,omitempty"`
+	Messages      int64      `json:"messages"`
+	LastMessageAt *time.Time `json:"last_message_at,omitempty"`
+}
+
+type UsageSnapshot struct {
+	SnapshotID        int64      `json:"snapshot_id"`
+	PeriodStart       time.Time  `json:"period_start"`
+	PeriodEnd         time.Time  `json:"period_end"`
+	MessagesIngested  int64      `json:"messages_ingested"`
+	StorageMB         float64    `json:"storage_mb"`
+	DevicesTotal      int        `json:"devices_total"`
+	QuotaMsgsPerMonth int64      `json:"quota_msgs_per_month"`
+	ClosedAt          *time.Time `json:"closed_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+type QuotaEvent struct {
+	AuditID   int64                  `json:"audit_id"`
+	EventType string                 `json:"event_type"`
+	DeviceID  string                 `json:"device_id,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+type SelfQuotasResponse struct {
+	TenantQuotaResponse
+	Usage        []QuotaLimitUsage `json:"usage"`
+	RecentEvents []QuotaEvent      `json:"recent_events"`
+}
+
+type SelfUsageResponse struct {
+	TenantID           string               `json:"tenant_id"`
+	PlanType           string               `json:"plan_type"`
+	BillingCycle       string               `json:"billing_cycle"`
+	PeriodStart        time.Time            `json:"period_start"`
+	PeriodEnd          time.Time            `json:"period_end"`
+	MessagesLast60Min  int64                `json:"messages_last_60min"`
+	MessagesThisPeriod int64                `json:"messages_this_period"`
+	DevicesTotal       int64                `json:"devices_total"`
+	StorageMBEstimated float64              `json:"storage_mb_estimated"`
+	Limits             []QuotaLimitUsage    `json:"limits"`
+	Devices            []DeviceMessageUsage `json:"devices"`
+	History            []UsageSnapshot      `json:"history"`
+	RecentEvents       []QuotaEvent         `json:"recent_events"`
+	Overage            *OverageUsage        `json:"overage,omitempty"`
+}
+
+// tenantConsumption is the raw usage read once per self-service request.
+type tenantConsumption struct {
+	DevicesTotal       int64
+	MessagesLast60Min  int64
+	PeakDeviceMsgsMin  int64
+	MessagesThisPeriod int64
+	StorageMB          float64
+}
+
+// GetOwnQuotas returns the JWT tenant's effective limits with current
+// consumption and the recent quota blocks (tenant admin self-service).
+func (h *TenantAdminHandler) GetOwnQuotas(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	ctx := r.Context()
+
+	plan, overrides, billingCycle, err := fetchTenantPlan(ctx, h.DB, tenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+	q := effectiveQuota(tenantID, billingCycle, *plan, overrides)
+	usage := readTenantConsumption(ctx, h.DB, h.Timescale, h.Redis, q)
+
+	utils.WriteJSON(w, http.StatusOK, SelfQuotasResponse{
+		TenantQuotaResponse: TenantQuotaResponse{
+			TenantID:          q.TenantID,
+			PlanID:            q.PlanID,
+			PlanType:          q.PlanType,
+			BillingCycle:      q.BillingCycle,
+			QuotaDevices:      q.QuotaDevices,
+			QuotaMsgsPerMin:   q.QuotaMsgsPerMin,
+			QuotaStorageMB:    q.QuotaStorageMB,
+			AllowOverage:      q.AllowOverage,
+			QuotaMsgsPerMonth: q.QuotaMsgsPerMonth,
+			RetentionDays:     q.RetentionDays,
+			Overrides:         overrides,
+		},
+		Usage:        quotaLimitUsages(q, usage),
+		RecentEvents: recentQuotaEvents(ctx, h.DB, tenantID),
+	})
+}
+
+// GetOwnUsage returns the JWT tenant's consumption, per-device message
+// breakdown for the current period, snapshot history and recent quota blocks.
+func (h *TenantAdminHandler) GetOwnUsage(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return
+	}
+	ctx := r.Context()
+
+	q, err := fetchTenantQuota(ctx, h.DB, tenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+	usage := readTenantConsumption(ctx, h.DB, h.Timescale, h.Redis, *q)
+	periodStart, periodEnd := currentMonthRange(time.Now().UTC())
+
+	var overage *OverageUsage
+	if q.AllowOverage {
+		o := loadOverageUsage(ctx, h.DB, tenantID, periodStart, periodEnd)
+		overage = &o
+	}
+
+	utils.WriteJSON(w, http.StatusOK, SelfUsageResponse{
+		TenantID:           tenantID,
+		PlanType:           q.PlanType,
+		BillingCycle:       q.BillingCycle,
+		PeriodStart:        periodStart,
+		PeriodEnd:          periodEnd,
+		MessagesLast60Min:  usage.MessagesLast60Min,
+		MessagesThisPeriod: usage.MessagesThisPeriod,
+		DevicesTotal:       usage.DevicesTotal,
+		StorageMBEstimated: math.Round(usage.StorageMB*100) / 100,
+		Limits:             quotaLimitUsages(*q, usage),
+		Devices:            deviceMessageUsage(ctx, h.DB, h.Timescale, tenantID, periodStart, periodEnd),
+		History:            usageSnapshotHistory(ctx, h.DB, tenantID),
+		RecentEvents:       recentQuotaEvents(ctx, h.DB, tenantID),
+		Overage:            overage,
+	})
+}
+
+func readTenantConsumption(ctx context.Context, db, ts *pgxpool.Pool, rdb *redis.Client, q TenantQuota) tenantConsumption {
+	var c tenantConsumption
+	_ = db.QueryRow(ctx, `SELECT COUNT(*) FROM devices WHERE tenant_id = $1::uuid`, q.TenantID).Scan(&c.DevicesTotal)
+	_ = ts.QueryRow(ctx, `SELECT COALESCE(COUNT(*),0) FROM telemetry WHERE tenant_id = $1::uuid AND timestamp >= NOW() - interval '60 minutes'`, q.TenantID).Scan(&c.MessagesLast60Min)
+	// quota_msgs_per_min applies per device: report the busiest one.
+	_ = ts.QueryRow(ctx, `
+		SELECT COALESCE(MAX(n),0) FROM (
+			SELECT COUNT(*) AS n FROM telemetry
+			WHERE tenant_id = $1::uuid AND timestamp >= NOW() - interval '60 seconds'
+			GROUP BY device_id
+		) per_device
+	`, q.TenantID).Scan(&c.PeakDeviceMsgsMin)
+
+	var storageBytes float64
+	_ = ts.QueryRow(ctx, `SELECT COALESCE(SUM(pg_column_size(value)),0)::float8 FROM telemetry WHERE tenant_id = $1::uuid`, q.TenantID).Scan(&storageBytes)
+	c.StorageMB = storageBytes / 1024.0 / 1024.0
+
+	c.MessagesThisPeriod = periodMessageCount(ctx, db, rdb, q.TenantID)
+	return c
+}
+
+// periodMessageCount reads the monthly counter from Redis, falling back to
+// the last flushed value in Postgres.
+func periodMessageCount(ctx context.Context, db *pgxpool.Pool, rdb *redis.Client, tenantID string) int64 {
+	var count int64
+	if rdb != nil {
+		count, _ = monthlyMessageCount(ctx, db, rdb, tenantID)
+		return count
+	}
+	periodStart, _ := currentMonthRange(time.Now().UTC())
+	_ = db.QueryRow(ctx, `
+		SELECT messages FROM tenant_message_counters
+		WHERE tenant_id = $1::uuid AND period_start = $2
+	`, tenantID, periodStart).Scan(&count)
+	return count
+}
+
+func quotaLimitUsages(q TenantQuota, c tenantConsumption) []QuotaLimitUsage {
+	return []QuotaLimitUsage{
+		newQuotaLimitUsage("quota_devices", "tenant", int64(q.QuotaDevices), float64(c.DevicesTotal)),
+		newQuotaLimitUsage("quota_msgs_per_min", "device", int64(q.QuotaMsgsPerMin), float64(c.PeakDeviceMsgsMin)),
+		newQuotaLimitUsage("quota_msgs_per_month", "tenant", q.QuotaMsgsPerMonth, float64(c.MessagesThisPeriod)),
+		newQuotaLimitUsage("quota_storage_mb", "tenant", int64(q.QuotaStorageMB), math.Round(c.StorageMB*100)/100),
+	}
+}
+
+// newQuotaLimitUsage builds one usage line; a zero limit means unlimited.
+func newQuotaLimitUsage(quota, scope string, limit int64, used float64) QuotaLimitUsage {
+	u := QuotaLimitUsage{Quota: quota, Scope: scope, Limit: limit, Used: used, Unlimited: limit == 0}
+	if u.Unlimited {
+		return u
+	}
+	remaining := math.Max(float64(limit)-used, 0)
+	percent := math.Round(used/float64(limit)*10000) / 100
+	u.Remaining = &remaining
+	u.PercentUsed = &percent
+	u.Exceeded = used > float64(limit)
+	return u
+}
+
+func deviceMessageUsage(ctx context.Context, db, ts *pgxpool.Pool, tenantID string, start, end time.Time) []DeviceMessageUsage {
+	out := []DeviceMessageUsage{}
+	rows, err := ts.Query(ctx, `
+		SELECT device_id::text, COUNT(*), MAX(timestamp)
+		FROM telemetry
+		WHERE tenant_id = $1::uuid AND timestamp >= $2 AND timestamp < $3
+		GROUP BY device_id
+		ORDER BY COUNT(*) DESC
+		LIMIT $4
+	`, tenantID, start, end, selfUsageDeviceLimit)
+	if err != nil {
+		return out
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var d DeviceMessageUsage
+		var last time.Time
+		if err := rows.Scan(&d.DeviceID, &d.Messages, &last); err != nil {
+			continue
+		}
+		d.LastMessageAt = &last
+		out = append(out, d)
+		ids = append(ids, d.DeviceID)
+	}
+	if len(ids) == 0 {
+		return out
+	}
+
+	// Labels live in Postgres; telemetry only has IDs.
+	labels := map[string]string{}
+	labelRows, err := db.Query(ctx, `
+		SELECT device_id::text, device_label
+		FROM devices
+		WHERE tenant_id = $1::uuid AND device_id::text = ANY($2)
+	`, tenantID, ids)
+	if err == nil {
+		defer labelRows.Close()
+		for labelRows.Next() {
+			var id, label string
+			if err := labelRows.Scan(&id, &label); err == nil {
+				labels[id] = label
+			}
+		}
+	}
+	for i := range out {
+		out[i].DeviceLabel = labels[out[i].DeviceID]
+	}
+	return out
+}
+
+func usageSnapshotHistory(ctx context.Context, db *pgxpool.Pool, tenantID string) []UsageSnapshot {
+	out := []UsageSnapshot{}
+	rows, err := db.Query(ctx, `
+		SELECT snapshot_id, period_start, period_end, messages_ingested, storage_mb::float8, devices_total,
+			quota_msgs_per_month, closed_at, created_at
+		FROM tenant_usage_snapshots
+		WHERE tenant_id = $1::uuid
+		ORDER BY period_start DESC
+		LIMIT $2
+	`, tenantID, selfUsageSnapshotLimit)
+	if err != nil {
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s UsageSnapshot
+		if err := rows.Scan(&s.SnapshotID, &s.PeriodStart, &s.PeriodEnd, &s.MessagesIngested, &s.StorageMB, &s.DevicesTotal,
+			&s.QuotaMsgsPerMonth, &s.ClosedAt, &s.CreatedAt); err != nil {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// recentQuotaEvents lists quota.*_exceeded audit entries of the last 30 days.
+func recentQuotaEvents(ctx context.Context, db *pgxpool.Pool, tenantID string) []QuotaEvent {
+	out := []QuotaEvent{}
+	rows, err := db.Query(ctx, `
+		SELECT audit_id, event_type, COALESCE(device_id::text, ''), COALESCE(metadata, '{}'::jsonb), timestamp
+		FROM audit_log
+		WHERE tenant_id = $1::uuid
+		  AND event_type LIKE 'quota.%\_exceeded'
+		  AND timestamp >= $2
+		ORDER BY timestamp DESC
+		LIMIT $3
+	`, tenantID, time.Now().UTC().Add(-selfUsageEventWindow), selfUsageEventLimit)
+	if err != nil {
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e QuotaEvent
+		var metadata []byte
+		if err := rows.Scan(&e.AuditID, &e.EventType, &e.DeviceID, &metadata, &e.Timestamp); err != nil {
+			continue
+		}
+		e.Metadata = map[string]interface{}{}
+		_ = json.Unmarshal(metadata, &e.Metadata)
+		if e.DeviceID == "" {
+			e.DeviceID, _ = e.Metadata["device_id"].(string)
+		}
+		out = append(out, e)
+	}
+	return out
+}
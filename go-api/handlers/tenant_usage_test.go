@@ -0,0 +1,55 @@
+package handlers
+
+import "testing"
+
+func TestNewQuotaLimitUsage(t *testing.T) {
+	t.Parallel()
+
+	u := newQuotaLimitUsage("quota_devices", "tenant", 10, 8)
+	if u.Unlimited || u.Exceeded || u.Remaining == nil || *u.Remaining != 2 || u.PercentUsed == nil || *u.PercentUsed != 80 {
+		t.Fatalf("usage 8/10 = %+v", u)
+	}
+
+	u = newQuotaLimitUsage("quota_msgs_per_month", "tenant", 1000, 1500)
+	if !u.Exceeded || *u.Remaining != 0 || *u.PercentUsed != 150 {
+		t.Fatalf("usage 1500/1000 = %+v", u)
+	}
+
+	// Reaching the limit exactly is still within it.
+	u = newQuotaLimitUsage("quota_devices", "tenant", 10, 10)
+	if u.Exceeded || *u.Remaining != 0 || *u.PercentUsed != 100 {
+		t.Fatalf("usage 10/10 = %+v, want at limit but not exceeded", u)
+	}
+
+	u = newQuotaLimitUsage("quota_devices", "tenant", 0, 42)
+	if !u.Unlimited || u.Exceeded || u.Remaining != nil || u.PercentUsed != nil {
+		t.Fatalf("unlimited usage = %+v", u)
+	}
+}
+
+func TestQuotaLimitUsagesCoverEveryQuota(t *testing.T) {
+	t.Parallel()
+
+	q := TenantQuota{QuotaDevices: 5, QuotaMsgsPerMin: 60, QuotaStorageMB: 100, QuotaMsgsPerMonth: 0}
+	got := quotaLimitUsages(q, tenantConsumption{DevicesTotal: 5, PeakDeviceMsgsMin: 61, StorageMB: 12.345})
+
+	byQuota := map[string]QuotaLimitUsage{}
+	for _, u := range got {
+		byQuota[u.Quota] = u
+	}
+	if len(byQuota) != 4 {
+		t.Fatalf("quotas = %v, want 4 entries", got)
+	}
+	if byQuota["quota_devices"].Exceeded || !byQuota["quota_msgs_per_min"].Exceeded {
+		t.Fatalf("devices at limit should not be exceeded, msgs_per_min over it should: %+v", got)
+	}
+	if byQuota["quota_msgs_per_min"].Scope != "device" {
+		t.Fatalf("msgs_per_min scope = %q, want device", byQuota["quota_msgs_per_min"].Scope)
+	}
+	if !byQuota["quota_msgs_per_month"].Unlimited {
+		t.Fatalf("msgs_per_month with 0 limit should be unlimited")
+	}
+	if byQuota["quota_storage_mb"].Used != 12.35 {
+		t.Fatalf("storage used = %v, want 12.35", byQuota["quota_storage_mb"].Used)
+	}
+}
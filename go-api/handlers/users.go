@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"iiot-go-api/config"
+	"iiot-go-api/models"
+	"iiot-go-api/utils"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// UserHandler manages the users of the JWT tenant. Routes run behind
+// TenantContextMiddleware, so queries go through the RLS transaction.
+type UserHandler struct {
+	DB     *pgxpool.Pool
+	Redis  *redis.Client
+	Config *config.Config
+}
+
+func NewUserHandler(db *pgxpool.Pool, redisClient *redis.Client, cfg *config.Config) *UserHandler {
+	return &UserHandler{
+		DB:     db,
+		Redis:  redisClient,
+		Config: cfg,
+	}
+}
+
+// UpdateUserRequest changes a colleague's role, custom role and/or status;
+// omitted fields stay as they are. custom_role_id "" removes the custom
+// role; custom roles only apply to tenant_user (promoting to tenant_admin
+// clears it).
+type UpdateUserRequest struct {
+	Role         *string `json:"role,omitempty" validate:"omitempty,oneof=tenant_admin tenant_user"`
+	CustomRoleID *string `json:"custom_role_id,omitempty"`
+	Status       *string `json:"status,omitempty" validate:"omitempty,oneof=active suspended"`
+}
+
+const userColumns = `user_id::text, tenant_id::text, email, role::text, status, COALESCE(email_verified, false), custom_role_id::text, created_at, last_login_at`
+
+func scanUser(row pgx.Row, u *models.User) error {
+	return row.Scan(&u.UserID, &u.TenantID, &u.Email, &u.Role, &u.Status, &u.EmailVerified, &u.CustomRoleID, &u.CreatedAt, &u.LastLoginAt)
+}
+
+// ListUsers lists users of the JWT tenant (deleted accounts are hidden
+// unless ?status=deleted; ?role= filters by role).
+func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	tenantID, tx, ok := tenantTx(w, r)
+	if !ok {
+		return
+	}
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	role := strings.TrimSpace(r.URL.Query().Get("role"))
+	switch status {
+	case "", "active", "suspended", "deleted":
+	default:
+		utils.WriteError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+	switch role {
+	case "", "tenant_admin", "tenant_user":
+	default:
+		utils.WriteError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	rows, err := tx.Query(r.Context(), `
+		SELECT `+userColumns+`
+		FROM users
+		WHERE tenant_id = $1::uuid
+		  AND (($2 = '' AND status <> 'deleted') OR status = $2)
+		  AND ($3 = '' OR role::text = $3)
+		ORDER BY created_at
+	`, tenantID, status, role)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var u models.User
+		if err := scanUser(rows, &u); err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+			return
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"users": users})
+}
+
+func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	tenantID, tx, ok := tenantTx(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var u models.User
+	err := scanUser(tx.QueryRow(r.Context(), `
+		SELECT `+userColumns+` FROM users WHERE user_id = $1::uuid AND tenant_id = $2::uuid
+	`, userID, tenantID), &u)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, u)
+}
+
+// UpdateUser changes role (tenant_admin/tenant_user), custom role and/or
+// status (active/suspended). Role changes are for tenant admins only.
+// Existing tokens of the user are revoked.
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+
+	var req UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, utils.ValidationErrorMessage(err))
+		return
+	}
+	if req.Role == nil && req.Status == nil && req.CustomRoleID == nil {
+		utils.WriteError(w, http.StatusBadRequest, "Nothing to update")
+		return
+	}
+	if req.CustomRoleID != nil && *req.CustomRoleID != "" {
+		if _, err := uuid.Parse(*req.CustomRoleID); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "Invalid custom_role_id")
+			return
+		}
+	}
+	if (req.Role != nil || req.CustomRoleID != nil) && !requireTenantAdmin(w, r) {
+		return
+	}
+	tenantID, tx, ok := tenantTx(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	current, ok := h.lockTenantUser(w, ctx, tx, tenantID, userID)
+	if !ok {
+		return
+	}
+	newRole, newStatus := current.Role, current.Status
+	if req.Role != nil {
+		newRole = *req.Role
+	}
+	if req.Status != nil {
+		newStatus = *req.Status
+	}
+	newCustomRole := stringOrEmpty(current.CustomRoleID)
+	if req.CustomRoleID != nil {
+		newCustomRole = *req.CustomRoleID
+	}
+	if newRole != "tenant_user" {
+		if req.CustomRoleID != nil && *req.CustomRoleID != "" {
+			utils.WriteErrorWithCode(w, http.StatusBadRequest, "custom_role_requires_tenant_user", "Custom roles apply only to tenant_user")
+			return
+		}
+		newCustomRole = ""
+	}
+	if newRole == current.Role && newStatus == current.Status && newCustomRole == stringOrEmpty(current.CustomRoleID) {
+		utils.WriteJSON(w, http.StatusOK, current)
+		return
+	}
+	if newCustomRole != "" && newCustomRole != stringOrEmpty(current.CustomRoleID) {
+		if !h.canAssignCustomRole(w, r, tx, tenantID, newCustomRole) {
+			return
+		}
+	}
+	if removesActiveAdmin(current, newRole, newStatus) {
+		if !otherActiveAdminExists(w, ctx, tx, tenantID, userID) {
+			return
+		}
+	}
+
+	var updated models.User
+	err := scanUser(tx.QueryRow(ctx, `
+		UPDATE users SET role = $3::user_role, status = $4, custom_role_id = NULLIF($5,'')::uuid, updated_at = NOW()
+		WHERE user_id = $1::uuid AND tenant_id = $2::uuid
+		RETURNING `+userColumns,
+		userID, tenantID, newRole, newStatus, newCustomRole), &updated)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	if _, err := revokeSessions(ctx, tx, h.Redis, h.Config, userID, "", actorUserID, "user_updated"); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	h.revokeTokens(userID)
+	recordUserEvent(ctx, tx, tenantID, actorUserID, userID, "user.updated", "update_user", map[string]interface{}{
+		"email":            updated.Email,
+		"role_from":        current.Role,
+		"role_to":          updated.Role,
+		"status_from":      current.Status,
+		"status_to":        updated.Status,
+		"custom_role_from": current.CustomRoleID,
+		"custom_role_to":   updated.CustomRoleID,
+	})
+	utils.WriteJSON(w, http.StatusOK, updated)
+}
+
+// canAssignCustomRole checks that roleID is a custom role of the tenant
+// whose permissions the caller holds, so a role cannot be used to escalate.
+func (h *UserHandler) canAssignCustomRole(w http.ResponseWriter, r *http.Request, tx pgx.Tx, tenantID, roleID string) bool {
+	var perms []string
+	err := tx.QueryRow(r.Context(), `
+		SELECT COALESCE(array_agg(p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+		FROM tenant_roles tr
+		LEFT JOIN tenant_role_permissions trp ON trp.role_id = tr.role_id
+		LEFT JOIN permissions p ON p.permission_id = trp.permission_id
+		WHERE tr.role_id = $1::uuid AND tr.tenant_id = $2::uuid
+		GROUP BY tr.role_id
+	`, roleID, tenantID).Scan(&perms)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusBadRequest, "Unknown custom_role_id")
+		return false
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return false
+	}
+	if missing := missingPermission(actorPermissions(r), perms); missing != "" {
+		utils.WriteError(w, http.StatusForbidden, "Cannot grant a permission you do not hold: "+missing)
+		return false
+	}
+	return true
+}
+
+// DeleteUser soft-deletes a user of the JWT tenant (status=deleted) and
+// revokes their tokens.
+func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	tenantID, tx, ok := tenantTx(w, r)
+	if !ok {
+		return
+	}
+	userID, ok := userIDParam(w, r)
+	if !ok {
+		return
+	}
+	actorUserID, _ := r.Context().Value("user_id").(string)
+
+	ctx := r.Context()
+	current, ok := h.lockTenantUser(w, ctx, tx, tenantID, userID)
+	if !ok {
+		return
+	}
+	if current.Status == "deleted" {
+		utils.WriteError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if removesActiveAdmin(current, current.Role, "deleted") {
+		if !otherActiveAdminExists(w, ctx, tx, tenantID, userID) {
+			return
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE users SET status = 'deleted', updated_at = NOW()
+		WHERE user_id = $1::uuid AND tenant_id = $2::uuid
+	`, userID, tenantID); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+
+	if _, err := revokeSessions(ctx, tx, h.Redis, h.Config, userID, "", actorUserID, "user_deleted"); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return
+	}
+	h.revokeTokens(userID)
+	recordUserEvent(ctx, tx, tenantID, actorUserID, userID, "user.deleted", "delete_user", map[string]interface{}{
+		"email":       current.Email,
+		"role":        current.Role,
+		"status_from": current.Status,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lockTenantUser loads the target user FOR UPDATE, writing 404 when it is
+// not in the tenant.
+func (h *UserHandler) lockTenantUser(w http.ResponseWriter, ctx context.Context, tx pgx.Tx, tenantID, userID string) (models.User, bool) {
+	var u models.User
+	err := scanUser(tx.QueryRow(ctx, `
+		SELECT `+userColumns+` FROM users WHERE user_id = $1::uuid AND tenant_id = $2::uuid FOR UPDATE
+	`, userID, tenantID), &u)
+	if err == pgx.ErrNoRows {
+		utils.WriteError(w, http.StatusNotFound, "User not found")
+		return u, false
+	}
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return u, false
+	}
+	return u, true
+}
+
+// otherActiveAdminExists locks the tenant's active admins so concurrent
+// demotions cannot leave the tenant without one; writes 409 when userID is
+// the last.
+func otherActiveAdminExists(w http.ResponseWriter, ctx context.Context, tx pgx.Tx, tenantID, userID string) bool {
+	rows, err := tx.Query(ctx, `
+		SELECT user_id::text FROM users
+		WHERE tenant_id = $1::uuid AND role = 'tenant_admin' AND status = 'active'
+		FOR UPDATE
+	`, tenantID)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return false
+	}
+	others := 0
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil && id != userID {
+			others++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return false
+	}
+	if others == 0 {
+		utils.WriteErrorWithCode(w, http.StatusConflict, "last_tenant_admin", "Tenant must keep at least one active tenant admin")
+		return false
+	}
+	return true
+}
+
+// removesActiveAdmin reports whether moving u to role/status takes away an
+// active tenant admin.
+func removesActiveAdmin(u models.User, role, status string) bool {
+	wasAdmin := u.Role == "tenant_admin" && u.Status == "active"
+	isAdmin := role == "tenant_admin" && status == "active"
+	return wasAdmin && !isAdmin
+}
+
+func (h *UserHandler) revokeTokens(userID string) {
+	if h.Redis == nil {
+		return
+	}
+	if err := utils.RevokeUserTokens(h.Redis, userID, h.Config.JWTRefreshExpiration); err != nil {
+		slog.Warn("user_token_revocation_failed", slog.String("user_id", userID), slog.Any("error", err))
+	}
+}
+
+// tenantTx returns the JWT tenant and the RLS transaction opened by
+// TenantContextMiddleware, writing the error response when either is missing.
+func tenantTx(w http.ResponseWriter, r *http.Request) (string, pgx.Tx, bool) {
+	tenantID, ok := r.Context().Value("tenant_id").(string)
+	if !ok || tenantID == "" {
+		utils.WriteError(w, http.StatusUnauthorized, "Missing tenant context")
+		return "", nil, false
+	}
+	tx, ok := r.Context().Value("db_tx").(pgx.Tx)
+	if !ok || tx == nil {
+		utils.WriteError(w, http.StatusInternalServerError, "Internal error")
+		return "", nil, false
+	}
+	return tenantID, tx, true
+}
+
+func userIDParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := r.PathValue("user_id")
+	if _, err := uuid.Parse(userID); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "Invalid user_id")
+		return "", false
+	}
+	return userID, true
+}
+
+// recordUserEvent writes the audit row in the request transaction: the
+// target row is locked FOR UPDATE there, so the audit_log FK check would
+// block on a separate connection.
+func recordUserEvent(ctx context.Context, tx pgx.Tx, tenantID, actorUserID, userID, eventType, action string, metadata map[string]interface{}) {
+	_, _ = tx.Exec(ctx, `
+		INSERT INTO audit_log (tenant_id, user_id, event_type, event_category, severity, actor_type, actor_id, action, result, resource_type, resource_id, metadata, timestamp)
+		VALUES ($1::uuid, NULLIF($2,'')::uuid, $3, 'auth', 'info', 'user', NULLIF($2,'')::uuid, $4, 'success', 'user', $5::uuid, $6::jsonb, NOW())
+	`, tenantID, actorUserID, eventType, action, userID, toJSONB(metadata))
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"iiot-go-api/models"
+)
+
+func TestRemovesActiveAdmin(t *testing.T) {
+	t.Parallel()
+
+	admin := models.User{Role: "tenant_admin", Status: "active"}
+	suspendedAdmin := models.User{Role: "tenant_admin", Status: "suspended"}
+	member := models.User{Role: "tenant_user", Status: "active"}
+
+	cases := []struct {
+		name         string
+		u            models.User
+		role, status string
+		want         bool
+	}{
+		{"demote admin", admin, "tenant_user", "active", true},
+		{"suspend admin", admin, "tenant_admin", "suspended", true},
+		{"delete admin", admin, "tenant_admin", "deleted", true},
+		{"admin unchanged", admin, "tenant_admin", "active", false},
+		{"promote member", member, "tenant_admin", "active", false},
+		{"suspend member", member, "tenant_user", "suspended", false},
+		{"already suspended admin", suspendedAdmin, "tenant_user", "suspended", false},
+	}
+	for _, tc := range cases {
+		if got := removesActiveAdmin(tc.u, tc.role, tc.status); got != tc.want {
+			t.Fatalf("%s: removesActiveAdmin = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestListUsersRequiresTenant(t *testing.T) {
+	t.Parallel()
+
+	h := &UserHandler{}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+	w := httptest.NewRecorder()
+
+	h.ListUsers(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestListUsersRequiresRLSTransaction(t *testing.T) {
+	t.Parallel()
+
+	h := &UserHandler{}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "tenant_id", "83409caf-43f8-40b3-8ffe-32b8f0c16a94"))
+	w := httptest.NewRecorder()
+
+	h.ListUsers(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
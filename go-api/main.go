@@ -36,6 +36,10 @@ func main() {
 		log.Fatal("MANUFACTURING_MASTER_KEY must be set to a strong value (refusing to start with default/empty)")
 	}
 
+	if err := utils.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+
 	// Connect to databases
 	db, err := database.Connect(ctx, cfg.PostgresURL(), cfg.TimescaleURL(), cfg.RedisAddr(), cfg.RedisPassword)
 	if err != nil {
@@ -45,18 +49,60 @@ func main() {
 
 	slog.Info("database_connections_established")
 
+	// JWT signing keys (RS256/EdDSA rotate in the background; HS256 keeps
+	// using JWT_SECRET and only retires keys left from an asymmetric setup)
+	jwtKeys, err := utils.NewJWTKeySet(cfg.JWTSigningAlg, cfg.JWTAcceptHS256)
+	if err != nil {
+		log.Fatalf("JWT_SIGNING_ALG: %v", err)
+	}
+	if cfg.JWTSigningAlg != utils.JWTAlgHS256 {
+		if cfg.JWTKeyEncryptionKey == "" {
+			log.Fatal("JWT_KEY_ENCRYPTION_KEY must be set when JWT_SIGNING_ALG is RS256 or EdDSA (refusing to start)")
+		}
+		if cfg.JWTAcceptHS256 {
+			slog.Warn("jwt_accept_hs256_enabled", slog.String("hint", "unset JWT_ACCEPT_HS256 once the refresh token lifetime has passed since switching from HS256"))
+		}
+	}
+	if err := handlers.LoadJWTKeys(ctx, db.Postgres, cfg, jwtKeys); err != nil {
+		log.Fatalf("JWT signing keys: %v", err)
+	}
+	utils.SetJWTKeySet(jwtKeys)
+
 	// Initialize middlewares
-	jwtMiddleware := middleware.NewJWTMiddleware(cfg.JWTSecret)
+	jwtMiddleware := middleware.NewJWTMiddleware(cfg.JWTSecret, db.Redis)
 	apiKeyMiddleware := middleware.NewAPIKeyMiddleware(db.Postgres, db.Redis)
+	authenticator := middleware.NewAuthenticator(jwtMiddleware, apiKeyMiddleware) // JWT or API key
 	tenantMiddleware := middleware.NewTenantContextMiddleware(db.Postgres)
-	rateLimitAuth := middleware.NewRateLimitAuth(db.Redis, 10, 60) // 10 attempts per minute
+	rateLimitAuth := middleware.NewRateLimitAuth(db.Redis, 10, 60)    // 10 attempts per minute
+	rateLimitAccount := middleware.NewRateLimitAuth(db.Redis, 5, 900) // 5 attempts per account per 15 minutes
 	corsConfig := middleware.NewCORSConfig(cfg.CORSAllowedOrigins, cfg.CORSAllowedMethods, cfg.CORSAllowedHeaders)
 
+	// Ingest cache (device/tenant lookups), invalidated across replicas via Redis Pub/Sub
+	bgCtx, stopBackground := context.WithCancel(ctx)
+	defer stopBackground()
+	ingestCache := handlers.NewIngestCache(db.Redis, cfg)
+	go ingestCache.Subscribe(bgCtx)
+	go handlers.RunMessageCounterFlusher(bgCtx, db.Postgres, db.Redis, time.Duration(cfg.MessageCounterFlushSecs)*time.Second)
+	// Usage snapshots for billing (single replica via pg advisory lock)
+	go handlers.RunUsageSnapshotScheduler(bgCtx, db.Postgres, db.Timescale, time.Duration(cfg.UsageSnapshotIntervalSecs)*time.Second)
+	go handlers.RunTelemetryRetention(bgCtx, db.Postgres, db.Timescale, time.Duration(cfg.TelemetryRetentionIntervalSecs)*time.Second)
+	go handlers.RunOverageMeter(bgCtx, db.Postgres, db.Timescale, db.Redis, cfg, time.Duration(cfg.OverageMeterIntervalSecs)*time.Second)
+	go handlers.RunJWTKeyRotation(bgCtx, db.Postgres, cfg, jwtKeys)
+	// Signed audit chain checkpoints (single replica via pg advisory lock)
+	go handlers.RunAuditCheckpoints(bgCtx, db.Postgres, cfg, time.Duration(cfg.AuditCheckpointIntervalSecs)*time.Second)
+
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(db.Postgres, db.Redis, cfg)
-	deviceHandler := handlers.NewDeviceHandler(db.Postgres, db.Redis, cfg)
-	telemetryHandler := handlers.NewTelemetryHandler(db.Postgres, db.Timescale, db.Redis, cfg)
-	tenantAdminHandler := handlers.NewTenantAdminHandler(db.Postgres, db.Timescale, cfg)
+	deviceHandler := handlers.NewDeviceHandler(db.Postgres, db.Redis, ingestCache, cfg)
+	telemetryHandler := handlers.NewTelemetryHandler(db.Postgres, db.Timescale, db.Redis, ingestCache, cfg)
+	tenantAdminHandler := handlers.NewTenantAdminHandler(db.Postgres, db.Timescale, db.Redis, ingestCache, cfg)
+	billingHandler := handlers.NewBillingHandler(db.Postgres, db.Timescale, cfg)
+	planHandler := handlers.NewPlanHandler(db.Postgres, ingestCache, cfg)
+	invitationHandler := handlers.NewInvitationHandler(db.Postgres, cfg)
+	userHandler := handlers.NewUserHandler(db.Postgres, db.Redis, cfg)
+	apiKeyHandler := handlers.NewAPIKeyHandler(db.Postgres, db.Redis, cfg)
+	auditHandler := handlers.NewAuditHandler(db.Postgres, cfg)
+	privacyHandler := handlers.NewPrivacyHandler(db.Postgres, db.Redis, cfg)
 
 	// Setup routes
 	mux := http.NewServeMux()
@@ -122,12 +168,30 @@ func main() {
 	})
 
 	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.Handle("GET /.well-known/jwks.json", handlers.JWKSHandler(jwtKeys))
 
 	registerRoutes := func(prefix string) {
 		// Auth endpoints
 		mux.Handle(fmt.Sprintf("%s/auth/register", prefix), middleware.RequireMethods(http.MethodPost)(rateLimitAuth.Limit(http.HandlerFunc(authHandler.Register))))
 		mux.Handle(fmt.Sprintf("%s/auth/login", prefix), middleware.RequireMethods(http.MethodPost)(rateLimitAuth.Limit(http.HandlerFunc(authHandler.Login))))
 		mux.Handle(fmt.Sprintf("%s/auth/refresh", prefix), middleware.RequireMethods(http.MethodPost)(rateLimitAuth.Limit(http.HandlerFunc(authHandler.Refresh))))
+		mux.Handle(fmt.Sprintf("%s/auth/password/forgot", prefix), middleware.RequireMethods(http.MethodPost)(rateLimitAuth.Limit(rateLimitAccount.LimitByEmail(http.HandlerFunc(authHandler.ForgotPassword)))))
+		mux.Handle(fmt.Sprintf("%s/auth/password/reset", prefix), middleware.RequireMethods(http.MethodPost)(rateLimitAuth.Limit(http.HandlerFunc(authHandler.ResetPassword))))
+		mux.Handle(fmt.Sprintf("%s/auth/verify-email", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPost)(rateLimitAuth.Limit(http.HandlerFunc(authHandler.VerifyEmail))))
+		mux.Handle(fmt.Sprintf("%s/auth/verify-email/resend", prefix), middleware.RequireMethods(http.MethodPost)(rateLimitAuth.Limit(rateLimitAccount.LimitByEmail(http.HandlerFunc(authHandler.ResendVerification)))))
+		mux.Handle(fmt.Sprintf("%s/auth/logout", prefix), middleware.RequireMethods(http.MethodPost)(jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.Logout))))
+		mux.Handle(fmt.Sprintf("%s/auth/logout-all", prefix), middleware.RequireMethods(http.MethodPost)(jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.LogoutAll))))
+		mux.Handle(fmt.Sprintf("%s/auth/sessions", prefix), middleware.RequireMethods(http.MethodGet)(jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.ListSessions))))
+		mux.Handle(fmt.Sprintf("%s/auth/sessions/{session_id}", prefix), middleware.RequireMethods(http.MethodDelete)(jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.RevokeSession))))
+		mux.Handle(fmt.Sprintf("%s/auth/mfa", prefix), middleware.RequireMethods(http.MethodGet)(jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.GetMFAStatus))))
+		mux.Handle(fmt.Sprintf("%s/auth/mfa/verify", prefix), middleware.RequireMethods(http.MethodPost)(rateLimitAuth.Limit(http.HandlerFunc(authHandler.VerifyMFA))))
+		mux.Handle(fmt.Sprintf("%s/auth/mfa/enroll", prefix), middleware.RequireMethods(http.MethodPost)(jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.EnrollMFA))))
+		mux.Handle(fmt.Sprintf("%s/auth/mfa/confirm", prefix), middleware.RequireMethods(http.MethodPost)(jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.ConfirmMFA))))
+		mux.Handle(fmt.Sprintf("%s/auth/mfa/disable", prefix), middleware.RequireMethods(http.MethodPost)(jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.DisableMFA))))
+		mux.Handle(fmt.Sprintf("%s/auth/mfa/recovery-codes", prefix), middleware.RequireMethods(http.MethodPost)(jwtMiddleware.Authenticate(http.HandlerFunc(authHandler.RegenerateRecoveryCodes))))
+		mux.Handle(fmt.Sprintf("%s/auth/oidc/{tenant}/login", prefix), middleware.RequireMethods(http.MethodGet)(rateLimitAuth.Limit(http.HandlerFunc(authHandler.OIDCLogin))))
+		mux.Handle(fmt.Sprintf("%s/auth/oidc/callback", prefix), middleware.RequireMethods(http.MethodGet)(rateLimitAuth.Limit(http.HandlerFunc(authHandler.OIDCCallback))))
+		mux.Handle(fmt.Sprintf("%s/auth/accept-invite", prefix), middleware.RequireMethods(http.MethodPost)(rateLimitAuth.Limit(http.HandlerFunc(authHandler.AcceptInvite))))
 
 		// Device bootstrap + secret (no auth required - devices poll this)
 		mux.Handle(fmt.Sprintf("%s/devices/bootstrap", prefix), middleware.RequireMethods(http.MethodPost)(http.HandlerFunc(deviceHandler.Bootstrap)))
@@ -151,9 +215,9 @@ func main() {
 			),
 		))
 
-		// Device list (requires JWT + RLS)
+		// Device list (JWT or API key + RLS)
 		mux.Handle(fmt.Sprintf("%s/devices", prefix), middleware.RequireMethods(http.MethodGet)(
-			jwtMiddleware.Authenticate(
+			authenticator.Authenticate(
 				tenantMiddleware.SetContext(
 					middleware.RequirePermission("devices:read")(
 						http.HandlerFunc(deviceHandler.ListDevices),
@@ -171,23 +235,25 @@ func main() {
 			),
 		))
 
-		// Telemetry webhook (requires API key)
+		// Telemetry webhook (requires API key with telemetry:write)
 		mux.Handle(fmt.Sprintf("%s/telemetry", prefix), middleware.RequireMethods(http.MethodPost)(
 			apiKeyMiddleware.Authenticate(
-				http.HandlerFunc(telemetryHandler.Webhook),
+				middleware.RequireScope("telemetry:write")(
+					http.HandlerFunc(telemetryHandler.Webhook),
+				),
 			),
 		))
 
-		// Telemetry reads (JWT + permission + tenant scoping)
+		// Telemetry reads (JWT or API key + permission + tenant scoping)
 		mux.Handle(fmt.Sprintf("%s/telemetry/latest", prefix), middleware.RequireMethods(http.MethodGet)(
-			jwtMiddleware.Authenticate(
+			authenticator.Authenticate(
 				middleware.RequirePermission("telemetry:read")(
 					http.HandlerFunc(telemetryHandler.GetLatest),
 				),
 			),
 		))
 		mux.Handle(fmt.Sprintf("%s/telemetry/slots", prefix), middleware.RequireMethods(http.MethodGet)(
-			jwtMiddleware.Authenticate(
+			authenticator.Authenticate(
 				middleware.RequirePermission("telemetry:read")(
 					http.HandlerFunc(telemetryHandler.GetActiveSlots),
 				),
@@ -218,6 +284,390 @@ func main() {
 				),
 			),
 		))
+
+		// Tenant authentication policy (super admin only)
+		mux.Handle(fmt.Sprintf("%s/tenants/{tenant_id}/auth-policy", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPut)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("system:admin")(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						switch r.Method {
+						case http.MethodGet:
+							tenantAdminHandler.GetTenantAuthPolicy(w, r)
+						case http.MethodPut:
+							tenantAdminHandler.PutTenantAuthPolicy(w, r)
+						default:
+							utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+						}
+					}),
+				),
+			),
+		))
+
+		// Plan catalog (super admin only)
+		mux.Handle(fmt.Sprintf("%s/plans", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPost)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("system:admin")(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						switch r.Method {
+						case http.MethodGet:
+							planHandler.ListPlans(w, r)
+						case http.MethodPost:
+							planHandler.CreatePlan(w, r)
+						default:
+							utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+						}
+					}),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/plans/{plan_id}", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPut, http.MethodDelete)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("system:admin")(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						switch r.Method {
+						case http.MethodGet:
+							planHandler.GetPlan(w, r)
+						case http.MethodPut:
+							planHandler.UpdatePlan(w, r)
+						case http.MethodDelete:
+							planHandler.DeletePlan(w, r)
+						default:
+							utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+						}
+					}),
+				),
+			),
+		))
+
+		// Tenant self-service quotas/usage (scoped to JWT tenant)
+		mux.Handle(fmt.Sprintf("%s/tenant/quotas", prefix), middleware.RequireMethods(http.MethodGet)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("tenants:read")(
+					http.HandlerFunc(tenantAdminHandler.GetOwnQuotas),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/tenant/usage", prefix), middleware.RequireMethods(http.MethodGet)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("tenants:read")(
+					http.HandlerFunc(tenantAdminHandler.GetOwnUsage),
+				),
+			),
+		))
+
+		// Tenant self-service authentication policy (MFA mandatory or not)
+		mux.Handle(fmt.Sprintf("%s/tenant/auth-policy", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPut)(
+			jwtMiddleware.Authenticate(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch r.Method {
+					case http.MethodGet:
+						middleware.RequirePermission("tenants:read")(http.HandlerFunc(tenantAdminHandler.GetOwnAuthPolicy)).ServeHTTP(w, r)
+					case http.MethodPut:
+						middleware.RequirePermission("users:write")(http.HandlerFunc(tenantAdminHandler.PutOwnAuthPolicy)).ServeHTTP(w, r)
+					default:
+						utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+					}
+				}),
+			),
+		))
+
+		// Tenant OIDC single sign-on provider (scoped to JWT tenant)
+		mux.Handle(fmt.Sprintf("%s/tenant/oidc", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPut, http.MethodDelete)(
+			jwtMiddleware.Authenticate(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch r.Method {
+					case http.MethodGet:
+						middleware.RequirePermission("users:read")(http.HandlerFunc(tenantAdminHandler.GetOwnOIDCConfig)).ServeHTTP(w, r)
+					case http.MethodPut:
+						middleware.RequirePermission("users:write")(http.HandlerFunc(tenantAdminHandler.PutOwnOIDCConfig)).ServeHTTP(w, r)
+					case http.MethodDelete:
+						middleware.RequirePermission("users:write")(http.HandlerFunc(tenantAdminHandler.DeleteOwnOIDCConfig)).ServeHTTP(w, r)
+					default:
+						utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+					}
+				}),
+			),
+		))
+
+		// Tenant invitations (tenant admin, scoped to JWT tenant)
+		mux.Handle(fmt.Sprintf("%s/invitations", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPost)(
+			jwtMiddleware.Authenticate(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch r.Method {
+					case http.MethodGet:
+						middleware.RequirePermission("users:read")(http.HandlerFunc(invitationHandler.ListInvitations)).ServeHTTP(w, r)
+					case http.MethodPost:
+						middleware.RequirePermission("users:write")(http.HandlerFunc(invitationHandler.CreateInvitation)).ServeHTTP(w, r)
+					default:
+						utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+					}
+				}),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/invitations/{invite_id}", prefix), middleware.RequireMethods(http.MethodDelete)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("users:write")(
+					http.HandlerFunc(invitationHandler.RevokeInvitation),
+				),
+			),
+		))
+
+		// Tenant API keys (tenant admin, scoped to JWT tenant)
+		mux.Handle(fmt.Sprintf("%s/api-keys", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPost)(
+			jwtMiddleware.Authenticate(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch r.Method {
+					case http.MethodGet:
+						middleware.RequirePermission("api_keys:read")(http.HandlerFunc(apiKeyHandler.ListAPIKeys)).ServeHTTP(w, r)
+					case http.MethodPost:
+						middleware.RequirePermission("api_keys:write")(http.HandlerFunc(apiKeyHandler.CreateAPIKey)).ServeHTTP(w, r)
+					default:
+						utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+					}
+				}),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/api-keys/{key_id}", prefix), middleware.RequireMethods(http.MethodDelete)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("api_keys:write")(
+					http.HandlerFunc(apiKeyHandler.RevokeAPIKey),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/api-keys/{key_id}/rotate", prefix), middleware.RequireMethods(http.MethodPost)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("api_keys:write")(
+					http.HandlerFunc(apiKeyHandler.RotateAPIKey),
+				),
+			),
+		))
+
+		// Tenant users (tenant admin, JWT tenant + RLS)
+		mux.Handle(fmt.Sprintf("%s/users", prefix), middleware.RequireMethods(http.MethodGet)(
+			jwtMiddleware.Authenticate(
+				tenantMiddleware.SetContext(
+					middleware.RequirePermission("users:read")(
+						http.HandlerFunc(userHandler.ListUsers),
+					),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/users/{user_id}", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPatch, http.MethodDelete)(
+			jwtMiddleware.Authenticate(
+				tenantMiddleware.SetContext(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						switch r.Method {
+						case http.MethodGet:
+							middleware.RequirePermission("users:read")(http.HandlerFunc(userHandler.GetUser)).ServeHTTP(w, r)
+						case http.MethodPatch:
+							middleware.RequirePermission("users:write")(http.HandlerFunc(userHandler.UpdateUser)).ServeHTTP(w, r)
+						case http.MethodDelete:
+							middleware.RequirePermission("users:delete")(http.HandlerFunc(userHandler.DeleteUser)).ServeHTTP(w, r)
+						default:
+							utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+						}
+					}),
+				),
+			),
+		))
+
+		mux.Handle(fmt.Sprintf("%s/users/{user_id}/sessions", prefix), middleware.RequireMethods(http.MethodGet, http.MethodDelete)(
+			jwtMiddleware.Authenticate(
+				tenantMiddleware.SetContext(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						switch r.Method {
+						case http.MethodGet:
+							middleware.RequirePermission("users:read")(http.HandlerFunc(userHandler.ListUserSessions)).ServeHTTP(w, r)
+						case http.MethodDelete:
+							middleware.RequirePermission("users:write")(http.HandlerFunc(userHandler.RevokeUserSessions)).ServeHTTP(w, r)
+						default:
+							utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+						}
+					}),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/users/{user_id}/unlock", prefix), middleware.RequireMethods(http.MethodPost)(
+			jwtMiddleware.Authenticate(
+				tenantMiddleware.SetContext(
+					middleware.RequirePermission("users:write")(
+						http.HandlerFunc(userHandler.UnlockUser),
+					),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/users/{user_id}/sessions/{session_id}", prefix), middleware.RequireMethods(http.MethodDelete)(
+			jwtMiddleware.Authenticate(
+				tenantMiddleware.SetContext(
+					middleware.RequirePermission("users:write")(
+						http.HandlerFunc(userHandler.RevokeUserSessions),
+					),
+				),
+			),
+		))
+
+		// Tenant custom roles (tenant admin, JWT tenant + RLS)
+		mux.Handle(fmt.Sprintf("%s/permissions", prefix), middleware.RequireMethods(http.MethodGet)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("users:read")(
+					http.HandlerFunc(userHandler.ListGrantablePermissions),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/roles", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPost)(
+			jwtMiddleware.Authenticate(
+				tenantMiddleware.SetContext(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						switch r.Method {
+						case http.MethodGet:
+							middleware.RequirePermission("users:read")(http.HandlerFunc(userHandler.ListRoles)).ServeHTTP(w, r)
+						case http.MethodPost:
+							middleware.RequirePermission("users:write")(http.HandlerFunc(userHandler.CreateRole)).ServeHTTP(w, r)
+						default:
+							utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+						}
+					}),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/roles/{role_id}", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPut, http.MethodDelete)(
+			jwtMiddleware.Authenticate(
+				tenantMiddleware.SetContext(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						switch r.Method {
+						case http.MethodGet:
+							middleware.RequirePermission("users:read")(http.HandlerFunc(userHandler.GetRole)).ServeHTTP(w, r)
+						case http.MethodPut:
+							middleware.RequirePermission("users:write")(http.HandlerFunc(userHandler.UpdateRole)).ServeHTTP(w, r)
+						case http.MethodDelete:
+							middleware.RequirePermission("users:write")(http.HandlerFunc(userHandler.DeleteRole)).ServeHTTP(w, r)
+						default:
+							utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+						}
+					}),
+				),
+			),
+		))
+
+		// Audit log (JWT or API key; own tenant, super admin across tenants)
+		mux.Handle(fmt.Sprintf("%s/audit", prefix), middleware.RequireMethods(http.MethodGet)(
+			authenticator.Authenticate(
+				middleware.RequirePermission("audit:read")(
+					http.HandlerFunc(auditHandler.ListAudit),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/audit/export", prefix), middleware.RequireMethods(http.MethodGet)(
+			authenticator.Authenticate(
+				middleware.RequirePermission("audit:read")(
+					http.HandlerFunc(auditHandler.ExportAudit),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/audit/verify", prefix), middleware.RequireMethods(http.MethodGet)(
+			authenticator.Authenticate(
+				middleware.RequirePermission("audit:read")(
+					http.HandlerFunc(auditHandler.VerifyAudit),
+				),
+			),
+		))
+
+		// LGPD data subject requests (own data; other users need privacy:manage)
+		mux.Handle(fmt.Sprintf("%s/privacy/export", prefix), middleware.RequireMethods(http.MethodPost)(
+			jwtMiddleware.Authenticate(
+				http.HandlerFunc(privacyHandler.ExportPersonalData),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/privacy/erasure-requests", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPost)(
+			jwtMiddleware.Authenticate(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch r.Method {
+					case http.MethodGet:
+						middleware.RequirePermission("privacy:manage")(http.HandlerFunc(privacyHandler.ListErasureRequests)).ServeHTTP(w, r)
+					case http.MethodPost:
+						privacyHandler.CreateErasureRequest(w, r)
+					default:
+						utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+					}
+				}),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/privacy/erasure-requests/{request_id}/approve", prefix), middleware.RequireMethods(http.MethodPost)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("privacy:manage")(
+					http.HandlerFunc(privacyHandler.ApproveErasureRequest),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/privacy/erasure-requests/{request_id}/reject", prefix), middleware.RequireMethods(http.MethodPost)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("privacy:manage")(
+					http.HandlerFunc(privacyHandler.RejectErasureRequest),
+				),
+			),
+		))
+
+		// Billing: pricing catalog + invoice generation/status (super admin only)
+		mux.Handle(fmt.Sprintf("%s/billing/pricing", prefix), middleware.RequireMethods(http.MethodGet)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("system:admin")(
+					http.HandlerFunc(billingHandler.ListPricing),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/billing/pricing/{plan_type}", prefix), middleware.RequireMethods(http.MethodPut)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("system:admin")(
+					http.HandlerFunc(billingHandler.PutPricing),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/tenants/{tenant_id}/invoices", prefix), middleware.RequireMethods(http.MethodGet, http.MethodPost)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("system:admin")(
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						switch r.Method {
+						case http.MethodGet:
+							billingHandler.ListTenantInvoices(w, r)
+						case http.MethodPost:
+							billingHandler.GenerateInvoice(w, r)
+						default:
+							utils.WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+						}
+					}),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/tenants/{tenant_id}/invoices/{invoice_id}/status", prefix), middleware.RequireMethods(http.MethodPost)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("system:admin")(
+					http.HandlerFunc(billingHandler.UpdateInvoiceStatus),
+				),
+			),
+		))
+
+		// Invoices (tenant admin, scoped to JWT tenant)
+		mux.Handle(fmt.Sprintf("%s/invoices", prefix), middleware.RequireMethods(http.MethodGet)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("tenants:read")(
+					http.HandlerFunc(billingHandler.ListInvoices),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/invoices/{invoice_id}", prefix), middleware.RequireMethods(http.MethodGet)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("tenants:read")(
+					http.HandlerFunc(billingHandler.GetInvoice),
+				),
+			),
+		))
+		mux.Handle(fmt.Sprintf("%s/invoices/{invoice_id}/document", prefix), middleware.RequireMethods(http.MethodGet)(
+			jwtMiddleware.Authenticate(
+				middleware.RequirePermission("tenants:read")(
+					http.HandlerFunc(billingHandler.GetInvoiceDocument),
+				),
+			),
+		))
 	}
 
 	// Versioned API (stable contract for B2B)
@@ -226,10 +676,27 @@ func main() {
 	registerRoutes("/api")
 
 	// CRITICAL: CORS must be FIRST in middleware chain to handle OPTIONS preflight
+	// Request audit sits inside RequestID (request_id in the metadata) and
+	// outside Recover (panics are recorded as 500).
+	var audited http.Handler = middleware.Recover(mux)
+	auditCtx, stopAudit := context.WithCancel(context.Background())
+	auditDone := make(chan struct{})
+	if cfg.RequestAuditEnabled {
+		requestAuditor := middleware.NewRequestAuditor(db.Postgres, int(cfg.RequestAuditBufferSize), int(cfg.RequestAuditBatchSize),
+			time.Duration(cfg.RequestAuditFlushMs)*time.Millisecond, cfg.RequestAuditSkipPaths)
+		audited = requestAuditor.Handle(audited)
+		go func() {
+			requestAuditor.Run(auditCtx)
+			close(auditDone)
+		}()
+	} else {
+		close(auditDone)
+	}
+
 	handler := corsConfig.Handle(
 		middleware.RequestID(
 			middleware.Logging(
-				middleware.Recover(mux),
+				audited,
 			),
 		),
 	)
@@ -260,6 +727,7 @@ func main() {
 	<-stop
 
 	slog.Info("server_shutdown_start")
+	stopBackground()
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeoutSecs)*time.Second)
 	defer cancel()
 	if err := server.Shutdown(shutdownCtx); err != nil {
@@ -267,4 +735,7 @@ func main() {
 	} else {
 		slog.Info("server_shutdown_complete")
 	}
+	// Flush the request audit queue once no more requests come in.
+	stopAudit()
+	<-auditDone
 }
@@ -43,6 +43,30 @@ var (
 		},
 		[]string{"path"},
 	)
+
+	ingestCacheLookupsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ingest_cache_lookups_total",
+			Help: "Total ingest cache lookups by cache and result",
+		},
+		[]string{"cache", "result"},
+	)
+
+	usageSnapshotRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "usage_snapshot_runs_total",
+			Help: "Total scheduled usage snapshot runs by result",
+		},
+		[]string{"result"},
+	)
+
+	auditRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "audit_requests_total",
+			Help: "Total request audit records by result (recorded, dropped, failed)",
+		},
+		[]string{"result"},
+	)
 )
 
 func init() {
@@ -52,6 +76,9 @@ func init() {
 		telemetryIngestedTotal,
 		telemetryRejectedTotal,
 		authRateLimitTotal,
+		ingestCacheLookupsTotal,
+		usageSnapshotRunsTotal,
+		auditRequestsTotal,
 	)
 }
 
@@ -74,3 +101,15 @@ func TelemetryRejected(reason string) {
 func AuthRateLimited(path string) {
 	authRateLimitTotal.WithLabelValues(path).Inc()
 }
+
+func IngestCacheLookup(cache, result string) {
+	ingestCacheLookupsTotal.WithLabelValues(cache, result).Inc()
+}
+
+func UsageSnapshotRun(result string) {
+	usageSnapshotRunsTotal.WithLabelValues(result).Inc()
+}
+
+func AuditRequests(result string, n int) {
+	auditRequestsTotal.WithLabelValues(result).Add(float64(n))
+}
@@ -20,9 +20,16 @@ type APIKeyMiddleware struct {
 }
 
 type APIKeyData struct {
-	KeyID    string   `json:"key_id"`
-	TenantID string   `json:"tenant_id"`
-	Scopes   []string `json:"scopes"`
+	KeyID     string     `json:"key_id"`
+	TenantID  string     `json:"tenant_id"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// expired reports whether the key is past expires_at (cached entries may
+// outlive it by clock skew between replicas and Redis).
+func (d *APIKeyData) expired(now time.Time) bool {
+	return d.ExpiresAt != nil && !d.ExpiresAt.After(now)
 }
 
 func NewAPIKeyMiddleware(db *pgxpool.Pool, rdb *redis.Client) *APIKeyMiddleware {
@@ -55,6 +62,7 @@ func (m *APIKeyMiddleware) Authenticate(next http.Handler) http.Handler {
 		ctx = context.WithValue(ctx, "api_key_id", keyData.KeyID)
 		ctx = context.WithValue(ctx, "tenant_id", keyData.TenantID)
 		ctx = context.WithValue(ctx, "scopes", keyData.Scopes)
+		setAuditActor(ctx, keyData.TenantID, "", keyData.KeyID)
 
 		// Update last_used async
 		go m.updateLastUsed(keyData.KeyID)
@@ -70,44 +78,63 @@ func (m *APIKeyMiddleware) validateAPIKey(ctx context.Context, key string) (*API
 
 	// 1. Redis cache (hot path)
 	if m.Redis != nil {
-		cacheKey := "apikey:valid:" + key
-		cached, err := m.Redis.Get(ctx, cacheKey).Result()
+		cached, err := m.Redis.Get(ctx, utils.APIKeyCacheKey(key)).Result()
 		if err == nil {
 			var data APIKeyData
 			if json.Unmarshal([]byte(cached), &data) == nil {
+				if data.expired(time.Now()) {
+					return nil, errors.New("api key expired")
+				}
 				return &data, nil
 			}
 		}
 	}
 
-	// 2. Database lookup (cold path)
-	prefix := key[:8]
-	var keyHash string
-	var data APIKeyData
-
-	err := m.DB.QueryRow(ctx, `
-		SELECT key_id, key_hash, tenant_id, scopes
+	// 2. Database lookup (cold path); legacy 8-char prefixes may collide
+	rows, err := m.DB.Query(ctx, `
+		SELECT key_id, key_hash, tenant_id, scopes, expires_at
 		FROM api_keys
 		WHERE key_prefix = $1 AND status = 'active'
-		LIMIT 1
-	`, prefix).Scan(&data.KeyID, &keyHash, &data.TenantID, &data.Scopes)
-
+		  AND (expires_at IS NULL OR expires_at > NOW())
+	`, utils.APIKeyLookupPrefix(key))
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	// 3. Bcrypt verify
-	if err := bcrypt.CompareHashAndPassword([]byte(keyHash), []byte(key)); err != nil {
+	var data *APIKeyData
+	for rows.Next() {
+		var candidate APIKeyData
+		var keyHash string
+		if err := rows.Scan(&candidate.KeyID, &keyHash, &candidate.TenantID, &candidate.Scopes, &candidate.ExpiresAt); err != nil {
+			return nil, err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(keyHash), []byte(key)) == nil {
+			data = &candidate
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	if data == nil {
+		return nil, errors.New("invalid api key")
+	}
 
-	// 4. Cache for 1 hour
+	// 4. Cache for 1 hour (less when the key expires sooner)
 	if m.Redis != nil {
-		jsonData, _ := json.Marshal(data)
-		m.Redis.Set(ctx, "apikey:valid:"+key, jsonData, time.Hour)
+		ttl := time.Hour
+		if data.ExpiresAt != nil && time.Until(*data.ExpiresAt) < ttl {
+			ttl = time.Until(*data.ExpiresAt)
+		}
+		if ttl > 0 {
+			jsonData, _ := json.Marshal(data)
+			_ = utils.CacheAPIKey(ctx, m.Redis, key, data.KeyID, jsonData, ttl)
+		}
 	}
 
-	return &data, nil
+	return data, nil
 }
 
 func (m *APIKeyMiddleware) updateLastUsed(keyID string) {
@@ -2,9 +2,15 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
+	"iiot-go-api/utils"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 )
 
 func TestValidateAPIKeyRejectsShortKey(t *testing.T) {
@@ -39,3 +45,26 @@ func TestAPIKeyAuthenticateHeaderValidation(t *testing.T) {
 		t.Fatalf("invalid format status = %d, want %d", wInvalid.Code, http.StatusUnauthorized)
 	}
 }
+
+func TestValidateAPIKeyRejectsExpiredCacheEntry(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	key := "iiot_0a1b2c3d_secret"
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	m := &APIKeyMiddleware{Redis: rdb}
+	for _, c := range []struct {
+		expiresAt *time.Time
+		valid     bool
+	}{{&future, true}, {&past, false}} {
+		data, _ := json.Marshal(APIKeyData{KeyID: "key-1", TenantID: "tenant-1", Scopes: []string{"telemetry:read"}, ExpiresAt: c.expiresAt})
+		mr.Set(utils.APIKeyCacheKey(key), string(data))
+		_, err := m.validateAPIKey(context.Background(), key)
+		if (err == nil) != c.valid {
+			t.Fatalf("expires_at %v: err = %v, want valid=%v", c.expiresAt, err, c.valid)
+		}
+	}
+}
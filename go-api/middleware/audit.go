@@ -0,0 +1,391 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"iiot-go-api/metrics"
+	"iiot-go-api/utils"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// maxAuditBodyBytes is how much of a request body is kept (redacted) in
+	// the audit metadata; larger bodies are only measured.
+	maxAuditBodyBytes = 8 << 10
+	// maxAuditErrorBytes is how much of an error response is read for its
+	// code and message.
+	maxAuditErrorBytes = 1 << 10
+	auditRedacted      = "[REDACTED]"
+)
+
+// auditSensitiveKeys are redacted (case-insensitive substring match) from
+// audited request bodies and query strings; *_id fields are kept.
+var auditSensitiveKeys = []string{"password", "secret", "token", "key", "code", "otp", "signature", "hmac", "credential", "authorization"}
+
+// RequestAuditRecord is one state-changing request as stored in audit_log.
+type RequestAuditRecord struct {
+	TenantID     string
+	UserID       string
+	APIKeyID     string
+	ActorType    string
+	ResourceType string
+	ResourceID   string
+	Method       string
+	Path         string
+	Status       int
+	Duration     time.Duration
+	IP           string
+	UserAgent    string
+	ErrorCode    string
+	ErrorMessage string
+	Metadata     map[string]interface{}
+	Timestamp    time.Time
+}
+
+// auditActor is filled by the authentication middlewares, which run inside
+// RequestAuditor.Handle and cannot hand their context back.
+type auditActor struct {
+	mu       sync.Mutex
+	tenantID string
+	userID   string
+	apiKeyID string
+}
+
+// setAuditActor records who authenticated the request, if it is audited.
+func setAuditActor(ctx context.Context, tenantID, userID, apiKeyID string) {
+	actor, ok := ctx.Value("audit_actor").(*auditActor)
+	if !ok {
+		return
+	}
+	actor.mu.Lock()
+	actor.tenantID, actor.userID, actor.apiKeyID = tenantID, userID, apiKeyID
+	actor.mu.Unlock()
+}
+
+// RequestAuditor writes an audit_log row (event_type http.request) for every
+// POST/PUT/PATCH/DELETE. Records are queued and inserted in batches by Run,
+// so requests never wait on the database; when the queue is full records
+// are dropped and counted (audit_requests_total{result="dropped"}).
+type RequestAuditor struct {
+	DB            *pgxpool.Pool
+	BatchSize     int
+	FlushInterval time.Duration
+	skip          map[string]bool
+	queue         chan RequestAuditRecord
+	write         func(ctx context.Context, records []RequestAuditRecord) error
+}
+
+func NewRequestAuditor(db *pgxpool.Pool, bufferSize, batchSize int, flushInterval time.Duration, skipPaths []string) *RequestAuditor {
+	a := &RequestAuditor{
+		DB:            db,
+		BatchSize:     batchSize,
+		FlushInterval: flushInterval,
+		skip:          map[string]bool{},
+		queue:         make(chan RequestAuditRecord, bufferSize),
+	}
+	for _, p := range skipPaths {
+		a.skip[strings.TrimSpace(p)] = true
+	}
+	a.write = a.insert
+	return a
+}
+
+func (a *RequestAuditor) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isStateChanging(r.Method) || a.skip[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		body := captureAuditBody(r)
+		actor := &auditActor{}
+		r = r.WithContext(context.WithValue(r.Context(), "audit_actor", actor))
+		aw := &auditResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		actor.mu.Lock()
+		rec := RequestAuditRecord{
+			TenantID:  actor.tenantID,
+			UserID:    actor.userID,
+			APIKeyID:  actor.apiKeyID,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    status,
+			Duration:  time.Since(start),
+			IP:        utils.ClientIP(r),
+			UserAgent: r.UserAgent(),
+			Timestamp: start,
+			Metadata:  map[string]interface{}{},
+		}
+		actor.mu.Unlock()
+		if net.ParseIP(rec.IP) == nil {
+			rec.IP = ""
+		}
+		switch {
+		case rec.APIKeyID != "":
+			rec.ActorType = "api_key"
+		case rec.UserID != "":
+			rec.ActorType = "user"
+		default:
+			rec.ActorType = "anonymous"
+		}
+		rec.ResourceType, rec.ResourceID = auditResource(r.URL.Path, body)
+		if reqID, _ := r.Context().Value("request_id").(string); reqID != "" {
+			rec.Metadata["request_id"] = reqID
+		}
+		if query := redactQuery(r.URL.Query()); len(query) > 0 {
+			rec.Metadata["query"] = query
+		}
+		if body.fields != nil {
+			rec.Metadata["request"] = redactFields(body.fields)
+		} else if body.size > 0 {
+			rec.Metadata["request_bytes"] = body.size
+		}
+		if status >= http.StatusBadRequest {
+			var env utils.ErrorEnvelope
+			if json.Unmarshal(aw.errBody.Bytes(), &env) == nil {
+				rec.ErrorCode, rec.ErrorMessage = env.Code, env.Message
+			}
+		}
+		a.Enqueue(rec)
+	})
+}
+
+// Enqueue queues rec without blocking; it is dropped if the queue is full.
+func (a *RequestAuditor) Enqueue(rec RequestAuditRecord) {
+	select {
+	case a.queue <- rec:
+	default:
+		metrics.AuditRequests("dropped", 1)
+	}
+}
+
+// Run inserts queued records every FlushInterval or BatchSize records,
+// whichever comes first. When ctx ends it flushes what is queued and
+// returns.
+func (a *RequestAuditor) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]RequestAuditRecord, 0, a.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		writeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := a.write(writeCtx, batch); err != nil {
+			slog.Warn("request_audit_write_failed", slog.Int("records", len(batch)), slog.Any("error", err))
+			metrics.AuditRequests("failed", len(batch))
+		} else {
+			metrics.AuditRequests("recorded", len(batch))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case rec := <-a.queue:
+					batch = append(batch, rec)
+					if len(batch) >= a.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		case rec := <-a.queue:
+			batch = append(batch, rec)
+			if len(batch) >= a.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (a *RequestAuditor) insert(ctx context.Context, records []RequestAuditRecord) error {
+	batch := &pgx.Batch{}
+	for _, rec := range records {
+		severity, result := "info", "success"
+		switch {
+		case rec.Status >= http.StatusInternalServerError:
+			severity, result = "error", "failure"
+		case rec.Status >= http.StatusBadRequest:
+			severity, result = "warning", "failure"
+		}
+		metadata, _ := json.Marshal(rec.Metadata)
+		batch.Queue(`
+			INSERT INTO audit_log (tenant_id, user_id, api_key_id, event_type, event_category, severity, actor_type, actor_id,
+				resource_type, resource_id, action, result, ip_address, user_agent, error_code, error_message,
+				request_path, request_method, response_status, duration_ms, metadata, timestamp)
+			VALUES (NULLIF($1,'')::uuid, NULLIF($2,'')::uuid, NULLIF($3,'')::uuid, 'http.request', 'request', $4, $5,
+				COALESCE(NULLIF($2,''), NULLIF($3,''))::uuid, NULLIF($6,''), NULLIF($7,'')::uuid, $8, $9,
+				NULLIF($10,'')::inet, NULLIF($11,''), NULLIF($12,''), NULLIF($13,''), $14, $15, $16, $17, $18::jsonb, $19)
+		`, rec.TenantID, rec.UserID, rec.APIKeyID, severity, rec.ActorType, truncate(rec.ResourceType, 50), rec.ResourceID,
+			truncate(rec.Method+" "+rec.Path, 100), result, rec.IP, rec.UserAgent, truncate(rec.ErrorCode, 50), rec.ErrorMessage,
+			truncate(rec.Path, 255), truncate(rec.Method, 10), rec.Status, rec.Duration.Milliseconds(), string(metadata), rec.Timestamp)
+	}
+	return a.DB.SendBatch(ctx, batch).Close()
+}
+
+func isStateChanging(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+type auditBody struct {
+	size   int
+	fields map[string]interface{}
+}
+
+// captureAuditBody reads up to maxAuditBodyBytes of a JSON object body and
+// puts the body back for the handler.
+func captureAuditBody(r *http.Request) auditBody {
+	if r.Body == nil || r.Body == http.NoBody {
+		return auditBody{}
+	}
+	head, _ := io.ReadAll(io.LimitReader(r.Body, maxAuditBodyBytes+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+
+	body := auditBody{size: len(head)}
+	if len(head) <= maxAuditBodyBytes {
+		_ = json.Unmarshal(head, &body.fields)
+	}
+	return body
+}
+
+func isSensitiveKey(key string) bool {
+	k := strings.ToLower(key)
+	if strings.HasSuffix(k, "_id") {
+		return false
+	}
+	for _, s := range auditSensitiveKeys {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactFields(fields map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if isSensitiveKey(k) {
+			out[k] = auditRedacted
+			continue
+		}
+		out[k] = redactValue(v)
+	}
+	return out
+}
+
+func redactValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return redactFields(val)
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = redactValue(item)
+		}
+		return out
+	}
+	return v
+}
+
+func redactQuery(values url.Values) map[string]string {
+	out := map[string]string{}
+	for k := range values {
+		if isSensitiveKey(k) {
+			out[k] = auditRedacted
+		} else {
+			out[k] = values.Get(k)
+		}
+	}
+	return out
+}
+
+// auditResource picks the resource of a request: the last UUID in the path
+// (type: the segment before it, e.g. /users/{id}/unlock -> users), or else
+// a UUID *_id field of the body (device_id -> device).
+func auditResource(path string, body auditBody) (string, string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := len(segments) - 1; i > 0; i-- {
+		if _, err := uuid.Parse(segments[i]); err == nil {
+			return segments[i-1], segments[i]
+		}
+	}
+	if id, ok := body.fields["device_id"].(string); ok {
+		if _, err := uuid.Parse(id); err == nil {
+			return "device", id
+		}
+	}
+	for k, v := range body.fields {
+		id, ok := v.(string)
+		if !ok || !strings.HasSuffix(k, "_id") {
+			continue
+		}
+		if _, err := uuid.Parse(id); err == nil {
+			return strings.TrimSuffix(k, "_id"), id
+		}
+	}
+	return "", ""
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// auditResponseWriter records the status and the start of error bodies.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	errBody bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusBadRequest && w.errBody.Len() < maxAuditErrorBytes {
+		w.errBody.Write(b[:min(len(b), maxAuditErrorBytes-w.errBody.Len())])
+	}
+	return w.ResponseWriter.Write(b)
+}
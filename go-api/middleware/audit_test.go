@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"context"
+	"iiot-go-api/utils"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestAuditorRecordsStateChangingRequests(t *testing.T) {
+	t.Parallel()
+
+	a := NewRequestAuditor(nil, 10, 10, time.Hour, []string{"/api/v1/telemetry"})
+	h := a.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setAuditActor(r.Context(), "tenant-1", "user-1", "")
+		body, _ := io.ReadAll(r.Body)
+		if r.Method == http.MethodPatch && !strings.Contains(string(body), "hunter2") {
+			t.Errorf("handler body = %q, want the original body", body)
+		}
+		utils.WriteErrorWithCode(w, http.StatusConflict, "last_tenant_admin", "Cannot remove the last admin")
+	}))
+
+	userID := "7f1f8f3e-9d7c-4c1a-8a43-2a4d8c0c1e11"
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/users/"+userID+"?token=abc&dry_run=1", strings.NewReader(`{"status":"suspended","password":"hunter2","mfa":{"code":"123456"}}`))
+	req.Header.Set("User-Agent", "test-agent")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		path := "/api/v1/users"
+		if method == http.MethodPost {
+			path = "/api/v1/telemetry"
+		}
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+	}
+
+	if len(a.queue) != 1 {
+		t.Fatalf("queued %d records, want 1 (GET and skipped paths are not audited)", len(a.queue))
+	}
+	rec := <-a.queue
+	if rec.ActorType != "user" || rec.UserID != "user-1" || rec.TenantID != "tenant-1" {
+		t.Fatalf("actor = %s/%s/%s", rec.ActorType, rec.UserID, rec.TenantID)
+	}
+	if rec.ResourceType != "users" || rec.ResourceID != userID {
+		t.Fatalf("resource = %s/%s", rec.ResourceType, rec.ResourceID)
+	}
+	if rec.Status != http.StatusConflict || rec.ErrorCode != "last_tenant_admin" || rec.UserAgent != "test-agent" {
+		t.Fatalf("outcome = %d %q %q", rec.Status, rec.ErrorCode, rec.UserAgent)
+	}
+	fields := rec.Metadata["request"].(map[string]interface{})
+	if fields["password"] != auditRedacted || fields["status"] != "suspended" {
+		t.Fatalf("request fields = %v", fields)
+	}
+	if nested := fields["mfa"].(map[string]interface{}); nested["code"] != auditRedacted {
+		t.Fatalf("nested fields = %v", nested)
+	}
+	if query := rec.Metadata["query"].(map[string]string); query["token"] != auditRedacted || query["dry_run"] != "1" {
+		t.Fatalf("query = %v", query)
+	}
+}
+
+func TestAuditResourceFromBody(t *testing.T) {
+	t.Parallel()
+
+	deviceID := "0b7d2a9c-3f4e-4d5a-9b6c-7d8e9f0a1b2c"
+	typ, id := auditResource("/api/v1/devices/reset", auditBody{fields: map[string]interface{}{"device_id": deviceID, "confirmation": "RESET"}})
+	if typ != "device" || id != deviceID {
+		t.Fatalf("resource = %s/%s, want device/%s", typ, id, deviceID)
+	}
+	if typ, id := auditResource("/api/v1/auth/login", auditBody{}); typ != "" || id != "" {
+		t.Fatalf("resource = %s/%s, want none", typ, id)
+	}
+}
+
+func TestRequestAuditorBatchesAndFlushesOnStop(t *testing.T) {
+	t.Parallel()
+
+	a := NewRequestAuditor(nil, 10, 2, time.Hour, nil)
+	var mu sync.Mutex
+	var batches []int
+	a.write = func(ctx context.Context, records []RequestAuditRecord) error {
+		mu.Lock()
+		batches = append(batches, len(records))
+		mu.Unlock()
+		return nil
+	}
+	for i := 0; i < 3; i++ {
+		a.Enqueue(RequestAuditRecord{Method: http.MethodPost})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	total := 0
+	for _, n := range batches {
+		if n > 2 {
+			t.Fatalf("batch of %d exceeds BatchSize", n)
+		}
+		total += n
+	}
+	if total != 3 {
+		t.Fatalf("wrote %d records (batches %v), want 3", total, batches)
+	}
+}
+
+func TestRequestAuditorDropsWhenFull(t *testing.T) {
+	t.Parallel()
+
+	a := NewRequestAuditor(nil, 1, 1, time.Hour, nil)
+	a.Enqueue(RequestAuditRecord{})
+	a.Enqueue(RequestAuditRecord{})
+	if len(a.queue) != 1 {
+		t.Fatalf("queue length = %d, want 1", len(a.queue))
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"iiot-go-api/utils"
+	"net/http"
+	"strings"
+)
+
+// Authenticator accepts either a user JWT or a tenant API key as the Bearer
+// credential. API key requests carry api_key_id, tenant_id and scopes (no
+// user_id or role); RequirePermission checks the scopes for them.
+type Authenticator struct {
+	JWT    *JWTMiddleware
+	APIKey *APIKeyMiddleware
+}
+
+func NewAuthenticator(jwt *JWTMiddleware, apiKey *APIKeyMiddleware) *Authenticator {
+	return &Authenticator{JWT: jwt, APIKey: apiKey}
+}
+
+func (a *Authenticator) Authenticate(next http.Handler) http.Handler {
+	viaJWT := a.JWT.Authenticate(next)
+	viaAPIKey := a.APIKey.Authenticate(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			utils.WriteError(w, http.StatusUnauthorized, "Missing authorization header")
+			return
+		}
+
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			utils.WriteError(w, http.StatusUnauthorized, "Invalid authorization format")
+			return
+		}
+
+		if isJWT(parts[1]) {
+			viaJWT.ServeHTTP(w, r)
+			return
+		}
+		viaAPIKey.ServeHTTP(w, r)
+	})
+}
+
+// isJWT tells a compact JWS (header.payload.signature) from an API key;
+// issued keys never contain a dot.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
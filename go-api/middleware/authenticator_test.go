@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticatorDispatch(t *testing.T) {
+	t.Parallel()
+
+	a := NewAuthenticator(NewJWTMiddleware("test-secret", nil), &APIKeyMiddleware{})
+	h := a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"missing", "", "Missing authorization header"},
+		{"wrong scheme", "ApiKey iiot_0a1b2c3d_secret", "Invalid authorization format"},
+		{"jwt", "Bearer aaa.bbb.ccc", "Invalid token"},
+		{"api key", "Bearer short", "Invalid API key"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/devices", nil)
+		if c.header != "" {
+			req.Header.Set("Authorization", c.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), c.want) {
+			t.Fatalf("%s: got %d %s, want 401 %q", c.name, w.Code, w.Body.String(), c.want)
+		}
+	}
+}
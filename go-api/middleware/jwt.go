@@ -3,16 +3,20 @@ package middleware
 import (
 	"context"
 	"iiot-go-api/utils"
+	"log/slog"
 	"net/http"
 	"strings"
+
+	"github.com/redis/go-redis/v9"
 )
 
 type JWTMiddleware struct {
 	Secret string
+	Redis  *redis.Client
 }
 
-func NewJWTMiddleware(secret string) *JWTMiddleware {
-	return &JWTMiddleware{Secret: secret}
+func NewJWTMiddleware(secret string, redisClient *redis.Client) *JWTMiddleware {
+	return &JWTMiddleware{Secret: secret, Redis: redisClient}
 }
 
 func (m *JWTMiddleware) Authenticate(next http.Handler) http.Handler {
@@ -39,6 +43,22 @@ func (m *JWTMiddleware) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Logout/session revocation and per-user revocation (role change,
+		// suspension, deletion). Fail-open on Redis errors: access tokens are
+		// short-lived and refresh re-checks the session in the DB.
+		if m.Redis != nil {
+			revoked, err := utils.IsAccessTokenRevoked(m.Redis, claims.JTI, claims.SessionID)
+			if err == nil && !revoked {
+				revoked, err = utils.IsUserTokenRevoked(m.Redis, claims.UserID, claims.IssuedAt)
+			}
+			if err != nil {
+				slog.Warn("jwt_revocation_check_failed", slog.Any("error", err))
+			} else if revoked {
+				utils.WriteError(w, http.StatusUnauthorized, "Token revoked")
+				return
+			}
+		}
+
 		// Add claims to context
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, "jwt_claims", claims)
@@ -46,6 +66,7 @@ func (m *JWTMiddleware) Authenticate(next http.Handler) http.Handler {
 		ctx = context.WithValue(ctx, "tenant_id", claims.TenantID)
 		ctx = context.WithValue(ctx, "role", claims.Role)
 		ctx = context.WithValue(ctx, "permissions", claims.Permissions)
+		setAuditActor(ctx, claims.TenantID, claims.UserID, "")
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -8,6 +8,9 @@ import (
 	"time"
 
 	"iiot-go-api/utils"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 )
 
 func TestJWTMiddlewareAccessToken(t *testing.T) {
@@ -19,7 +22,7 @@ func TestJWTMiddlewareAccessToken(t *testing.T) {
 		t.Fatalf("GenerateJWT error: %v", err)
 	}
 
-	mw := NewJWTMiddleware(secret)
+	mw := NewJWTMiddleware(secret, nil)
 	h := mw.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if got := r.Context().Value("user_id"); got != "u1" {
 			t.Fatalf("user_id in context = %v, want u1", got)
@@ -46,7 +49,7 @@ func TestJWTMiddlewareRejectsRefreshToken(t *testing.T) {
 		t.Fatalf("GenerateJWT error: %v", err)
 	}
 
-	mw := NewJWTMiddleware(secret)
+	mw := NewJWTMiddleware(secret, nil)
 	h := mw.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 	}))
@@ -76,7 +79,7 @@ func TestJWTMiddlewareRejectsTamperedToken(t *testing.T) {
 	}
 	tampered := parts[0] + "." + parts[1] + ".tampered-signature"
 
-	mw := NewJWTMiddleware(secret)
+	mw := NewJWTMiddleware(secret, nil)
 	h := mw.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 	}))
@@ -90,3 +93,93 @@ func TestJWTMiddlewareRejectsTamperedToken(t *testing.T) {
 		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
 	}
 }
+
+func TestJWTMiddlewareRejectsRevokedUserToken(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	secret := "test-jwt-secret"
+	token, err := utils.GenerateJWT(secret, "access", "u1", "t1", "user@example.com", "tenant_admin", []string{"devices:read"}, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateJWT error: %v", err)
+	}
+
+	mw := NewJWTMiddleware(secret, rdb)
+	h := mw.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/api/devices", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := serve(); code != http.StatusNoContent {
+		t.Fatalf("status before revocation = %d, want %d", code, http.StatusNoContent)
+	}
+	if err := utils.RevokeUserTokens(rdb, "u1", time.Hour); err != nil {
+		t.Fatalf("RevokeUserTokens error: %v", err)
+	}
+	if code := serve(); code != http.StatusUnauthorized {
+		t.Fatalf("status after revocation = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestJWTMiddlewareRejectsRevokedSessionAndBlacklistedToken(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	secret := "test-jwt-secret"
+	sessionToken, err := utils.GenerateSessionJWT(secret, "access", "s1", "u1", "t1", "user@example.com", "tenant_admin", []string{"devices:read"}, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateSessionJWT error: %v", err)
+	}
+	otherToken, err := utils.GenerateSessionJWT(secret, "access", "s2", "u1", "t1", "user@example.com", "tenant_admin", []string{"devices:read"}, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateSessionJWT error: %v", err)
+	}
+
+	mw := NewJWTMiddleware(secret, rdb)
+	h := mw.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/devices", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if err := utils.RevokeSession(rdb, "s1", time.Hour); err != nil {
+		t.Fatalf("RevokeSession error: %v", err)
+	}
+	if code := serve(sessionToken); code != http.StatusUnauthorized {
+		t.Fatalf("revoked session status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := serve(otherToken); code != http.StatusNoContent {
+		t.Fatalf("other session status = %d, want %d", code, http.StatusNoContent)
+	}
+
+	claims, err := utils.ValidateJWT(secret, otherToken)
+	if err != nil {
+		t.Fatalf("ValidateJWT error: %v", err)
+	}
+	if claims.SessionID != "s2" {
+		t.Fatalf("sid = %q, want s2", claims.SessionID)
+	}
+	if err := utils.BlacklistToken(rdb, claims.JTI, time.Hour); err != nil {
+		t.Fatalf("BlacklistToken error: %v", err)
+	}
+	if code := serve(otherToken); code != http.StatusUnauthorized {
+		t.Fatalf("blacklisted token status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
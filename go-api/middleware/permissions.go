@@ -5,9 +5,21 @@ import (
 	"net/http"
 )
 
+// RequirePermission checks the permissions claim of a JWT, or the scopes of
+// an API key (see Authenticator). Scopes never imply system:admin.
 func RequirePermission(permission string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiKeyID, _ := r.Context().Value("api_key_id").(string); apiKeyID != "" {
+				scopes, _ := r.Context().Value("scopes").([]string)
+				if !containsString(scopes, permission) {
+					utils.WriteError(w, http.StatusForbidden, "API key lacks scope "+permission)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			permissions, ok := r.Context().Value("permissions").([]string)
 			if !ok {
 				utils.WriteError(w, http.StatusForbidden, "No permissions found")
@@ -32,3 +44,29 @@ func RequirePermission(permission string) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// RequireScope restricts API key requests to keys carrying scope; JWT
+// requests pass through (their permissions are checked separately).
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiKeyID, _ := r.Context().Value("api_key_id").(string); apiKeyID != "" {
+				scopes, _ := r.Context().Value("scopes").([]string)
+				if !containsString(scopes, scope) {
+					utils.WriteError(w, http.StatusForbidden, "API key lacks scope "+scope)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
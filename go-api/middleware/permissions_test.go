@@ -30,3 +30,38 @@ func TestRequirePermission(t *testing.T) {
 		t.Fatalf("status = %d, want %d", wDenied.Code, http.StatusForbidden)
 	}
 }
+
+func TestRequirePermissionWithAPIKeyScopes(t *testing.T) {
+	t.Parallel()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	apiKeyRequest := func(scopes ...string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/telemetry/latest", nil)
+		ctx := context.WithValue(req.Context(), "api_key_id", "key-1")
+		ctx = context.WithValue(ctx, "scopes", scopes)
+		return req.WithContext(ctx)
+	}
+
+	cases := []struct {
+		name    string
+		handler http.Handler
+		req     *http.Request
+		status  int
+	}{
+		{"scope held", RequirePermission("telemetry:read")(ok), apiKeyRequest("telemetry:read"), http.StatusNoContent},
+		{"scope missing", RequirePermission("devices:read")(ok), apiKeyRequest("telemetry:read"), http.StatusForbidden},
+		{"no admin bypass", RequirePermission("devices:read")(ok), apiKeyRequest("system:admin"), http.StatusForbidden},
+		{"require scope", RequireScope("telemetry:write")(ok), apiKeyRequest("telemetry:read"), http.StatusForbidden},
+		{"require scope held", RequireScope("telemetry:write")(ok), apiKeyRequest("telemetry:write"), http.StatusNoContent},
+		{"require scope jwt", RequireScope("telemetry:write")(ok), httptest.NewRequest(http.MethodGet, "/api/v1/telemetry", nil), http.StatusNoContent},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		c.handler.ServeHTTP(w, c.req)
+		if w.Code != c.status {
+			t.Fatalf("%s: status = %d, want %d", c.name, w.Code, c.status)
+		}
+	}
+}
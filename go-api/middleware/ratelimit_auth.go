@@ -1,11 +1,15 @@
 package middleware
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"iiot-go-api/metrics"
 	"iiot-go-api/utils"
-	"net"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -36,49 +40,79 @@ func (rl *RateLimitAuth) Limit(next http.Handler) http.Handler {
 			return
 		}
 
-		// Get client IP (handle X-Forwarded-For for proxies)
-		clientIP := r.RemoteAddr
-		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-			// Use only the first IP in the list
-			parts := strings.Split(xff, ",")
-			clientIP = strings.TrimSpace(parts[0])
-		}
-		if xri := r.Header.Get("X-Real-IP"); xri != "" {
-			clientIP = xri
-		}
+		clientIP := utils.ClientIP(r)
 
-		// Remove port from IP address
-		if host, _, err := net.SplitHostPort(clientIP); err == nil {
-			clientIP = host
+		if !rl.allow(w, r, fmt.Sprintf("rl:auth:%s", clientIP)) {
+			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		// Redis key for rate limiting
-		key := fmt.Sprintf("rl:auth:%s", clientIP)
-		ctx := context.Background()
+// LimitByEmail limits requests per target account, read from the "email"
+// field of the JSON body (the body is restored for the handler). Requests
+// without an email pass through; the handler validates them.
+func (rl *RateLimitAuth) LimitByEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rl.Redis == nil || r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		// Increment counter
-		val, err := rl.Redis.Incr(ctx, key).Result()
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRateLimitBodyBytes))
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
 		if err != nil {
-			// On Redis error, fail open (allow request)
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Set expiration on first request
-		if val == 1 {
-			rl.Redis.Expire(ctx, key, time.Duration(rl.WindowSecs)*time.Second)
+		var payload struct {
+			Email string `json:"email"`
 		}
-
-		// Check if limit exceeded
-		if val > rl.MaxAttempts {
-			metrics.AuthRateLimited(r.URL.Path)
-			// Get TTL for Retry-After header
-			ttl, _ := rl.Redis.TTL(ctx, key).Result()
-			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(ttl.Seconds())))
-			utils.WriteError(w, http.StatusTooManyRequests, "Too many authentication attempts. Please try again later.")
+		_ = json.Unmarshal(body, &payload)
+		email := strings.ToLower(strings.TrimSpace(payload.Email))
+		if email == "" {
+			next.ServeHTTP(w, r)
 			return
 		}
 
+		// Hash keeps addresses out of Redis keys.
+		sum := sha256.Sum256([]byte(email))
+		if !rl.allow(w, r, fmt.Sprintf("rl:auth:email:%s", hex.EncodeToString(sum[:]))) {
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+const maxRateLimitBodyBytes = 64 << 10
+
+// allow counts one attempt under key, writing 429 once the window is
+// exhausted. Redis errors fail open.
+func (rl *RateLimitAuth) allow(w http.ResponseWriter, r *http.Request, key string) bool {
+	ctx := context.Background()
+
+	// Increment counter
+	val, err := rl.Redis.Incr(ctx, key).Result()
+	if err != nil {
+		// On Redis error, fail open (allow request)
+		return true
+	}
+
+	// Set expiration on first request
+	if val == 1 {
+		rl.Redis.Expire(ctx, key, time.Duration(rl.WindowSecs)*time.Second)
+	}
+
+	// Check if limit exceeded
+	if val > rl.MaxAttempts {
+		metrics.AuthRateLimited(r.URL.Path)
+		// Get TTL for Retry-After header
+		ttl, _ := rl.Redis.TTL(ctx, key).Result()
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(ttl.Seconds())))
+		utils.WriteError(w, http.StatusTooManyRequests, "Too many authentication attempts. Please try again later.")
+		return false
+	}
+	return true
+}
@@ -1,9 +1,14 @@
 package middleware
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 )
 
 func TestRateLimitAuthAllowsWhenRedisNil(t *testing.T) {
@@ -22,3 +27,36 @@ func TestRateLimitAuthAllowsWhenRedisNil(t *testing.T) {
 		t.Fatalf("status = %d, want %d", w.Code, http.StatusNoContent)
 	}
 }
+
+func TestRateLimitAuthLimitByEmail(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	rl := NewRateLimitAuth(rdb, 1, 60)
+	h := rl.LimitByEmail(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "victim@example.com") {
+			t.Fatalf("body not restored for handler: %q", body)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	serve := func(remoteAddr, email string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/password/forgot", strings.NewReader(`{"email":"`+email+`"}`))
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := serve("10.0.0.1:1234", "victim@example.com"); code != http.StatusAccepted {
+		t.Fatalf("first request status = %d, want %d", code, http.StatusAccepted)
+	}
+	// Same account from another IP is still limited (case-insensitive).
+	if code := serve("10.0.0.2:1234", "Victim@Example.com"); code != http.StatusTooManyRequests {
+		t.Fatalf("second request status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
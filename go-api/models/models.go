@@ -7,14 +7,16 @@ import (
 
 // User represents a user in the system
 type User struct {
-	UserID       string     `json:"user_id" db:"user_id"`
-	TenantID     *string    `json:"tenant_id" db:"tenant_id"`
-	Email        string     `json:"email" db:"email"`
-	PasswordHash string     `json:"-" db:"password_hash"`
-	Role         string     `json:"role" db:"role"`
-	Status       string     `json:"status" db:"status"`
-	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
-	LastLoginAt  *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+	UserID        string     `json:"user_id" db:"user_id"`
+	TenantID      *string    `json:"tenant_id" db:"tenant_id"`
+	Email         string     `json:"email" db:"email"`
+	PasswordHash  string     `json:"-" db:"password_hash"`
+	Role          string     `json:"role" db:"role"`
+	Status        string     `json:"status" db:"status"`
+	EmailVerified bool       `json:"email_verified" db:"email_verified"`
+	CustomRoleID  *string    `json:"custom_role_id,omitempty" db:"custom_role_id"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	LastLoginAt   *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
 }
 
 // Device represents a device in the system
@@ -39,6 +41,7 @@ type Device struct {
 type JWTClaims struct {
 	JTI         string   `json:"jti"`
 	TokenType   string   `json:"token_type"`
+	SessionID   string   `json:"sid,omitempty"`
 	UserID      string   `json:"user_id"`
 	TenantID    string   `json:"tenant_id"`
 	Email       string   `json:"email"`
@@ -54,12 +57,15 @@ type RegisterRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
-// RegisterResponse represents a registration response
+// RegisterResponse represents a registration response. Tokens are omitted
+// when the unverified-login policy is "deny".
 type RegisterResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresIn    int64  `json:"expires_in"`
-	User         User   `json:"user"`
+	AccessToken           string `json:"access_token,omitempty"`
+	RefreshToken          string `json:"refresh_token,omitempty"`
+	ExpiresIn             int64  `json:"expires_in,omitempty"`
+	User                  User   `json:"user"`
+	Message               string `json:"message,omitempty"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
 }
 
 // LoginRequest represents a login request
@@ -70,10 +76,11 @@ type LoginRequest struct {
 
 // LoginResponse represents a login response
 type LoginResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresIn    int64  `json:"expires_in"`
-	User         User   `json:"user"`
+	AccessToken           string `json:"access_token"`
+	RefreshToken          string `json:"refresh_token"`
+	ExpiresIn             int64  `json:"expires_in"`
+	User                  User   `json:"user"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
 }
 
 // RefreshRequest represents a token refresh request
@@ -83,9 +90,10 @@ type RefreshRequest struct {
 
 // RefreshResponse represents a token refresh response
 type RefreshResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresIn    int64  `json:"expires_in"`
+	AccessToken           string `json:"access_token"`
+	RefreshToken          string `json:"refresh_token"`
+	ExpiresIn             int64  `json:"expires_in"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
 }
 
 // ClaimDeviceRequest represents a device claim request
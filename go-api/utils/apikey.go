@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// APIKeyPrefix marks keys issued by the API ("iiot_<8 hex>_<secret>"), so
+// they are recognizable in logs and by secret scanners.
+const APIKeyPrefix = "iiot_"
+
+// apiKeyLookupLen is the stored key_prefix of issued keys ("iiot_" + id).
+const apiKeyLookupLen = len(APIKeyPrefix) + 8
+
+// GenerateAPIKey returns a new key and its lookup prefix (stored in
+// api_keys.key_prefix; the key itself is only stored as a bcrypt hash).
+func GenerateAPIKey() (key, prefix string, err error) {
+	id := make([]byte, 4)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	prefix = APIKeyPrefix + hex.EncodeToString(id)
+	return prefix + "_" + base64.RawURLEncoding.EncodeToString(secret), prefix, nil
+}
+
+// APIKeyLookupPrefix is the key_prefix to look a key up by: the "iiot_<id>"
+// part of issued keys, the first 8 characters of legacy keys.
+func APIKeyLookupPrefix(key string) string {
+	if strings.HasPrefix(key, APIKeyPrefix) && len(key) > apiKeyLookupLen && key[apiKeyLookupLen] == '_' {
+		return key[:apiKeyLookupLen]
+	}
+	if len(key) < 8 {
+		return key
+	}
+	return key[:8]
+}
+
+// APIKeyCacheKey is the Redis key caching a validated API key. It holds a
+// hash of the key, never the key itself.
+func APIKeyCacheKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "apikey:valid:" + hex.EncodeToString(sum[:])
+}
+
+func apiKeyCacheIndexKey(keyID string) string {
+	return "apikey:cached:" + keyID
+}
+
+// CacheAPIKey stores the validated key data for ttl and indexes the entry
+// by key id so PurgeAPIKeyCache can drop it.
+func CacheAPIKey(ctx context.Context, rdb *redis.Client, key, keyID string, data []byte, ttl time.Duration) error {
+	cacheKey := APIKeyCacheKey(key)
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, cacheKey, data, ttl)
+	pipe.SAdd(ctx, apiKeyCacheIndexKey(keyID), cacheKey)
+	pipe.Expire(ctx, apiKeyCacheIndexKey(keyID), ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// PurgeAPIKeyCache drops the cached validations of keyID, so a revoked or
+// rotated key is re-checked against the database on its next use.
+func PurgeAPIKeyCache(ctx context.Context, rdb *redis.Client, keyID string) error {
+	indexKey := apiKeyCacheIndexKey(keyID)
+	cacheKeys, err := rdb.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return err
+	}
+	return rdb.Del(ctx, append(cacheKeys, indexKey)...).Err()
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	t.Parallel()
+
+	key, prefix, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey error: %v", err)
+	}
+	if !strings.HasPrefix(key, prefix+"_") || !strings.HasPrefix(prefix, APIKeyPrefix) || len(prefix) != 13 {
+		t.Fatalf("key %q / prefix %q: unexpected format", key, prefix)
+	}
+	if got := APIKeyLookupPrefix(key); got != prefix {
+		t.Fatalf("APIKeyLookupPrefix = %q, want %q", got, prefix)
+	}
+	if other, _, _ := GenerateAPIKey(); other == key {
+		t.Fatal("GenerateAPIKey returned the same key twice")
+	}
+	if got := APIKeyLookupPrefix("legacykey-0123456789"); got != "legacyke" {
+		t.Fatalf("legacy lookup prefix = %q, want first 8 characters", got)
+	}
+}
+
+func TestPurgeAPIKeyCache(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+
+	if err := CacheAPIKey(ctx, rdb, "iiot_aaaaaaaa_one", "key-1", []byte(`{}`), time.Hour); err != nil {
+		t.Fatalf("CacheAPIKey error: %v", err)
+	}
+	if err := CacheAPIKey(ctx, rdb, "iiot_bbbbbbbb_two", "key-2", []byte(`{}`), time.Hour); err != nil {
+		t.Fatalf("CacheAPIKey error: %v", err)
+	}
+	if mr.Exists("apikey:valid:iiot_aaaaaaaa_one") {
+		t.Fatal("cache key must not contain the plaintext key")
+	}
+
+	if err := PurgeAPIKeyCache(ctx, rdb, "key-1"); err != nil {
+		t.Fatalf("PurgeAPIKeyCache error: %v", err)
+	}
+	if mr.Exists(APIKeyCacheKey("iiot_aaaaaaaa_one")) {
+		t.Fatal("revoked key still cached")
+	}
+	if !mr.Exists(APIKeyCacheKey("iiot_bbbbbbbb_two")) {
+		t.Fatal("purge dropped another key's cache")
+	}
+}
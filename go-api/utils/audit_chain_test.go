@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditCheckpointSignature(t *testing.T) {
+	t.Parallel()
+
+	chain := "7f1f8f3e-9d7c-4c1a-8a43-2a4d8c0c1e11"
+	at := time.Date(2026, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	sig := SignAuditCheckpoint("checkpoint-key", chain, 42, "abc123", at)
+
+	// Postgres keeps microseconds: the stored time must still verify.
+	if !VerifyAuditCheckpoint("checkpoint-key", chain, 42, "abc123", at.Truncate(time.Microsecond).In(time.FixedZone("BRT", -3*3600)), sig) {
+		t.Fatal("signature rejected after a timestamp round trip")
+	}
+	for name, ok := range map[string]bool{
+		"key":   VerifyAuditCheckpoint("other-key", chain, 42, "abc123", at, sig),
+		"seq":   VerifyAuditCheckpoint("checkpoint-key", chain, 43, "abc123", at, sig),
+		"hash":  VerifyAuditCheckpoint("checkpoint-key", chain, 42, "abc124", at, sig),
+		"chain": VerifyAuditCheckpoint("checkpoint-key", "00000000-0000-0000-0000-000000000000", 42, "abc123", at, sig),
+		"time":  VerifyAuditCheckpoint("checkpoint-key", chain, 42, "abc123", at.Add(time.Second), sig),
+	} {
+		if ok {
+			t.Fatalf("signature accepted with a different %s", name)
+		}
+	}
+	if AuditCheckpointKeyID("checkpoint-key") == AuditCheckpointKeyID("other-key") || len(AuditCheckpointKeyID("k")) != 16 {
+		t.Fatal("AuditCheckpointKeyID must be a 16 hex digest of the key")
+	}
+}
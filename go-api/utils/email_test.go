@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestBuildEmailMessage(t *testing.T) {
+	t.Parallel()
+
+	msg := string(buildEmailMessage("noreply@example.com", []string{"a@example.com", "b@example.com"}, "Hello", "line1\nline2"))
+	for _, want := range []string{
+		"From: noreply@example.com\r\n",
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: Hello\r\n",
+		"\r\n\r\nline1\r\nline2",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("message missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestBuildEmailMessageEncodesSubject(t *testing.T) {
+	t.Parallel()
+
+	msg := string(buildEmailMessage("noreply@example.com", []string{"a@example.com"}, "Redefinição de senha", "corpo"))
+	header := msg[:strings.Index(msg, "\r\n\r\n")]
+	for i := 0; i < len(header); i++ {
+		if header[i] > 0x7f {
+			t.Fatalf("header has raw non-ASCII bytes:\n%s", header)
+		}
+	}
+	for _, want := range []string{
+		"Subject: =?utf-8?q?Redefini=C3=A7=C3=A3o_de_senha?=\r\n",
+		"MIME-Version: 1.0\r\n",
+		"Content-Type: text/plain; charset=UTF-8\r\n",
+	} {
+		if !strings.Contains(header, want) {
+			t.Fatalf("header missing %q:\n%s", want, header)
+		}
+	}
+}
+
+func TestSendEmailRejectsHeaderInjection(t *testing.T) {
+	t.Parallel()
+
+	cfg := SMTPConfig{Host: "127.0.0.1", Port: "1", From: "noreply@example.com"}
+	if err := SendEmail(context.Background(), cfg, []string{"a@example.com\r\nBcc: x@example.com"}, "s", "b"); err == nil {
+		t.Fatalf("expected error for recipient with CRLF")
+	}
+	if err := SendEmail(context.Background(), cfg, []string{"a@example.com"}, "s\r\nBcc: x@example.com", "b"); err == nil {
+		t.Fatalf("expected error for subject with CRLF")
+	}
+}
+
+func TestSendEmailNoopWithoutHost(t *testing.T) {
+	t.Parallel()
+
+	if err := SendEmail(context.Background(), SMTPConfig{}, []string{"a@example.com"}, "s", "b"); err != nil {
+		t.Fatalf("SendEmail without host = %v, want nil", err)
+	}
+}
@@ -20,6 +20,12 @@ var (
 
 // GenerateJWT generates a JWT token with a unique JTI and token_type
 func GenerateJWT(secret, tokenType, userID, tenantID, email, role string, permissions []string, expiration time.Duration) (string, error) {
+	return GenerateSessionJWT(secret, tokenType, "", userID, tenantID, email, role, permissions, expiration)
+}
+
+// GenerateSessionJWT is GenerateJWT bound to a server-side session (sid
+// claim), so revoking the session invalidates the token.
+func GenerateSessionJWT(secret, tokenType, sessionID, userID, tenantID, email, role string, permissions []string, expiration time.Duration) (string, error) {
 	now := time.Now()
 	jti := uuid.New().String() // Generate unique token ID
 
@@ -34,19 +40,56 @@ func GenerateJWT(secret, tokenType, userID, tenantID, email, role string, permis
 		"exp":         now.Add(expiration).Unix(),
 		"iat":         now.Unix(),
 	}
+	if sessionID != "" {
+		claims["sid"] = sessionID
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return signJWT(jwtKeys.Load(), secret, claims)
+}
+
+// signJWT signs with the current asymmetric key of ks (kid header), or with
+// HS256 and secret when ks is nil or configured for HS256.
+func signJWT(ks *JWTKeySet, secret string, claims jwt.MapClaims) (string, error) {
+	if ks == nil || ks.Algorithm() == JWTAlgHS256 {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(secret))
+	}
+	key, ok := ks.SigningKey(time.Now())
+	if !ok {
+		return "", ErrNoSigningKey
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.KID
+	return token.SignedString(key.PrivateKey)
 }
 
 // ValidateJWT validates a JWT token and returns typed claims
 func ValidateJWT(secret, tokenString string) (*models.JWTClaims, error) {
+	return validateJWT(jwtKeys.Load(), secret, tokenString)
+}
+
+func validateJWT(ks *JWTKeySet, secret, tokenString string) (*models.JWTClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, ErrInvalidToken
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			// HS256 stays valid until JWT_ACCEPT_HS256 is turned off
+			if ks != nil && !ks.AcceptsHS256() {
+				return nil, ErrInvalidToken
+			}
+			return []byte(secret), nil
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodEd25519:
+			if ks == nil {
+				return nil, ErrInvalidToken
+			}
+			kid, _ := token.Header["kid"].(string)
+			key, ok := ks.VerificationKey(kid, token.Method.Alg(), time.Now())
+			if !ok {
+				return nil, ErrInvalidToken
+			}
+			return key.PrivateKey.Public(), nil
 		}
-		return []byte(secret), nil
-	})
+		return nil, ErrInvalidToken
+	}, jwt.WithValidMethods([]string{JWTAlgHS256, JWTAlgRS256, JWTAlgEdDSA}))
 
 	if err != nil {
 		return nil, err
@@ -89,6 +132,14 @@ func IsTokenBlacklisted(redisClient *redis.Client, jti string) (bool, error) {
 	return exists > 0, nil
 }
 
+// Blacklist values tell why a JTI is blacklisted: revoked (logout) or
+// spent by refresh rotation (ClaimToken). Only a spent refresh token coming
+// back is a replay.
+const (
+	blacklistRevoked = "1"
+	blacklistRotated = "rotated"
+)
+
 // BlacklistToken adds a token JTI to the blacklist with TTL
 func BlacklistToken(redisClient *redis.Client, jti string, ttl time.Duration) error {
 	if jti == "" {
@@ -98,9 +149,115 @@ func BlacklistToken(redisClient *redis.Client, jti string, ttl time.Duration) er
 	ctx := context.Background()
 	key := fmt.Sprintf("jwt:blacklist:%s", jti)
 
+	return redisClient.Set(ctx, key, blacklistRevoked, ttl).Err()
+}
+
+// IsTokenRotated reports whether a blacklisted JTI was spent by refresh
+// rotation (ClaimToken) rather than revoked by a logout.
+func IsTokenRotated(redisClient *redis.Client, jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+
+	ctx := context.Background()
+	key := fmt.Sprintf("jwt:blacklist:%s", jti)
+
+	value, err := redisClient.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return value == blacklistRotated, nil
+}
+
+// ClaimToken atomically blacklists a token JTI (SET NX) and reports whether
+// this caller was the first to do so. A false result means the token was
+// already used or revoked (see IsTokenRotated).
+func ClaimToken(redisClient *redis.Client, jti string, ttl time.Duration) (bool, error) {
+	if jti == "" {
+		return true, nil // No JTI = old token, allow for backward compatibility
+	}
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+
+	ctx := context.Background()
+	key := fmt.Sprintf("jwt:blacklist:%s", jti)
+
+	return redisClient.SetNX(ctx, key, blacklistRotated, ttl).Result()
+}
+
+// RevokeSession marks a session as revoked so access tokens bound to it are
+// rejected before they expire. ttl should cover the access token lifetime.
+func RevokeSession(redisClient *redis.Client, sessionID string, ttl time.Duration) error {
+	if sessionID == "" {
+		return nil
+	}
+
+	ctx := context.Background()
+	key := fmt.Sprintf("jwt:session_revoked:%s", sessionID)
+
 	return redisClient.Set(ctx, key, "1", ttl).Err()
 }
 
+// IsAccessTokenRevoked reports whether the token JTI is blacklisted or its
+// session revoked (single round trip).
+func IsAccessTokenRevoked(redisClient *redis.Client, jti, sessionID string) (bool, error) {
+	keys := make([]string, 0, 2)
+	if jti != "" {
+		keys = append(keys, fmt.Sprintf("jwt:blacklist:%s", jti))
+	}
+	if sessionID != "" {
+		keys = append(keys, fmt.Sprintf("jwt:session_revoked:%s", sessionID))
+	}
+	if len(keys) == 0 {
+		return false, nil
+	}
+
+	exists, err := redisClient.Exists(context.Background(), keys...).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return exists > 0, nil
+}
+
+// RevokeUserTokens invalidates every token issued to a user up to now (role
+// change, suspension, deletion). ttl should cover the longest token lifetime.
+func RevokeUserTokens(redisClient *redis.Client, userID string, ttl time.Duration) error {
+	if userID == "" {
+		return nil
+	}
+
+	ctx := context.Background()
+	key := fmt.Sprintf("jwt:user_revoked:%s", userID)
+
+	return redisClient.Set(ctx, key, time.Now().Unix(), ttl).Err()
+}
+
+// IsUserTokenRevoked reports whether a token issued at issuedAt (unix seconds)
+// predates the user's last revocation.
+func IsUserTokenRevoked(redisClient *redis.Client, userID string, issuedAt int64) (bool, error) {
+	if userID == "" {
+		return false, nil
+	}
+
+	ctx := context.Background()
+	key := fmt.Sprintf("jwt:user_revoked:%s", userID)
+
+	revokedAt, err := redisClient.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return issuedAt <= revokedAt, nil
+}
+
 // parseJWTClaims converts jwt.MapClaims to models.JWTClaims
 func parseJWTClaims(m jwt.MapClaims) (*models.JWTClaims, error) {
 	claims := &models.JWTClaims{}
@@ -112,6 +269,9 @@ func parseJWTClaims(m jwt.MapClaims) (*models.JWTClaims, error) {
 	if tokenType, ok := m["token_type"].(string); ok {
 		claims.TokenType = tokenType
 	}
+	if sid, ok := m["sid"].(string); ok {
+		claims.SessionID = sid
+	}
 
 	// Required fields
 	userID, ok := m["user_id"].(string)
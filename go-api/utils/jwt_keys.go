@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// JWT signing algorithms. HS256 signs with JWT_SECRET (legacy); RS256 and
+// EdDSA sign with rotating key pairs published at /.well-known/jwks.json.
+const (
+	JWTAlgHS256 = "HS256"
+	JWTAlgRS256 = "RS256"
+	JWTAlgEdDSA = "EdDSA"
+)
+
+var ErrNoSigningKey = errors.New("no active JWT signing key")
+
+// JWTKey is an asymmetric signing key. It signs from NotBefore until a newer
+// key takes over and verifies until ExpiresAt (nil while it is current).
+type JWTKey struct {
+	KID        string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	NotBefore  time.Time
+	ExpiresAt  *time.Time
+}
+
+// JWTKeySet is the set of keys used to sign and verify tokens. It is safe
+// for concurrent use; Replace swaps the keys after a reload.
+type JWTKeySet struct {
+	algorithm   string
+	acceptHS256 bool
+
+	mu   sync.RWMutex
+	keys []JWTKey
+}
+
+// NewJWTKeySet creates an empty set signing with algorithm. acceptHS256
+// keeps HS256 tokens valid (migration from JWT_SECRET signing).
+func NewJWTKeySet(algorithm string, acceptHS256 bool) (*JWTKeySet, error) {
+	switch algorithm {
+	case JWTAlgHS256, JWTAlgRS256, JWTAlgEdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported JWT signing algorithm %q", algorithm)
+	}
+	return &JWTKeySet{algorithm: algorithm, acceptHS256: acceptHS256}, nil
+}
+
+func (s *JWTKeySet) Algorithm() string {
+	return s.algorithm
+}
+
+// AcceptsHS256 reports whether tokens signed with JWT_SECRET are valid.
+func (s *JWTKeySet) AcceptsHS256() bool {
+	return s.algorithm == JWTAlgHS256 || s.acceptHS256
+}
+
+func (s *JWTKeySet) Replace(keys []JWTKey) {
+	s.mu.Lock()
+	s.keys = append([]JWTKey(nil), keys...)
+	s.mu.Unlock()
+}
+
+// SigningKey returns the newest key of the configured algorithm whose
+// NotBefore has passed. Keys published ahead of time are skipped.
+func (s *JWTKeySet) SigningKey(now time.Time) (JWTKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var best JWTKey
+	found := false
+	for _, k := range s.keys {
+		if k.Algorithm != s.algorithm || k.PrivateKey == nil || k.NotBefore.After(now) || k.expired(now) {
+			continue
+		}
+		if !found || k.NotBefore.After(best.NotBefore) {
+			best, found = k, true
+		}
+	}
+	return best, found
+}
+
+// VerificationKey looks up an unexpired key by kid and algorithm.
+func (s *JWTKeySet) VerificationKey(kid, algorithm string, now time.Time) (JWTKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if k.KID == kid && k.Algorithm == algorithm && !k.expired(now) {
+			return k, true
+		}
+	}
+	return JWTKey{}, false
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes every unexpired key, including keys that will start
+// signing later, so verifiers learn them before they are used.
+func (s *JWTKeySet) JWKS(now time.Time) JWKS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range s.keys {
+		if k.expired(now) || k.PrivateKey == nil {
+			continue
+		}
+		if jwk, ok := publicJWK(k); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+func (k JWTKey) expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+func publicJWK(k JWTKey) (JWK, bool) {
+	b64 := base64.RawURLEncoding
+	switch pub := k.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", Kid: k.KID, Use: "sig", Alg: k.Algorithm, N: b64.EncodeToString(pub.N.Bytes()), E: b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())}, true
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Kid: k.KID, Use: "sig", Alg: k.Algorithm, Crv: "Ed25519", X: b64.EncodeToString(pub)}, true
+	}
+	return JWK{}, false
+}
+
+// GenerateJWTKey creates a key pair for algorithm (RSA 2048 for RS256,
+// Ed25519 for EdDSA). The kid is derived from the public key.
+func GenerateJWTKey(algorithm string, notBefore time.Time) (JWTKey, error) {
+	var priv crypto.Signer
+	switch algorithm {
+	case JWTAlgRS256:
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return JWTKey{}, err
+		}
+		priv = k
+	case JWTAlgEdDSA:
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return JWTKey{}, err
+		}
+		priv = k
+	default:
+		return JWTKey{}, fmt.Errorf("unsupported JWT signing algorithm %q", algorithm)
+	}
+	kid, err := jwtKeyID(priv)
+	if err != nil {
+		return JWTKey{}, err
+	}
+	return JWTKey{KID: kid, Algorithm: algorithm, PrivateKey: priv, NotBefore: notBefore}, nil
+}
+
+// MarshalJWTPrivateKey encodes a private key as PKCS#8 PEM.
+func MarshalJWTPrivateKey(k crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParseJWTPrivateKey decodes a key produced by MarshalJWTPrivateKey.
+func ParseJWTPrivateKey(pemKey string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("invalid PEM private key")
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+func jwtKeyID(k crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(k.Public())
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
+
+var jwtKeys atomic.Pointer[JWTKeySet]
+
+// SetJWTKeySet makes GenerateJWT/ValidateJWT use ks. With no set (or an
+// HS256 set without keys) tokens are signed with the shared secret.
+func SetJWTKeySet(ks *JWTKeySet) {
+	jwtKeys.Store(ks)
+}
+
+// CurrentJWTKeySet returns the set installed by SetJWTKeySet, or nil.
+func CurrentJWTKeySet() *JWTKeySet {
+	return jwtKeys.Load()
+}
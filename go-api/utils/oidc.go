@@ -0,0 +1,342 @@
+package utils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidIDToken     = errors.New("invalid id token")
+	ErrOIDCURLNotAllowed  = errors.New("oidc url not allowed")
+	errOIDCAddrNotAllowed = fmt.Errorf("%w: private or loopback address", ErrOIDCURLNotAllowed)
+	oidcBlockedIPv4Ranges = mustParseCIDRs([]string{"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15"})
+)
+
+// IdP URLs are chosen by tenant admins, so the server must not be usable to
+// reach internal services: only https, and the dialer refuses loopback,
+// private, link-local (cloud metadata) and other non-public addresses after
+// DNS resolution. allowInsecure (OIDC_ALLOW_INSECURE_ISSUERS, dev/test only)
+// lifts both rules.
+var (
+	oidcHTTPClient         = newOIDCHTTPClient(false)
+	oidcInsecureHTTPClient = newOIDCHTTPClient(true)
+)
+
+// newOIDCHTTPClient talks to identity providers; they are external
+// services, so every call is bounded.
+func newOIDCHTTPClient(allowInsecure bool) *http.Client {
+	if allowInsecure {
+		return &http.Client{Timeout: 10 * time.Second}
+	}
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !oidcPublicIP(ip) {
+				return errOIDCAddrNotAllowed
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		// No proxy: the dialer check must see the IdP address itself.
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("oidc: too many redirects")
+			}
+			return checkOIDCURL(req.URL.String(), false)
+		},
+	}
+}
+
+func oidcClient(allowInsecure bool) *http.Client {
+	if allowInsecure {
+		return oidcInsecureHTTPClient
+	}
+	return oidcHTTPClient
+}
+
+// oidcPublicIP reports whether ip is a public unicast address.
+func oidcPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range oidcBlockedIPv4Ranges {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkOIDCURL requires an absolute https URL whose host, when it is an IP
+// literal, is public (names are checked again at dial time).
+func checkOIDCURL(rawURL string, allowInsecure bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%w: %q", ErrOIDCURLNotAllowed, rawURL)
+	}
+	if allowInsecure {
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return fmt.Errorf("%w: scheme %q", ErrOIDCURLNotAllowed, u.Scheme)
+		}
+		return nil
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("%w: https required", ErrOIDCURLNotAllowed)
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return errOIDCAddrNotAllowed
+	}
+	if ip := net.ParseIP(host); ip != nil && !oidcPublicIP(ip) {
+		return errOIDCAddrNotAllowed
+	}
+	return nil
+}
+
+// OIDCProvider holds the endpoints published in the issuer's discovery
+// document.
+type OIDCProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	allowInsecure bool
+}
+
+// DiscoverOIDC fetches {issuer}/.well-known/openid-configuration and checks
+// that it belongs to issuer. Unless allowInsecure, the issuer and the
+// endpoints it publishes must be https URLs on public hosts
+// (ErrOIDCURLNotAllowed).
+func DiscoverOIDC(ctx context.Context, issuer string, allowInsecure bool) (*OIDCProvider, error) {
+	issuer = strings.TrimRight(issuer, "/")
+	if err := checkOIDCURL(issuer, allowInsecure); err != nil {
+		return nil, err
+	}
+	p := OIDCProvider{allowInsecure: allowInsecure}
+	if err := oidcGetJSON(ctx, oidcClient(allowInsecure), issuer+"/.well-known/openid-configuration", &p); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc discovery issuer mismatch: %q", p.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("oidc discovery document incomplete")
+	}
+	for _, endpoint := range []string{p.TokenEndpoint, p.JWKSURI} {
+		if err := checkOIDCURL(endpoint, allowInsecure); err != nil {
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+// NewPKCEVerifier returns a random RFC 7636 code verifier.
+func NewPKCEVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PKCEChallenge is the S256 code challenge of verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthorizationURL builds the authorization-code request with PKCE (S256).
+func (p *OIDCProvider) AuthorizationURL(clientID, redirectURI, state, nonce, verifier string) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", clientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", "openid email profile")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", PKCEChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// ExchangeCode redeems an authorization code at the token endpoint and
+// returns the ID token. clientSecret may be empty (public client).
+func (p *OIDCProvider) ExchangeCode(ctx context.Context, clientID, clientSecret, code, redirectURI, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", clientID)
+	form.Set("code_verifier", verifier)
+	if clientSecret != "" {
+		form.Set("client_secret", clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oidcClient(p.allowInsecure).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("oidc token endpoint status=%d", resp.StatusCode)
+	}
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc token response without id_token")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks the ID token signature against the provider's JWKS
+// (RS256 or ES256) and its iss, aud, exp and nonce claims.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, clientID, nonce string) (jwt.MapClaims, error) {
+	var set jwkSet
+	if err := oidcGetJSON(ctx, oidcClient(p.allowInsecure), p.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return set.key(kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// key returns the signing key with kid (or the only one when the token has
+// no kid).
+func (s jwkSet) key(kid string) (interface{}, error) {
+	for _, k := range s.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if kid != "" && k.Kid != kid {
+			continue
+		}
+		if kid == "" && len(s.Keys) != 1 {
+			break
+		}
+		return k.publicKey()
+	}
+	return nil, fmt.Errorf("signing key %q not found", kid)
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func oidcGetJSON(ctx context.Context, client *http.Client, rawURL string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("oidc GET %s status=%d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dst)
+}
@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockOIDCProvider is a minimal local IdP: discovery, JWKS and a token
+// endpoint that checks the PKCE verifier and returns a signed ID token.
+type mockOIDCProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	clientID  string
+	code      string
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T, clientID string) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	m := &mockOIDCProvider{key: key, clientID: clientID, code: "auth-code"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil ||
+			r.PostForm.Get("code") != m.code ||
+			r.PostForm.Get("client_id") != m.clientID ||
+			PKCEChallenge(r.PostForm.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": m.idToken(t, m.claims)})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockOIDCProvider) idToken(t *testing.T, extra jwt.MapClaims) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"iss":   m.server.URL,
+		"aud":   m.clientID,
+		"sub":   "idp-user-1",
+		"email": "ana@empresa.com",
+		"nonce": m.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatalf("SignedString error: %v", err)
+	}
+	return signed
+}
+
+func TestOIDCAuthorizationCodeFlowWithPKCE(t *testing.T) {
+	t.Parallel()
+
+	m := newMockOIDCProvider(t, "iiot-client")
+	ctx := context.Background()
+
+	p, err := DiscoverOIDC(ctx, m.server.URL+"/", true)
+	if err != nil {
+		t.Fatalf("DiscoverOIDC error: %v", err)
+	}
+	verifier, err := NewPKCEVerifier()
+	if err != nil {
+		t.Fatalf("NewPKCEVerifier error: %v", err)
+	}
+
+	authURL, err := url.Parse(p.AuthorizationURL("iiot-client", "http://api/callback", "st", "n1", verifier))
+	if err != nil {
+		t.Fatalf("parse authorization url: %v", err)
+	}
+	q := authURL.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("state") != "st" || q.Get("nonce") != "n1" {
+		t.Fatalf("unexpected authorization query: %s", authURL.RawQuery)
+	}
+	m.challenge, m.nonce = q.Get("code_challenge"), "n1"
+
+	idToken, err := p.ExchangeCode(ctx, "iiot-client", "", m.code, "http://api/callback", verifier)
+	if err != nil {
+		t.Fatalf("ExchangeCode error: %v", err)
+	}
+	claims, err := p.VerifyIDToken(ctx, idToken, "iiot-client", "n1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken error: %v", err)
+	}
+	if claims["email"] != "ana@empresa.com" || claims["sub"] != "idp-user-1" {
+		t.Fatalf("unexpected claims: %v", claims)
+	}
+}
+
+func TestOIDCExchangeRejectsWrongVerifier(t *testing.T) {
+	t.Parallel()
+
+	m := newMockOIDCProvider(t, "iiot-client")
+	m.challenge = PKCEChallenge("right-verifier")
+	p, err := DiscoverOIDC(context.Background(), m.server.URL, true)
+	if err != nil {
+		t.Fatalf("DiscoverOIDC error: %v", err)
+	}
+	if _, err := p.ExchangeCode(context.Background(), "iiot-client", "", m.code, "http://api/callback", "wrong-verifier"); err == nil {
+		t.Fatal("expected error for wrong code_verifier")
+	}
+}
+
+func TestVerifyIDTokenRejectsBadClaims(t *testing.T) {
+	t.Parallel()
+
+	m := newMockOIDCProvider(t, "iiot-client")
+	m.nonce = "n1"
+	p, err := DiscoverOIDC(context.Background(), m.server.URL, true)
+	if err != nil {
+		t.Fatalf("DiscoverOIDC error: %v", err)
+	}
+
+	cases := map[string]string{
+		"wrong nonce":    m.idToken(t, jwt.MapClaims{"nonce": "other"}),
+		"wrong audience": m.idToken(t, jwt.MapClaims{"aud": "someone-else"}),
+		"wrong issuer":   m.idToken(t, jwt.MapClaims{"iss": "https://evil.example"}),
+		"expired":        m.idToken(t, jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}),
+		"hs256":          mustHS256(t),
+	}
+	for name, token := range cases {
+		if _, err := p.VerifyIDToken(context.Background(), token, "iiot-client", "n1"); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestDiscoverOIDCRejectsIssuerMismatch(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"issuer":"https://other.example","authorization_endpoint":"a","token_endpoint":"t","jwks_uri":"j"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	if _, err := DiscoverOIDC(context.Background(), srv.URL, true); err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Fatalf("err = %v, want issuer mismatch", err)
+	}
+}
+
+func TestDiscoverOIDCRefusesInternalTargets(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("internal IdP reached: %s", r.URL)
+	}))
+	t.Cleanup(srv.Close)
+
+	for _, issuer := range []string{
+		"http://idp.example.com",
+		"https://127.0.0.1",
+		"https://10.0.0.5/realms/acme",
+		"https://169.254.169.254/latest/meta-data",
+		"https://[::1]:8443",
+		"https://localhost",
+		srv.URL,
+	} {
+		if _, err := DiscoverOIDC(context.Background(), issuer, false); !errors.Is(err, ErrOIDCURLNotAllowed) {
+			t.Fatalf("DiscoverOIDC(%s) = %v, want ErrOIDCURLNotAllowed", issuer, err)
+		}
+	}
+
+	// Names are checked after DNS resolution, at dial time.
+	if err := oidcGetJSON(context.Background(), oidcHTTPClient, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1), &struct{}{}); !errors.Is(err, ErrOIDCURLNotAllowed) {
+		t.Fatalf("dial to a name resolving to loopback = %v, want ErrOIDCURLNotAllowed", err)
+	}
+}
+
+func TestOIDCPublicIP(t *testing.T) {
+	t.Parallel()
+
+	for ip, want := range map[string]bool{
+		"8.8.8.8":         true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.100.100.200": false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := oidcPublicIP(net.ParseIP(ip)); got != want {
+			t.Fatalf("oidcPublicIP(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func mustHS256(t *testing.T) string {
+	t.Helper()
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"nonce": "n1"}).SignedString([]byte("k"))
+	if err != nil {
+		t.Fatalf("SignedString error: %v", err)
+	}
+	return s
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultTrustedProxies are loopback and private networks, where the reverse
+// proxy runs in the usual deployments.
+var DefaultTrustedProxies = []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"}
+
+var (
+	trustedProxiesMu sync.RWMutex
+	trustedProxies   = mustParseCIDRs(DefaultTrustedProxies)
+)
+
+// SetTrustedProxies replaces the networks whose X-Forwarded-For/X-Real-IP
+// headers are believed. An empty list trusts no proxy.
+func SetTrustedProxies(cidrs []string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	trustedProxiesMu.Lock()
+	trustedProxies = nets
+	trustedProxiesMu.Unlock()
+	return nil
+}
+
+// ClientIP returns the caller address. X-Real-IP and the first
+// X-Forwarded-For hop are honoured only when the connection comes from a
+// trusted proxy; anyone else could forge them to dodge per-IP limits.
+func ClientIP(r *http.Request) string {
+	clientIP := r.RemoteAddr
+	// Remove port from IP address
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	if !isTrustedProxy(clientIP) {
+		return clientIP
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		// Use only the first IP in the list
+		parts := strings.Split(xff, ",")
+		clientIP = strings.TrimSpace(parts[0])
+	}
+	if xri := r.Header.Get("X-Real-IP"); xri != "" {
+		clientIP = xri
+	}
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	return clientIP
+}
+
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	trustedProxiesMu.RLock()
+	defer trustedProxiesMu.RUnlock()
+	for _, n := range trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(c))
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func mustParseCIDRs(cidrs []string) []*net.IPNet {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
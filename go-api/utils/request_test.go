@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+	if got := ClientIP(req); got != "10.0.0.1" {
+		t.Fatalf("remote addr: ClientIP = %q", got)
+	}
+
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.2")
+	if got := ClientIP(req); got != "203.0.113.7" {
+		t.Fatalf("forwarded: ClientIP = %q", got)
+	}
+
+	req.Header.Set("X-Real-IP", "198.51.100.9")
+	if got := ClientIP(req); got != "198.51.100.9" {
+		t.Fatalf("real ip: ClientIP = %q", got)
+	}
+}
+
+func TestClientIPIgnoresHeadersFromUntrustedPeers(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.50:4444"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("X-Real-IP", "198.51.100.2")
+	if got := ClientIP(req); got != "203.0.113.50" {
+		t.Fatalf("ClientIP = %q, want the connection address", got)
+	}
+}
+
+func TestSetTrustedProxiesRejectsInvalidCIDR(t *testing.T) {
+	t.Parallel()
+
+	if err := SetTrustedProxies([]string{"not-a-cidr"}); err == nil {
+		t.Fatal("expected error for invalid CIDR")
+	}
+}
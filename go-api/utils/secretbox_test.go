@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSecretboxRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	sealed, err := EncryptSecret("key-a", "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatalf("EncryptSecret error: %v", err)
+	}
+	if strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+		t.Fatal("ciphertext leaks the plaintext")
+	}
+	plain, err := DecryptSecret("key-a", sealed)
+	if err != nil || plain != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("DecryptSecret = %q, %v", plain, err)
+	}
+	if _, err := DecryptSecret("key-b", sealed); err != ErrInvalidCiphertext {
+		t.Fatalf("wrong key err = %v, want ErrInvalidCiphertext", err)
+	}
+}
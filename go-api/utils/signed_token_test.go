@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignedTokenRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	token := SignToken("secret", "email_verify", []string{"u1", "user@example.com"}, now.Add(time.Hour))
+
+	fields, err := VerifySignedToken("secret", "email_verify", token, now)
+	if err != nil {
+		t.Fatalf("VerifySignedToken error: %v", err)
+	}
+	if len(fields) != 2 || fields[0] != "u1" || fields[1] != "user@example.com" {
+		t.Fatalf("fields = %v", fields)
+	}
+}
+
+func TestSignedTokenRejectsTamperingPurposeAndExpiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	token := SignToken("secret", "email_verify", []string{"u1"}, now.Add(time.Hour))
+
+	if _, err := VerifySignedToken("other-secret", "email_verify", token, now); err != ErrInvalidSignedToken {
+		t.Fatalf("wrong secret err = %v, want ErrInvalidSignedToken", err)
+	}
+	if _, err := VerifySignedToken("secret", "password_reset", token, now); err != ErrInvalidSignedToken {
+		t.Fatalf("wrong purpose err = %v, want ErrInvalidSignedToken", err)
+	}
+	forged := SignToken("secret", "email_verify", []string{"u2"}, now.Add(time.Hour))
+	if _, err := VerifySignedToken("secret", "email_verify", token[:len(token)/2]+forged[len(forged)/2:], now); err == nil {
+		t.Fatal("spliced token should be rejected")
+	}
+	if _, err := VerifySignedToken("secret", "email_verify", token, now.Add(2*time.Hour)); err != ErrExpiredToken {
+		t.Fatalf("expired err = %v, want ErrExpiredToken", err)
+	}
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA1 secret "12345678901234567890" (6-digit codes).
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	t.Parallel()
+
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, want := range cases {
+		got, err := TOTPCode(rfcTOTPSecret, time.Unix(ts, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d) error: %v", ts, err)
+		}
+		if got != want {
+			t.Fatalf("TOTPCode(%d) = %s, want %s", ts, got, want)
+		}
+	}
+}
+
+func TestValidateTOTPSkewAndStep(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1111111109, 0)
+	previous, _ := TOTPCode(rfcTOTPSecret, now.Add(-30*time.Second))
+
+	step, ok := ValidateTOTP(rfcTOTPSecret, previous, now, 1)
+	if !ok || step != now.Unix()/30-1 {
+		t.Fatalf("ValidateTOTP previous step = %d, %v", step, ok)
+	}
+	if _, ok := ValidateTOTP(rfcTOTPSecret, previous, now, 0); ok {
+		t.Fatal("previous code should fail without skew")
+	}
+	if _, ok := ValidateTOTP(rfcTOTPSecret, "12345", now, 1); ok {
+		t.Fatal("short code should fail")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	t.Parallel()
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil || len(secret) != 32 {
+		t.Fatalf("GenerateTOTPSecret = %q, %v", secret, err)
+	}
+	uri := TOTPProvisioningURI("IIoT Platform", "user@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/IIoT%20Platform:user@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("uri = %s", uri)
+	}
+}